@@ -14,6 +14,12 @@ var (
 
 func main() {
 	logger := log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := app.Migrate(os.Args[2:], os.Stdout); err != nil {
+			logger.Fatalf("migrate: %v", err)
+		}
+		return
+	}
 	application, err := app.New(version, buildDate, logger)
 	if err != nil {
 		logger.Fatalf("failed to init server: %v", err)
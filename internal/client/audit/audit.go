@@ -0,0 +1,139 @@
+// Package audit checks decrypted login records for weak, reused and stale
+// passwords.
+package audit
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"gophkeeper/internal/client/passgen"
+)
+
+// Login is a decrypted login record.
+type Login struct {
+	ID        string
+	Site      string
+	Login     string
+	Password  string
+	UpdatedAt time.Time
+}
+
+// Options tune the checks.
+type Options struct {
+	// MinBits is the estimated entropy below which a password is weak.
+	MinBits float64
+	// StaleAfter flags records not updated for longer; zero disables it.
+	StaleAfter time.Duration
+	Now        time.Time
+}
+
+// Finding is the result of auditing one login. ReusedWith and SimilarTo hold
+// the IDs of the other logins sharing the password or a close variant of it.
+type Finding struct {
+	ID         string    `json:"id"`
+	Site       string    `json:"site"`
+	Login      string    `json:"login"`
+	Bits       float64   `json:"bits"`
+	Rating     string    `json:"rating"`
+	Weak       bool      `json:"weak"`
+	ReusedWith []string  `json:"reused_with,omitempty"`
+	SimilarTo  []string  `json:"similar_to,omitempty"`
+	Stale      bool      `json:"stale"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Issues names the problems of f in the order they are reported.
+func (f Finding) Issues() []string {
+	var out []string
+	if f.Weak {
+		out = append(out, "weak")
+	}
+	if len(f.ReusedWith) > 0 {
+		out = append(out, "reused")
+	}
+	if len(f.SimilarTo) > 0 {
+		out = append(out, "similar")
+	}
+	if f.Stale {
+		out = append(out, "stale")
+	}
+	return out
+}
+
+// Run audits logins and returns a finding for each, in the same order.
+func Run(logins []Login, o Options) []Finding {
+	out := make([]Finding, len(logins))
+	for i, l := range logins {
+		bits := passgen.Strength(l.Password)
+		out[i] = Finding{
+			ID:        l.ID,
+			Site:      l.Site,
+			Login:     l.Login,
+			Bits:      bits,
+			Rating:    passgen.Rating(bits),
+			Weak:      bits < o.MinBits,
+			Stale:     o.StaleAfter > 0 && o.Now.Sub(l.UpdatedAt) > o.StaleAfter,
+			UpdatedAt: l.UpdatedAt,
+		}
+	}
+	for i := range logins {
+		for j := i + 1; j < len(logins); j++ {
+			a, b := logins[i].Password, logins[j].Password
+			switch {
+			case a == "":
+			case a == b:
+				out[i].ReusedWith = append(out[i].ReusedWith, logins[j].ID)
+				out[j].ReusedWith = append(out[j].ReusedWith, logins[i].ID)
+			case Similar(a, b):
+				out[i].SimilarTo = append(out[i].SimilarTo, logins[j].ID)
+				out[j].SimilarTo = append(out[j].SimilarTo, logins[i].ID)
+			}
+		}
+	}
+	return out
+}
+
+// Similar reports whether two different passwords are variants of one
+// another: equal up to case and leading or trailing digits and symbols
+// ("Summer2023!" and "summer2024"), or a couple of edits apart.
+func Similar(a, b string) bool {
+	if a == b {
+		return false
+	}
+	if na := normalize(a); na != "" && na == normalize(b) {
+		return true
+	}
+	ra, rb := []rune(a), []rune(b)
+	limit := 1
+	if len(ra) >= 8 && len(rb) >= 8 {
+		limit = 2
+	}
+	return distance(ra, rb) <= limit
+}
+
+// normalize lowercases p and trims everything but letters from its ends.
+func normalize(p string) string {
+	return strings.ToLower(strings.TrimFunc(p, func(r rune) bool { return !unicode.IsLetter(r) }))
+}
+
+// distance returns the Levenshtein distance between a and b.
+func distance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSimilar(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{"Summer2023!", "summer2024", true},
+		{"correct-horse-battery", "correct-horse-battery1", true},
+		{"k8#Qz!pL2@vm", "k8#Qz!pL3@vn", true},
+		{"k8#Qz!pL2@vm", "r4$Wt^nB7&yc", false},
+		{"1234", "5678", false},
+		{"same", "same", false},
+	} {
+		if got := Similar(c.a, c.b); got != c.want {
+			t.Errorf("Similar(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	logins := []Login{
+		{ID: "a", Site: "mail", Password: "x7#Kq!9vR2@mTz5", UpdatedAt: now},
+		{ID: "b", Site: "bank", Password: "x7#Kq!9vR2@mTz5", UpdatedAt: now},
+		{ID: "c", Site: "shop", Password: "password", UpdatedAt: now},
+		{ID: "d", Site: "forum", Password: "Autumn2019", UpdatedAt: now.AddDate(-2, 0, 0)},
+		{ID: "e", Site: "blog", Password: "autumn2020!", UpdatedAt: now},
+	}
+	got := Run(logins, Options{MinBits: 64, StaleAfter: 365 * 24 * time.Hour, Now: now})
+	issues := map[string][]string{}
+	for _, f := range got {
+		issues[f.ID] = f.Issues()
+	}
+	want := map[string][]string{
+		"a": {"reused"},
+		"b": {"reused"},
+		"c": {"weak"},
+		"d": {"weak", "similar", "stale"},
+		"e": {"weak", "similar"},
+	}
+	if !reflect.DeepEqual(issues, want) {
+		t.Fatalf("issues: %v, want %v", issues, want)
+	}
+	if !reflect.DeepEqual(got[0].ReusedWith, []string{"b"}) || !reflect.DeepEqual(got[4].SimilarTo, []string{"d"}) {
+		t.Fatalf("links: %+v", got)
+	}
+	if got[2].Bits != 0 || got[2].Rating != "weak" {
+		t.Fatalf("common password: %+v", got[2])
+	}
+}
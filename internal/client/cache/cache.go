@@ -0,0 +1,316 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"gophkeeper/internal/shared/models"
+)
+
+// OpKind is a kind of operation queued while the server is unreachable.
+type OpKind string
+
+const (
+	OpUpsert OpKind = "upsert"
+	OpDelete OpKind = "delete"
+)
+
+// Op is a queued write replayed against the server on the next connection.
+// ExpectedVersion is sent as If-Match, so a replay never overwrites a
+// record changed on another device in the meantime.
+type Op struct {
+	Seq             int64
+	Kind            OpKind
+	Record          models.Record
+	ExpectedVersion int64
+}
+
+// Cache is a local SQLite copy of the encrypted records last seen from the
+// server plus a queue of pending writes. Payloads are stored as received,
+// i.e. still encrypted with the vault key.
+type Cache struct {
+	db *sql.DB
+}
+
+const schema = `
+    CREATE TABLE IF NOT EXISTS records (
+        id TEXT PRIMARY KEY,
+        type TEXT NOT NULL,
+        meta BLOB NOT NULL,
+        payload BLOB NOT NULL,
+        version INTEGER NOT NULL,
+        updated_at TIMESTAMP NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS pending_ops (
+        seq INTEGER PRIMARY KEY AUTOINCREMENT,
+        kind TEXT NOT NULL,
+        record BLOB NOT NULL,
+        expected_version INTEGER NOT NULL,
+        created_at TIMESTAMP NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS state (
+        key TEXT PRIMARY KEY,
+        value TEXT NOT NULL
+    );
+`
+
+// upgrades are applied in order on top of schema; the number of applied
+// upgrades is kept in PRAGMA user_version.
+var upgrades = []string{
+	`ALTER TABLE records ADD COLUMN content_id TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE records ADD COLUMN content_size INTEGER NOT NULL DEFAULT 0;`,
+}
+
+// recordColumns are the columns read by scanRecord, in order.
+const recordColumns = "id, type, meta, payload, version, updated_at, content_id, content_size"
+
+// Path returns default cache location under the user's config dir.
+func Path() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir, _ = os.UserHomeDir()
+	}
+	return filepath.Join(dir, "gophkeeper", "cache.db")
+}
+
+// Open opens or creates the cache database at path.
+func Open(path string) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`PRAGMA busy_timeout = 5000;` + schema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := upgrade(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	_ = os.Chmod(path, 0600)
+	return &Cache{db: db}, nil
+}
+
+func upgrade(db *sql.DB) error {
+	var applied int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&applied); err != nil {
+		return err
+	}
+	for i := applied; i < len(upgrades); i++ {
+		if _, err := db.Exec(upgrades[i] + fmt.Sprintf(`PRAGMA user_version = %d;`, i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Cursor returns the server sync cursor of the cached snapshot.
+func (c *Cache) Cursor(ctx context.Context) (int64, error) {
+	var v string
+	err := c.db.QueryRowContext(ctx, `SELECT value FROM state WHERE key = 'cursor'`).Scan(&v)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// Account returns the email the cache was filled for, if any.
+func (c *Cache) Account(ctx context.Context) (string, error) {
+	var v string
+	err := c.db.QueryRowContext(ctx, `SELECT value FROM state WHERE key = 'account'`).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return v, err
+}
+
+// SetAccount records the email the cache belongs to.
+func (c *Cache) SetAccount(ctx context.Context, email string) error {
+	_, err := c.db.ExecContext(ctx, `INSERT INTO state(key, value) VALUES('account', ?) ON CONFLICT(key) DO UPDATE SET value=excluded.value`, email)
+	return err
+}
+
+// ApplyChanges merges a server delta into the cache and stores its cursor.
+func (c *Cache) ApplyChanges(ctx context.Context, changes models.Changes) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if changes.Reset {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM records`); err != nil {
+			return err
+		}
+	}
+	for _, rec := range changes.Records {
+		if err := putRecord(ctx, tx, rec); err != nil {
+			return err
+		}
+	}
+	for _, t := range changes.Deleted {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM records WHERE id = ?`, t.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO state(key, value) VALUES('cursor', ?) ON CONFLICT(key) DO UPDATE SET value=excluded.value`, strconv.FormatInt(changes.Cursor, 10)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PutRecord stores a single record in the cache.
+func (c *Cache) PutRecord(ctx context.Context, rec models.Record) error {
+	return putRecord(ctx, c.db, rec)
+}
+
+// DeleteRecord removes a record from the cache.
+func (c *Cache) DeleteRecord(ctx context.Context, id string) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM records WHERE id = ?`, id)
+	return err
+}
+
+// ListRecords returns cached records, most recently updated first.
+func (c *Cache) ListRecords(ctx context.Context) ([]models.Record, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+recordColumns+` FROM records ORDER BY updated_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.Record{}
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// GetRecord returns a cached record or sql.ErrNoRows.
+func (c *Cache) GetRecord(ctx context.Context, id string) (models.Record, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT `+recordColumns+` FROM records WHERE id = ?`, id)
+	return scanRecord(row)
+}
+
+// Enqueue appends an operation to the replay queue.
+func (c *Cache) Enqueue(ctx context.Context, op Op) error {
+	recJSON, err := json.Marshal(op.Record)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.ExecContext(ctx, `INSERT INTO pending_ops(kind, record, expected_version, created_at) VALUES(?,?,?,?)`, string(op.Kind), recJSON, op.ExpectedVersion, time.Now().UTC())
+	return err
+}
+
+// Pending returns queued operations in the order they were made.
+func (c *Cache) Pending(ctx context.Context) ([]Op, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT seq, kind, record, expected_version FROM pending_ops ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Op
+	for rows.Next() {
+		var op Op
+		var kind string
+		var recJSON []byte
+		if err := rows.Scan(&op.Seq, &kind, &recJSON, &op.ExpectedVersion); err != nil {
+			return nil, err
+		}
+		op.Kind = OpKind(kind)
+		if err := json.Unmarshal(recJSON, &op.Record); err != nil {
+			return nil, err
+		}
+		out = append(out, op)
+	}
+	return out, rows.Err()
+}
+
+// Dequeue removes a replayed operation from the queue.
+func (c *Cache) Dequeue(ctx context.Context, seq int64) error {
+	_, err := c.db.ExecContext(ctx, `DELETE FROM pending_ops WHERE seq = ?`, seq)
+	return err
+}
+
+// Reset drops all cached records, queued operations and the sync cursor.
+// It returns the number of discarded queued operations.
+func (c *Cache) Reset(ctx context.Context) (int64, error) {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.ExecContext(ctx, `DELETE FROM pending_ops`)
+	if err != nil {
+		return 0, err
+	}
+	discarded, _ := res.RowsAffected()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM records; DELETE FROM state;`); err != nil {
+		return 0, err
+	}
+	return discarded, tx.Commit()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func putRecord(ctx context.Context, db execer, rec models.Record) error {
+	metaJSON, _ := json.Marshal(rec.Meta)
+	payload := rec.Payload
+	if payload == nil {
+		payload = []byte{}
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO records(id, type, meta, payload, version, updated_at, content_id, content_size) VALUES(?,?,?,?,?,?,?,?)
+		ON CONFLICT(id) DO UPDATE SET
+			type=excluded.type,
+			meta=excluded.meta,
+			payload=excluded.payload,
+			version=excluded.version,
+			updated_at=excluded.updated_at,
+			content_id=excluded.content_id,
+			content_size=excluded.content_size
+	`, rec.ID, string(rec.Type), metaJSON, payload, rec.Version, rec.UpdatedAt, rec.ContentID, rec.ContentSize)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecord(s scanner) (models.Record, error) {
+	var rec models.Record
+	var typ string
+	var metaBytes []byte
+	if err := s.Scan(&rec.ID, &typ, &metaBytes, &rec.Payload, &rec.Version, &rec.UpdatedAt, &rec.ContentID, &rec.ContentSize); err != nil {
+		return models.Record{}, err
+	}
+	rec.Type = models.RecordType(typ)
+	if len(metaBytes) > 0 {
+		var meta map[string]string
+		_ = json.Unmarshal(metaBytes, &meta)
+		rec.Meta = meta
+	}
+	return rec, nil
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/shared/models"
+)
+
+func openTemp(t *testing.T) *Cache {
+	t.Helper()
+	c, err := Open(filepath.Join(t.TempDir(), "sub", "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestApplyChanges(t *testing.T) {
+	c := openTemp(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	err := c.ApplyChanges(ctx, models.Changes{
+		Records: []models.Record{
+			{ID: "a", Type: models.RecordTypeText, Meta: map[string]string{"title": "a"}, Payload: []byte("ct-a"), Version: 1, UpdatedAt: now},
+			{ID: "b", Type: models.RecordTypeText, Payload: []byte("ct-b"), Version: 1, UpdatedAt: now.Add(time.Second)},
+		},
+		Cursor: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur, _ := c.Cursor(ctx); cur != 2 {
+		t.Fatalf("cursor: %d", cur)
+	}
+	list, err := c.ListRecords(ctx)
+	if err != nil || len(list) != 2 || list[0].ID != "b" {
+		t.Fatalf("list: %v %+v", err, list)
+	}
+	got, err := c.GetRecord(ctx, "a")
+	if err != nil || string(got.Payload) != "ct-a" || got.Meta["title"] != "a" {
+		t.Fatalf("get: %v %+v", err, got)
+	}
+
+	// delta with an update and a tombstone
+	err = c.ApplyChanges(ctx, models.Changes{
+		Records: []models.Record{{ID: "a", Type: models.RecordTypeText, Payload: []byte("ct-a2"), Version: 2, UpdatedAt: now}},
+		Deleted: []models.Tombstone{{ID: "b", Version: 2}},
+		Cursor:  4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetRecord(ctx, "b"); err == nil {
+		t.Fatalf("tombstoned record must be removed")
+	}
+	if got, _ := c.GetRecord(ctx, "a"); got.Version != 2 {
+		t.Fatalf("record not updated: %+v", got)
+	}
+
+	// reset drops everything not in the snapshot
+	if err := c.PutRecord(ctx, models.Record{ID: "stale", Type: models.RecordTypeText, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ApplyChanges(ctx, models.Changes{Reset: true, Cursor: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := c.ListRecords(ctx); len(list) != 0 {
+		t.Fatalf("reset must clear cache: %+v", list)
+	}
+}
+
+func TestPendingQueueAndReset(t *testing.T) {
+	c := openTemp(t)
+	ctx := context.Background()
+
+	if err := c.Enqueue(ctx, Op{Kind: OpUpsert, Record: models.Record{ID: "x", Type: models.RecordTypeText, Payload: []byte("ct")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Enqueue(ctx, Op{Kind: OpDelete, Record: models.Record{ID: "y"}, ExpectedVersion: 3}); err != nil {
+		t.Fatal(err)
+	}
+	ops, err := c.Pending(ctx)
+	if err != nil || len(ops) != 2 {
+		t.Fatalf("pending: %v %+v", err, ops)
+	}
+	if ops[0].Kind != OpUpsert || string(ops[0].Record.Payload) != "ct" || ops[1].Kind != OpDelete || ops[1].ExpectedVersion != 3 {
+		t.Fatalf("unexpected ops: %+v", ops)
+	}
+	if err := c.Dequeue(ctx, ops[0].Seq); err != nil {
+		t.Fatal(err)
+	}
+	if ops, _ := c.Pending(ctx); len(ops) != 1 || ops[0].Record.ID != "y" {
+		t.Fatalf("dequeue: %+v", ops)
+	}
+
+	if err := c.SetAccount(ctx, "u@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if acc, _ := c.Account(ctx); acc != "u@example.com" {
+		t.Fatalf("account: %q", acc)
+	}
+	n, err := c.Reset(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("reset: %d %v", n, err)
+	}
+	if acc, _ := c.Account(ctx); acc != "" {
+		t.Fatalf("account must be cleared: %q", acc)
+	}
+}
+
+func TestContentFieldsSurviveReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	rec := models.Record{ID: "f", Type: models.RecordTypeBinary, Version: 1, ContentID: "blob", ContentSize: 1 << 20}
+	if err := c.PutRecord(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Close()
+
+	c, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	got, err := c.GetRecord(ctx, "f")
+	if err != nil || got.ContentID != "blob" || got.ContentSize != 1<<20 {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gophkeeper/internal/client/audit"
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/client/vault"
+	"gophkeeper/internal/shared/models"
+)
+
+func newAuditCmd(serverURL *string) *cobra.Command {
+	r := &recordsClient{serverURL: serverURL}
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Report weak, reused and stale passwords of the login records",
+		Long: "Decrypts the login records locally, refreshed from the server when it is reachable, and\n" +
+			"reports passwords with low estimated entropy, passwords shared or nearly shared between\n" +
+			"sites, and records not updated for --stale-days. Passwords themselves are never printed.",
+		Args: cobra.NoArgs,
+		RunE: r.audit,
+	}
+	cmd.Flags().String("format", "table", "Output format: table or json")
+	cmd.Flags().Float64("min-bits", 64, "Estimated entropy below which a password is weak")
+	cmd.Flags().Int("stale-days", 365, "Flag records not updated for this many days, 0 to disable")
+	cmd.Flags().Bool("all", false, "Also list records without issues")
+	return cmd
+}
+
+func (r *recordsClient) audit(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("--format %q: want table or json", format)
+	}
+	minBits, _ := cmd.Flags().GetFloat64("min-bits")
+	staleDays, _ := cmd.Flags().GetInt("stale-days")
+	if staleDays < 0 {
+		return errors.New("--stale-days must not be negative")
+	}
+	all, _ := cmd.Flags().GetBool("all")
+
+	logins, err := r.auditLogins(cmd)
+	if err != nil {
+		return err
+	}
+	findings := audit.Run(logins, audit.Options{
+		MinBits:    minBits,
+		StaleAfter: time.Duration(staleDays) * 24 * time.Hour,
+		Now:        time.Now(),
+	})
+	counts := map[string]int{}
+	shown := []audit.Finding{}
+	for _, f := range findings {
+		issues := f.Issues()
+		for _, issue := range issues {
+			counts[issue]++
+		}
+		if all || len(issues) > 0 {
+			shown = append(shown, f)
+		}
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(shown); err != nil {
+			return err
+		}
+	} else if len(shown) > 0 {
+		if err := printAudit(cmd, logins, shown); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Audited %d logins: %d weak, %d reused, %d similar, %d stale\n",
+		len(logins), counts["weak"], counts["reused"], counts["similar"], counts["stale"])
+	return nil
+}
+
+// auditLogins decrypts the cached login records, sorted by site.
+func (r *recordsClient) auditLogins(cmd *cobra.Command) ([]audit.Login, error) {
+	kr, err := vault.Load()
+	if err != nil {
+		return nil, err
+	}
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if err := r.pull(cmd, c, false); errors.Is(err, errOffline) {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Server unreachable, auditing cached records")
+	} else if err != nil {
+		return nil, err
+	}
+	recs, err := c.ListRecords(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+	var logins []audit.Login
+	for _, rec := range recs {
+		if rec.Type != models.RecordTypeLogin {
+			continue
+		}
+		var content models.LoginContent
+		if err := openContent(kr, rec, &content); err != nil {
+			return nil, fmt.Errorf("record %s: %w", rec.ID, err)
+		}
+		logins = append(logins, audit.Login{
+			ID:        rec.ID,
+			Site:      rec.Meta["site"],
+			Login:     content.Login,
+			Password:  content.Password,
+			UpdatedAt: rec.UpdatedAt,
+		})
+	}
+	sort.SliceStable(logins, func(i, j int) bool { return logins[i].Site < logins[j].Site })
+	return logins, nil
+}
+
+// printAudit writes findings as a table, naming related records by site.
+func printAudit(cmd *cobra.Command, logins []audit.Login, findings []audit.Finding) error {
+	sites := make(map[string]string, len(logins))
+	for _, l := range logins {
+		sites[l.ID] = l.Site
+	}
+	names := func(ids []string) string {
+		out := make([]string, len(ids))
+		for i, id := range ids {
+			out[i] = sites[id]
+		}
+		return strings.Join(out, ", ")
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSITE\tLOGIN\tSTRENGTH\tUPDATED\tISSUES")
+	for _, f := range findings {
+		var issues []string
+		for _, issue := range f.Issues() {
+			switch issue {
+			case "reused":
+				issue = "reused with " + names(f.ReusedWith)
+			case "similar":
+				issue = "similar to " + names(f.SimilarTo)
+			}
+			issues = append(issues, issue)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t~%.0f bits (%s)\t%s\t%s\n",
+			f.ID, f.Site, f.Login, f.Bits, f.Rating, f.UpdatedAt.Local().Format(time.DateOnly), strings.Join(issues, "; "))
+	}
+	return w.Flush()
+}
@@ -11,6 +11,8 @@ import (
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
+
+	"gophkeeper/internal/client/cache"
 )
 
 type authClient struct {
@@ -80,12 +82,44 @@ func (a *authClient) login(cmd *cobra.Command, args []string) error {
 	if result.RefreshToken != "" {
 		_ = saveRefresh(result.RefreshToken)
 	}
+	if err := resetCacheForAccount(cmd, email); err != nil {
+		return err
+	}
 	fmt.Fprintln(cmd.OutOrStdout(), "Logged in")
 	return nil
 }
 
+// resetCacheForAccount drops the local offline cache when it was filled for
+// another account, so records of different users never mix.
+func resetCacheForAccount(cmd *cobra.Command, email string) error {
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	account, err := c.Account(cmd.Context())
+	if err != nil || account == email {
+		return err
+	}
+	discarded, err := c.Reset(cmd.Context())
+	if err != nil {
+		return err
+	}
+	if discarded > 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Discarded %d queued offline operations of %s\n", discarded, account)
+	}
+	return c.SetAccount(cmd.Context(), email)
+}
+
+// promptPassword reads a password without echo. When stdin is not a terminal
+// (pipes, tests) the password is read as a plain line from the command input.
 func promptPassword(cmd *cobra.Command, prompt string) ([]byte, error) {
 	fmt.Fprint(cmd.OutOrStdout(), prompt)
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := readLine(cmd.InOrStdin())
+		fmt.Fprintln(cmd.OutOrStdout())
+		return []byte(line), err
+	}
 	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
 	fmt.Fprintln(cmd.OutOrStdout())
 	return pass, err
@@ -136,7 +170,7 @@ func ensureAccessToken() (string, error) {
 	b, _ := json.Marshal(body)
 	resp, err := http.Post(getServerURL()+"/api/v1/auth/refresh", "application/json", bytes.NewReader(b))
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %v", errOffline, err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
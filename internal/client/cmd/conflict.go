@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gophkeeper/internal/client/totp"
+	"gophkeeper/internal/client/vault"
+	"gophkeeper/internal/shared/models"
+)
+
+// structured reports whether records of type t have plaintext fields that
+// can be edited and merged field by field.
+func structured(t models.RecordType) bool {
+	s, ok := models.SchemaOf(t)
+	return ok && s.Structured()
+}
+
+// metaFieldPrefix distinguishes metadata from payload fields in a flat field map.
+const metaFieldPrefix = "meta."
+
+// errVersionConflict is returned when the server rejects If-Match.
+var errVersionConflict = errors.New("version conflict")
+
+// conflictError carries the server copy returned with 412, if it still exists.
+type conflictError struct {
+	current *models.Record
+}
+
+func (e *conflictError) Error() string        { return errVersionConflict.Error() }
+func (e *conflictError) Is(target error) bool { return target == errVersionConflict }
+
+// prompter reads answers line by line from the command input.
+type prompter struct {
+	cmd *cobra.Command
+}
+
+func newPrompter(cmd *cobra.Command) *prompter {
+	return &prompter{cmd: cmd}
+}
+
+func (p *prompter) ask(prompt string) (string, error) {
+	fmt.Fprint(p.cmd.OutOrStdout(), prompt)
+	line, err := readLine(p.cmd.InOrStdin())
+	return strings.TrimSpace(line), err
+}
+
+// readLine reads up to a newline byte by byte, so that several prompts can
+// share one unbuffered input without losing data.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+// fieldDiff is a field whose value differs between the local and server copy.
+type fieldDiff struct {
+	Field  string
+	Mine   string
+	Server string
+}
+
+// diffFields compares two flat field maps, sorted by field name.
+func diffFields(mine, server map[string]string) []fieldDiff {
+	names := map[string]struct{}{}
+	for k := range mine {
+		names[k] = struct{}{}
+	}
+	for k := range server {
+		names[k] = struct{}{}
+	}
+	var out []fieldDiff
+	for k := range names {
+		if mine[k] != server[k] {
+			out = append(out, fieldDiff{Field: k, Mine: mine[k], Server: server[k]})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Field < out[j].Field })
+	return out
+}
+
+// secretField reports whether field is masked by the schema of any of types.
+func secretField(field string, types ...models.RecordType) bool {
+	for _, t := range types {
+		if schema, ok := models.SchemaOf(t); ok && schema.IsSecret(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordFields decrypts rec into a flat map of metadata and payload fields.
+// Payloads of types without plaintext fields are left out.
+func recordFields(kr *vault.Keyring, rec models.Record) (map[string]string, error) {
+	fields := map[string]string{}
+	for k, v := range rec.Meta {
+		if k == keyIDMeta {
+			continue
+		}
+		fields[metaFieldPrefix+k] = v
+	}
+	if !structured(rec.Type) {
+		return fields, nil
+	}
+	content, err := typedContent(kr, rec)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range content {
+		fields[k] = v
+	}
+	return fields, nil
+}
+
+// typedContent decrypts the payload of a structured record through the
+// content struct of its schema and returns its fields.
+func typedContent(kr *vault.Keyring, rec models.Record) (map[string]string, error) {
+	schema, _ := models.SchemaOf(rec.Type)
+	v := schema.NewContent()
+	if v == nil {
+		return nil, fmt.Errorf("%s records have no fields", rec.Type)
+	}
+	if err := openContent(kr, rec, v); err != nil {
+		return nil, fmt.Errorf("record %s: %w", rec.ID, err)
+	}
+	b, _ := json.Marshal(v)
+	content := map[string]string{}
+	return content, json.Unmarshal(b, &content)
+}
+
+// encodeContent builds the payload of a structured record from its fields
+// through the content struct of the schema, rejecting values the struct
+// cannot hold and content the type's own checks refuse.
+func encodeContent(typ models.RecordType, content map[string]string) ([]byte, error) {
+	schema, _ := models.SchemaOf(typ)
+	v := schema.NewContent()
+	if v == nil {
+		return nil, fmt.Errorf("%s records have no fields", typ)
+	}
+	b, _ := json.Marshal(content)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return nil, fmt.Errorf("invalid %s fields: %w", typ, err)
+	}
+	switch c := v.(type) {
+	case *models.TOTPContent:
+		if err := totp.Validate(*c); err != nil {
+			return nil, fmt.Errorf("invalid totp fields: %w", err)
+		}
+	case *models.SSHKeyContent:
+		if _, err := parseSSHKey(*c); err != nil {
+			return nil, fmt.Errorf("invalid ssh_key fields: %w", err)
+		}
+	}
+	return json.Marshal(v)
+}
+
+// fieldsRecord is the reverse of recordFields: it builds and encrypts a record.
+func fieldsRecord(kr *vault.Keyring, id string, typ models.RecordType, fields map[string]string) (models.Record, error) {
+	rec := models.Record{ID: id, Type: typ, Meta: map[string]string{}}
+	content := map[string]string{}
+	for k, v := range fields {
+		if name, ok := strings.CutPrefix(k, metaFieldPrefix); ok {
+			rec.Meta[name] = v
+		} else {
+			content[k] = v
+		}
+	}
+	pbytes, _ := json.Marshal(content)
+	if structured(typ) {
+		var err error
+		if pbytes, err = encodeContent(typ, content); err != nil {
+			return models.Record{}, err
+		}
+	}
+	err := sealRecord(kr, &rec, pbytes)
+	return rec, err
+}
+
+func decryptContent(kr *vault.Keyring, rec models.Record) (map[string]string, error) {
+	pt, err := openRecord(kr, rec)
+	if err != nil {
+		return nil, err
+	}
+	content := map[string]string{}
+	_ = json.Unmarshal(pt, &content)
+	return content, nil
+}
+
+// resolveConflict shows a field-level diff between the local record and the
+// current server copy and lets the user keep one of them or merge field by
+// field. It returns the record to upload, or false to keep the server copy.
+func resolveConflict(p *prompter, kr *vault.Keyring, mine, server models.Record) (models.Record, bool, error) {
+	mineFields, err := recordFields(kr, mine)
+	if err != nil {
+		return models.Record{}, false, err
+	}
+	serverFields, err := recordFields(kr, server)
+	if err != nil {
+		return models.Record{}, false, err
+	}
+	out := p.cmd.OutOrStdout()
+	diffs := diffFields(mineFields, serverFields)
+	mergeable := structured(mine.Type)
+	if len(diffs) == 0 && mergeable && mine.Type == server.Type {
+		fmt.Fprintf(out, "Record %s already has the same content on the server\n", server.ID)
+		return server, false, nil
+	}
+	fmt.Fprintf(out, "Record %s was changed on the server (version %d):\n", server.ID, server.Version)
+	for _, d := range diffs {
+		if secretField(d.Field, mine.Type, server.Type) {
+			fmt.Fprintf(out, "  %-12s differs (secret, not shown)\n", d.Field)
+			continue
+		}
+		fmt.Fprintf(out, "  %-12s mine: %q  server: %q\n", d.Field, d.Mine, d.Server)
+	}
+	if !mergeable || mine.Type != server.Type {
+		fmt.Fprintln(out, "  (payload cannot be compared field by field)")
+	}
+	prompt := "Keep [m]ine or [s]erver? "
+	if mergeable && mine.Type == server.Type {
+		prompt = "Keep [m]ine, [s]erver or merge [f]ield by field? "
+	}
+	choice, err := p.ask(prompt)
+	if err != nil {
+		return models.Record{}, false, err
+	}
+	switch strings.ToLower(choice) {
+	case "m", "mine":
+		return mine, true, nil
+	case "s", "server":
+		return server, false, nil
+	case "f", "merge":
+		if !mergeable || mine.Type != server.Type {
+			break
+		}
+		merged := serverFields
+		for _, d := range diffs {
+			pick, err := p.ask(fmt.Sprintf("%s [m/s]: ", d.Field))
+			if err != nil {
+				return models.Record{}, false, err
+			}
+			switch strings.ToLower(pick) {
+			case "m", "mine":
+				merged[d.Field] = d.Mine
+				if d.Mine == "" {
+					delete(merged, d.Field)
+				}
+			case "s", "server":
+			default:
+				return models.Record{}, false, fmt.Errorf("unknown choice %q", pick)
+			}
+		}
+		rec, err := fieldsRecord(kr, mine.ID, mine.Type, merged)
+		return rec, true, err
+	}
+	return models.Record{}, false, fmt.Errorf("unknown choice %q", choice)
+}
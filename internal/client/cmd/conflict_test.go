@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"gophkeeper/internal/client/vault"
+	"gophkeeper/internal/shared/models"
+)
+
+func testPrompter(input string) (*prompter, *bytes.Buffer) {
+	cmd := &cobra.Command{}
+	out := new(bytes.Buffer)
+	cmd.SetOut(out)
+	cmd.SetIn(strings.NewReader(input))
+	return newPrompter(cmd), out
+}
+
+// testKeyring returns a keyring holding a single all-zero key.
+func testKeyring() *vault.Keyring {
+	return &vault.Keyring{CurrentID: "test", Keys: []vault.Key{{ID: "test", Key: make([]byte, vault.KeyLength)}}}
+}
+
+func loginRecord(t *testing.T, key *vault.Keyring, site, login, password string) models.Record {
+	t.Helper()
+	rec, err := fieldsRecord(key, "rec-1", models.RecordTypeLogin, map[string]string{"meta.site": site, "login": login, "password": password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestDiffFields(t *testing.T) {
+	diffs := diffFields(map[string]string{"a": "1", "b": "2", "c": "3"}, map[string]string{"a": "1", "b": "x", "d": "4"})
+	if len(diffs) != 3 || diffs[0].Field != "b" || diffs[1].Field != "c" || diffs[2].Field != "d" {
+		t.Fatalf("unexpected diff: %+v", diffs)
+	}
+	if diffs[1].Server != "" || diffs[2].Mine != "" {
+		t.Fatalf("missing side must be empty: %+v", diffs)
+	}
+}
+
+func TestResolveConflict_Choices(t *testing.T) {
+	key := testKeyring()
+	mine := loginRecord(t, key, "example.com", "alice", "new-pass")
+	server := loginRecord(t, key, "example.com", "alice@corp", "old-pass")
+	server.Version = 5
+
+	p, _ := testPrompter("s\n")
+	if _, upload, err := resolveConflict(p, key, mine, server); err != nil || upload {
+		t.Fatalf("keep server: %v %v", upload, err)
+	}
+
+	p, _ = testPrompter("m\n")
+	got, upload, err := resolveConflict(p, key, mine, server)
+	if err != nil || !upload || !bytes.Equal(got.Payload, mine.Payload) {
+		t.Fatalf("keep mine: %v %v", upload, err)
+	}
+
+	// merge: fields are asked in name order -> login, password
+	p, out := testPrompter("f\ns\nm\n")
+	got, upload, err = resolveConflict(p, key, mine, server)
+	if err != nil || !upload {
+		t.Fatalf("merge: %v %v", upload, err)
+	}
+	if !strings.Contains(out.String(), `mine: "alice"  server: "alice@corp"`) {
+		t.Fatalf("diff not shown: %q", out.String())
+	}
+	if strings.Contains(out.String(), "new-pass") || strings.Contains(out.String(), "old-pass") || !strings.Contains(out.String(), "password     differs (secret, not shown)") {
+		t.Fatalf("secret shown in the diff: %q", out.String())
+	}
+	content, err := decryptContent(key, got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content["login"] != "alice@corp" || content["password"] != "new-pass" || got.Meta["site"] != "example.com" {
+		t.Fatalf("bad merge: %+v %+v", content, got.Meta)
+	}
+
+	p, _ = testPrompter("x\n")
+	if _, _, err := resolveConflict(p, key, mine, server); err == nil {
+		t.Fatalf("want error on unknown choice")
+	}
+}
+
+func TestResolveConflict_Binary(t *testing.T) {
+	key := testKeyring()
+	mine := models.Record{ID: "b", Type: models.RecordTypeBinary, Meta: map[string]string{"name": "a.bin"}, Payload: []byte("opaque")}
+	server := models.Record{ID: "b", Type: models.RecordTypeBinary, Meta: map[string]string{"name": "a.bin"}, Payload: []byte("other")}
+	p, out := testPrompter("f\n")
+	if _, _, err := resolveConflict(p, key, mine, server); err == nil {
+		t.Fatalf("field merge must not be offered for binary records")
+	}
+	if !strings.Contains(out.String(), "Keep [m]ine or [s]erver?") {
+		t.Fatalf("unexpected prompt: %q", out.String())
+	}
+}
+
+func TestResolveConflict_MasksSecrets(t *testing.T) {
+	key := testKeyring()
+	fields := func(cvv string) map[string]string {
+		return map[string]string{"meta.bank": "b", "number": "4111111111111111", "holder": "A", "exp": "12/30", "cvv": cvv}
+	}
+	mine, err := fieldsRecord(key, "card", models.RecordTypeBankCard, fields("123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := fieldsRecord(key, "card", models.RecordTypeBankCard, fields("987"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, out := testPrompter("s\n")
+	if _, _, err := resolveConflict(p, key, mine, server); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "123") || strings.Contains(out.String(), "987") || !strings.Contains(out.String(), "cvv") {
+		t.Fatalf("card secret shown: %q", out.String())
+	}
+}
@@ -0,0 +1,404 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/client/vault"
+	cryptohelper "gophkeeper/internal/shared/crypto"
+	"gophkeeper/internal/shared/models"
+)
+
+// streamThreshold is the file size above which add-file encrypts the file as
+// a chunked stream and uploads it in parts instead of an inline payload.
+var streamThreshold int64 = 512 << 10
+
+// partChunks is the number of stream chunks sent per upload request.
+var partChunks = 8
+
+// uploadState is kept while a file is streamed to the server so that an
+// interrupted upload resumes with the same record id and stream header.
+type uploadState struct {
+	UploadID string    `json:"upload_id"`
+	RecordID string    `json:"record_id"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Header   []byte    `json:"header"`
+}
+
+func uploadStatePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(filepath.Dir(cache.Path()), "uploads", hex.EncodeToString(sum[:12])+".json")
+}
+
+func loadUploadState(path string, info os.FileInfo) *uploadState {
+	b, err := os.ReadFile(uploadStatePath(path))
+	if err != nil {
+		return nil
+	}
+	var st uploadState
+	if json.Unmarshal(b, &st) != nil || st.Size != info.Size() || !st.ModTime.Equal(info.ModTime()) {
+		return nil
+	}
+	return &st
+}
+
+func saveUploadState(path string, st *uploadState) error {
+	p := uploadStatePath(path)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(st)
+	return os.WriteFile(p, b, 0o600)
+}
+
+// addLargeFile streams the file at path to the server as a new binary record.
+// Unlike inline records it cannot be queued while offline.
+func (r *recordsClient) addLargeFile(cmd *cobra.Command, kr *vault.Keyring, path string) error {
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	token, err := r.connect(cmd, c, false)
+	if errors.Is(err, errOffline) {
+		return fmt.Errorf("files larger than %d bytes are uploaded directly and need the server: %w", streamThreshold, err)
+	}
+	if err != nil {
+		return err
+	}
+	rec := models.Record{Type: models.RecordTypeBinary, Meta: map[string]string{"name": path}}
+	stored, err := r.uploadContent(cmd, token, kr, rec, path, 0)
+	if err != nil {
+		return err
+	}
+	if err := c.PutRecord(cmd.Context(), stored); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Record stored")
+	return nil
+}
+
+// uploadContent encrypts the file at path as a stream and stores it as the
+// content of rec, conditionally on expectedVersion. An empty rec.ID is taken
+// from an interrupted upload of the same file or generated.
+func (r *recordsClient) uploadContent(cmd *cobra.Command, token string, kr *vault.Keyring, rec models.Record, path string, expectedVersion int64) (models.Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return models.Record{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return models.Record{}, err
+	}
+
+	var sh cryptohelper.StreamHeader
+	var offset int64
+	st := loadUploadState(path, info)
+	if st != nil && (rec.ID == "" || rec.ID == st.RecordID) {
+		sh, err = cryptohelper.ReadStreamHeader(bytes.NewReader(st.Header))
+		if _, found := kr.Lookup(sh.KeyID); err != nil || !found {
+			st = nil
+		} else if up, err := r.uploadStatus(token, st.UploadID); err == nil {
+			offset = up.Size
+			fmt.Fprintf(cmd.ErrOrStderr(), "Resuming upload at %d bytes\n", offset)
+		} else {
+			st = nil
+		}
+	} else {
+		st = nil
+	}
+	if st == nil {
+		key := kr.Current()
+		if sh, err = cryptohelper.NewStreamHeader(cryptohelper.Header{KeyID: key.ID, Alg: kr.Algorithm()}, cryptohelper.DefaultChunkSize); err != nil {
+			return models.Record{}, err
+		}
+		hdr, err := sh.Encode()
+		if err != nil {
+			return models.Record{}, err
+		}
+		up, err := r.createUpload(token)
+		if err != nil {
+			return models.Record{}, err
+		}
+		id := rec.ID
+		if id == "" {
+			id = uuid.NewString()
+		}
+		st = &uploadState{UploadID: up.ID, RecordID: id, Size: info.Size(), ModTime: info.ModTime(), Header: hdr}
+		if err := saveUploadState(path, st); err != nil {
+			return models.Record{}, err
+		}
+	}
+
+	rec.ID = st.RecordID
+	rec.Meta = copyMeta(rec.Meta)
+	rec.Meta[keyIDMeta] = sh.KeyID
+	rec.Payload = []byte{}
+	key, _ := kr.Lookup(sh.KeyID)
+	sc, err := cryptohelper.NewStreamCipher(key.Key, sh, recordAAD(rec))
+	if err != nil {
+		return models.Record{}, err
+	}
+	if err := r.sendStream(token, st.UploadID, f, info.Size(), sc, sh, offset); err != nil {
+		return models.Record{}, err
+	}
+	stored, err := r.completeUpload(token, st.UploadID, rec, expectedVersion)
+	if err != nil {
+		return models.Record{}, err
+	}
+	_ = os.Remove(uploadStatePath(path))
+	return stored, nil
+}
+
+// sendStream uploads the encrypted stream of the size-byte file f starting
+// at the given stream offset. Chunks are sealed deterministically, so a
+// chunk cut by an earlier attempt is sealed again and sent from the middle.
+func (r *recordsClient) sendStream(token, uploadID string, f io.ReaderAt, size int64, sc *cryptohelper.StreamCipher, sh cryptohelper.StreamHeader, offset int64) error {
+	header, err := sh.Encode()
+	if err != nil {
+		return err
+	}
+	chunkSize := int64(sh.ChunkSize)
+	sealedSize := chunkSize + int64(sc.Overhead())
+	count := cryptohelper.ChunkCount(size, sh.ChunkSize)
+
+	var part bytes.Buffer
+	pos := offset
+	i, skip := int64(0), int64(0)
+	if pos < int64(len(header)) {
+		part.Write(header[pos:])
+	} else {
+		i, skip = (pos-int64(len(header)))/sealedSize, (pos-int64(len(header)))%sealedSize
+	}
+	buf := make([]byte, chunkSize)
+	for sent := 0; i < count; i++ {
+		n := min(chunkSize, size-i*chunkSize)
+		if _, err := f.ReadAt(buf[:n], i*chunkSize); err != nil && !(errors.Is(err, io.EOF) && n == 0) {
+			return fmt.Errorf("read file: %w", err)
+		}
+		ct, err := sc.SealChunk(uint64(i), buf[:n], i == count-1)
+		if err != nil {
+			return err
+		}
+		part.Write(ct[skip:])
+		skip = 0
+		if sent++; sent%partChunks != 0 && i < count-1 {
+			continue
+		}
+		if pos, err = r.appendUpload(token, uploadID, pos, part.Bytes()); err != nil {
+			return err
+		}
+		part.Reset()
+	}
+	return nil
+}
+
+func (r *recordsClient) createUpload(token string) (models.Upload, error) {
+	req, _ := http.NewRequest("POST", *r.serverURL+"/api/v1/uploads", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return models.Upload{}, &statusError{op: "upload", code: resp.StatusCode, status: resp.Status}
+	}
+	var up models.Upload
+	err = json.NewDecoder(resp.Body).Decode(&up)
+	return up, err
+}
+
+func (r *recordsClient) uploadStatus(token, id string) (models.Upload, error) {
+	req, _ := http.NewRequest("GET", *r.serverURL+"/api/v1/uploads/"+id, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return models.Upload{}, &statusError{op: "upload status", code: resp.StatusCode, status: resp.Status}
+	}
+	var up models.Upload
+	err = json.NewDecoder(resp.Body).Decode(&up)
+	return up, err
+}
+
+// appendUpload sends data at offset and returns the new upload offset.
+func (r *recordsClient) appendUpload(token, id string, offset int64, data []byte) (int64, error) {
+	req, _ := http.NewRequest("PATCH", *r.serverURL+"/api/v1/uploads/"+id, bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	resp, err := doRequest(req)
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return offset, &statusError{op: "upload", code: resp.StatusCode, status: resp.Status}
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// completeUpload stores rec with the uploaded stream as its content.
+func (r *recordsClient) completeUpload(token, id string, rec models.Record, expectedVersion int64) (models.Record, error) {
+	body := map[string]any{"id": rec.ID, "type": rec.Type, "meta": rec.Meta, "payload": rec.Payload}
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", *r.serverURL+"/api/v1/uploads/"+id+"/complete", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-Match", strconv.FormatInt(expectedVersion, 10))
+	resp, err := doRequest(req)
+	if err != nil {
+		return models.Record{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		var body struct {
+			Current *models.Record `json:"current"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return models.Record{}, &conflictError{current: body.Current}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return models.Record{}, &statusError{op: "upload", code: resp.StatusCode, status: resp.Status}
+	}
+	var stored models.Record
+	err = json.NewDecoder(resp.Body).Decode(&stored)
+	return stored, err
+}
+
+func (r *recordsClient) download(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	id, out := args[0], args[1]
+	rec, err := r.fetch(cmd, c, id)
+	if errors.Is(err, errOffline) && rec.ContentID == "" {
+		if rec, err = c.GetRecord(cmd.Context(), id); err != nil {
+			return fmt.Errorf("server unreachable and record %s is not cached", id)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if rec.ContentID == "" {
+		pt, err := openRecord(kr, rec)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(out, pt, 0o600); err != nil {
+			return err
+		}
+	} else {
+		token, err := ensureAccessToken()
+		if err != nil {
+			return err
+		}
+		if err := r.downloadContent(token, kr, rec, out); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Saved to %s\n", out)
+	return nil
+}
+
+// downloadContent fetches the encrypted content of rec into out.part,
+// continuing a previous partial download, and decrypts it into out.
+func (r *recordsClient) downloadContent(token string, kr *vault.Keyring, rec models.Record, out string) error {
+	part := out + ".part"
+	pf, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	have, err := pf.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if have < rec.ContentSize {
+		req, _ := http.NewRequest("GET", *r.serverURL+"/api/v1/records/"+rec.ID+"/content", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if have > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", have))
+			req.Header.Set("If-Range", strconv.Quote(rec.ContentID))
+		}
+		resp, err := doRequest(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+		case http.StatusOK:
+			if err := pf.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := pf.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		default:
+			return &statusError{op: "download", code: resp.StatusCode, status: resp.Status}
+		}
+		if _, err := io.Copy(pf, resp.Body); err != nil {
+			return fmt.Errorf("download interrupted, run the command again to resume: %w", err)
+		}
+	}
+
+	if _, err := pf.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sh, err := cryptohelper.ReadStreamHeader(pf)
+	if err != nil {
+		_ = os.Remove(part)
+		return err
+	}
+	key, found := kr.Lookup(sh.KeyID)
+	if !found {
+		return fmt.Errorf("record %s is encrypted with unknown key %s, run `gophkeeper vault pull-key --force`", rec.ID, sh.KeyID)
+	}
+	if _, err := pf.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	of, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := cryptohelper.DecryptStream(of, pf, key.Key, recordAAD(rec)); err != nil {
+		_ = of.Close()
+		_ = os.Remove(out)
+		_ = os.Remove(part)
+		return fmt.Errorf("decrypt %s: %w", rec.ID, err)
+	}
+	if err := of.Close(); err != nil {
+		return err
+	}
+	return os.Remove(part)
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/shared/models"
+)
+
+// withSmallStreams makes add-file stream small files one chunk per request.
+func withSmallStreams(t *testing.T) {
+	t.Helper()
+	oldThreshold, oldParts := streamThreshold, partChunks
+	streamThreshold, partChunks = 1024, 1
+	t.Cleanup(func() { streamThreshold, partChunks = oldThreshold, oldParts })
+}
+
+func cachedRecord(t *testing.T, id string) models.Record {
+	t.Helper()
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if id == "" {
+		list, _ := c.ListRecords(context.Background())
+		if len(list) != 1 {
+			t.Fatalf("expected one cached record, got %d", len(list))
+		}
+		return list[0]
+	}
+	rec, err := c.GetRecord(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestRecords_LargeFileResumableUploadAndDownload(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	unlockTestVault(t)
+	withSmallStreams(t)
+	ts := newTestBackend(t)
+
+	data := make([]byte, 3*64<<10+100)
+	_, _ = rand.Read(data)
+	file := filepath.Join(t.TempDir(), "archive.bin")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second part fails, as if the connection dropped
+	backend := ts.Config.Handler
+	var patches int
+	ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "PATCH" {
+			if patches++; patches == 2 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		backend.ServeHTTP(w, req)
+	})
+	if _, err := runCLI(t, ts.URL, "records", "add-file", file); err == nil {
+		t.Fatal("expected interrupted upload to fail")
+	}
+	out, err := runCLI(t, ts.URL, "records", "add-file", file)
+	if err != nil || !strings.Contains(out, "Resuming upload") {
+		t.Fatalf("resume: %v %q", err, out)
+	}
+	if _, err := os.Stat(uploadStatePath(file)); !os.IsNotExist(err) {
+		t.Fatalf("upload state must be removed, got %v", err)
+	}
+	rec := cachedRecord(t, "")
+	if rec.ContentID == "" || rec.ContentSize <= int64(len(data)) {
+		t.Fatalf("expected streamed content: %+v", rec)
+	}
+
+	out, err = runCLI(t, ts.URL, "records", "get", rec.ID)
+	if err != nil || !strings.Contains(out, "records download") {
+		t.Fatalf("get: %v %q", err, out)
+	}
+
+	// a partial earlier download is continued
+	token, _ := loadToken()
+	req, _ := http.NewRequest("GET", ts.URL+"/api/v1/records/"+rec.ID+"/content", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	dest := filepath.Join(t.TempDir(), "restored.bin")
+	if err := os.WriteFile(dest+".part", encrypted[:70000], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runCLI(t, ts.URL, "records", "download", rec.ID, dest); err != nil {
+		t.Fatalf("download: %v %q", err, out)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("downloaded content differs: %v", err)
+	}
+	if _, err := os.Stat(dest + ".part"); !os.IsNotExist(err) {
+		t.Fatalf("partial download must be removed, got %v", err)
+	}
+}
+
+func TestVault_RotateReencryptsStreamedContent(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	kr := unlockTestVault(t)
+	withSmallStreams(t)
+	ts := newTestBackend(t)
+
+	data := bytes.Repeat([]byte("rotate me "), 10000)
+	file := filepath.Join(t.TempDir(), "doc.txt")
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runCLI(t, ts.URL, "records", "add-file", file); err != nil {
+		t.Fatalf("add-file: %v %q", err, out)
+	}
+	before := cachedRecord(t, "")
+
+	if out, err := runCLIInput(t, ts.URL, "master\n", "vault", "rotate"); err != nil || !strings.Contains(out, "Re-encrypted 1 records") {
+		t.Fatalf("rotate: %v %q", err, out)
+	}
+	after := cachedRecord(t, before.ID)
+	if after.Meta[keyIDMeta] == kr.CurrentID || after.ContentID == before.ContentID {
+		t.Fatalf("content not re-encrypted: %+v", after)
+	}
+	dest := filepath.Join(t.TempDir(), "doc.out")
+	if out, err := runCLI(t, ts.URL, "records", "download", before.ID, dest); err != nil {
+		t.Fatalf("download: %v %q", err, out)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Fatal("content differs after rotation")
+	}
+}
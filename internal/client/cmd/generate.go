@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gophkeeper/internal/client/passgen"
+)
+
+func newGenerateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a random password or diceware passphrase",
+		Long: "Prints a password following a policy profile, adjusted by the flags, or with --words a\n" +
+			"diceware passphrase. The entropy estimate is printed to stderr.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			secret, bits, err := generateSecret(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), secret)
+			fmt.Fprintf(cmd.ErrOrStderr(), "Entropy: ~%.0f bits (%s)\n", bits, passgen.Rating(bits))
+			return nil
+		},
+	}
+	addGeneratorFlags(cmd.Flags())
+	return cmd
+}
+
+// addGeneratorFlags registers the flags read by generateSecret.
+func addGeneratorFlags(flags *pflag.FlagSet) {
+	profiles := make([]string, 0, len(passgen.Profiles))
+	for name := range passgen.Profiles {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	flags.String("profile", "strong", "Password policy: "+strings.Join(profiles, ", "))
+	flags.Int("length", 0, "Password length, the profile's by default")
+	flags.Bool("lower", false, "Use lowercase letters (--lower=false to leave them out)")
+	flags.Bool("upper", false, "Use uppercase letters")
+	flags.Bool("digits", false, "Use digits")
+	flags.Bool("symbols", false, "Use symbols")
+	flags.Bool("no-ambiguous", false, "Leave out characters like l, 1, O and 0")
+	flags.Int("words", 0, "Generate a diceware passphrase of this many words instead")
+	flags.String("separator", passgen.DefaultPassphrase.Separator, "Passphrase word separator")
+	flags.Bool("capitalize", false, "Capitalize passphrase words")
+	flags.String("wordlist", "", "Passphrase word list file, one word per line (the built-in list by default)")
+}
+
+// generatorFlags are the flags registered by addGeneratorFlags.
+var generatorFlags = []string{"profile", "length", "lower", "upper", "digits", "symbols", "no-ambiguous", "words", "separator", "capitalize", "wordlist"}
+
+// generatorFlagsChanged reports whether any generator flag was given.
+func generatorFlagsChanged(flags *pflag.FlagSet) bool {
+	for _, name := range generatorFlags {
+		if flags.Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateSecret returns a secret generated as the flags of
+// addGeneratorFlags ask, with its entropy in bits.
+func generateSecret(flags *pflag.FlagSet) (string, float64, error) {
+	words, _ := flags.GetInt("words")
+	wordlist, _ := flags.GetString("wordlist")
+	if words > 0 || wordlist != "" {
+		p := passgen.DefaultPassphrase
+		if words > 0 {
+			p.Words = words
+		}
+		p.Separator, _ = flags.GetString("separator")
+		p.Capitalize, _ = flags.GetBool("capitalize")
+		list := passgen.Words()
+		if wordlist != "" {
+			f, err := os.Open(wordlist)
+			if err != nil {
+				return "", 0, err
+			}
+			defer f.Close()
+			if list, err = passgen.ReadWords(f); err != nil {
+				return "", 0, fmt.Errorf("%s: %w", wordlist, err)
+			}
+		}
+		secret, err := passgen.Passphrase(p, list)
+		return secret, passgen.PassphraseEntropy(p, len(list)), err
+	}
+
+	name, _ := flags.GetString("profile")
+	p, ok := passgen.Profiles[name]
+	if !ok {
+		return "", 0, fmt.Errorf("unknown profile %q", name)
+	}
+	if flags.Changed("length") {
+		p.Length, _ = flags.GetInt("length")
+	}
+	for flag, class := range map[string]*bool{"lower": &p.Lower, "upper": &p.Upper, "digits": &p.Digits, "symbols": &p.Symbols} {
+		if flags.Changed(flag) {
+			*class, _ = flags.GetBool(flag)
+		}
+	}
+	if flags.Changed("no-ambiguous") {
+		p.ExcludeAmbiguous, _ = flags.GetBool("no-ambiguous")
+	}
+	secret, err := passgen.Password(p)
+	return secret, p.Entropy(), err
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/client/vault"
+	"gophkeeper/internal/shared/models"
+)
+
+// history prints the earlier versions of a record kept by the server,
+// newest first, decrypted like `records get`.
+func (r *recordsClient) history(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	token, err := r.connect(cmd, c, false)
+	if err != nil {
+		return err
+	}
+	revs, err := r.revisions(token, args[0])
+	if err != nil {
+		return err
+	}
+	out := make([]map[string]any, 0, len(revs))
+	for _, rev := range revs {
+		item := map[string]any{"version": rev.Version, "updated_at": rev.UpdatedAt, "type": rev.Type, "meta": rev.Meta}
+		if rev.ContentID != "" {
+			item["content_size"] = rev.ContentSize
+		} else {
+			// a revision under a key no longer held is listed, not fatal
+			if content, err := decryptContent(kr, rev); err != nil {
+				item["error"] = err.Error()
+			} else {
+				item["content"] = content
+			}
+		}
+		out = append(out, item)
+	}
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// revisions fetches the kept earlier versions of record id, newest first.
+func (r *recordsClient) revisions(token, id string) ([]models.Record, error) {
+	req, _ := http.NewRequest("GET", *r.serverURL+"/api/v1/records/"+id+"/revisions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, &statusError{op: "history", code: resp.StatusCode, status: resp.Status}
+	}
+	var revs []models.Record
+	if err := json.NewDecoder(resp.Body).Decode(&revs); err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// dropRevisions removes the kept earlier versions of record id on the server.
+func (r *recordsClient) dropRevisions(token, id string) error {
+	req, _ := http.NewRequest("DELETE", *r.serverURL+"/api/v1/records/"+id+"/revisions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return &statusError{op: "drop history", code: resp.StatusCode, status: resp.Status}
+	}
+	return nil
+}
+
+// restore makes a kept earlier version the newest version of the record.
+func (r *recordsClient) restore(cmd *cobra.Command, args []string) error {
+	version, _ := cmd.Flags().GetInt64("version")
+	if version <= 0 {
+		return errors.New("--version is required")
+	}
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	token, err := r.connect(cmd, c, false)
+	if err != nil {
+		return err
+	}
+	req, _ := http.NewRequest("POST", *r.serverURL+"/api/v1/records/"+args[0]+"/restore?version="+strconv.FormatInt(version, 10), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &statusError{op: "restore", code: resp.StatusCode, status: resp.Status}
+	}
+	var rec models.Record
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil {
+		return err
+	}
+	if err := c.PutRecord(cmd.Context(), rec); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Restored version %d as version %d\n", version, rec.Version)
+	return nil
+}
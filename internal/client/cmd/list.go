@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/shared/models"
+)
+
+// listParams converts the `records list` flags into query parameters of
+// GET /api/v1/records.
+func listParams(cmd *cobra.Command) (url.Values, error) {
+	params := url.Values{}
+	flags := cmd.Flags()
+	if limit, _ := flags.GetInt("limit"); limit != 0 {
+		if limit < 0 {
+			return nil, errors.New("--limit must be positive")
+		}
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	if cursor, _ := flags.GetString("cursor"); cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	if typ, _ := flags.GetString("type"); typ != "" {
+		if _, ok := models.SchemaOf(models.RecordType(typ)); !ok {
+			return nil, fmt.Errorf("--type %q: want one of %v", typ, models.RecordTypes())
+		}
+		params.Set("type", typ)
+	}
+	meta, _ := flags.GetStringArray("meta")
+	for _, kv := range meta {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("--meta %q: want key=value", kv)
+		}
+		params.Set("meta."+k, v)
+	}
+	if after, _ := flags.GetString("updated-after"); after != "" {
+		t, err := time.Parse(time.RFC3339, after)
+		if err != nil {
+			d, derr := time.ParseDuration(after)
+			if derr != nil {
+				return nil, fmt.Errorf("--updated-after %q: want an RFC 3339 time or a duration", after)
+			}
+			t = time.Now().Add(-d)
+		}
+		params.Set("updated_after", t.UTC().Format(time.RFC3339Nano))
+	}
+	if order, _ := flags.GetString("sort"); flags.Changed("sort") {
+		if order != "updated_at" && order != "-updated_at" {
+			return nil, fmt.Errorf("--sort %q: want updated_at or -updated_at", order)
+		}
+		params.Set("sort", order)
+	}
+	return params, nil
+}
+
+// query prints the summaries of the records matching params. Only metadata
+// is requested from the server, so listing never downloads payloads. Offline,
+// the cached records are filtered instead; paging through a server cursor
+// then is not possible.
+func (r *recordsClient) query(cmd *cobra.Command, c *cache.Cache, params url.Values) error {
+	items, next, err := r.queryServer(cmd, c, params)
+	if errors.Is(err, errOffline) {
+		if params.Get("cursor") != "" {
+			return errors.New("server unreachable, --cursor needs a connection")
+		}
+		fmt.Fprintln(cmd.ErrOrStderr(), "Server unreachable, showing cached records")
+		items, err = queryCache(cmd, c, params)
+	}
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(items); err != nil {
+		return err
+	}
+	if next != "" {
+		fmt.Fprintf(cmd.ErrOrStderr(), "More records available, continue with --cursor %s\n", next)
+	}
+	return nil
+}
+
+func (r *recordsClient) queryServer(cmd *cobra.Command, c *cache.Cache, params url.Values) ([]models.RecordSummary, string, error) {
+	token, err := r.connect(cmd, c, false)
+	if err != nil {
+		return nil, "", err
+	}
+	q := url.Values{"fields": {"meta"}}
+	for k, v := range params {
+		q[k] = v
+	}
+	req, _ := http.NewRequest("GET", *r.serverURL+"/api/v1/records?"+q.Encode(), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Error != "" {
+			return nil, "", fmt.Errorf("list failed: %s", body.Error)
+		}
+		return nil, "", &statusError{op: "list", code: resp.StatusCode, status: resp.Status}
+	}
+	var items []models.RecordSummary
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, "", err
+	}
+	if items == nil {
+		items = []models.RecordSummary{}
+	}
+	return items, resp.Header.Get("X-Next-Cursor"), nil
+}
+
+// queryCache applies params to the cached records.
+func queryCache(cmd *cobra.Command, c *cache.Cache, params url.Values) ([]models.RecordSummary, error) {
+	q := models.RecordQuery{Type: models.RecordType(params.Get("type"))}
+	for name, vals := range params {
+		if k, ok := strings.CutPrefix(name, "meta."); ok {
+			if q.Meta == nil {
+				q.Meta = map[string]string{}
+			}
+			q.Meta[k] = vals[0]
+		}
+	}
+	if s := params.Get("updated_after"); s != "" {
+		q.UpdatedAfter, _ = time.Parse(time.RFC3339Nano, s)
+	}
+	all, err := c.ListRecords(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+	items := []models.RecordSummary{}
+	for _, rec := range all {
+		if q.Matches(rec) {
+			items = append(items, rec.Summary())
+		}
+	}
+	if params.Get("sort") == "updated_at" {
+		sort.SliceStable(items, func(i, j int) bool { return items[i].UpdatedAt.Before(items[j].UpdatedAt) })
+	}
+	if limit, _ := strconv.Atoi(params.Get("limit")); limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
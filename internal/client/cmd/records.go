@@ -3,63 +3,112 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/client/passgen"
 	"gophkeeper/internal/client/vault"
 	cryptohelper "gophkeeper/internal/shared/crypto"
+	"gophkeeper/internal/shared/models"
 )
 
-type recordsClient struct{ serverURL *string }
+type recordsClient struct {
+	serverURL *string
+	prompter  *prompter
+}
 
 func newRecordsCmd(serverURL *string) *cobra.Command {
 	r := &recordsClient{serverURL: serverURL}
 	cmd := &cobra.Command{Use: "records", Short: "Manage records"}
-	cmd.AddCommand(&cobra.Command{Use: "list", Short: "List records", RunE: r.list})
-	cmd.AddCommand(&cobra.Command{Use: "add-login", Short: "Add login/password record", RunE: r.addLogin})
+	list := &cobra.Command{Use: "list", Short: "List records", RunE: r.list}
+	list.Flags().Int("limit", 0, "Return at most this many records and print a cursor for the next page")
+	list.Flags().String("cursor", "", "Continue a paged listing")
+	list.Flags().String("type", "", "Only records of this type")
+	list.Flags().StringArray("meta", nil, "Only records whose meta has key=value (repeatable)")
+	list.Flags().String("updated-after", "", "Only records changed after an RFC 3339 time or a duration ago, e.g. 72h")
+	list.Flags().String("sort", "-updated_at", "Order: -updated_at (newest first) or updated_at")
+	cmd.AddCommand(list)
+	addLogin := &cobra.Command{Use: "add-login", Short: "Add login/password record", RunE: r.addLogin}
+	addLogin.Flags().Bool("generate", false, "Generate the password instead of prompting for it, see `gophkeeper generate`; implied by the generator flags")
+	addGeneratorFlags(addLogin.Flags())
+	cmd.AddCommand(addLogin)
 	cmd.AddCommand(&cobra.Command{Use: "get", Short: "Get record by id", Args: cobra.ExactArgs(1), RunE: r.get})
 	cmd.AddCommand(&cobra.Command{Use: "delete", Short: "Delete record by id", Args: cobra.ExactArgs(1), RunE: r.delete})
 	cmd.AddCommand(&cobra.Command{Use: "add-text", Short: "Add text record", RunE: r.addText})
 	cmd.AddCommand(&cobra.Command{Use: "add-file", Short: "Add binary file record", Args: cobra.ExactArgs(1), RunE: r.addFile})
+	cmd.AddCommand(&cobra.Command{Use: "download <id> <file>", Short: "Decrypt a binary record into a file", Args: cobra.ExactArgs(2), RunE: r.download})
 	cmd.AddCommand(&cobra.Command{Use: "add-card", Short: "Add bank card record", RunE: r.addCard})
+	cmd.AddCommand(&cobra.Command{Use: "add-totp [otpauth-uri]", Short: "Add TOTP key from an otpauth:// URI", Args: cobra.MaximumNArgs(1), RunE: r.addTOTP})
+	addSSHKey := &cobra.Command{Use: "add-ssh-key <private-key-file>", Short: "Add SSH key pair from a private key file", Args: cobra.ExactArgs(1), RunE: r.addSSHKey}
+	addSSHKey.Flags().String("name", "", "Record name, the file name by default")
+	addSSHKey.Flags().String("comment", "", "Key comment, taken from the .pub file by default")
+	cmd.AddCommand(addSSHKey)
+	genSSHKey := &cobra.Command{Use: "generate-ssh-key", Short: "Generate SSH key pair and print its public key", RunE: r.generateSSHKey}
+	genSSHKey.Flags().String("name", "", "Record name (required)")
+	genSSHKey.Flags().String("comment", "", "Key comment")
+	genSSHKey.Flags().String("type", "ed25519", "Key type: ed25519 or rsa")
+	genSSHKey.Flags().Int("bits", 3072, "RSA key size")
+	cmd.AddCommand(genSSHKey)
+	cmd.AddCommand(&cobra.Command{Use: "totp <id>", Short: "Show the current one-time code of a TOTP record", Args: cobra.ExactArgs(1), RunE: r.totp})
+	cmd.AddCommand(&cobra.Command{Use: "edit", Short: "Edit login, card or text record", Args: cobra.ExactArgs(1), RunE: r.edit})
+	cmd.AddCommand(&cobra.Command{Use: "history <id>", Short: "Show earlier versions of a record kept by the server", Args: cobra.ExactArgs(1), RunE: r.history})
+	restore := &cobra.Command{Use: "restore <id>", Short: "Make an earlier version the newest version of a record", Args: cobra.ExactArgs(1), RunE: r.restore}
+	restore.Flags().Int64("version", 0, "Version to restore, see `records history`")
+	cmd.AddCommand(restore)
+	cmd.AddCommand(&cobra.Command{Use: "sync", Short: "Replay offline changes, resolve conflicts and refresh local cache", RunE: r.sync})
 	return cmd
 }
 
 func (r *recordsClient) list(cmd *cobra.Command, args []string) error {
-	token, err := ensureAccessToken()
+	params, err := listParams(cmd)
 	if err != nil {
 		return err
 	}
-	req, _ := http.NewRequest("GET", *r.serverURL+"/api/v1/records", nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-	resp, err := http.DefaultClient.Do(req)
+	c, err := cache.Open(cache.Path())
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("list failed: %s", resp.Status)
+	defer c.Close()
+	return r.query(cmd, c, params)
+}
+
+func (r *recordsClient) sync(cmd *cobra.Command, args []string) error {
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
 	}
-	var items []map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+	defer c.Close()
+	if err := r.pull(cmd, c, true); err != nil {
 		return err
 	}
-	enc := json.NewEncoder(os.Stdout)
-	enc.SetIndent("", "  ")
-	return enc.Encode(items)
+	pending, err := c.Pending(cmd.Context())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Synced, %d queued operations pending\n", len(pending))
+	return nil
 }
 
 func (r *recordsClient) addLogin(cmd *cobra.Command, args []string) error {
-	token, err := ensureAccessToken()
+	kr, err := vault.Load()
 	if err != nil {
 		return err
 	}
-	key, err := vault.Load()
-	if err != nil {
-		return err
+	// generator flags imply --generate unless it is turned off explicitly
+	generate, _ := cmd.Flags().GetBool("generate")
+	if generatorFlagsChanged(cmd.Flags()) {
+		if cmd.Flags().Changed("generate") && !generate {
+			return errors.New("generator flags cannot be combined with --generate=false")
+		}
+		generate = true
 	}
 	// prompt simple stdin
 	var site, login, password string
@@ -67,68 +116,228 @@ func (r *recordsClient) addLogin(cmd *cobra.Command, args []string) error {
 	fmt.Fscanln(os.Stdin, &site)
 	fmt.Fprint(cmd.OutOrStdout(), "Login: ")
 	fmt.Fscanln(os.Stdin, &login)
-	fmt.Fprint(cmd.OutOrStdout(), "Password: ")
-	fmt.Fscanln(os.Stdin, &password)
-	plaintext := map[string]string{"login": login, "password": password}
-	pbytes, _ := json.Marshal(plaintext)
-	aad := []byte("login:" + site)
-	ct, err := cryptohelper.EncryptAESGCM(key, pbytes, aad)
+	if generate {
+		var bits float64
+		if password, bits, err = generateSecret(cmd.Flags()); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Password: generated, entropy ~%.0f bits (%s)\n", bits, passgen.Rating(bits))
+	} else {
+		fmt.Fprint(cmd.OutOrStdout(), "Password: ")
+		fmt.Fscanln(os.Stdin, &password)
+	}
+	pbytes, _ := json.Marshal(models.LoginContent{Login: login, Password: password})
+	rec := models.Record{ID: uuid.NewString(), Type: models.RecordTypeLogin, Meta: map[string]string{"site": site}}
+	if err := sealRecord(kr, &rec, pbytes); err != nil {
+		return err
+	}
+	if err := r.store(cmd, "add-login", rec, 0); err != nil {
+		return err
+	}
+	if generate {
+		fmt.Fprintf(cmd.OutOrStdout(), "Show the password with `records get %s`\n", rec.ID)
+	}
+	return nil
+}
+
+func (r *recordsClient) get(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
 	if err != nil {
 		return err
 	}
-	body := map[string]any{
-		"type":    "login",
-		"meta":    map[string]string{"site": site},
-		"payload": ct,
+	rec, err := r.load(cmd, args[0])
+	if err != nil {
+		return err
 	}
-	b, _ := json.Marshal(body)
-	req, _ := http.NewRequest("POST", *r.serverURL+"/api/v1/records", bytes.NewReader(b))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+token)
-	resp, err := http.DefaultClient.Do(req)
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	if rec.ContentID != "" {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Content is stored separately, use `records download %s <file>`\n", rec.ID)
+		return enc.Encode(map[string]any{"id": rec.ID, "type": rec.Type, "meta": rec.Meta, "content_size": rec.ContentSize})
+	}
+	content, err := decryptContent(kr, rec)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("add-login failed: %s", resp.Status)
+	return enc.Encode(map[string]any{"id": rec.ID, "type": rec.Type, "meta": rec.Meta, "content": content})
+}
+
+// load fetches a record from the server or, when it is unreachable, from the
+// local cache.
+func (r *recordsClient) load(cmd *cobra.Command, id string) (models.Record, error) {
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return models.Record{}, err
 	}
-	fmt.Fprintln(cmd.OutOrStdout(), "Record stored")
+	defer c.Close()
+	rec, err := r.fetch(cmd, c, id)
+	if errors.Is(err, errOffline) {
+		if rec, err = c.GetRecord(cmd.Context(), id); err != nil {
+			return models.Record{}, fmt.Errorf("server unreachable and record %s is not cached", id)
+		}
+		fmt.Fprintln(cmd.ErrOrStderr(), "Server unreachable, showing cached record")
+	}
+	return rec, err
+}
+
+func (r *recordsClient) delete(cmd *cobra.Command, args []string) error {
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx := cmd.Context()
+	id := args[0]
+	token, err := r.connect(cmd, c, false)
+	if err == nil {
+		err = r.remove(token, id)
+	}
+	if errors.Is(err, errOffline) {
+		if err := c.Enqueue(ctx, cache.Op{Kind: cache.OpDelete, Record: models.Record{ID: id}}); err != nil {
+			return err
+		}
+		if err := c.DeleteRecord(ctx, id); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Server unreachable, deletion queued for sync")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.DeleteRecord(ctx, id); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Moved to trash, undo with `trash restore %s`\n", id)
 	return nil
 }
 
-func (r *recordsClient) get(cmd *cobra.Command, args []string) error {
-	token, err := ensureAccessToken()
+func (r *recordsClient) edit(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
 	if err != nil {
 		return err
 	}
-	key, err := vault.Load()
+	c, err := cache.Open(cache.Path())
 	if err != nil {
 		return err
 	}
-	id := args[0]
-	req, _ := http.NewRequest("GET", *r.serverURL+"/api/v1/records/"+id, nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-	resp, err := http.DefaultClient.Do(req)
+	rec, err := r.fetch(cmd, c, args[0])
+	if errors.Is(err, errOffline) {
+		rec, err = c.GetRecord(cmd.Context(), args[0])
+	}
+	_ = c.Close()
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("get failed: %s", resp.Status)
+	schema, ok := models.SchemaOf(rec.Type)
+	if !ok || !schema.Structured() {
+		return fmt.Errorf("editing %s records is not supported", rec.Type)
 	}
-	var rec struct {
-		ID      string            `json:"id"`
-		Type    string            `json:"type"`
-		Meta    map[string]string `json:"meta"`
-		Payload []byte            `json:"payload"`
+	content, err := typedContent(kr, rec)
+	if err != nil {
+		return err
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil {
+	p := r.prompt(cmd)
+	fmt.Fprintln(cmd.OutOrStdout(), "Enter new values, empty input keeps the current one")
+	for _, f := range schema.Fields {
+		current := content[f]
+		if schema.IsSecret(f) {
+			current = strings.Repeat("*", len(current))
+		}
+		v, err := p.ask(fmt.Sprintf("%s [%s]: ", f, current))
+		if err != nil {
+			return err
+		}
+		if v != "" {
+			content[f] = v
+		}
+	}
+	pbytes, err := encodeContent(rec.Type, content)
+	if err != nil {
+		return err
+	}
+	updated := models.Record{ID: rec.ID, Type: rec.Type, Meta: copyMeta(rec.Meta)}
+	if err := sealRecord(kr, &updated, pbytes); err != nil {
+		return err
+	}
+	return r.store(cmd, "edit", updated, rec.Version)
+}
+
+func (r *recordsClient) addText(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	var title string
+	var text string
+	fmt.Fprint(cmd.OutOrStdout(), "Title: ")
+	fmt.Fscanln(os.Stdin, &title)
+	fmt.Fprintln(cmd.OutOrStdout(), "Enter text, end with EOF (Ctrl+Z then Enter on Windows):")
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(os.Stdin); err != nil { /* ignore */
+	}
+	text = buf.String()
+	pbytes, _ := json.Marshal(models.TextContent{Text: text})
+	rec := models.Record{ID: uuid.NewString(), Type: models.RecordTypeText, Meta: map[string]string{"title": title}}
+	if err := sealRecord(kr, &rec, pbytes); err != nil {
+		return err
+	}
+	return r.store(cmd, "add-text", rec, 0)
+}
+
+func (r *recordsClient) addFile(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	path := args[0]
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > streamThreshold {
+		return r.addLargeFile(cmd, kr, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	rec := models.Record{ID: uuid.NewString(), Type: models.RecordTypeBinary, Meta: map[string]string{"name": path}}
+	if err := sealRecord(kr, &rec, data); err != nil {
 		return err
 	}
-	// Determine AAD based on record type and metadata to match encryption
+	return r.store(cmd, "add-file", rec, 0)
+}
+
+func (r *recordsClient) addCard(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	var bank, holder, number, exp, cvv string
+	fmt.Fprint(cmd.OutOrStdout(), "Bank: ")
+	fmt.Fscanln(os.Stdin, &bank)
+	fmt.Fprint(cmd.OutOrStdout(), "Holder: ")
+	fmt.Fscanln(os.Stdin, &holder)
+	fmt.Fprint(cmd.OutOrStdout(), "Number: ")
+	fmt.Fscanln(os.Stdin, &number)
+	fmt.Fprint(cmd.OutOrStdout(), "Exp (MM/YY): ")
+	fmt.Fscanln(os.Stdin, &exp)
+	fmt.Fprint(cmd.OutOrStdout(), "CVV: ")
+	fmt.Fscanln(os.Stdin, &cvv)
+	pbytes, _ := json.Marshal(models.BankCardContent{Holder: holder, Number: number, Exp: exp, CVV: cvv})
+	rec := models.Record{ID: uuid.NewString(), Type: models.RecordTypeBankCard, Meta: map[string]string{"bank": bank}}
+	if err := sealRecord(kr, &rec, pbytes); err != nil {
+		return err
+	}
+	return r.store(cmd, "add-card", rec, 0)
+}
+
+// recordAAD derives Additional Authenticated Data from record type and
+// metadata, binding the ciphertext to the record it belongs to.
+func recordAAD(rec models.Record) []byte {
 	var aad []byte
-	switch strings.TrimSpace(rec.Type) {
+	switch strings.TrimSpace(string(rec.Type)) {
 	case "login":
 		if s, ok := rec.Meta["site"]; ok {
 			aad = []byte("login:" + s)
@@ -145,163 +354,394 @@ func (r *recordsClient) get(cmd *cobra.Command, args []string) error {
 		if s, ok := rec.Meta["bank"]; ok {
 			aad = []byte("bank_card:" + s)
 		}
+	case "ssh_key":
+		if s, ok := rec.Meta["name"]; ok {
+			aad = []byte("ssh_key:" + s)
+		}
+	case "totp":
+		if s, ok := rec.Meta["account"]; ok {
+			aad = []byte("totp:" + rec.Meta["issuer"] + ":" + s)
+		}
 	}
 	if len(aad) == 0 {
-		aad = []byte(strings.TrimSpace(rec.Type))
+		aad = []byte(strings.TrimSpace(string(rec.Type)))
 	}
-	pt, err := cryptohelper.DecryptAESGCM(key, rec.Payload, aad)
-	if err != nil {
-		return err
+	return aad
+}
+
+// keyIDMeta is the meta key naming the vault key a payload is encrypted with.
+// Records written before key rotation existed have no key id.
+const keyIDMeta = "key_id"
+
+// sealRecord encrypts plaintext into rec.Payload with the current vault key
+// and the vault's cipher, and records the key id in rec.Meta and in the payload envelope.
+func sealRecord(kr *vault.Keyring, rec *models.Record, plaintext []byte) error {
+	key := kr.Current()
+	if rec.Meta == nil {
+		rec.Meta = map[string]string{}
+	}
+	rec.Meta[keyIDMeta] = key.ID
+	var err error
+	rec.Payload, err = cryptohelper.Seal(key.Key, cryptohelper.Header{KeyID: key.ID, Alg: kr.Algorithm()}, plaintext, recordAAD(*rec))
+	return err
+}
+
+// openRecord decrypts rec.Payload with the key named in its envelope or, for
+// legacy payloads, in its meta. Payloads without a key id are tried with
+// every key of the keyring.
+func openRecord(kr *vault.Keyring, rec models.Record) ([]byte, error) {
+	id, ok := rec.Meta[keyIDMeta]
+	if h, isEnvelope := cryptohelper.ParseHeader(rec.Payload); isEnvelope {
+		if _, found := kr.Lookup(h.KeyID); found {
+			id, ok = h.KeyID, true
+		}
 	}
-	var content map[string]string
-	_ = json.Unmarshal(pt, &content)
-	enc := json.NewEncoder(os.Stdout)
-	enc.SetIndent("", "  ")
-	return enc.Encode(map[string]any{"id": rec.ID, "type": rec.Type, "meta": rec.Meta, "content": content})
+	if ok {
+		key, found := kr.Lookup(id)
+		if !found {
+			return nil, fmt.Errorf("record %s is encrypted with unknown key %s, run `gophkeeper vault pull-key --force`", rec.ID, id)
+		}
+		return cryptohelper.Open(key.Key, rec.Payload, recordAAD(rec))
+	}
+	var err error
+	for _, key := range kr.Keys {
+		var pt []byte
+		if pt, err = cryptohelper.Open(key.Key, rec.Payload, recordAAD(rec)); err == nil {
+			return pt, nil
+		}
+	}
+	return nil, err
 }
 
-func (r *recordsClient) delete(cmd *cobra.Command, args []string) error {
-	token, err := ensureAccessToken()
+// openContent decrypts the structured payload of rec into v, one of the
+// content types of the record schemas.
+func openContent(kr *vault.Keyring, rec models.Record, v any) error {
+	pt, err := openRecord(kr, rec)
 	if err != nil {
 		return err
 	}
-	id := args[0]
-	req, _ := http.NewRequest("DELETE", *r.serverURL+"/api/v1/records/"+id, nil)
-	req.Header.Set("Authorization", "Bearer "+token)
+	return json.Unmarshal(pt, v)
+}
+
+func copyMeta(meta map[string]string) map[string]string {
+	out := make(map[string]string, len(meta))
+	for k, v := range meta {
+		out[k] = v
+	}
+	return out
+}
+
+// errOffline marks failures to reach the server. Writes failing this way are
+// queued in the local cache and replayed on the next successful connection.
+var errOffline = errors.New("server unreachable")
+
+// statusError reports an unexpected HTTP status from the server.
+type statusError struct {
+	op     string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return e.op + " failed: " + e.status }
+
+func doRequest(req *http.Request) (*http.Response, error) {
 	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errOffline, err)
+	}
+	return resp, nil
+}
+
+// store uploads a new or changed record. When the server is unreachable the
+// write is queued and the record is kept in the local cache until replayed.
+func (r *recordsClient) store(cmd *cobra.Command, op string, rec models.Record, expectedVersion int64) error {
+	c, err := cache.Open(cache.Path())
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("delete failed: %s", resp.Status)
+	defer c.Close()
+	ctx := cmd.Context()
+	token, err := r.connect(cmd, c, false)
+	if err == nil {
+		var stored models.Record
+		if stored, err = r.uploadResolving(cmd, token, op, rec, expectedVersion); err == nil {
+			if err := c.PutRecord(ctx, stored); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Record stored")
+			return nil
+		}
+	}
+	if !errors.Is(err, errOffline) {
+		return err
+	}
+	if err := c.Enqueue(ctx, cache.Op{Kind: cache.OpUpsert, Record: rec, ExpectedVersion: expectedVersion}); err != nil {
+		return err
+	}
+	rec.UpdatedAt = time.Now().UTC()
+	if err := c.PutRecord(ctx, rec); err != nil {
+		return err
 	}
-	fmt.Fprintln(cmd.OutOrStdout(), "Deleted")
+	fmt.Fprintln(cmd.OutOrStdout(), "Server unreachable, record queued for sync")
 	return nil
 }
 
-func (r *recordsClient) addText(cmd *cobra.Command, args []string) error {
+// prompt returns the prompter reading answers from the command input.
+func (r *recordsClient) prompt(cmd *cobra.Command) *prompter {
+	if r.prompter == nil {
+		r.prompter = newPrompter(cmd)
+	}
+	return r.prompter
+}
+
+// connect obtains an access token and replays operations queued while offline.
+// With resolve, conflicting queued changes are resolved interactively.
+func (r *recordsClient) connect(cmd *cobra.Command, c *cache.Cache, resolve bool) (string, error) {
 	token, err := ensureAccessToken()
 	if err != nil {
-		return err
+		return "", err
 	}
-	key, err := vault.Load()
+	return token, r.replay(cmd, c, token, resolve)
+}
+
+// replay sends queued operations in order. Operations rejected by the server
+// are dropped; version conflicts stay queued unless resolve is set.
+func (r *recordsClient) replay(cmd *cobra.Command, c *cache.Cache, token string, resolve bool) error {
+	ctx := cmd.Context()
+	ops, err := c.Pending(ctx)
 	if err != nil {
 		return err
 	}
-	var title string
-	var text string
-	fmt.Fprint(cmd.OutOrStdout(), "Title: ")
-	fmt.Fscanln(os.Stdin, &title)
-	fmt.Fprintln(cmd.OutOrStdout(), "Enter text, end with EOF (Ctrl+Z then Enter on Windows):")
-	buf := new(bytes.Buffer)
-	if _, err := buf.ReadFrom(os.Stdin); err != nil { /* ignore */
+	if len(ops) > 1 {
+		applied, err := r.replayBatch(cmd, c, token, ops)
+		if err != nil || applied {
+			return err
+		}
 	}
-	text = buf.String()
-	pbytes, _ := json.Marshal(map[string]string{"text": text})
-	aad := []byte("text:" + strings.TrimSpace(title))
-	ct, err := cryptohelper.EncryptAESGCM(key, pbytes, aad)
-	if err != nil {
-		return err
+	for _, op := range ops {
+		switch op.Kind {
+		case cache.OpUpsert:
+			var rec models.Record
+			if resolve {
+				rec, err = r.uploadResolving(cmd, token, "replay", op.Record, op.ExpectedVersion)
+			} else {
+				rec, err = r.upload(token, "replay", op.Record, op.ExpectedVersion)
+			}
+			if err == nil {
+				err = c.PutRecord(ctx, rec)
+			}
+		case cache.OpDelete:
+			err = r.remove(token, op.Record.ID)
+		default:
+			err = fmt.Errorf("unknown queued operation %q", op.Kind)
+		}
+		var se *statusError
+		switch {
+		case errors.Is(err, errVersionConflict):
+			fmt.Fprintf(cmd.ErrOrStderr(), "Queued change of record %s conflicts with the server version, run `records sync` to resolve\n", op.Record.ID)
+			continue
+		case errors.As(err, &se) && se.code == http.StatusNotFound && op.Kind == cache.OpDelete:
+		case errors.As(err, &se) && se.code < http.StatusInternalServerError:
+			fmt.Fprintf(cmd.ErrOrStderr(), "Queued %s of record %s rejected (%s), dropped\n", op.Kind, op.Record.ID, se.status)
+		case err != nil:
+			return err
+		}
+		if err := c.Dequeue(ctx, op.Seq); err != nil {
+			return err
+		}
 	}
-	body := map[string]any{
-		"type":    "text",
-		"meta":    map[string]string{"title": title},
-		"payload": ct,
+	return nil
+}
+
+// replayBatch sends all queued writes in one atomic batch and reports whether
+// it was applied. A rejected batch, or a server without batch support, is
+// left to the one-by-one replay that handles each failure on its own.
+func (r *recordsClient) replayBatch(cmd *cobra.Command, c *cache.Cache, token string, ops []cache.Op) (bool, error) {
+	ctx := cmd.Context()
+	batch := make([]map[string]any, len(ops))
+	for i, op := range ops {
+		switch op.Kind {
+		case cache.OpUpsert:
+			rec := map[string]any{"id": op.Record.ID, "type": op.Record.Type, "meta": op.Record.Meta, "payload": op.Record.Payload}
+			if op.Record.ContentID != "" {
+				rec["content_id"] = op.Record.ContentID
+			}
+			batch[i] = map[string]any{"op": models.BatchUpsert, "record": rec, "expected_version": op.ExpectedVersion}
+		case cache.OpDelete:
+			batch[i] = map[string]any{"op": models.BatchDelete, "id": op.Record.ID}
+		default:
+			return false, nil
+		}
 	}
-	b, _ := json.Marshal(body)
-	req, _ := http.NewRequest("POST", *r.serverURL+"/api/v1/records", bytes.NewReader(b))
+	b, _ := json.Marshal(map[string]any{"ops": batch})
+	req, _ := http.NewRequest("POST", *r.serverURL+"/api/v1/records:batch", bytes.NewReader(b))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 300 {
-		return fmt.Errorf("add-text failed: %s", resp.Status)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return false, &statusError{op: "replay", code: resp.StatusCode, status: resp.Status}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+	var body struct {
+		Results []models.BatchResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, err
+	}
+	if len(body.Results) != len(ops) {
+		return false, fmt.Errorf("replay: %d results for %d operations", len(body.Results), len(ops))
+	}
+	for i, op := range ops {
+		if op.Kind == cache.OpUpsert {
+			rec := op.Record
+			rec.ID, rec.Version = body.Results[i].ID, body.Results[i].Version
+			if t := body.Results[i].UpdatedAt; t != nil {
+				rec.UpdatedAt = *t
+			}
+			if err := c.PutRecord(ctx, rec); err != nil {
+				return true, err
+			}
+		}
+		if err := c.Dequeue(ctx, op.Seq); err != nil {
+			return true, err
+		}
 	}
-	fmt.Fprintln(cmd.OutOrStdout(), "Record stored")
-	return nil
+	return true, nil
 }
 
-func (r *recordsClient) addFile(cmd *cobra.Command, args []string) error {
-	token, err := ensureAccessToken()
-	if err != nil {
-		return err
-	}
-	key, err := vault.Load()
-	if err != nil {
-		return err
-	}
-	path := args[0]
-	data, err := os.ReadFile(path)
+// pull replays queued writes and merges server changes since the cached cursor.
+func (r *recordsClient) pull(cmd *cobra.Command, c *cache.Cache, resolve bool) error {
+	token, err := r.connect(cmd, c, resolve)
 	if err != nil {
 		return err
 	}
-	name := path
-	aad := []byte("binary:" + name)
-	ct, err := cryptohelper.EncryptAESGCM(key, data, aad)
+	cursor, err := c.Cursor(cmd.Context())
 	if err != nil {
 		return err
 	}
-	body := map[string]any{"type": "binary", "meta": map[string]string{"name": name}, "payload": ct}
-	b, _ := json.Marshal(body)
-	req, _ := http.NewRequest("POST", *r.serverURL+"/api/v1/records", bytes.NewReader(b))
-	req.Header.Set("Content-Type", "application/json")
+	req, _ := http.NewRequest("GET", *r.serverURL+"/api/v1/sync?since="+strconv.FormatInt(cursor, 10), nil)
 	req.Header.Set("Authorization", "Bearer "+token)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 300 {
-		return fmt.Errorf("add-file failed: %s", resp.Status)
+		return &statusError{op: "sync", code: resp.StatusCode, status: resp.Status}
 	}
-	fmt.Fprintln(cmd.OutOrStdout(), "Record stored")
-	return nil
+	var changes models.Changes
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return err
+	}
+	return c.ApplyChanges(cmd.Context(), changes)
 }
 
-func (r *recordsClient) addCard(cmd *cobra.Command, args []string) error {
-	token, err := ensureAccessToken()
+// fetch loads a record from the server and refreshes its cached copy.
+func (r *recordsClient) fetch(cmd *cobra.Command, c *cache.Cache, id string) (models.Record, error) {
+	token, err := r.connect(cmd, c, false)
 	if err != nil {
-		return err
+		return models.Record{}, err
 	}
-	key, err := vault.Load()
+	req, _ := http.NewRequest("GET", *r.serverURL+"/api/v1/records/"+id, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
 	if err != nil {
-		return err
+		return models.Record{}, err
 	}
-	var bank, holder, number, exp, cvv string
-	fmt.Fprint(cmd.OutOrStdout(), "Bank: ")
-	fmt.Fscanln(os.Stdin, &bank)
-	fmt.Fprint(cmd.OutOrStdout(), "Holder: ")
-	fmt.Fscanln(os.Stdin, &holder)
-	fmt.Fprint(cmd.OutOrStdout(), "Number: ")
-	fmt.Fscanln(os.Stdin, &number)
-	fmt.Fprint(cmd.OutOrStdout(), "Exp (MM/YY): ")
-	fmt.Fscanln(os.Stdin, &exp)
-	fmt.Fprint(cmd.OutOrStdout(), "CVV: ")
-	fmt.Fscanln(os.Stdin, &cvv)
-	content := map[string]string{"holder": holder, "number": number, "exp": exp, "cvv": cvv}
-	pbytes, _ := json.Marshal(content)
-	aad := []byte("bank_card:" + bank)
-	ct, err := cryptohelper.EncryptAESGCM(key, pbytes, aad)
-	if err != nil {
-		return err
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		_ = c.DeleteRecord(cmd.Context(), id)
+	}
+	if resp.StatusCode >= 300 {
+		return models.Record{}, &statusError{op: "get", code: resp.StatusCode, status: resp.Status}
+	}
+	var rec models.Record
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil {
+		return models.Record{}, err
+	}
+	return rec, c.PutRecord(cmd.Context(), rec)
+}
+
+// upload stores rec on the server if its current version is expectedVersion;
+// zero means the record must not exist yet.
+func (r *recordsClient) upload(token, op string, rec models.Record, expectedVersion int64) (models.Record, error) {
+	body := map[string]any{"id": rec.ID, "type": rec.Type, "meta": rec.Meta, "payload": rec.Payload}
+	if rec.ContentID != "" {
+		// keeps the uploaded content when only the record itself changes
+		body["content_id"] = rec.ContentID
 	}
-	body := map[string]any{"type": "bank_card", "meta": map[string]string{"bank": bank}, "payload": ct}
 	b, _ := json.Marshal(body)
 	req, _ := http.NewRequest("POST", *r.serverURL+"/api/v1/records", bytes.NewReader(b))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
-	resp, err := http.DefaultClient.Do(req)
+	req.Header.Set("If-Match", strconv.FormatInt(expectedVersion, 10))
+	resp, err := doRequest(req)
 	if err != nil {
-		return err
+		return models.Record{}, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		var body struct {
+			Current *models.Record `json:"current"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return models.Record{}, &conflictError{current: body.Current}
+	}
 	if resp.StatusCode >= 300 {
-		return fmt.Errorf("add-card failed: %s", resp.Status)
+		return models.Record{}, &statusError{op: op, code: resp.StatusCode, status: resp.Status}
+	}
+	var stored models.Record
+	if err := json.NewDecoder(resp.Body).Decode(&stored); err != nil {
+		return models.Record{}, err
+	}
+	return stored, nil
+}
+
+// uploadResolving uploads rec and, on version conflicts, lets the user resolve
+// them against the server copy and retries with the fresh version.
+func (r *recordsClient) uploadResolving(cmd *cobra.Command, token, op string, rec models.Record, expectedVersion int64) (models.Record, error) {
+	var kr *vault.Keyring
+	for {
+		stored, err := r.upload(token, op, rec, expectedVersion)
+		var ce *conflictError
+		if !errors.As(err, &ce) {
+			return stored, err
+		}
+		if ce.current == nil {
+			return models.Record{}, fmt.Errorf("record %s was deleted on the server", rec.ID)
+		}
+		if kr == nil {
+			if kr, err = vault.Load(); err != nil {
+				return models.Record{}, err
+			}
+		}
+		resolved, upload, err := resolveConflict(r.prompt(cmd), kr, rec, *ce.current)
+		if err != nil {
+			return models.Record{}, err
+		}
+		if !upload {
+			return *ce.current, nil
+		}
+		rec, expectedVersion = resolved, ce.current.Version
+	}
+}
+
+func (r *recordsClient) remove(token, id string) error {
+	req, _ := http.NewRequest("DELETE", *r.serverURL+"/api/v1/records/"+id, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return &statusError{op: "delete", code: resp.StatusCode, status: resp.Status}
 	}
-	fmt.Fprintln(cmd.OutOrStdout(), "Record stored")
 	return nil
 }
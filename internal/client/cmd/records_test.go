@@ -0,0 +1,626 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/client/totp"
+	"gophkeeper/internal/client/vault"
+	"gophkeeper/internal/server/config"
+	"gophkeeper/internal/server/httpapi"
+	"gophkeeper/internal/server/repository/memory"
+	"gophkeeper/internal/server/service"
+	"gophkeeper/internal/shared/models"
+)
+
+// newTestBackend starts a real API server and stores an access token for a
+// fresh user in the temporary home.
+func newTestBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	svcs := service.NewServices(memory.New(), config.Config{JWTSecret: "test", MaxRequestBytes: 1 << 20, MaxRecordPayloadBytes: 1 << 20, DataDir: t.TempDir()})
+	ts := httptest.NewServer(httpapi.NewRouter(svcs, nil, 1<<20))
+	t.Cleanup(ts.Close)
+
+	creds, _ := json.Marshal(map[string]string{"email": "cli@example.com", "password": "pass"})
+	resp, err := http.Post(ts.URL+"/api/v1/auth/register", "application/json", bytes.NewReader(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Post(ts.URL+"/api/v1/auth/login", "application/json", bytes.NewReader(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var tok models.TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveToken(tok.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// offlineURL returns an address nothing listens on.
+func offlineURL(t *testing.T) string {
+	t.Helper()
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	return ts.URL
+}
+
+func runCLI(t *testing.T, serverURL string, args ...string) (string, error) {
+	t.Helper()
+	return runCLIInput(t, serverURL, "", args...)
+}
+
+func runCLIInput(t *testing.T, serverURL, input string, args ...string) (string, error) {
+	t.Helper()
+	root := NewRootCmd("test", "today")
+	out := new(bytes.Buffer)
+	root.SetOut(out)
+	root.SetErr(out)
+	root.SetIn(strings.NewReader(input))
+	root.SetArgs(append([]string{"--server", serverURL}, args...))
+	err := root.Execute()
+	return out.String(), err
+}
+
+// unlockTestVault creates a vault key and exports an unlocked session for it.
+func unlockTestVault(t *testing.T) *vault.Keyring {
+	t.Helper()
+	key, err := vault.Generate([]byte("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := vault.Unlock([]byte("master"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(vault.SessionEnv, secret)
+	return key
+}
+
+func TestRecords_OfflineQueueAndReplay(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	unlockTestVault(t)
+	ts := newTestBackend(t)
+	offline := offlineURL(t)
+
+	file := filepath.Join(t.TempDir(), "note.bin")
+	if err := os.WriteFile(file, []byte("secret bytes"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runCLI(t, offline, "records", "add-file", file)
+	if err != nil || !strings.Contains(out, "queued") {
+		t.Fatalf("offline add: %v %q", err, out)
+	}
+
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, _ := c.Pending(context.Background())
+	list, _ := c.ListRecords(context.Background())
+	_ = c.Close()
+	if len(ops) != 1 || len(list) != 1 {
+		t.Fatalf("want one queued op and cached record: %d %d", len(ops), len(list))
+	}
+	id := list[0].ID
+
+	// offline list is served from cache
+	out, err = runCLI(t, offline, "records", "list")
+	if err != nil || !strings.Contains(out, id) {
+		t.Fatalf("offline list: %v %q", err, out)
+	}
+
+	// back online: queue is replayed and the server copy is cached
+	out, err = runCLI(t, ts.URL, "records", "sync")
+	if err != nil || !strings.Contains(out, "0 queued") {
+		t.Fatalf("sync: %v %q", err, out)
+	}
+	out, err = runCLI(t, ts.URL, "records", "get", id)
+	if err != nil || !strings.Contains(out, id) {
+		t.Fatalf("online get: %v %q", err, out)
+	}
+
+	// offline get and delete
+	out, err = runCLI(t, offline, "records", "get", id)
+	if err != nil || !strings.Contains(out, "cached") {
+		t.Fatalf("offline get: %v %q", err, out)
+	}
+	out, err = runCLI(t, offline, "records", "delete", id)
+	if err != nil || !strings.Contains(out, "queued") {
+		t.Fatalf("offline delete: %v %q", err, out)
+	}
+	out, err = runCLI(t, ts.URL, "records", "list")
+	if err != nil || strings.Contains(out, id) {
+		t.Fatalf("deletion not replayed: %v %q", err, out)
+	}
+}
+
+func TestRecords_ReplayUsesOneBatch(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	unlockTestVault(t)
+	ts := newTestBackend(t)
+	offline := offlineURL(t)
+
+	var ids []string
+	for _, name := range []string{"a.bin", "b.bin"} {
+		file := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(file, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := runCLI(t, offline, "records", "add-file", file); err != nil || !strings.Contains(out, "queued") {
+			t.Fatalf("offline add: %v %q", err, out)
+		}
+	}
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, _ := c.ListRecords(context.Background())
+	_ = c.Close()
+	for _, rec := range list {
+		ids = append(ids, rec.ID)
+	}
+	if out, err := runCLI(t, offline, "records", "delete", ids[0]); err != nil || !strings.Contains(out, "queued") {
+		t.Fatalf("offline delete: %v %q", err, out)
+	}
+
+	var paths []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		ts.Config.Handler.ServeHTTP(w, req)
+	}))
+	defer proxy.Close()
+	// history replays the queue without pulling, so the cache keeps what the
+	// batch reported
+	if _, err := runCLI(t, proxy.URL, "records", "history", ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	c, err = cache.Open(cache.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, _ := c.GetRecord(context.Background(), ids[1])
+	_ = c.Close()
+	if cached.UpdatedAt.IsZero() || time.Since(cached.UpdatedAt) > time.Minute {
+		t.Fatalf("cached update time after batch replay: %v", cached.UpdatedAt)
+	}
+	out, err := runCLI(t, proxy.URL, "records", "sync")
+	if err != nil || !strings.Contains(out, "0 queued") {
+		t.Fatalf("sync: %v %q", err, out)
+	}
+	replayed := strings.Join(paths, ",")
+	if !strings.Contains(replayed, "POST /api/v1/records:batch") || strings.Contains(replayed, "POST /api/v1/records,") || strings.Contains(replayed, "DELETE") {
+		t.Fatalf("queued writes not sent as one batch: %s", replayed)
+	}
+	out, err = runCLI(t, ts.URL, "records", "list")
+	if err != nil || strings.Contains(out, ids[0]) || !strings.Contains(out, ids[1]) {
+		t.Fatalf("list after batch replay: %v %q", err, out)
+	}
+}
+
+// postRecord stores rec directly through the API with the saved token.
+func postRecord(t *testing.T, serverURL string, rec models.Record, ifMatch string) models.Record {
+	t.Helper()
+	token, err := loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(rec)
+	req, _ := http.NewRequest("POST", serverURL+"/api/v1/records", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("post record: %s", resp.Status)
+	}
+	var stored models.Record
+	_ = json.NewDecoder(resp.Body).Decode(&stored)
+	return stored
+}
+
+func TestRecords_EditConflictResolvedOnSync(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	key := unlockTestVault(t)
+	ts := newTestBackend(t)
+	offline := offlineURL(t)
+
+	rec, err := fieldsRecord(key, "11111111-1111-1111-1111-111111111111", models.RecordTypeLogin, map[string]string{"meta.site": "example.com", "login": "alice", "password": "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = postRecord(t, ts.URL, rec, "")
+	if _, err := runCLI(t, ts.URL, "records", "sync"); err != nil {
+		t.Fatal(err)
+	}
+
+	// local edit while offline changes the login
+	out, err := runCLIInput(t, offline, "bob\n\n", "records", "edit", rec.ID)
+	if err != nil || !strings.Contains(out, "queued") {
+		t.Fatalf("offline edit: %v %q", err, out)
+	}
+	// another device changes the password meanwhile
+	other, _ := fieldsRecord(key, rec.ID, models.RecordTypeLogin, map[string]string{"meta.site": "example.com", "login": "alice", "password": "new"})
+	postRecord(t, ts.URL, other, "1")
+
+	out, err = runCLI(t, ts.URL, "records", "list")
+	if err != nil || !strings.Contains(out, "records sync") {
+		t.Fatalf("conflict must be reported and kept: %v %q", err, out)
+	}
+	// merge: keep my login, take server password
+	out, err = runCLIInput(t, ts.URL, "f\nm\ns\n", "records", "sync")
+	if err != nil || !strings.Contains(out, "0 queued") {
+		t.Fatalf("sync: %v %q", err, out)
+	}
+	out, err = runCLI(t, ts.URL, "records", "get", rec.ID)
+	if err != nil || !strings.Contains(out, `"login": "bob"`) || !strings.Contains(out, `"password": "new"`) {
+		t.Fatalf("merged record: %v %q", err, out)
+	}
+}
+
+func TestRecords_ListFiltersAndPaging(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	unlockTestVault(t)
+	ts := newTestBackend(t)
+	offline := offlineURL(t)
+
+	var ids []string
+	for _, site := range []string{"a.example", "b.example", "a.example"} {
+		rec := postRecord(t, ts.URL, models.Record{Type: models.RecordTypeLogin, Meta: map[string]string{"site": site}, Payload: []byte("x")}, "")
+		ids = append(ids, rec.ID)
+	}
+	note := postRecord(t, ts.URL, models.Record{Type: models.RecordTypeText, Meta: map[string]string{"title": "n"}, Payload: []byte("x")}, "")
+
+	out, err := runCLI(t, ts.URL, "records", "list", "--type", "login", "--meta", "site=a.example")
+	if err != nil || !strings.Contains(out, ids[0]) || !strings.Contains(out, ids[2]) || strings.Contains(out, ids[1]) || strings.Contains(out, note.ID) {
+		t.Fatalf("filtered list: %v %q", err, out)
+	}
+	if strings.Contains(out, "payload") {
+		t.Fatalf("list must print summaries only: %q", out)
+	}
+
+	out, err = runCLI(t, ts.URL, "records", "list", "--limit", "3", "--sort", "updated_at")
+	if err != nil || !strings.Contains(out, ids[0]) || strings.Contains(out, note.ID) {
+		t.Fatalf("first page: %v %q", err, out)
+	}
+	_, cursor, ok := strings.Cut(out, "--cursor ")
+	if !ok {
+		t.Fatalf("no cursor printed: %q", out)
+	}
+	out, err = runCLI(t, ts.URL, "records", "list", "--limit", "3", "--sort", "updated_at", "--cursor", strings.TrimSpace(cursor))
+	if err != nil || !strings.Contains(out, note.ID) || strings.Contains(out, ids[0]) || strings.Contains(out, "--cursor") {
+		t.Fatalf("second page: %v %q", err, out)
+	}
+
+	// listing does not download payloads; offline the filters apply to the
+	// records cached by sync
+	if _, err := runCLI(t, ts.URL, "records", "sync"); err != nil {
+		t.Fatal(err)
+	}
+	out, err = runCLI(t, offline, "records", "list", "--type", "text")
+	if err != nil || !strings.Contains(out, "cached") || !strings.Contains(out, note.ID) || strings.Contains(out, ids[0]) {
+		t.Fatalf("offline filtered list: %v %q", err, out)
+	}
+	if _, err := runCLI(t, ts.URL, "records", "list", "--meta", "site"); err == nil {
+		t.Fatal("malformed --meta must fail")
+	}
+}
+
+func TestRecords_HistoryAndRestore(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	key := unlockTestVault(t)
+	ts := newTestBackend(t)
+
+	rec, _ := fieldsRecord(key, "22222222-2222-2222-2222-222222222222", models.RecordTypeLogin, map[string]string{"meta.site": "example.com", "login": "alice", "password": "old"})
+	postRecord(t, ts.URL, rec, "0")
+	rec, _ = fieldsRecord(key, rec.ID, models.RecordTypeLogin, map[string]string{"meta.site": "example.com", "login": "alice", "password": "new"})
+	postRecord(t, ts.URL, rec, "1")
+
+	out, err := runCLI(t, ts.URL, "records", "history", rec.ID)
+	if err != nil || !strings.Contains(out, `"version": 1`) || !strings.Contains(out, `"password": "old"`) || strings.Contains(out, `"password": "new"`) {
+		t.Fatalf("history: %v %q", err, out)
+	}
+	if _, err := runCLI(t, ts.URL, "records", "restore", rec.ID); err == nil {
+		t.Fatal("restore without --version must fail")
+	}
+	out, err = runCLI(t, ts.URL, "records", "restore", rec.ID, "--version", "1")
+	if err != nil || !strings.Contains(out, "as version 3") {
+		t.Fatalf("restore: %v %q", err, out)
+	}
+	out, err = runCLI(t, ts.URL, "records", "get", rec.ID)
+	if err != nil || !strings.Contains(out, `"password": "old"`) {
+		t.Fatalf("restored record: %v %q", err, out)
+	}
+}
+
+func TestRecords_TOTP(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	unlockTestVault(t)
+	ts := newTestBackend(t)
+
+	uri := "otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example"
+	if out, err := runCLIInput(t, ts.URL, uri+"\n", "records", "add-totp"); err != nil || !strings.Contains(out, "Record stored") {
+		t.Fatalf("add-totp: %v %q", err, out)
+	}
+	if _, err := runCLI(t, ts.URL, "records", "add-totp", "otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP"); err == nil {
+		t.Fatal("hotp URI must be rejected")
+	}
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, _ := c.ListRecords(context.Background())
+	_ = c.Close()
+	if len(list) != 1 || list[0].Type != models.RecordTypeTOTP || list[0].Meta["issuer"] != "Example" || list[0].Meta["account"] != "alice@example.com" {
+		t.Fatalf("stored: %+v", list)
+	}
+
+	key, _ := totp.Parse(uri)
+	for _, server := range []string{ts.URL, offlineURL(t)} {
+		before, _, _ := totp.Code(key.TOTPContent, time.Now())
+		out, err := runCLI(t, server, "records", "totp", list[0].ID)
+		after, _, _ := totp.Code(key.TOTPContent, time.Now())
+		if err != nil || !strings.Contains(out, "valid for") || !strings.Contains(out, before) && !strings.Contains(out, after) {
+			t.Fatalf("totp: %v %q, want %s", err, out, before)
+		}
+	}
+
+	// edited fields go through the typed payload and its checks
+	for _, input := range []string{"\n\nabc\n\n", "\n\n4\n\n", "\nMD5\n\n\n"} {
+		if _, err := runCLIInput(t, ts.URL, input, "records", "edit", list[0].ID); err == nil {
+			t.Fatalf("edit with %q must be rejected", input)
+		}
+	}
+	if out, err := runCLIInput(t, ts.URL, "\n\n8\n\n", "records", "edit", list[0].ID); err != nil {
+		t.Fatalf("edit digits: %v %q", err, out)
+	}
+	if out, err := runCLI(t, ts.URL, "records", "totp", list[0].ID); err != nil || len(strings.Fields(out)[0]) != 8 {
+		t.Fatalf("totp after edit: %v %q", err, out)
+	}
+
+	note := postRecord(t, ts.URL, models.Record{Type: models.RecordTypeText, Meta: map[string]string{"title": "n"}, Payload: []byte("x")}, "")
+	if _, err := runCLI(t, ts.URL, "records", "totp", note.ID); err == nil || !strings.Contains(err.Error(), "not totp") {
+		t.Fatalf("totp of a text record: %v", err)
+	}
+}
+
+func TestSSHKeys_AddGenerateAndAgent(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	unlockTestVault(t)
+	ts := newTestBackend(t)
+
+	out, err := runCLI(t, ts.URL, "records", "generate-ssh-key", "--name", "deploy", "--comment", "ci@example.com")
+	if err != nil || !strings.Contains(out, "ssh-ed25519 ") || !strings.Contains(out, "ci@example.com") {
+		t.Fatalf("generate-ssh-key: %v %q", err, out)
+	}
+
+	_, priv, _ := ed25519.GenerateKey(nil)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := ssh.NewPublicKey(priv.Public())
+	if err := os.WriteFile(file+".pub", []byte(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))+" alice@laptop\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCLIInput(t, ts.URL, "wrong\n", "records", "add-ssh-key", file); err == nil {
+		t.Fatal("wrong passphrase must be rejected")
+	}
+	if out, err := runCLIInput(t, ts.URL, "hunter2\n", "records", "add-ssh-key", file); err != nil || !strings.Contains(out, "Record stored") {
+		t.Fatalf("add-ssh-key: %v %q", err, out)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	root := NewRootCmd("test", "today")
+	root.SetOut(new(bytes.Buffer))
+	root.SetErr(new(bytes.Buffer))
+	root.SetArgs([]string{"--server", ts.URL, "ssh-agent", "--socket", socket})
+	done := make(chan error, 1)
+	go func() { done <- root.ExecuteContext(ctx) }()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unix", socket); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("agent socket: %v", err)
+	}
+	defer conn.Close()
+	if fi, err := os.Stat(socket); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode: %v %v", fi.Mode(), err)
+	}
+	client := agent.NewClient(conn)
+	keys, err := client.List()
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("agent keys: %v %v", keys, err)
+	}
+	var comments []string
+	for _, k := range keys {
+		comments = append(comments, k.Comment)
+	}
+	sort.Strings(comments)
+	if strings.Join(comments, ",") != "alice@laptop,ci@example.com" {
+		t.Fatalf("agent key comments: %v", comments)
+	}
+	sig, err := client.Sign(pub, []byte("challenge"))
+	if err != nil || pub.Verify([]byte("challenge"), sig) != nil {
+		t.Fatalf("sign with imported key: %v", err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("agent exit: %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("socket left behind: %v", err)
+	}
+
+	shared := t.TempDir()
+	if err := os.Chmod(shared, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCLI(t, ts.URL, "ssh-agent", "--socket", filepath.Join(shared, "agent.sock")); err == nil || !strings.Contains(err.Error(), "writable by other users") {
+		t.Fatalf("socket in a shared directory: %v", err)
+	}
+}
+
+func TestRecords_AddLoginGenerated(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	key := unlockTestVault(t)
+	ts := newTestBackend(t)
+
+	// generator flags alone imply --generate
+	out, err := runCLI(t, ts.URL, "records", "add-login", "--profile", "alphanumeric", "--length", "24")
+	if err != nil || !strings.Contains(out, "entropy ~138 bits") || !strings.Contains(out, "Record stored") {
+		t.Fatalf("add-login --length: %v %q", err, out)
+	}
+	if _, err := runCLI(t, ts.URL, "records", "add-login", "--generate=false", "--length", "24"); err == nil {
+		t.Fatal("--generate=false with generator flags must fail")
+	}
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, _ := c.ListRecords(context.Background())
+	_ = c.Close()
+	if len(list) != 1 {
+		t.Fatalf("records: %d", len(list))
+	}
+	var content models.LoginContent
+	if err := openContent(key, list[0], &content); err != nil || len(content.Password) != 24 || strings.ContainsAny(content.Password, "!#$%&") {
+		t.Fatalf("generated password %q: %v", content.Password, err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	key := unlockTestVault(t)
+	ts := newTestBackend(t)
+
+	ids := map[string]string{}
+	for site, pw := range map[string]string{
+		"mail.example": "x7#Kq!9vR2@mTz5",
+		"bank.example": "x7#Kq!9vR2@mTz5",
+		"shop.example": "Summer2023!",
+		"blog.example": "summer2024",
+		"safe.example": "r4$Wt^nB7&ycL1p",
+	} {
+		rec, err := fieldsRecord(key, uuid.NewString(), models.RecordTypeLogin, map[string]string{"meta.site": site, "login": "alice", "password": pw})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[site] = postRecord(t, ts.URL, rec, "").ID
+	}
+
+	out, err := runCLI(t, ts.URL, "audit")
+	if err != nil || !strings.Contains(out, "Audited 5 logins: 2 weak, 2 reused, 2 similar, 0 stale") {
+		t.Fatalf("audit: %v %q", err, out)
+	}
+	if !strings.Contains(out, "reused with mail.example") || !strings.Contains(out, "weak; similar to shop.example") ||
+		strings.Contains(out, "safe.example") || strings.Contains(out, "x7#Kq") {
+		t.Fatalf("audit table: %q", out)
+	}
+
+	out, err = runCLI(t, offlineURL(t), "audit", "--format", "json", "--all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var findings []map[string]any
+	if err := json.Unmarshal([]byte(out[strings.Index(out, "["):strings.LastIndex(out, "]")+1]), &findings); err != nil || len(findings) != 5 {
+		t.Fatalf("audit json: %v %q", err, out)
+	}
+	for _, f := range findings {
+		if f["site"] == "bank.example" && fmt.Sprint(f["reused_with"]) != "["+ids["mail.example"]+"]" {
+			t.Fatalf("bank finding: %v", f)
+		}
+	}
+	if _, err := runCLI(t, ts.URL, "audit", "--format", "csv"); err == nil {
+		t.Fatal("unknown format must fail")
+	}
+}
+
+func TestTrash_RestoreAndEmpty(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	key := unlockTestVault(t)
+	ts := newTestBackend(t)
+
+	rec, _ := fieldsRecord(key, "33333333-3333-3333-3333-333333333333", models.RecordTypeText, map[string]string{"meta.title": "note", "text": "hello"})
+	postRecord(t, ts.URL, rec, "0")
+	other, _ := fieldsRecord(key, "44444444-4444-4444-4444-444444444444", models.RecordTypeText, map[string]string{"meta.title": "other", "text": "bye"})
+	postRecord(t, ts.URL, other, "0")
+	for _, id := range []string{rec.ID, other.ID} {
+		out, err := runCLI(t, ts.URL, "records", "delete", id)
+		if err != nil || !strings.Contains(out, "trash restore "+id) {
+			t.Fatalf("delete: %v %q", err, out)
+		}
+	}
+
+	out, err := runCLI(t, ts.URL, "trash", "list")
+	if err != nil || !strings.Contains(out, rec.ID) || !strings.Contains(out, "deleted_at") || strings.Contains(out, "payload") {
+		t.Fatalf("trash list: %v %q", err, out)
+	}
+	if out, err = runCLI(t, ts.URL, "trash", "restore", rec.ID); err != nil || !strings.Contains(out, "Restored") {
+		t.Fatalf("restore: %v %q", err, out)
+	}
+	out, err = runCLI(t, ts.URL, "records", "get", rec.ID)
+	if err != nil || !strings.Contains(out, `"text": "hello"`) {
+		t.Fatalf("restored record: %v %q", err, out)
+	}
+
+	if out, err = runCLIInput(t, ts.URL, "n\n", "trash", "empty"); err != nil || !strings.Contains(out, "Cancelled") {
+		t.Fatalf("cancelled empty: %v %q", err, out)
+	}
+	if out, err = runCLI(t, ts.URL, "trash", "empty", "--yes"); err != nil || !strings.Contains(out, "Removed 1 records") {
+		t.Fatalf("empty: %v %q", err, out)
+	}
+	if _, err := runCLI(t, ts.URL, "trash", "restore", other.ID); err == nil {
+		t.Fatal("restore from an emptied trash must fail")
+	}
+}
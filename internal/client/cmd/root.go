@@ -15,6 +15,10 @@ func NewRootCmd(version, buildDate string) *cobra.Command {
 	root.AddCommand(newVersionCmd(version, buildDate))
 	root.AddCommand(newAuthCmd(&serverURL))
 	root.AddCommand(newRecordsCmd(&serverURL))
-	root.AddCommand(newVaultCmd())
+	root.AddCommand(newTrashCmd(&serverURL))
+	root.AddCommand(newVaultCmd(&serverURL))
+	root.AddCommand(newSSHAgentCmd(&serverURL))
+	root.AddCommand(newGenerateCmd())
+	root.AddCommand(newAuditCmd(&serverURL))
 	return root
 }
@@ -3,7 +3,9 @@ package cmd
 import (
 	"bytes"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -14,6 +16,8 @@ func withTempHome(t *testing.T) func() {
 	oldUSERPROFILE, hadUSERPROFILE := os.LookupEnv("USERPROFILE")
 	os.Setenv("HOME", dir)
 	os.Setenv("USERPROFILE", dir)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, ".config"))
+	t.Setenv("APPDATA", filepath.Join(dir, "AppData"))
 	if runtime.GOOS == "windows" {
 		os.Setenv("HOMEDRIVE", "")
 		os.Setenv("HOMEPATH", "")
@@ -48,14 +52,71 @@ func TestRoot_VersionAndVault(t *testing.T) {
 		t.Fatalf("no version output")
 	}
 
-	// vault init + status
+	// vault init + unlock + status
 	out.Reset()
+	root.SetIn(strings.NewReader("master\nmaster\n"))
 	root.SetArgs([]string{"vault", "init"})
 	if err := root.Execute(); err != nil {
 		t.Fatal(err)
 	}
+	out.Reset()
+	root.SetIn(strings.NewReader("master\n"))
+	root.SetArgs([]string{"vault", "unlock", "--raw"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	t.Setenv("GOPHKEEPER_SESSION", strings.TrimSpace(lines[len(lines)-1]))
+	out.Reset()
+	root.SetArgs([]string{"vault", "status"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "unlocked until") {
+		t.Fatalf("status: %q", out.String())
+	}
+	out.Reset()
+	root.SetArgs([]string{"vault", "lock"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
 	root.SetArgs([]string{"vault", "status"})
 	if err := root.Execute(); err != nil {
 		t.Fatal(err)
 	}
+	if !strings.Contains(out.String(), "locked") || strings.Contains(out.String(), "unlocked") {
+		t.Fatalf("status after lock: %q", out.String())
+	}
+}
+
+func TestVault_InitRejectsMismatchedPasswords(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	root := NewRootCmd("1.0.0", "2025-08-13")
+	root.SetOut(new(bytes.Buffer))
+	root.SetIn(strings.NewReader("one\ntwo\n"))
+	root.SetArgs([]string{"vault", "init"})
+	if err := root.Execute(); err == nil {
+		t.Fatal("expected mismatch error")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	out, err := runCLI(t, offlineURL(t), "generate", "--profile", "pin", "--length", "8")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if err != nil || len(lines) != 2 || len(lines[0]) != 8 || strings.Trim(lines[0], "0123456789") != "" || !strings.Contains(lines[1], "~27 bits (weak)") {
+		t.Fatalf("pin: %v %q", err, out)
+	}
+	out, err = runCLI(t, offlineURL(t), "generate", "--symbols=false", "--no-ambiguous")
+	if err != nil || strings.ContainsAny(strings.Split(out, "\n")[0], "!#$%0O1l") {
+		t.Fatalf("classes: %v %q", err, out)
+	}
+	out, err = runCLI(t, offlineURL(t), "generate", "--words", "4", "--separator", ".")
+	if err != nil || strings.Count(strings.Split(out, "\n")[0], ".") != 3 || !strings.Contains(out, "~40 bits (fair)") {
+		t.Fatalf("passphrase: %v %q", err, out)
+	}
+	if _, err := runCLI(t, offlineURL(t), "generate", "--profile", "nope"); err == nil {
+		t.Fatal("unknown profile must fail")
+	}
 }
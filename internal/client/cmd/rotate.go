@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/client/vault"
+	cryptohelper "gophkeeper/internal/shared/crypto"
+	"gophkeeper/internal/shared/models"
+)
+
+// rotate replaces the vault key: a new key is added to the keyring and
+// marked as the pending rotation, every record and trashed record still
+// encrypted with another key is re-encrypted and uploaded with If-Match, the
+// kept revisions encrypted with other keys are dropped, and finally the old
+// keys are removed from the keyring.
+// An interrupted rotation resumes on the next run, since the keyring keeps
+// the pending key id and the old keys until the rotation is complete.
+func (r *recordsClient) rotate(cmd *cobra.Command, args []string) error {
+	if _, err := vault.Load(); err != nil {
+		return err
+	}
+	password, err := promptPassword(cmd, "Master password: ")
+	if err != nil {
+		return err
+	}
+	kr, err := vault.Unwrap(password)
+	if err != nil {
+		return err
+	}
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx := cmd.Context()
+	if err := r.pull(cmd, c, false); err != nil {
+		return err
+	}
+	pending, err := c.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d queued changes must be synced first, run `gophkeeper records sync`", len(pending))
+	}
+	token, err := ensureAccessToken()
+	if err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+	if kr.Rotating == "" {
+		if kr, err = vault.Rotate(password); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Generated new vault key", kr.CurrentID)
+	} else {
+		fmt.Fprintln(out, "Resuming rotation to vault key", kr.CurrentID)
+	}
+	// Other devices need the new key before they meet re-encrypted records.
+	if err := r.updateKeyEscrow(token); err != nil {
+		return err
+	}
+
+	trashed, err := r.rotateTrash(cmd, c, token, kr)
+	if err != nil {
+		return fmt.Errorf("rotation interrupted, run `gophkeeper vault rotate` again to resume: %w", err)
+	}
+	recs, err := c.ListRecords(ctx)
+	if err != nil {
+		return err
+	}
+	var rotated int
+	for _, rec := range recs {
+		done, err := r.reencrypt(cmd, c, token, kr, rec)
+		if err != nil {
+			return fmt.Errorf("rotation interrupted, run `gophkeeper vault rotate` again to resume: %w", err)
+		}
+		if done {
+			rotated++
+		}
+	}
+	dropped, err := r.dropStaleRevisions(token, kr, recs)
+	if err != nil {
+		return fmt.Errorf("rotation interrupted, run `gophkeeper vault rotate` again to resume: %w", err)
+	}
+
+	if kr, err = vault.Prune(password); err != nil {
+		return err
+	}
+	if err := r.updateKeyEscrow(token); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Re-encrypted %d records, vault key is now %s\n", rotated, kr.CurrentID)
+	if trashed > 0 {
+		fmt.Fprintf(out, "Re-encrypted %d records in the trash\n", trashed)
+	}
+	if dropped > 0 {
+		fmt.Fprintf(out, "Dropped the history of %d records kept under the old key\n", dropped)
+	}
+	return nil
+}
+
+// rotateTrash re-encrypts the trashed records still encrypted with another
+// key: each is restored, re-encrypted and deleted again, and the revisions
+// this leaves behind are dropped. Records purged meanwhile are skipped.
+func (r *recordsClient) rotateTrash(cmd *cobra.Command, c *cache.Cache, token string, kr *vault.Keyring) (int, error) {
+	items, err := r.trash(token)
+	if err != nil {
+		return 0, err
+	}
+	var rotated int
+	for _, it := range items {
+		if id, ok := payloadKeyID(kr, it.Record); ok && id == kr.CurrentID {
+			continue
+		}
+		rec, err := r.undelete(token, it.ID)
+		var se *statusError
+		if errors.As(err, &se) && se.code == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return rotated, err
+		}
+		if _, err := r.reencrypt(cmd, c, token, kr, rec); err != nil {
+			return rotated, err
+		}
+		if err := r.remove(token, it.ID); err != nil && !(errors.As(err, &se) && se.code == http.StatusNotFound) {
+			return rotated, err
+		}
+		if err := c.DeleteRecord(cmd.Context(), it.ID); err != nil {
+			return rotated, err
+		}
+		if err := r.dropRevisions(token, it.ID); err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
+// dropStaleRevisions drops the kept revisions of recs when any of them is
+// encrypted with a key other than the current one, so that the old keys can
+// be removed. It returns the number of records whose history was dropped.
+func (r *recordsClient) dropStaleRevisions(token string, kr *vault.Keyring, recs []models.Record) (int, error) {
+	var dropped int
+	for _, rec := range recs {
+		revs, err := r.revisions(token, rec.ID)
+		var se *statusError
+		if errors.As(err, &se) && se.code == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return dropped, err
+		}
+		for _, rev := range revs {
+			if id, ok := payloadKeyID(kr, rev); !ok || id != kr.CurrentID {
+				if err := r.dropRevisions(token, rec.ID); err != nil {
+					return dropped, err
+				}
+				dropped++
+				break
+			}
+		}
+	}
+	return dropped, nil
+}
+
+// payloadKeyID returns the id of the key rec is encrypted with, trying the
+// keys of the keyring for legacy payloads that do not name it.
+func payloadKeyID(kr *vault.Keyring, rec models.Record) (string, bool) {
+	if h, ok := cryptohelper.ParseHeader(rec.Payload); ok {
+		if _, found := kr.Lookup(h.KeyID); found {
+			return h.KeyID, true
+		}
+	}
+	if id, ok := rec.Meta[keyIDMeta]; ok {
+		return id, true
+	}
+	for _, key := range kr.Keys {
+		if _, err := cryptohelper.Open(key.Key, rec.Payload, recordAAD(rec)); err == nil {
+			return key.ID, true
+		}
+	}
+	return "", false
+}
+
+// reencrypt moves rec to the current key. On version conflicts it retries
+// with the server copy; records deleted meanwhile are skipped.
+func (r *recordsClient) reencrypt(cmd *cobra.Command, c *cache.Cache, token string, kr *vault.Keyring, rec models.Record) (bool, error) {
+	ctx := cmd.Context()
+	for {
+		if rec.Meta[keyIDMeta] == kr.CurrentID {
+			return false, nil
+		}
+		var stored models.Record
+		var err error
+		if rec.ContentID != "" {
+			stored, err = r.reencryptContent(cmd, token, kr, rec)
+		} else {
+			var plaintext []byte
+			if plaintext, err = openRecord(kr, rec); err != nil {
+				return false, fmt.Errorf("record %s: %w", rec.ID, err)
+			}
+			updated := models.Record{ID: rec.ID, Type: rec.Type, Meta: copyMeta(rec.Meta)}
+			if err := sealRecord(kr, &updated, plaintext); err != nil {
+				return false, err
+			}
+			stored, err = r.upload(token, "rotate", updated, rec.Version)
+		}
+		var ce *conflictError
+		if errors.As(err, &ce) {
+			if ce.current == nil {
+				return false, c.DeleteRecord(ctx, rec.ID)
+			}
+			rec = *ce.current
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, c.PutRecord(ctx, stored)
+	}
+}
+
+// reencryptContent downloads and decrypts the streamed content of rec into a
+// temporary file and uploads it again under the current key.
+func (r *recordsClient) reencryptContent(cmd *cobra.Command, token string, kr *vault.Keyring, rec models.Record) (models.Record, error) {
+	dir, err := os.MkdirTemp("", "gophkeeper-rotate-")
+	if err != nil {
+		return models.Record{}, err
+	}
+	defer os.RemoveAll(dir)
+	plain := filepath.Join(dir, "content")
+	defer os.Remove(uploadStatePath(plain))
+	if err := r.downloadContent(token, kr, rec, plain); err != nil {
+		return models.Record{}, fmt.Errorf("record %s: %w", rec.ID, err)
+	}
+	updated := models.Record{ID: rec.ID, Type: rec.Type, Meta: copyMeta(rec.Meta)}
+	return r.uploadContent(cmd, token, kr, updated, plain, rec.Version)
+}
+
+// updateKeyEscrow replaces the escrowed key with the local envelope when the
+// user keeps one on the server.
+func (r *recordsClient) updateKeyEscrow(token string) error {
+	escrow, err := getKeyEscrow(*r.serverURL, token)
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	blob, err := vault.Envelope()
+	if err != nil {
+		return err
+	}
+	return putKeyEscrow(*r.serverURL, token, blob, strconv.FormatInt(escrow.Version, 10))
+}
@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gophkeeper/internal/client/vault"
+	cryptohelper "gophkeeper/internal/shared/crypto"
+	"gophkeeper/internal/shared/models"
+)
+
+func TestVault_RotateReencryptsRecords(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	kr := unlockTestVault(t)
+	oldID := kr.CurrentID
+	ts := newTestBackend(t)
+	if _, err := runCLI(t, ts.URL, "vault", "push-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	login, err := fieldsRecord(kr, "22222222-2222-2222-2222-222222222222", models.RecordTypeLogin, map[string]string{"meta.site": "example.com", "login": "alice", "password": "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postRecord(t, ts.URL, login, "0")
+	// a record written before key ids existed
+	legacy := models.Record{ID: "33333333-3333-3333-3333-333333333333", Type: models.RecordTypeText, Meta: map[string]string{"title": "note"}}
+	if legacy.Payload, err = cryptohelper.EncryptAESGCM(kr.Current().Key, []byte(`{"text":"hello"}`), recordAAD(legacy)); err != nil {
+		t.Fatal(err)
+	}
+	postRecord(t, ts.URL, legacy, "0")
+
+	out, err := runCLIInput(t, ts.URL, "master\n", "vault", "rotate")
+	if err != nil || !strings.Contains(out, "Re-encrypted 2 records") {
+		t.Fatalf("rotate: %v %q", err, out)
+	}
+	kr, err = vault.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kr.Lookup(oldID); kr.CurrentID == oldID || len(kr.Keys) != 1 || ok || kr.Rotating != "" {
+		t.Fatalf("unexpected keyring after rotation: %+v", kr)
+	}
+	for _, id := range []string{login.ID, legacy.ID} {
+		out, err := runCLI(t, ts.URL, "records", "get", id)
+		if err != nil {
+			t.Fatalf("get %s after rotation: %v %q", id, err, out)
+		}
+		var got struct {
+			Meta map[string]string `json:"meta"`
+		}
+		_ = json.Unmarshal([]byte(out), &got)
+		if got.Meta[keyIDMeta] != kr.CurrentID {
+			t.Fatalf("record %s not re-encrypted: %q", id, out)
+		}
+	}
+
+	// the escrowed key follows the rotation
+	token, _ := loadToken()
+	escrow, err := getKeyEscrow(ts.URL, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if escrow.Version < 2 {
+		t.Fatalf("escrow not updated: version %d", escrow.Version)
+	}
+}
+
+func TestVault_RotateResumes(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	kr := unlockTestVault(t)
+	ts := newTestBackend(t)
+	rec, err := fieldsRecord(kr, "44444444-4444-4444-4444-444444444444", models.RecordTypeText, map[string]string{"meta.title": "t", "text": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postRecord(t, ts.URL, rec, "0")
+
+	// an earlier run generated the new key and stopped before re-encrypting
+	next, err := vault.Rotate([]byte("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := runCLIInput(t, ts.URL, "master\n", "vault", "rotate")
+	if err != nil || !strings.Contains(out, "Resuming rotation to vault key "+next.CurrentID) || !strings.Contains(out, "Re-encrypted 1 records") {
+		t.Fatalf("resume: %v %q", err, out)
+	}
+	if out, err := runCLI(t, ts.URL, "records", "get", rec.ID); err != nil || !strings.Contains(out, next.CurrentID) {
+		t.Fatalf("get after resume: %v %q", err, out)
+	}
+	if kr, err := vault.Load(); err != nil || len(kr.Keys) != 1 || kr.Rotating != "" {
+		t.Fatalf("keyring after resume: %+v %v", kr, err)
+	}
+}
+
+func TestVault_RotateAfterRestore(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	kr := unlockTestVault(t)
+	ts := newTestBackend(t)
+	id := "55555555-5555-5555-5555-555555555555"
+	post := func(pw string, ifMatch int) {
+		t.Helper()
+		rec, err := fieldsRecord(kr, id, models.RecordTypeLogin, map[string]string{"meta.site": "example.com", "login": "alice", "password": pw})
+		if err != nil {
+			t.Fatal(err)
+		}
+		postRecord(t, ts.URL, rec, strconv.Itoa(ifMatch))
+	}
+	post("first", 0)
+	post("second", 1)
+
+	// the re-encryption leaves version 3 and drops the history under the old key
+	out, err := runCLIInput(t, ts.URL, "master\n", "vault", "rotate")
+	if err != nil || !strings.Contains(out, "Re-encrypted 1 records") || !strings.Contains(out, "Dropped the history of 1 records") {
+		t.Fatalf("rotate: %v %q", err, out)
+	}
+	if kr, err = vault.Load(); err != nil || len(kr.Keys) != 1 || kr.Rotating != "" {
+		t.Fatalf("keyring after rotation: %+v %v", kr, err)
+	}
+	post("third", 3)
+	if out, err := runCLI(t, ts.URL, "records", "restore", id, "--version", "3"); err != nil {
+		t.Fatalf("restore: %v %q", err, out)
+	}
+
+	// a restored revision must not be mistaken for an interrupted rotation
+	firstID := kr.CurrentID
+	out, err = runCLIInput(t, ts.URL, "master\n", "vault", "rotate")
+	if err != nil || !strings.Contains(out, "Generated new vault key") {
+		t.Fatalf("second rotate: %v %q", err, out)
+	}
+	if kr, err = vault.Load(); err != nil || kr.CurrentID == firstID || len(kr.Keys) != 1 || kr.Rotating != "" {
+		t.Fatalf("keyring after second rotation: %+v %v", kr, err)
+	}
+	out, err = runCLI(t, ts.URL, "records", "get", id)
+	if err != nil || !strings.Contains(out, `"password": "second"`) || !strings.Contains(out, kr.CurrentID) {
+		t.Fatalf("get after second rotation: %v %q", err, out)
+	}
+	if out, err := runCLI(t, ts.URL, "records", "history", id); err != nil || strings.Contains(out, "unknown key") {
+		t.Fatalf("history after second rotation: %v %q", err, out)
+	}
+}
+
+func TestRecords_HistoryUnknownKey(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	kr := unlockTestVault(t)
+	ts := newTestBackend(t)
+	id := "77777777-7777-7777-7777-777777777777"
+	rec, err := fieldsRecord(kr, id, models.RecordTypeLogin, map[string]string{"meta.site": "example.com", "login": "alice", "password": "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postRecord(t, ts.URL, rec, "0")
+
+	// the key of the first version is lost
+	if _, err := vault.Rotate([]byte("master")); err != nil {
+		t.Fatal(err)
+	}
+	if kr, err = vault.Prune([]byte("master")); err != nil {
+		t.Fatal(err)
+	}
+	for i, pw := range []string{"second", "third"} {
+		if rec, err = fieldsRecord(kr, id, models.RecordTypeLogin, map[string]string{"meta.site": "example.com", "login": "alice", "password": pw}); err != nil {
+			t.Fatal(err)
+		}
+		postRecord(t, ts.URL, rec, strconv.Itoa(i+1))
+	}
+
+	out, err := runCLI(t, ts.URL, "records", "history", id)
+	if err != nil || !strings.Contains(out, "unknown key") || !strings.Contains(out, `"password": "second"`) {
+		t.Fatalf("history with a lost key: %v %q", err, out)
+	}
+}
+
+func TestVault_RotateKeepsTrashRestorable(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	kr := unlockTestVault(t)
+	oldID := kr.CurrentID
+	ts := newTestBackend(t)
+	rec, err := fieldsRecord(kr, "66666666-6666-6666-6666-666666666666", models.RecordTypeText, map[string]string{"meta.title": "t", "text": "trashed note"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postRecord(t, ts.URL, rec, "0")
+	if out, err := runCLI(t, ts.URL, "records", "delete", rec.ID); err != nil {
+		t.Fatalf("delete: %v %q", err, out)
+	}
+
+	out, err := runCLIInput(t, ts.URL, "master\n", "vault", "rotate")
+	if err != nil || !strings.Contains(out, "Re-encrypted 1 records in the trash") {
+		t.Fatalf("rotate: %v %q", err, out)
+	}
+	if kr, err = vault.Load(); err != nil || kr.CurrentID == oldID || len(kr.Keys) != 1 {
+		t.Fatalf("keyring after rotation: %+v %v", kr, err)
+	}
+	if out, err := runCLI(t, ts.URL, "records", "get", rec.ID); err == nil {
+		t.Fatalf("rotation left the trashed record live: %q", out)
+	}
+	if out, err := runCLI(t, ts.URL, "trash", "restore", rec.ID); err != nil {
+		t.Fatalf("trash restore: %v %q", err, out)
+	}
+	if out, err := runCLI(t, ts.URL, "records", "get", rec.ID); err != nil || !strings.Contains(out, "trashed note") {
+		t.Fatalf("get restored record: %v %q", err, out)
+	}
+	if out, err := runCLI(t, ts.URL, "records", "sync"); err != nil {
+		t.Fatalf("sync: %v %q", err, out)
+	}
+}
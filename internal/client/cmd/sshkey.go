@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/client/vault"
+	"gophkeeper/internal/shared/models"
+)
+
+// addSSHKey stores a private key file together with its public key. An
+// encrypted key is checked against the prompted passphrase, which is kept in
+// the record so that ssh-agent can load the key unattended.
+func (r *recordsClient) addSSHKey(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	path := args[0]
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	content := models.SSHKeyContent{PrivateKey: string(pemBytes)}
+	if _, err := ssh.ParseRawPrivateKey(pemBytes); err != nil {
+		var missing *ssh.PassphraseMissingError
+		if !errors.As(err, &missing) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		pass, err := promptPassword(cmd, "Passphrase for "+path+": ")
+		if err != nil {
+			return err
+		}
+		content.Passphrase = string(pass)
+	}
+	key, err := parseSSHKey(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	content.Comment, _ = cmd.Flags().GetString("comment")
+	if content.Comment == "" {
+		content.Comment = pubKeyComment(path + ".pub")
+	}
+	if content.PublicKey, err = authorizedKey(key, content.Comment); err != nil {
+		return err
+	}
+	name, _ := cmd.Flags().GetString("name")
+	if name == "" {
+		name = filepath.Base(path)
+	}
+	rec, err := sealSSHKey(kr, name, content)
+	if err != nil {
+		return err
+	}
+	return r.store(cmd, "add-ssh-key", rec, 0)
+}
+
+// generateSSHKey creates a key pair in the vault only and prints the public
+// key for authorized_keys.
+func (r *recordsClient) generateSSHKey(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	name, _ := cmd.Flags().GetString("name")
+	if name == "" {
+		return errors.New("--name is required")
+	}
+	comment, _ := cmd.Flags().GetString("comment")
+	var key any
+	switch typ, _ := cmd.Flags().GetString("type"); typ {
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		bits, _ := cmd.Flags().GetInt("bits")
+		if bits < 2048 {
+			return errors.New("--bits must be at least 2048")
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+	default:
+		return fmt.Errorf("unsupported key type %q, want ed25519 or rsa", typ)
+	}
+	if err != nil {
+		return err
+	}
+	block, err := ssh.MarshalPrivateKey(key, comment)
+	if err != nil {
+		return err
+	}
+	content := models.SSHKeyContent{PrivateKey: string(pem.EncodeToMemory(block)), Comment: comment}
+	if content.PublicKey, err = authorizedKey(key, comment); err != nil {
+		return err
+	}
+	rec, err := sealSSHKey(kr, name, content)
+	if err != nil {
+		return err
+	}
+	if err := r.store(cmd, "generate-ssh-key", rec, 0); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), content.PublicKey)
+	return nil
+}
+
+// authorizedKey returns the authorized_keys line of the public half of key.
+func authorizedKey(key any, comment string) (string, error) {
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line, nil
+}
+
+// sealSSHKey encrypts content into a new ssh_key record.
+func sealSSHKey(kr *vault.Keyring, name string, content models.SSHKeyContent) (models.Record, error) {
+	pbytes, _ := json.Marshal(content)
+	rec := models.Record{ID: uuid.NewString(), Type: models.RecordTypeSSHKey, Meta: map[string]string{"name": name}}
+	return rec, sealRecord(kr, &rec, pbytes)
+}
+
+// parseSSHKey decodes the private key of c, decrypting it with the stored
+// passphrase if needed.
+func parseSSHKey(c models.SSHKeyContent) (any, error) {
+	if c.Passphrase != "" {
+		return ssh.ParseRawPrivateKeyWithPassphrase([]byte(c.PrivateKey), []byte(c.Passphrase))
+	}
+	return ssh.ParseRawPrivateKey([]byte(c.PrivateKey))
+}
+
+// pubKeyComment returns the comment of the public key file at path, if any.
+func pubKeyComment(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	_, comment, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return ""
+	}
+	return comment
+}
+
+func newSSHAgentCmd(serverURL *string) *cobra.Command {
+	r := &recordsClient{serverURL: serverURL}
+	cmd := &cobra.Command{
+		Use:   "ssh-agent",
+		Short: "Serve the vault's SSH keys to ssh over the ssh-agent protocol",
+		Long: "Decrypts the ssh_key records in memory and serves them on a Unix socket until interrupted.\n" +
+			"Point ssh at it with the printed SSH_AUTH_SOCK; keys are never written to disk.",
+		Args: cobra.NoArgs,
+		RunE: r.sshAgent,
+	}
+	cmd.Flags().String("socket", "", "Socket path, a new private temporary directory by default")
+	return cmd
+}
+
+// sshAgent loads the SSH keys of the vault into an in-memory keyring and
+// serves it until the command context is done or the process is signalled.
+func (r *recordsClient) sshAgent(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	keyring, n, err := r.agentKeyring(cmd, kr)
+	if err != nil {
+		return err
+	}
+
+	path, _ := cmd.Flags().GetString("socket")
+	if path == "" {
+		dir, err := os.MkdirTemp("", "gophkeeper-agent-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		path = filepath.Join(dir, "agent.sock")
+	} else if err := checkSocketDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	ln, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	fmt.Fprintf(cmd.OutOrStdout(), "SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", path)
+	fmt.Fprintf(cmd.ErrOrStderr(), "Serving %d SSH keys, interrupt to stop\n", n)
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return serveAgent(ctx, ln, keyring)
+}
+
+// checkSocketDir refuses a socket directory that other users can write to,
+// where they could replace the socket or race its creation.
+func checkSocketDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("--socket directory %s is writable by other users, choose a private one", dir)
+	}
+	return nil
+}
+
+// agentKeyring decrypts the ssh_key records, refreshed from the server when
+// it is reachable, into a new keyring and returns it with the number of keys.
+func (r *recordsClient) agentKeyring(cmd *cobra.Command, kr *vault.Keyring) (agent.Agent, int, error) {
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer c.Close()
+	if err := r.pull(cmd, c, false); errors.Is(err, errOffline) {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Server unreachable, using cached keys")
+	} else if err != nil {
+		return nil, 0, err
+	}
+	recs, err := c.ListRecords(cmd.Context())
+	if err != nil {
+		return nil, 0, err
+	}
+	keyring := agent.NewKeyring()
+	n := 0
+	for _, rec := range recs {
+		if rec.Type != models.RecordTypeSSHKey {
+			continue
+		}
+		var content models.SSHKeyContent
+		if err := openContent(kr, rec, &content); err != nil {
+			return nil, 0, fmt.Errorf("record %s: %w", rec.ID, err)
+		}
+		key, err := parseSSHKey(content)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Skipping SSH key %s (%s): %v\n", rec.Meta["name"], rec.ID, err)
+			continue
+		}
+		comment := content.Comment
+		if comment == "" {
+			comment = rec.Meta["name"]
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key, Comment: comment}); err != nil {
+			return nil, 0, err
+		}
+		n++
+	}
+	return keyring, n, nil
+}
+
+// serveAgent answers ssh-agent requests on ln until ctx is done.
+func serveAgent(ctx context.Context, ln net.Listener, keyring agent.Agent) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			_ = agent.ServeAgent(keyring, conn)
+		}()
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package cmd
+
+import (
+	"net"
+	"os"
+)
+
+// listenPrivate creates the agent socket at path and restricts it to the
+// owner where the platform supports file modes.
+func listenPrivate(path string) (net.Listener, error) {
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package cmd
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate creates the agent socket at path with mode 0600 from the
+// start, so that nobody else can connect before its mode is fixed.
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0o177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
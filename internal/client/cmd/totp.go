@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"gophkeeper/internal/client/totp"
+	"gophkeeper/internal/client/vault"
+	"gophkeeper/internal/shared/models"
+)
+
+// addTOTP stores the key of an otpauth:// URI, read from the argument or
+// prompted for, as a totp record labelled with its issuer and account.
+func (r *recordsClient) addTOTP(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	var uri string
+	if len(args) == 1 {
+		uri = args[0]
+	} else if uri, err = r.prompt(cmd).ask("otpauth URI: "); err != nil {
+		return err
+	}
+	key, err := totp.Parse(uri)
+	if err != nil {
+		return err
+	}
+	pbytes, _ := json.Marshal(key.TOTPContent)
+	rec := models.Record{ID: uuid.NewString(), Type: models.RecordTypeTOTP, Meta: map[string]string{"issuer": key.Issuer, "account": key.Account}}
+	if err := sealRecord(kr, &rec, pbytes); err != nil {
+		return err
+	}
+	return r.store(cmd, "add-totp", rec, 0)
+}
+
+// totp prints the current code of a totp record and how long it stays
+// valid. The code is computed locally, so it also works offline.
+func (r *recordsClient) totp(cmd *cobra.Command, args []string) error {
+	kr, err := vault.Load()
+	if err != nil {
+		return err
+	}
+	rec, err := r.load(cmd, args[0])
+	if err != nil {
+		return err
+	}
+	if rec.Type != models.RecordTypeTOTP {
+		return fmt.Errorf("record %s is a %s record, not totp", rec.ID, rec.Type)
+	}
+	var key models.TOTPContent
+	if err := openContent(kr, rec, &key); err != nil {
+		return err
+	}
+	code, left, err := totp.Code(key, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s (valid for %ds)\n", code, int(left/time.Second))
+	return nil
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gophkeeper/internal/client/cache"
+	"gophkeeper/internal/shared/models"
+)
+
+func newTrashCmd(serverURL *string) *cobra.Command {
+	r := &recordsClient{serverURL: serverURL}
+	cmd := &cobra.Command{Use: "trash", Short: "Restore or permanently remove deleted records"}
+	cmd.AddCommand(&cobra.Command{Use: "list", Short: "List deleted records that can be restored", RunE: r.trashList})
+	cmd.AddCommand(&cobra.Command{Use: "restore <id>", Short: "Restore a deleted record", Args: cobra.ExactArgs(1), RunE: r.trashRestore})
+	empty := &cobra.Command{Use: "empty", Short: "Permanently remove all deleted records", RunE: r.trashEmpty}
+	empty.Flags().Bool("yes", false, "Do not ask for confirmation")
+	cmd.AddCommand(empty)
+	return cmd
+}
+
+// trashList prints the trashed records without their payloads.
+func (r *recordsClient) trashList(cmd *cobra.Command, args []string) error {
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	token, err := r.connect(cmd, c, false)
+	if err != nil {
+		return err
+	}
+	items, err := r.trash(token)
+	if err != nil {
+		return err
+	}
+	out := make([]map[string]any, 0, len(items))
+	for _, it := range items {
+		out = append(out, map[string]any{"id": it.ID, "type": it.Type, "meta": it.Meta, "version": it.Version, "deleted_at": it.DeletedAt})
+	}
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// trash fetches the trashed records with their payloads.
+func (r *recordsClient) trash(token string) ([]models.TrashedRecord, error) {
+	req, _ := http.NewRequest("GET", *r.serverURL+"/api/v1/trash", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, &statusError{op: "trash list", code: resp.StatusCode, status: resp.Status}
+	}
+	var items []models.TrashedRecord
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// trashRestore stores a trashed record again and caches it.
+func (r *recordsClient) trashRestore(cmd *cobra.Command, args []string) error {
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	token, err := r.connect(cmd, c, false)
+	if err != nil {
+		return err
+	}
+	rec, err := r.undelete(token, args[0])
+	if err != nil {
+		return err
+	}
+	if err := c.PutRecord(cmd.Context(), rec); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Restored", rec.ID)
+	return nil
+}
+
+// undelete stores the trashed record id again and returns it.
+func (r *recordsClient) undelete(token, id string) (models.Record, error) {
+	req, _ := http.NewRequest("POST", *r.serverURL+"/api/v1/trash/"+id+"/restore", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return models.Record{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return models.Record{}, &statusError{op: "trash restore", code: resp.StatusCode, status: resp.Status}
+	}
+	var rec models.Record
+	if err := json.NewDecoder(resp.Body).Decode(&rec); err != nil {
+		return models.Record{}, err
+	}
+	return rec, nil
+}
+
+// trashEmpty permanently removes the trashed records after confirmation.
+func (r *recordsClient) trashEmpty(cmd *cobra.Command, args []string) error {
+	if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+		answer, err := r.prompt(cmd).ask("Permanently remove all records from the trash? [y/N]: ")
+		if err != nil {
+			return err
+		}
+		if a := strings.ToLower(answer); a != "y" && a != "yes" {
+			fmt.Fprintln(cmd.OutOrStdout(), "Cancelled")
+			return nil
+		}
+	}
+	c, err := cache.Open(cache.Path())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	token, err := r.connect(cmd, c, false)
+	if err != nil {
+		return err
+	}
+	req, _ := http.NewRequest("DELETE", *r.serverURL+"/api/v1/trash", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &statusError{op: "trash empty", code: resp.StatusCode, status: resp.Status}
+	}
+	var body struct {
+		Deleted int64 `json:"deleted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Removed %d records from the trash\n", body.Deleted)
+	return nil
+}
@@ -1,28 +1,249 @@
 package cmd
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/spf13/cobra"
 	"gophkeeper/internal/client/vault"
+	cryptohelper "gophkeeper/internal/shared/crypto"
+	"gophkeeper/internal/shared/models"
 )
 
-func newVaultCmd() *cobra.Command {
+func newVaultCmd(serverURL *string) *cobra.Command {
 	cmd := &cobra.Command{Use: "vault", Short: "Manage local vault key"}
-	cmd.AddCommand(&cobra.Command{Use: "init", Short: "Generate local vault key", RunE: func(cmd *cobra.Command, args []string) error {
-		_, err := vault.Generate()
-		if err != nil {
+	cmd.AddCommand(&cobra.Command{Use: "init", Short: "Generate vault key protected by a master password", RunE: vaultInit})
+	unlock := &cobra.Command{Use: "unlock", Short: "Unlock vault key for a session", RunE: vaultUnlock}
+	unlock.Flags().Duration("timeout", 15*time.Minute, "Session lifetime")
+	unlock.Flags().Bool("raw", false, "Print only the session secret")
+	cmd.AddCommand(unlock)
+	push := &cobra.Command{Use: "push-key", Short: "Upload password-wrapped vault key to the server", RunE: func(cmd *cobra.Command, args []string) error {
+		return vaultPushKey(cmd, *serverURL)
+	}}
+	push.Flags().Bool("force", false, "Replace a key already stored on the server")
+	cmd.AddCommand(push)
+	pull := &cobra.Command{Use: "pull-key", Short: "Download vault key stored on the server", RunE: func(cmd *cobra.Command, args []string) error {
+		return vaultPullKey(cmd, *serverURL)
+	}}
+	pull.Flags().Bool("force", false, "Replace the local vault key")
+	cmd.AddCommand(pull)
+	r := &recordsClient{serverURL: serverURL}
+	cmd.AddCommand(&cobra.Command{Use: "rotate", Short: "Generate a new vault key and re-encrypt all records", RunE: r.rotate})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "cipher [aes-256-gcm|xchacha20-poly1305]",
+		Short: "Show or select the cipher for new records",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  vaultCipher,
+	})
+	cmd.AddCommand(&cobra.Command{Use: "lock", Short: "Forget unlocked vault key", RunE: func(cmd *cobra.Command, args []string) error {
+		if err := vault.Lock(); err != nil {
 			return err
 		}
-		fmt.Fprintln(cmd.OutOrStdout(), "Vault key generated at", vault.Path())
+		fmt.Fprintln(cmd.OutOrStdout(), "Vault locked")
 		return nil
 	}})
 	cmd.AddCommand(&cobra.Command{Use: "status", Short: "Show vault status", Run: func(cmd *cobra.Command, args []string) {
-		if vault.Exists() {
-			fmt.Fprintln(cmd.OutOrStdout(), "Vault: ready")
-		} else {
+		switch {
+		case !vault.Exists() && vault.LegacyExists():
+			fmt.Fprintln(cmd.OutOrStdout(), "Vault: unprotected legacy key, run `gophkeeper vault init` to set a master password")
+		case !vault.Exists():
 			fmt.Fprintln(cmd.OutOrStdout(), "Vault: not initialized")
+		default:
+			if exp, ok := vault.SessionExpiry(); ok {
+				fmt.Fprintln(cmd.OutOrStdout(), "Vault: unlocked until", exp.Local().Format(time.RFC3339))
+			} else {
+				fmt.Fprintln(cmd.OutOrStdout(), "Vault: locked")
+			}
 		}
 	}})
 	return cmd
 }
+
+func vaultInit(cmd *cobra.Command, args []string) error {
+	if vault.Exists() {
+		return errors.New("vault key already exists")
+	}
+	password, err := promptNewPassword(cmd)
+	if err != nil {
+		return err
+	}
+	if vault.LegacyExists() {
+		if _, err := vault.Migrate(password); err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Existing vault key protected with master password at", vault.Path())
+		return nil
+	}
+	if _, err := vault.Generate(password); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Vault key generated at", vault.Path())
+	fmt.Fprintln(cmd.OutOrStdout(), "Run `gophkeeper vault push-key` to make it available on other devices")
+	return nil
+}
+
+func vaultUnlock(cmd *cobra.Command, args []string) error {
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	raw, _ := cmd.Flags().GetBool("raw")
+	password, err := promptPassword(cmd, "Master password: ")
+	if err != nil {
+		return err
+	}
+	secret, expires, err := vault.Unlock(password, timeout)
+	if err != nil {
+		return err
+	}
+	if raw {
+		fmt.Fprintln(cmd.OutOrStdout(), secret)
+		return nil
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Vault unlocked until", expires.Local().Format(time.RFC3339))
+	fmt.Fprintln(cmd.OutOrStdout(), "To use the session, set the environment variable:")
+	fmt.Fprintf(cmd.OutOrStdout(), "  export %s=%q                (Linux/macOS)\n", vault.SessionEnv, secret)
+	fmt.Fprintf(cmd.OutOrStdout(), "  $env:%s=%q                  (PowerShell)\n", vault.SessionEnv, secret)
+	return nil
+}
+
+// vaultCipher prints the vault's cipher or switches it. Records already
+// stored keep their cipher until they are next written.
+func vaultCipher(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		kr, err := vault.Load()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), "Cipher:", kr.Algorithm())
+		return nil
+	}
+	alg, err := cryptohelper.ParseAlgorithm(args[0])
+	if err != nil {
+		return err
+	}
+	password, err := promptPassword(cmd, "Master password: ")
+	if err != nil {
+		return err
+	}
+	if _, err := vault.SetCipher(password, alg); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "New records will be encrypted with", alg)
+	fmt.Fprintln(cmd.OutOrStdout(), "Run `gophkeeper vault push-key --force` to share the setting with other devices")
+	return nil
+}
+
+// vaultPushKey uploads the envelope file. The key inside stays wrapped by the
+// master password, so the server never sees it in clear.
+func vaultPushKey(cmd *cobra.Command, serverURL string) error {
+	force, _ := cmd.Flags().GetBool("force")
+	blob, err := vault.Envelope()
+	if err != nil {
+		return fmt.Errorf("read vault key: %w", err)
+	}
+	token, err := ensureAccessToken()
+	if err != nil {
+		return err
+	}
+	ifMatch := "0"
+	if force {
+		ifMatch = ""
+	}
+	err = putKeyEscrow(serverURL, token, blob, ifMatch)
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusPreconditionFailed {
+		return errors.New("server already holds a vault key, use --force to replace it")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Vault key uploaded")
+	return nil
+}
+
+// vaultPullKey downloads the envelope stored by push-key and installs it
+// after checking the master password.
+func vaultPullKey(cmd *cobra.Command, serverURL string) error {
+	force, _ := cmd.Flags().GetBool("force")
+	if vault.Exists() && !force {
+		return errors.New("vault key already exists, use --force to replace it")
+	}
+	token, err := ensureAccessToken()
+	if err != nil {
+		return err
+	}
+	escrow, err := getKeyEscrow(serverURL, token)
+	var se *statusError
+	if errors.As(err, &se) && se.code == http.StatusNotFound {
+		return errors.New("no vault key stored on the server, run `gophkeeper vault push-key` on a device that has it")
+	}
+	if err != nil {
+		return err
+	}
+	password, err := promptPassword(cmd, "Master password: ")
+	if err != nil {
+		return err
+	}
+	if err := vault.Import(escrow.Blob, password, force); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Vault key installed at", vault.Path())
+	return nil
+}
+
+func getKeyEscrow(serverURL, token string) (models.KeyEscrow, error) {
+	req, _ := http.NewRequest("GET", serverURL+"/api/v1/keys/vault", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := doRequest(req)
+	if err != nil {
+		return models.KeyEscrow{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return models.KeyEscrow{}, &statusError{op: "pull key", code: resp.StatusCode, status: resp.Status}
+	}
+	var escrow models.KeyEscrow
+	err = json.NewDecoder(resp.Body).Decode(&escrow)
+	return escrow, err
+}
+
+// putKeyEscrow uploads blob; an empty ifMatch overwrites unconditionally.
+func putKeyEscrow(serverURL, token string, blob []byte, ifMatch string) error {
+	b, _ := json.Marshal(map[string][]byte{"blob": blob})
+	req, _ := http.NewRequest("PUT", serverURL+"/api/v1/keys/vault", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &statusError{op: "push key", code: resp.StatusCode, status: resp.Status}
+	}
+	return nil
+}
+
+// promptNewPassword asks for a new master password twice.
+func promptNewPassword(cmd *cobra.Command) ([]byte, error) {
+	password, err := promptPassword(cmd, "New master password: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, errors.New("master password must not be empty")
+	}
+	confirm, err := promptPassword(cmd, "Repeat master password: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(password, confirm) {
+		return nil, errors.New("passwords do not match")
+	}
+	return password, nil
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gophkeeper/internal/client/vault"
+	cryptohelper "gophkeeper/internal/shared/crypto"
+	"gophkeeper/internal/shared/models"
+)
+
+func TestVault_PushAndPullKey(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	ts := newTestBackend(t)
+	if _, err := runCLIInput(t, ts.URL, "master\nmaster\n", "vault", "init"); err != nil {
+		t.Fatal(err)
+	}
+	original, err := vault.Unwrap([]byte("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runCLI(t, ts.URL, "vault", "push-key"); err != nil {
+		t.Fatalf("push: %v %q", err, out)
+	}
+	if _, err := runCLI(t, ts.URL, "vault", "push-key"); err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("second push must require --force, got %v", err)
+	}
+
+	// A second device: fresh home with the same account.
+	token, err := loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup2 := withTempHome(t)
+	defer cleanup2()
+	if err := saveToken(token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCLIInput(t, ts.URL, "wrong\n", "vault", "pull-key"); err == nil || vault.Exists() {
+		t.Fatalf("pull with wrong password must fail without installing, got %v", err)
+	}
+	if out, err := runCLIInput(t, ts.URL, "master\n", "vault", "pull-key"); err != nil {
+		t.Fatalf("pull: %v %q", err, out)
+	}
+	pulled, err := vault.Unwrap([]byte("master"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pulled.Current().Key, original.Current().Key) {
+		t.Fatalf("pulled key differs from pushed key")
+	}
+	if _, err := runCLIInput(t, ts.URL, "master\n", "vault", "pull-key"); err == nil {
+		t.Fatalf("pull over an existing key must require --force")
+	}
+}
+
+func TestVault_CipherSelection(t *testing.T) {
+	cleanup := withTempHome(t)
+	defer cleanup()
+	unlockTestVault(t)
+	ts := newTestBackend(t)
+
+	if out, err := runCLIInput(t, ts.URL, "master\n", "vault", "cipher", "xchacha20-poly1305"); err != nil {
+		t.Fatalf("set cipher: %v %q", err, out)
+	}
+	if out, err := runCLI(t, ts.URL, "vault", "cipher"); err != nil || !strings.Contains(out, "xchacha20-poly1305") {
+		t.Fatalf("show cipher: %v %q", err, out)
+	}
+	kr, err := vault.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := fieldsRecord(kr, "55555555-5555-5555-5555-555555555555", models.RecordTypeText, map[string]string{"meta.title": "t", "text": "chacha"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, ok := cryptohelper.ParseHeader(rec.Payload); !ok || h.Alg != cryptohelper.AlgXChaCha20Poly1305 {
+		t.Fatalf("unexpected envelope header %+v %v", h, ok)
+	}
+	postRecord(t, ts.URL, rec, "0")
+
+	// switching back does not affect records already written
+	if _, err := runCLIInput(t, ts.URL, "master\n", "vault", "cipher", "aes-256-gcm"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runCLI(t, ts.URL, "records", "get", rec.ID); err != nil || !strings.Contains(out, "chacha") {
+		t.Fatalf("get: %v %q", err, out)
+	}
+	if _, err := runCLIInput(t, ts.URL, "master\n", "vault", "cipher", "des"); err == nil {
+		t.Fatalf("expected error for unknown cipher")
+	}
+}
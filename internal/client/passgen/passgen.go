@@ -0,0 +1,272 @@
+// Package passgen generates random passwords and diceware passphrases with
+// crypto/rand and estimates their entropy.
+package passgen
+
+import (
+	"bufio"
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Character classes of generated passwords.
+const (
+	Lower   = "abcdefghijklmnopqrstuvwxyz"
+	Upper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Digits  = "0123456789"
+	Symbols = "!#$%&()*+,-./:;<=>?@[]^_{|}~"
+	// Ambiguous characters are easily confused when read or typed.
+	Ambiguous = "Il1|O0o`'\""
+)
+
+// Policy describes the passwords to generate. Every enabled class is used at
+// least once.
+type Policy struct {
+	Length           int
+	Lower            bool
+	Upper            bool
+	Digits           bool
+	Symbols          bool
+	ExcludeAmbiguous bool
+}
+
+// DefaultPolicy is a 20 character password of all classes.
+var DefaultPolicy = Policy{Length: 20, Lower: true, Upper: true, Digits: true, Symbols: true}
+
+// Profiles are named policies for common password rules.
+var Profiles = map[string]Policy{
+	"strong":       DefaultPolicy,
+	"alphanumeric": {Length: 20, Lower: true, Upper: true, Digits: true},
+	"readable":     {Length: 16, Lower: true, Upper: true, Digits: true, ExcludeAmbiguous: true},
+	"pin":          {Length: 6, Digits: true},
+}
+
+// classes returns the alphabets of the enabled classes.
+func (p Policy) classes() []string {
+	var out []string
+	for _, c := range []struct {
+		on    bool
+		chars string
+	}{{p.Lower, Lower}, {p.Upper, Upper}, {p.Digits, Digits}, {p.Symbols, Symbols}} {
+		if !c.on {
+			continue
+		}
+		chars := c.chars
+		if p.ExcludeAmbiguous {
+			chars = strings.Map(func(r rune) rune {
+				if strings.ContainsRune(Ambiguous, r) {
+					return -1
+				}
+				return r
+			}, chars)
+		}
+		out = append(out, chars)
+	}
+	return out
+}
+
+// Entropy estimates the bits of entropy of passwords generated with p by
+// position: the first characters come from one class each, as Password
+// draws them, the rest from the whole alphabet. The shuffle is not counted.
+func (p Policy) Entropy() float64 {
+	classes := p.classes()
+	if len(classes) == 0 || p.Length < len(classes) {
+		return 0
+	}
+	var bits float64
+	for _, c := range classes {
+		bits += math.Log2(float64(len(c)))
+	}
+	return bits + float64(p.Length-len(classes))*math.Log2(float64(len(strings.Join(classes, ""))))
+}
+
+// Password returns a password following p.
+func Password(p Policy) (string, error) {
+	classes := p.classes()
+	if len(classes) == 0 {
+		return "", errors.New("no character classes enabled")
+	}
+	if p.Length < len(classes) {
+		return "", fmt.Errorf("length %d cannot hold one character of each of %d classes", p.Length, len(classes))
+	}
+	alphabet := strings.Join(classes, "")
+	out := make([]byte, p.Length)
+	for i := range out {
+		// the first characters cover every class, the shuffle hides where
+		chars := alphabet
+		if i < len(classes) {
+			chars = classes[i]
+		}
+		n, err := randInt(len(chars))
+		if err != nil {
+			return "", err
+		}
+		out[i] = chars[n]
+	}
+	for i := len(out) - 1; i > 0; i-- {
+		j, err := randInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out), nil
+}
+
+//go:embed words.txt
+var defaultWords string
+
+// Words returns the built-in diceware word list of 1024 short words.
+func Words() []string {
+	return strings.Fields(defaultWords)
+}
+
+// ReadWords reads a word list with one word per line, as diceware lists do;
+// a leading dice roll column like "11111<tab>abacus" is ignored.
+func ReadWords(r io.Reader) ([]string, error) {
+	var words []string
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		w := fields[len(fields)-1]
+		if !seen[w] {
+			seen[w] = true
+			words = append(words, w)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) < 2 {
+		return nil, errors.New("word list needs at least two distinct words")
+	}
+	return words, nil
+}
+
+// PassphrasePolicy describes diceware passphrases.
+type PassphrasePolicy struct {
+	Words      int
+	Separator  string
+	Capitalize bool
+}
+
+// DefaultPassphrase is six words, about 60 bits with the built-in list.
+var DefaultPassphrase = PassphrasePolicy{Words: 6, Separator: "-"}
+
+// PassphraseEntropy estimates the bits of entropy of passphrases of p drawn
+// from a list of n words.
+func PassphraseEntropy(p PassphrasePolicy, n int) float64 {
+	return float64(p.Words) * math.Log2(float64(n))
+}
+
+// Passphrase returns p.Words words picked at random from words.
+func Passphrase(p PassphrasePolicy, words []string) (string, error) {
+	if p.Words <= 0 {
+		return "", errors.New("word count must be positive")
+	}
+	if len(words) < 2 {
+		return "", errors.New("word list needs at least two words")
+	}
+	out := make([]string, p.Words)
+	for i := range out {
+		n, err := randInt(len(words))
+		if err != nil {
+			return "", err
+		}
+		out[i] = words[n]
+		if p.Capitalize {
+			r, size := utf8.DecodeRuneInString(out[i])
+			out[i] = string(unicode.ToUpper(r)) + out[i][size:]
+		}
+	}
+	return strings.Join(out, p.Separator), nil
+}
+
+// Rating names the strength of a secret with the given bits of entropy.
+func Rating(bits float64) string {
+	switch {
+	case bits < 40:
+		return "weak"
+	case bits < 64:
+		return "fair"
+	case bits < 100:
+		return "strong"
+	default:
+		return "very strong"
+	}
+}
+
+// common are passwords tried first by any guessing attack.
+var common = map[string]bool{
+	"123456": true, "123456789": true, "12345678": true, "12345": true, "1234567": true,
+	"111111": true, "000000": true, "123123": true, "password": true, "password1": true,
+	"qwerty": true, "qwerty123": true, "qwertyuiop": true, "abc123": true, "iloveyou": true,
+	"admin": true, "welcome": true, "letmein": true, "monkey": true, "dragon": true,
+	"football": true, "sunshine": true, "princess": true, "1q2w3e4r": true, "passw0rd": true,
+}
+
+// Strength estimates the bits of entropy of an existing password from the
+// character classes it uses. Characters repeating or continuing a sequence
+// of the previous one ("aaa", "123", "cba") add a single bit, and well-known
+// passwords are rated as guessed at once.
+func Strength(password string) float64 {
+	if password == "" || common[strings.ToLower(password)] {
+		return 0
+	}
+	var lower, upper, digits, other, wide bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digits = true
+		case r < utf8.RuneSelf:
+			other = true
+		default:
+			wide = true
+		}
+	}
+	pool := 0
+	for _, c := range []struct {
+		on   bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digits, 10}, {other, 33}, {wide, 100}} {
+		if c.on {
+			pool += c.size
+		}
+	}
+	perChar := math.Log2(float64(pool))
+	bits := 0.0
+	prev := rune(-1)
+	for _, r := range password {
+		if d := r - prev; d >= -1 && d <= 1 {
+			bits++
+		} else {
+			bits += perChar
+		}
+		prev = r
+	}
+	return bits
+}
+
+// randInt returns a uniform random int in [0, n).
+func randInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
@@ -0,0 +1,104 @@
+package passgen
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestPassword_Policy(t *testing.T) {
+	p := Policy{Length: 12, Lower: true, Digits: true, Symbols: true, ExcludeAmbiguous: true}
+	for i := 0; i < 200; i++ {
+		pw, err := Password(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pw) != 12 || strings.ContainsAny(pw, Upper+Ambiguous) {
+			t.Fatalf("password %q breaks the policy", pw)
+		}
+		if !strings.ContainsAny(pw, Lower) || !strings.ContainsAny(pw, Digits) || !strings.ContainsAny(pw, Symbols) {
+			t.Fatalf("password %q misses a class", pw)
+		}
+	}
+	if _, err := Password(Policy{Length: 8}); err == nil {
+		t.Fatal("no classes must fail")
+	}
+	if _, err := Password(Policy{Length: 3, Lower: true, Upper: true, Digits: true, Symbols: true}); err == nil {
+		t.Fatal("length below the number of classes must fail")
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	if got := (Policy{Length: 10, Digits: true}).Entropy(); math.Abs(got-10*math.Log2(10)) > 1e-9 {
+		t.Fatalf("pin entropy: %v", got)
+	}
+	// a short policy draws half of its characters from single classes
+	short := Policy{Length: 4, Lower: true, Digits: true}
+	if got, want := short.Entropy(), math.Log2(26)+math.Log2(10)+2*math.Log2(36); math.Abs(got-want) > 1e-9 {
+		t.Fatalf("short policy entropy: %v, want %v", got, want)
+	}
+	if got := PassphraseEntropy(DefaultPassphrase, len(Words())); got != 60 {
+		t.Fatalf("default passphrase entropy: %v", got)
+	}
+	if Rating(30) != "weak" || Rating(60) != "fair" || Rating(80) != "strong" || Rating(128) != "very strong" {
+		t.Fatal("ratings")
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	words := Words()
+	seen := map[string]bool{}
+	for _, w := range words {
+		if seen[w] || w != strings.ToLower(w) {
+			t.Fatalf("built-in list: duplicate or mixed case %q", w)
+		}
+		seen[w] = true
+	}
+	if len(words) != 1024 {
+		t.Fatalf("built-in list has %d words", len(words))
+	}
+	phrase, err := Passphrase(PassphrasePolicy{Words: 5, Separator: " ", Capitalize: true}, words)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(phrase, " ")
+	if len(parts) != 5 {
+		t.Fatalf("passphrase %q", phrase)
+	}
+	for _, w := range parts {
+		if !seen[strings.ToLower(w)] || w[:1] != strings.ToUpper(w[:1]) {
+			t.Fatalf("passphrase word %q", w)
+		}
+	}
+}
+
+func TestReadWords(t *testing.T) {
+	words, err := ReadWords(strings.NewReader("11111\tabacus\n11112\tabdomen\n\nabacus\nzebra\n"))
+	if err != nil || strings.Join(words, ",") != "abacus,abdomen,zebra" {
+		t.Fatalf("words: %v %v", words, err)
+	}
+	if _, err := ReadWords(strings.NewReader("one\n")); err == nil {
+		t.Fatal("a single word must be rejected")
+	}
+}
+
+func TestStrength(t *testing.T) {
+	for _, pw := range []string{"", "password", "Qwerty123"} {
+		if got := Strength(pw); got != 0 {
+			t.Fatalf("Strength(%q) = %v, want 0 for well-known passwords", pw, got)
+		}
+	}
+	if got := Strength("aaaaaaaaaa"); got > 15 {
+		t.Fatalf("repeated characters: %v bits", got)
+	}
+	if got, rnd := Strength("abcdefgh12"), Strength("qmzrtwkx94"); got >= rnd {
+		t.Fatalf("sequence %v bits must score below random %v", got, rnd)
+	}
+	if got := Strength("x7#Kq!9vR2@m"); Rating(got) != "strong" {
+		t.Fatalf("mixed 12 characters: %v bits (%s)", got, Rating(got))
+	}
+	pw, _ := Password(DefaultPolicy)
+	if got := Strength(pw); got < 100 {
+		t.Fatalf("generated %q: %v bits", pw, got)
+	}
+}
@@ -0,0 +1,157 @@
+// Package totp parses otpauth:// URIs and computes RFC 6238 time-based
+// one-time passwords.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gophkeeper/internal/shared/models"
+)
+
+// Defaults of otpauth URIs that leave the parameters out.
+const (
+	DefaultAlgorithm = "SHA1"
+	DefaultDigits    = 6
+	DefaultPeriod    = 30
+)
+
+// Key is a TOTP key with the issuer and account it is labelled with.
+type Key struct {
+	Issuer  string
+	Account string
+	models.TOTPContent
+}
+
+// Parse reads an otpauth://totp/ URI as exported by authenticator apps and
+// QR codes, e.g. otpauth://totp/Example:alice?secret=JBSWY3DPEHPK3PXP&issuer=Example.
+func Parse(uri string) (Key, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return Key{}, err
+	}
+	if u.Scheme != "otpauth" {
+		return Key{}, errors.New("not an otpauth:// URI")
+	}
+	if u.Host != "totp" {
+		return Key{}, fmt.Errorf("unsupported OTP type %q, want totp", u.Host)
+	}
+	q := u.Query()
+	k := Key{TOTPContent: models.TOTPContent{
+		Secret:    normalizeSecret(q.Get("secret")),
+		Algorithm: strings.ToUpper(q.Get("algorithm")),
+	}}
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		k.Issuer, k.Account = strings.TrimSpace(issuer), strings.TrimSpace(account)
+	} else {
+		k.Account = strings.TrimSpace(label)
+	}
+	if issuer := q.Get("issuer"); issuer != "" {
+		k.Issuer = issuer
+	}
+	if k.Algorithm == "" {
+		k.Algorithm = DefaultAlgorithm
+	}
+	if k.Digits, err = intParam(q, "digits", DefaultDigits); err != nil {
+		return Key{}, err
+	}
+	if k.Period, err = intParam(q, "period", DefaultPeriod); err != nil {
+		return Key{}, err
+	}
+	if err := Validate(k.TOTPContent); err != nil {
+		return Key{}, err
+	}
+	return k, nil
+}
+
+func intParam(q url.Values, name string, def int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+// normalizeSecret drops the spaces and padding authenticator apps show
+// base32 secrets with.
+func normalizeSecret(s string) string {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	return strings.TrimRight(s, "=")
+}
+
+// Validate checks the parameters of a stored key.
+func Validate(c models.TOTPContent) error {
+	if c.Secret == "" {
+		return errors.New("secret required")
+	}
+	if _, err := decodeSecret(c.Secret); err != nil {
+		return fmt.Errorf("secret is not base32: %w", err)
+	}
+	if newHash(c.Algorithm) == nil {
+		return fmt.Errorf("unsupported algorithm %q", c.Algorithm)
+	}
+	if c.Digits < 6 || c.Digits > 10 {
+		return fmt.Errorf("digits must be 6 to 10, got %d", c.Digits)
+	}
+	if c.Period <= 0 {
+		return fmt.Errorf("period must be positive, got %d", c.Period)
+	}
+	return nil
+}
+
+func decodeSecret(s string) ([]byte, error) {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalizeSecret(s))
+}
+
+func newHash(algorithm string) func() hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+// Code returns the code of key c valid at t and how long it stays valid.
+func Code(c models.TOTPContent, t time.Time) (string, time.Duration, error) {
+	if err := Validate(c); err != nil {
+		return "", 0, err
+	}
+	secret, _ := decodeSecret(c.Secret)
+	period := int64(c.Period)
+	counter := t.Unix() / period
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(newHash(c.Algorithm), secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := int64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+	mod := int64(1)
+	for i := 0; i < c.Digits; i++ {
+		mod *= 10
+	}
+	code := fmt.Sprintf("%0*d", c.Digits, value%mod)
+	next := time.Unix((counter+1)*period, 0)
+	return code, next.Sub(t), nil
+}
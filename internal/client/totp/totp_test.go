@@ -0,0 +1,72 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/shared/models"
+)
+
+// TestCode_RFC6238 checks the test vectors of RFC 6238 appendix B.
+func TestCode_RFC6238(t *testing.T) {
+	secret := func(s string) string { return base32.StdEncoding.EncodeToString([]byte(s)) }
+	keys := map[string]string{
+		"SHA1":   secret("12345678901234567890"),
+		"SHA256": secret("12345678901234567890123456789012"),
+		"SHA512": secret("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	for _, tc := range []struct {
+		unix int64
+		alg  string
+		code string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{20000000000, "SHA256", "77737706"},
+	} {
+		c := models.TOTPContent{Secret: keys[tc.alg], Algorithm: tc.alg, Digits: 8, Period: 30}
+		code, left, err := Code(c, time.Unix(tc.unix, 0))
+		if err != nil || code != tc.code {
+			t.Errorf("%s at %d: %s %v, want %s", tc.alg, tc.unix, code, err, tc.code)
+		}
+		if want := time.Duration(30-tc.unix%30) * time.Second; left != want {
+			t.Errorf("%s at %d: valid for %v, want %v", tc.alg, tc.unix, left, want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	k, err := Parse("otpauth://totp/ACME%20Co:john@example.com?secret=jbsw%20y3dp%20ehpk%203pxp&issuer=ACME%20Co&algorithm=sha256&digits=8&period=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Key{Issuer: "ACME Co", Account: "john@example.com", TOTPContent: models.TOTPContent{Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA256", Digits: 8, Period: 60}}
+	if k != want {
+		t.Fatalf("parsed %+v, want %+v", k, want)
+	}
+	k, err = Parse("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP")
+	if err != nil || k.Account != "alice" || k.Issuer != "" || k.Algorithm != "SHA1" || k.Digits != 6 || k.Period != 30 {
+		t.Fatalf("defaults: %+v %v", k, err)
+	}
+
+	for uri, msg := range map[string]string{
+		"https://example.com":                                    "otpauth",
+		"otpauth://hotp/a?secret=JBSWY3DPEHPK3PXP&counter=1":     "hotp",
+		"otpauth://totp/a":                                       "secret required",
+		"otpauth://totp/a?secret=not-base32!":                    "base32",
+		"otpauth://totp/a?secret=JBSWY3DPEHPK3PXP&algorithm=MD5": "algorithm",
+		"otpauth://totp/a?secret=JBSWY3DPEHPK3PXP&digits=4":      "digits",
+		"otpauth://totp/a?secret=JBSWY3DPEHPK3PXP&period=x":      "period",
+	} {
+		if _, err := Parse(uri); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: got %v, want error about %s", uri, err, msg)
+		}
+	}
+}
@@ -2,50 +2,205 @@ package vault
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"time"
+
+	cryptohelper "gophkeeper/internal/shared/crypto"
+	"gophkeeper/internal/shared/passhash"
 )
 
 // KeyLength defines AES-256 key size.
 const KeyLength = 32
 
-// Path returns default vault key path.
+// SessionEnv is the environment variable holding the secret of an unlocked session.
+const SessionEnv = "GOPHKEEPER_SESSION"
+
+// envelopeVersion is the current format of the vault key file. Version 1
+// wraps a single raw key, version 2 wraps a JSON Keyring.
+const envelopeVersion = 2
+
+var (
+	// ErrLocked is returned by Load when there is no valid unlocked session.
+	ErrLocked = errors.New("vault is locked, run `gophkeeper vault unlock`")
+	// ErrWrongPassword is returned when the master password does not unwrap the key.
+	ErrWrongPassword = errors.New("wrong master password")
+)
+
+// Bounds of the Argon2id parameters accepted from an envelope, which may come
+// from the server: no weaker than what Save writes, and no costlier than a
+// client can afford.
+const (
+	maxKDFMemory      = 1 << 20 // KiB, 1 GiB
+	maxKDFIterations  = 64
+	maxKDFParallelism = 16
+)
+
+var (
+	envelopeAAD = []byte("gophkeeper-vault-v1")
+	sessionAAD  = []byte("gophkeeper-session-v1")
+)
+
+// envelope is the on-disk vault key file: the vault key encrypted with a key
+// derived from the master password by Argon2id.
+type envelope struct {
+	Version     int    `json:"version"`
+	KDF         string `json:"kdf"`
+	Salt        []byte `json:"salt"`
+	Memory      uint32 `json:"memory"`
+	Iterations  uint32 `json:"iterations"`
+	Parallelism uint8  `json:"parallelism"`
+	WrappedKey  []byte `json:"wrapped_key"`
+}
+
+// Key is a vault key with its identifier.
+type Key struct {
+	ID  string `json:"id"`
+	Key []byte `json:"key"`
+}
+
+// Keyring holds the current vault key and, while a rotation is in progress,
+// the previous keys still needed to decrypt records not yet re-encrypted.
+// Rotating is the id of the key a started rotation moves records to; it is
+// cleared by Prune. Cipher names the algorithm for new payloads; empty means
+// AES-256-GCM.
+type Keyring struct {
+	CurrentID string `json:"current"`
+	Keys      []Key  `json:"keys"`
+	Rotating  string `json:"rotating,omitempty"`
+	Cipher    string `json:"cipher,omitempty"`
+}
+
+// KeyID derives a stable public identifier of key.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func newKeyring(key []byte) *Keyring {
+	id := KeyID(key)
+	return &Keyring{CurrentID: id, Keys: []Key{{ID: id, Key: key}}}
+}
+
+// Current returns the key used for new encryptions.
+func (k *Keyring) Current() Key {
+	key, _ := k.Lookup(k.CurrentID)
+	return key
+}
+
+// Lookup returns the key with the given id.
+func (k *Keyring) Lookup(id string) (Key, bool) {
+	for _, key := range k.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Algorithm returns the cipher for new payloads.
+func (k *Keyring) Algorithm() cryptohelper.Algorithm {
+	if k.Cipher == "" {
+		return cryptohelper.AlgAESGCM
+	}
+	alg, _ := cryptohelper.ParseAlgorithm(k.Cipher)
+	return alg
+}
+
+func (k *Keyring) validate() error {
+	if len(k.Keys) == 0 {
+		return errors.New("empty keyring")
+	}
+	if k.Cipher != "" {
+		if _, err := cryptohelper.ParseAlgorithm(k.Cipher); err != nil {
+			return err
+		}
+	}
+	for _, key := range k.Keys {
+		if len(key.Key) != KeyLength {
+			return errors.New("invalid key length")
+		}
+	}
+	if _, ok := k.Lookup(k.CurrentID); !ok {
+		return errors.New("current key missing from keyring")
+	}
+	return nil
+}
+
+// session is an unlocked vault keyring encrypted with a per-session secret kept
+// only in the SessionEnv variable of the user's shell.
+type session struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Key       []byte    `json:"key"`
+}
+
+// Path returns default vault key envelope path.
 func Path() string {
+	return homeFile(".gophkeeper_vault")
+}
+
+// LegacyPath returns the path of the plaintext key file used by older versions.
+func LegacyPath() string {
+	return homeFile(".gophkeeper_vault_key")
+}
+
+// SessionPath returns the path of the unlocked session file.
+func SessionPath() string {
+	return homeFile(".gophkeeper_session")
+}
+
+func homeFile(name string) string {
 	home, _ := os.UserHomeDir()
-	return home + string(os.PathSeparator) + ".gophkeeper_vault_key"
+	return home + string(os.PathSeparator) + name
 }
 
-// Exists checks if vault key file exists.
+// Exists checks if password-protected vault key exists.
 func Exists() bool {
 	_, err := os.Stat(Path())
 	return err == nil
 }
 
-// Generate creates and stores a new random key.
-func Generate() ([]byte, error) {
+// LegacyExists checks if an unprotected key file of older versions exists.
+func LegacyExists() bool {
+	_, err := os.Stat(LegacyPath())
+	return err == nil
+}
+
+// Generate creates a new random key and stores it wrapped by password.
+func Generate(password []byte) (*Keyring, error) {
 	if Exists() {
 		return nil, errors.New("vault key already exists")
 	}
-	key := make([]byte, KeyLength)
-	if _, err := rand.Read(key); err != nil {
+	key, err := newKey()
+	if err != nil {
 		return nil, err
 	}
-	if err := Save(key); err != nil {
+	kr := newKeyring(key)
+	if err := Save(kr, password); err != nil {
 		return nil, err
 	}
-	return key, nil
+	return kr, nil
 }
 
-// Save writes key to disk base64 encoded with 0600 perms.
-func Save(key []byte) error {
-	b64 := base64.StdEncoding.EncodeToString(key)
-	return os.WriteFile(Path(), []byte(b64), 0600)
+func newKey() ([]byte, error) {
+	key := make([]byte, KeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
 }
 
-// Load reads key from disk.
-func Load() ([]byte, error) {
-	b, err := os.ReadFile(Path())
+// Migrate wraps the legacy plaintext key with password and removes the legacy file.
+func Migrate(password []byte) (*Keyring, error) {
+	if Exists() {
+		return nil, errors.New("vault key already exists")
+	}
+	b, err := os.ReadFile(LegacyPath())
 	if err != nil {
 		return nil, err
 	}
@@ -56,5 +211,298 @@ func Load() ([]byte, error) {
 	if len(key) != KeyLength {
 		return nil, errors.New("invalid key length")
 	}
-	return key, nil
+	kr := newKeyring(key)
+	if err := Save(kr, password); err != nil {
+		return nil, err
+	}
+	return kr, os.Remove(LegacyPath())
+}
+
+// Save wraps the keyring with a key derived from password and writes the
+// envelope with 0600 perms.
+func Save(kr *Keyring, password []byte) error {
+	if err := kr.validate(); err != nil {
+		return err
+	}
+	plain, err := json.Marshal(kr)
+	if err != nil {
+		return err
+	}
+	p := passhash.DefaultParams
+	salt, err := passhash.NewSalt(p)
+	if err != nil {
+		return err
+	}
+	wrapped, err := cryptohelper.EncryptAESGCM(passhash.DeriveKey(password, salt, p), plain, envelopeAAD)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(envelope{
+		Version:     envelopeVersion,
+		KDF:         "argon2id",
+		Salt:        salt,
+		Memory:      p.Memory,
+		Iterations:  p.Iterations,
+		Parallelism: p.Parallelism,
+		WrappedKey:  wrapped,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(Path(), b, 0600)
+}
+
+// Unwrap reads the envelope and decrypts the vault keyring with password.
+func Unwrap(password []byte) (*Keyring, error) {
+	b, err := os.ReadFile(Path())
+	if err != nil {
+		return nil, err
+	}
+	return unwrapEnvelope(b, password)
+}
+
+// Envelope returns the raw envelope file, i.e. the vault key in its
+// password-wrapped form that is safe to hand to the server.
+func Envelope() ([]byte, error) {
+	return os.ReadFile(Path())
+}
+
+// Import stores an envelope obtained from Envelope on another device after
+// checking that password unwraps it. An existing key is replaced only with
+// overwrite, and the unlocked session of the previous key is dropped.
+func Import(b, password []byte, overwrite bool) error {
+	if Exists() && !overwrite {
+		return errors.New("vault key already exists")
+	}
+	if _, err := unwrapEnvelope(b, password); err != nil {
+		return err
+	}
+	if err := os.WriteFile(Path(), b, 0600); err != nil {
+		return err
+	}
+	return Lock()
+}
+
+func unwrapEnvelope(b, password []byte) (*Keyring, error) {
+	var env envelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	if env.Version < 1 || env.Version > envelopeVersion || env.KDF != "argon2id" {
+		return nil, errors.New("unsupported vault key format")
+	}
+	if err := env.checkParams(); err != nil {
+		return nil, err
+	}
+	kek := passhash.DeriveKey(password, env.Salt, passhash.Params{
+		Memory:      env.Memory,
+		Iterations:  env.Iterations,
+		Parallelism: env.Parallelism,
+		KeyLength:   KeyLength,
+	})
+	plain, err := cryptohelper.DecryptAESGCM(kek, env.WrappedKey, envelopeAAD)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	if env.Version == 1 {
+		plain, err = json.Marshal(newKeyring(plain))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decodeKeyring(plain)
+}
+
+// checkParams rejects key derivation parameters outside the accepted bounds
+// before any work is spent on them.
+func (env envelope) checkParams() error {
+	d := passhash.DefaultParams
+	switch {
+	case env.Memory < d.Memory || env.Memory > maxKDFMemory:
+		return fmt.Errorf("vault key: argon2id memory %d KiB outside [%d, %d]", env.Memory, d.Memory, maxKDFMemory)
+	case env.Iterations < d.Iterations || env.Iterations > maxKDFIterations:
+		return fmt.Errorf("vault key: argon2id iterations %d outside [%d, %d]", env.Iterations, d.Iterations, maxKDFIterations)
+	case env.Parallelism < d.Parallelism || env.Parallelism > maxKDFParallelism:
+		return fmt.Errorf("vault key: argon2id parallelism %d outside [%d, %d]", env.Parallelism, d.Parallelism, maxKDFParallelism)
+	case len(env.Salt) < int(d.SaltLength):
+		return fmt.Errorf("vault key: salt of %d bytes is too short", len(env.Salt))
+	}
+	return nil
+}
+
+func decodeKeyring(b []byte) (*Keyring, error) {
+	var kr Keyring
+	if err := json.Unmarshal(b, &kr); err != nil {
+		return nil, err
+	}
+	if err := kr.validate(); err != nil {
+		return nil, err
+	}
+	return &kr, nil
+}
+
+// Rotate adds a new random key to the keyring and makes it current. Previous
+// keys stay in the keyring until Prune, so records not yet re-encrypted can
+// still be read. An unlocked session is updated in place.
+func Rotate(password []byte) (*Keyring, error) {
+	kr, err := Unwrap(password)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	id := KeyID(key)
+	kr.Keys = append(kr.Keys, Key{ID: id, Key: key})
+	kr.CurrentID, kr.Rotating = id, id
+	if err := Save(kr, password); err != nil {
+		return nil, err
+	}
+	return kr, refreshSession(kr)
+}
+
+// SetCipher selects the cipher used for new payloads. Existing payloads keep
+// their algorithm, which is recorded in each envelope.
+func SetCipher(password []byte, alg cryptohelper.Algorithm) (*Keyring, error) {
+	kr, err := Unwrap(password)
+	if err != nil {
+		return nil, err
+	}
+	kr.Cipher = alg.String()
+	if err := Save(kr, password); err != nil {
+		return nil, err
+	}
+	return kr, refreshSession(kr)
+}
+
+// Prune drops every key except the current one and ends the rotation, once
+// nothing kept locally or on the server is encrypted with the other keys.
+func Prune(password []byte) (*Keyring, error) {
+	kr, err := Unwrap(password)
+	if err != nil {
+		return nil, err
+	}
+	kr.Keys = []Key{kr.Current()}
+	kr.Rotating = ""
+	if err := Save(kr, password); err != nil {
+		return nil, err
+	}
+	return kr, refreshSession(kr)
+}
+
+// Unlock unwraps the vault key and stores it in a session file valid for ttl.
+// The returned secret must be exported as SessionEnv for Load to succeed.
+func Unlock(password []byte, ttl time.Duration) (string, time.Time, error) {
+	kr, err := Unwrap(password)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	secret := make([]byte, KeyLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(ttl).UTC()
+	if err := writeSession(secret, expires, kr); err != nil {
+		return "", time.Time{}, err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), expires, nil
+}
+
+func writeSession(secret []byte, expires time.Time, kr *Keyring) error {
+	plain, err := json.Marshal(kr)
+	if err != nil {
+		return err
+	}
+	sealed, err := cryptohelper.EncryptAESGCM(secret, plain, sessionAAD)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(session{ExpiresAt: expires, Key: sealed})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(SessionPath(), b, 0600)
+}
+
+// refreshSession replaces the keyring of the current unlocked session, if
+// any, keeping its secret and expiry.
+func refreshSession(kr *Keyring) error {
+	s, secret, err := openSession()
+	if err != nil {
+		return nil
+	}
+	if _, err := cryptohelper.DecryptAESGCM(secret, s.Key, sessionAAD); err != nil {
+		return nil
+	}
+	return writeSession(secret, s.ExpiresAt, kr)
+}
+
+// Lock removes the unlocked session.
+func Lock() error {
+	if err := os.Remove(SessionPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// SessionExpiry reports when the current session expires; ok is false when
+// the vault is locked.
+func SessionExpiry() (expires time.Time, ok bool) {
+	s, err := readSession()
+	if err != nil || time.Now().After(s.ExpiresAt) {
+		return time.Time{}, false
+	}
+	return s.ExpiresAt, true
+}
+
+// Load returns the vault keyring of the unlocked session.
+func Load() (*Keyring, error) {
+	if !Exists() && LegacyExists() {
+		return nil, errors.New("vault key is stored unprotected, run `gophkeeper vault init` to set a master password")
+	}
+	s, secret, err := openSession()
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(s.ExpiresAt) {
+		_ = Lock()
+		return nil, ErrLocked
+	}
+	plain, err := cryptohelper.DecryptAESGCM(secret, s.Key, sessionAAD)
+	if err != nil {
+		return nil, ErrLocked
+	}
+	kr, err := decodeKeyring(plain)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted session: %w", err)
+	}
+	return kr, nil
+}
+
+// openSession reads the session file and the secret from SessionEnv.
+func openSession() (session, []byte, error) {
+	secretB64 := os.Getenv(SessionEnv)
+	if secretB64 == "" {
+		return session{}, nil, ErrLocked
+	}
+	s, err := readSession()
+	if err != nil {
+		return session{}, nil, ErrLocked
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(secretB64)
+	if err != nil {
+		return session{}, nil, ErrLocked
+	}
+	return s, secret, nil
+}
+
+func readSession() (session, error) {
+	var s session
+	b, err := os.ReadFile(SessionPath())
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	return s, err
 }
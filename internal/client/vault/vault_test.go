@@ -1,9 +1,18 @@
 package vault
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	cryptohelper "gophkeeper/internal/shared/crypto"
+	"gophkeeper/internal/shared/passhash"
 )
 
 func TestGenerateSaveLoad(t *testing.T) {
@@ -35,21 +44,175 @@ func TestGenerateSaveLoad(t *testing.T) {
 	if Exists() {
 		t.Fatalf("key should not exist")
 	}
-	key, err := Generate()
+	kr, err := Generate([]byte("master"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(key) != KeyLength {
-		t.Fatalf("len: %d", len(key))
+	key := kr.Current()
+	if len(key.Key) != KeyLength || key.ID != KeyID(key.Key) {
+		t.Fatalf("unexpected key: %+v", key)
 	}
 	if !Exists() {
 		t.Fatalf("key must exist after generate")
 	}
+	if _, err := Load(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected locked, got %v", err)
+	}
+	if _, err := Unwrap([]byte("wrong")); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("expected wrong password, got %v", err)
+	}
+	secret, _, err := Unlock([]byte("master"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(SessionEnv, secret)
+	loaded, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Current().Key, key.Key) {
+		t.Fatalf("loaded key differs")
+	}
+	if err := Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected locked after lock, got %v", err)
+	}
+}
+
+func TestUnlock_Expired(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("USERPROFILE", dir)
+	if _, err := Generate([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := Unlock([]byte("pw"), -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(SessionEnv, secret)
+	if _, err := Load(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected locked, got %v", err)
+	}
+	if _, ok := SessionExpiry(); ok {
+		t.Fatalf("expired session must not be reported")
+	}
+}
+
+func TestMigrateLegacyKey(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("USERPROFILE", dir)
+	legacy := bytes.Repeat([]byte{7}, KeyLength)
+	if err := os.WriteFile(LegacyPath(), []byte(base64.StdEncoding.EncodeToString(legacy)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(); err == nil || errors.Is(err, ErrLocked) {
+		t.Fatalf("expected unprotected key error, got %v", err)
+	}
+	if _, err := Migrate([]byte("pw")); err != nil {
+		t.Fatal(err)
+	}
+	if LegacyExists() {
+		t.Fatalf("legacy key must be removed")
+	}
+	kr, err := Unwrap([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kr.Current().Key, legacy) {
+		t.Fatalf("migrated key differs")
+	}
+}
+
+func TestRotateAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("USERPROFILE", dir)
+	old, err := Generate([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _, err := Unlock([]byte("pw"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(SessionEnv, secret)
+
+	kr, err := Rotate([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kr.Keys) != 2 || kr.CurrentID == old.CurrentID || kr.Rotating != kr.CurrentID {
+		t.Fatalf("unexpected keyring after rotate: %+v", kr)
+	}
+	if _, ok := kr.Lookup(old.CurrentID); !ok {
+		t.Fatalf("previous key must stay until prune")
+	}
+	// The unlocked session follows the rotation without a new unlock.
 	loaded, err := Load()
+	if err != nil || loaded.CurrentID != kr.CurrentID {
+		t.Fatalf("session not refreshed: %+v %v", loaded, err)
+	}
+
+	kr, err = Prune([]byte("pw"))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if len(loaded) != KeyLength {
-		t.Fatalf("loaded len %d", len(loaded))
+	if len(kr.Keys) != 1 || kr.Rotating != "" {
+		t.Fatalf("prune must keep only the current key and end the rotation: %+v", kr)
+	}
+	unwrapped, err := Unwrap([]byte("pw"))
+	if err != nil || len(unwrapped.Keys) != 1 || unwrapped.CurrentID != kr.CurrentID || unwrapped.Rotating != "" {
+		t.Fatalf("unexpected stored keyring: %+v %v", unwrapped, err)
+	}
+}
+
+func TestUnwrap_VersionOneEnvelope(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("USERPROFILE", dir)
+	key := bytes.Repeat([]byte{3}, KeyLength)
+	p := passhash.DefaultParams
+	salt, _ := passhash.NewSalt(p)
+	wrapped, err := cryptohelper.EncryptAESGCM(passhash.DeriveKey([]byte("pw"), salt, p), key, envelopeAAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(envelope{Version: 1, KDF: "argon2id", Salt: salt, Memory: p.Memory, Iterations: p.Iterations, Parallelism: p.Parallelism, WrappedKey: wrapped})
+	if err := os.WriteFile(Path(), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	kr, err := Unwrap([]byte("pw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kr.Current().Key, key) || kr.CurrentID != KeyID(key) {
+		t.Fatalf("unexpected keyring from v1 envelope: %+v", kr)
+	}
+}
+
+func TestImport_RejectsUnsafeKDFParams(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("USERPROFILE", dir)
+	p := passhash.DefaultParams
+	salt, _ := passhash.NewSalt(p)
+	for _, env := range []envelope{
+		{Memory: p.Memory, Iterations: 0, Parallelism: p.Parallelism},
+		{Memory: p.Memory, Iterations: p.Iterations, Parallelism: 0},
+		{Memory: 1 << 31, Iterations: p.Iterations, Parallelism: p.Parallelism},
+		{Memory: 8, Iterations: 1, Parallelism: 1},
+	} {
+		env.Version, env.KDF, env.Salt, env.WrappedKey = envelopeVersion, "argon2id", salt, []byte("x")
+		b, _ := json.Marshal(env)
+		if err := Import(b, []byte("pw"), false); err == nil || !strings.Contains(err.Error(), "outside") {
+			t.Fatalf("envelope %+v: %v", env, err)
+		}
+	}
+	if Exists() {
+		t.Fatal("rejected envelope was stored")
 	}
 }
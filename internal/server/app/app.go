@@ -2,33 +2,44 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"gophkeeper/internal/server/config"
 	"gophkeeper/internal/server/httpapi"
+	"gophkeeper/internal/server/repository/memory"
+	"gophkeeper/internal/server/repository/migrate"
+	"gophkeeper/internal/server/repository/postgres"
 	"gophkeeper/internal/server/repository/sqlite"
 	"gophkeeper/internal/server/service"
 )
 
 type App struct {
-	version   string
-	buildDate string
-	logger    *log.Logger
-	server    *http.Server
-	repoClose io.Closer
+	version       string
+	buildDate     string
+	logger        *log.Logger
+	server        *http.Server
+	services      *service.Services
+	purgeInterval time.Duration
+	repoClose     io.Closer
 }
 
 func New(version, buildDate string, logger *log.Logger) (*App, error) {
 	cfg := config.Load()
-	repo, err := sqlite.New(cfg.DatabaseDSN)
+	repo, err := openRepository(cfg.DatabaseDSN)
 	if err != nil {
 		return nil, err
 	}
+	if err := prepareSchema(context.Background(), repo, cfg.AutoMigrate, logger); err != nil {
+		_ = repo.Close()
+		return nil, err
+	}
 	services := service.NewServices(repo, cfg)
 	router := httpapi.NewRouter(services, logger, cfg.MaxRequestBytes)
 	server := &http.Server{
@@ -39,7 +50,59 @@ func New(version, buildDate string, logger *log.Logger) (*App, error) {
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       60 * time.Second,
 	}
-	return &App{version: version, buildDate: buildDate, logger: logger, server: server, repoClose: repo}, nil
+	return &App{
+		version:       version,
+		buildDate:     buildDate,
+		logger:        logger,
+		server:        server,
+		services:      services,
+		purgeInterval: cfg.PurgeInterval,
+		repoClose:     repo,
+	}, nil
+}
+
+// repository is a service.Repository holding database connections.
+type repository interface {
+	service.Repository
+	io.Closer
+}
+
+// migratable is a repository with a versioned schema.
+type migratable interface {
+	Migrator() *migrate.Migrator
+}
+
+// openRepository picks the implementation by the DSN: postgres:// and
+// postgresql:// URLs select PostgreSQL, memory:// an empty in-memory store,
+// anything else is a SQLite DSN. The schema is not migrated.
+func openRepository(dsn string) (repository, error) {
+	if dsn == config.MemoryDSN {
+		return memory.New(), nil
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return postgres.Open(dsn)
+	}
+	return sqlite.Open(dsn)
+}
+
+// prepareSchema applies pending migrations, or with autoMigrate off fails
+// unless the schema is current.
+func prepareSchema(ctx context.Context, repo repository, autoMigrate bool, logger *log.Logger) error {
+	m, ok := repo.(migratable)
+	if !ok {
+		return nil
+	}
+	if !autoMigrate {
+		if err := m.Migrator().Check(ctx); err != nil {
+			return fmt.Errorf("%w; run `migrate up` first", err)
+		}
+		return nil
+	}
+	applied, err := m.Migrator().Up(ctx, 0)
+	for _, mig := range applied {
+		logger.Printf("applied migration %d_%s", mig.ID, mig.Name)
+	}
+	return err
 }
 
 func (a *App) Run() error {
@@ -53,6 +116,8 @@ func (a *App) Run() error {
 		}
 	}()
 
+	go a.purgeLoop(ctx)
+
 	a.logger.Printf("GophKeeper server %s (%s) listening on %s", a.version, a.buildDate, a.server.Addr)
 
 	<-ctx.Done()
@@ -60,3 +125,38 @@ func (a *App) Run() error {
 	defer cancel()
 	return a.server.Shutdown(shutdownCtx)
 }
+
+// purgeLoop periodically removes expired record tombstones, expired trash,
+// abandoned uploads and unreferenced blobs until ctx is done.
+func (a *App) purgeLoop(ctx context.Context) {
+	ticker := time.NewTicker(a.purgeInterval)
+	defer ticker.Stop()
+	for {
+		n, err := a.services.Records.PurgeTombstones(ctx)
+		if err != nil {
+			a.logger.Printf("purge tombstones: %v", err)
+		} else if n > 0 {
+			a.logger.Printf("purged %d record tombstones", n)
+		}
+		if n, err := a.services.Records.PurgeTrash(ctx); err != nil {
+			a.logger.Printf("purge trash: %v", err)
+		} else if n > 0 {
+			a.logger.Printf("purged %d records from trash", n)
+		}
+		if n, err := a.services.Uploads.PurgeStale(ctx); err != nil {
+			a.logger.Printf("purge uploads: %v", err)
+		} else if n > 0 {
+			a.logger.Printf("purged %d stale uploads", n)
+		}
+		if n, err := a.services.Records.CollectGarbage(ctx); err != nil {
+			a.logger.Printf("collect blobs: %v", err)
+		} else if n > 0 {
+			a.logger.Printf("collected %d unreferenced blobs", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gophkeeper/internal/server/config"
+)
+
+const migrateUsage = "usage: migrate up [ID] | down [N] | status"
+
+// Migrate runs the migrate subcommand against the configured database:
+// "up [ID]" applies pending migrations up to ID or all of them, "down [N]"
+// reverts the last N applied migrations (1 by default) and "status" lists
+// the migrations.
+func Migrate(args []string, out io.Writer) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New(migrateUsage)
+	}
+	n := 0
+	if len(args) == 2 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v <= 0 || args[0] == "status" {
+			return errors.New(migrateUsage)
+		}
+		n = v
+	}
+	cfg := config.Load()
+	repo, err := openRepository(cfg.DatabaseDSN)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = repo.Close() }()
+	m, ok := repo.(migratable)
+	if !ok {
+		return fmt.Errorf("%s has no schema to migrate", cfg.DatabaseDSN)
+	}
+	migrator := m.Migrator()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx, n)
+		for _, mig := range applied {
+			fmt.Fprintf(out, "applied %d_%s\n", mig.ID, mig.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "schema is up to date")
+		}
+		return err
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		reverted, err := migrator.Down(ctx, n)
+		for _, mig := range reverted {
+			fmt.Fprintf(out, "reverted %d_%s\n", mig.ID, mig.Name)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Fprintln(out, "no applied migrations")
+		}
+		return err
+	case "status":
+		states, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range states {
+			status, at := "pending", ""
+			switch {
+			case s.Unknown:
+				status = "unknown"
+			case s.Modified:
+				status = "modified"
+			case s.Applied:
+				status = "applied"
+			}
+			if s.Applied {
+				at = s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.ID, s.Name, status, at)
+		}
+		return tw.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+}
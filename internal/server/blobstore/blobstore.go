@@ -0,0 +1,67 @@
+// Package blobstore keeps large encrypted payloads outside the database.
+// Blobs are immutable and addressed by the hex SHA-256 of their content, so
+// identical payloads are stored once and integrity can be checked on write.
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrNotFound is returned for blobs that are not stored.
+	ErrNotFound = errors.New("blob not found")
+	// ErrInvalidKey is returned for keys that are not a hex SHA-256.
+	ErrInvalidKey = errors.New("invalid blob key")
+	// ErrHashMismatch is returned by Put when the content does not match the key.
+	ErrHashMismatch = errors.New("blob content does not match its key")
+)
+
+// Store is a content-addressed blob storage backend.
+type Store interface {
+	// Put stores size bytes read from r under key, the hex SHA-256 of the
+	// bytes. Storing a key that already exists is not an error.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open returns the blob for reading; it supports seeking for ranged reads.
+	Open(ctx context.Context, key string) (Object, error)
+	// Delete removes the blob. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is an opened blob.
+type Object interface {
+	io.ReadSeekCloser
+	Size() int64
+}
+
+// Key returns the key of the given content.
+func Key(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyOf reads r to the end and returns its key and size.
+func KeyOf(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// ValidKey reports whether key is a lowercase hex SHA-256.
+func ValidKey(key string) bool {
+	if len(key) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range key {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
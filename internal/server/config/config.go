@@ -4,23 +4,88 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 )
 
 type Config struct {
-	HTTPAddr              string
-	DatabaseDSN           string
+	HTTPAddr    string
+	DatabaseDSN string
+	// AutoMigrate applies pending schema migrations at startup; when off the
+	// server refuses to start until `migrate up` is run.
+	AutoMigrate           bool
 	JWTSecret             string
 	MaxRequestBytes       int64
 	MaxRecordPayloadBytes int64
+	// TombstoneRetention is how long tombstones of records no longer in the
+	// trash are kept for sync; 0 keeps them forever.
+	TombstoneRetention time.Duration
+	// RecordRevisions is how many earlier versions of each record are kept
+	// for restore; 0 keeps all of them.
+	RecordRevisions int64
+	// TrashRetention is how long deleted records can be restored; 0 keeps
+	// them until the trash is emptied.
+	TrashRetention time.Duration
+	PurgeInterval  time.Duration
+	// DataDir holds in-progress uploads and, with the fs blob backend, the
+	// blobs; empty disables uploads and the fs blob store.
+	DataDir         string
+	MaxUploadBytes  int64
+	UploadRetention time.Duration
+	// BlobBackend is "fs" or "s3". Payloads larger than BlobInlineMax are
+	// kept in the blob store; unreferenced blobs are collected after
+	// BlobGCGrace.
+	BlobBackend   string
+	BlobInlineMax int64
+	BlobGCGrace   time.Duration
+	S3            S3Config
 }
 
+// S3Config locates the bucket of the s3 blob backend.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string
+}
+
+// MemoryDSN selects the in-memory repository, which keeps nothing across
+// restarts.
+const MemoryDSN = "memory://"
+
 func Load() Config {
 	cfg := Config{
 		HTTPAddr:              getEnv("GOPHKEEPER_HTTP_ADDR", ":8080"),
 		DatabaseDSN:           getEnv("GOPHKEEPER_DB_DSN", "file:gophkeeper.db?cache=shared&mode=rwc"),
+		AutoMigrate:           getEnvBool("GOPHKEEPER_DB_AUTO_MIGRATE", true),
 		JWTSecret:             getEnv("GOPHKEEPER_JWT_SECRET", "dev-secret-change"),
 		MaxRequestBytes:       getEnvInt64("GOPHKEEPER_MAX_REQUEST_BYTES", 1<<20),
 		MaxRecordPayloadBytes: getEnvInt64("GOPHKEEPER_MAX_RECORD_PAYLOAD_BYTES", 1<<20),
+		TombstoneRetention:    getEnvRetention("GOPHKEEPER_TOMBSTONE_RETENTION", 30*24*time.Hour),
+		RecordRevisions:       getEnvLimit("GOPHKEEPER_RECORD_REVISIONS", 10),
+		TrashRetention:        time.Duration(getEnvLimit("GOPHKEEPER_TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		PurgeInterval:         getEnvDuration("GOPHKEEPER_PURGE_INTERVAL", time.Hour),
+		DataDir:               getEnv("GOPHKEEPER_DATA_DIR", "data"),
+		MaxUploadBytes:        getEnvInt64("GOPHKEEPER_MAX_UPLOAD_BYTES", 1<<30),
+		UploadRetention:       getEnvDuration("GOPHKEEPER_UPLOAD_RETENTION", 24*time.Hour),
+		BlobBackend:           getEnv("GOPHKEEPER_BLOB_BACKEND", "fs"),
+		BlobInlineMax:         getEnvInt64("GOPHKEEPER_BLOB_INLINE_MAX", 64<<10),
+		BlobGCGrace:           getEnvDuration("GOPHKEEPER_BLOB_GC_GRACE", time.Hour),
+		S3: S3Config{
+			Endpoint:  getEnv("GOPHKEEPER_S3_ENDPOINT", ""),
+			Region:    getEnv("GOPHKEEPER_S3_REGION", "us-east-1"),
+			Bucket:    getEnv("GOPHKEEPER_S3_BUCKET", ""),
+			AccessKey: getEnv("GOPHKEEPER_S3_ACCESS_KEY", ""),
+			SecretKey: getEnv("GOPHKEEPER_S3_SECRET_KEY", ""),
+			Prefix:    getEnv("GOPHKEEPER_S3_PREFIX", ""),
+		},
+	}
+	if cfg.BlobBackend == "s3" && (cfg.S3.Endpoint == "" || cfg.S3.Bucket == "") {
+		log.Println("WARNING: GOPHKEEPER_BLOB_BACKEND=s3 needs GOPHKEEPER_S3_ENDPOINT and GOPHKEEPER_S3_BUCKET")
+	}
+	if cfg.DatabaseDSN == MemoryDSN {
+		log.Println("WARNING: GOPHKEEPER_DB_DSN=memory:// keeps data in memory only; it is lost on restart")
 	}
 	if cfg.JWTSecret == "dev-secret-change" {
 		log.Println("WARNING: using development JWT secret; set GOPHKEEPER_JWT_SECRET")
@@ -44,3 +109,46 @@ func getEnvInt64(key string, def int64) int64 {
 	}
 	return def
 }
+
+func getEnvBool(key string, def bool) bool {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+		log.Printf("WARNING: invalid %s='%s', using default %t", key, v, def)
+	}
+	return def
+}
+
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("WARNING: invalid %s='%s', using default %s", key, v, def)
+	}
+	return def
+}
+
+// getEnvRetention is getEnvDuration that also accepts 0, which disables the
+// purge.
+func getEnvRetention(key string, def time.Duration) time.Duration {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("WARNING: invalid %s='%s', using default %s", key, v, def)
+	}
+	return def
+}
+
+// getEnvLimit is getEnvInt64 that also accepts 0, which lifts the limit.
+func getEnvLimit(key string, def int64) int64 {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			return n
+		}
+		log.Printf("WARNING: invalid %s='%s', using default %d", key, v, def)
+	}
+	return def
+}
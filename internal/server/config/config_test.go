@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoadDefaultsAndEnv(t *testing.T) {
@@ -14,13 +15,44 @@ func TestLoadDefaultsAndEnv(t *testing.T) {
 	if cfg.HTTPAddr == "" || cfg.DatabaseDSN == "" || cfg.JWTSecret == "" {
 		t.Fatalf("empty config fields")
 	}
+	if cfg.TombstoneRetention <= 0 || cfg.TrashRetention <= 0 || cfg.PurgeInterval <= 0 {
+		t.Fatalf("empty purge settings: %+v", cfg)
+	}
+	if !cfg.AutoMigrate {
+		t.Fatalf("migrations must be applied by default")
+	}
 
 	// env override
 	os.Setenv("GOPHKEEPER_HTTP_ADDR", ":9999")
 	os.Setenv("GOPHKEEPER_DB_DSN", "file::memory:")
 	os.Setenv("GOPHKEEPER_JWT_SECRET", "secret")
+	os.Setenv("GOPHKEEPER_TOMBSTONE_RETENTION", "48h")
+	os.Setenv("GOPHKEEPER_PURGE_INTERVAL", "bad")
+	os.Setenv("GOPHKEEPER_DB_AUTO_MIGRATE", "false")
+	os.Setenv("GOPHKEEPER_TRASH_RETENTION_DAYS", "7")
+	t.Cleanup(func() {
+		os.Unsetenv("GOPHKEEPER_TRASH_RETENTION_DAYS")
+		os.Unsetenv("GOPHKEEPER_TOMBSTONE_RETENTION")
+		os.Unsetenv("GOPHKEEPER_PURGE_INTERVAL")
+		os.Unsetenv("GOPHKEEPER_DB_AUTO_MIGRATE")
+	})
 	cfg = Load()
 	if cfg.HTTPAddr != ":9999" || cfg.DatabaseDSN != "file::memory:" || cfg.JWTSecret != "secret" {
 		t.Fatalf("env not applied: %+v", cfg)
 	}
+	if cfg.AutoMigrate {
+		t.Fatalf("GOPHKEEPER_DB_AUTO_MIGRATE not applied")
+	}
+	if cfg.TombstoneRetention != 48*time.Hour || cfg.TrashRetention != 7*24*time.Hour || cfg.PurgeInterval != time.Hour {
+		t.Fatalf("durations not applied: %+v", cfg)
+	}
+
+	// zero disables the purges and keeps all revisions
+	os.Setenv("GOPHKEEPER_TOMBSTONE_RETENTION", "0")
+	os.Setenv("GOPHKEEPER_RECORD_REVISIONS", "0")
+	os.Setenv("GOPHKEEPER_TRASH_RETENTION_DAYS", "0")
+	t.Cleanup(func() { os.Unsetenv("GOPHKEEPER_RECORD_REVISIONS") })
+	if cfg = Load(); cfg.TombstoneRetention != 0 || cfg.RecordRevisions != 0 || cfg.TrashRetention != 0 {
+		t.Fatalf("zero limits: %+v", cfg)
+	}
 }
@@ -0,0 +1,53 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gophkeeper/internal/server/repository"
+	"gophkeeper/internal/server/service"
+	"gophkeeper/internal/shared/models"
+)
+
+// batchResponse carries the per-operation results of a batch, with Error set
+// when the batch was not applied.
+type batchResponse struct {
+	Error   string               `json:"error,omitempty"`
+	Results []models.BatchResult `json:"results"`
+}
+
+// handleBatchRecords applies {"ops": [...]} atomically. A malformed
+// operation fails the batch with 400, a version conflict or a missing record
+// with 409; either way the results tell which operations failed.
+func (r *Router) handleBatchRecords(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	if r.maxRequestBytes > 0 {
+		req.Body = http.MaxBytesReader(w, req.Body, r.maxRequestBytes)
+	}
+	var body struct {
+		Ops []models.BatchOp `json:"ops"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "request entity too large"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return
+	}
+	results, err := r.services.Records.Batch(req.Context(), userID, body.Ops)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, batchResponse{Results: results})
+	case errors.Is(err, service.ErrBatchInvalid) && results == nil:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrBatchInvalid):
+		writeJSON(w, http.StatusBadRequest, batchResponse{Error: err.Error(), Results: results})
+	case errors.Is(err, repository.ErrBatchRejected):
+		writeJSON(w, http.StatusConflict, batchResponse{Error: err.Error(), Results: results})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
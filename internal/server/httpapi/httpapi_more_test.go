@@ -6,7 +6,7 @@ import (
 	"testing"
 
 	"gophkeeper/internal/server/config"
-	"gophkeeper/internal/server/repository/sqlite"
+	"gophkeeper/internal/server/repository/memory"
 	"gophkeeper/internal/server/service"
 )
 
@@ -47,11 +47,7 @@ func TestAuthMiddleware_InvalidToken(t *testing.T) {
 }
 
 func TestNotFoundHandlers(t *testing.T) {
-	// Build a separate server to avoid interference
-	repo, err := sqlite.New("file:httpapi_notfound?mode=memory&cache=shared")
-	if err != nil {
-		t.Fatal(err)
-	}
+	repo := memory.New()
 	svcs := service.NewServices(repo, config.Config{JWTSecret: "test", MaxRequestBytes: 1 << 20, MaxRecordPayloadBytes: 1 << 20})
 	ts := NewRouter(svcs, nil, 1<<20)
 	// Register and login to get token
@@ -5,19 +5,18 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"gophkeeper/internal/server/config"
-	"gophkeeper/internal/server/repository/sqlite"
+	"gophkeeper/internal/server/repository/memory"
 	"gophkeeper/internal/server/service"
+	"gophkeeper/internal/shared/models"
 )
 
 func newTestServer(t *testing.T) http.Handler {
 	t.Helper()
-	repo, err := sqlite.New("file::memory:?cache=shared")
-	if err != nil {
-		t.Fatalf("repo: %v", err)
-	}
+	repo := memory.New()
 	svcs := service.NewServices(repo, config.Config{JWTSecret: "test", MaxRequestBytes: 1 << 20, MaxRecordPayloadBytes: 1 << 20})
 	return NewRouter(svcs, nil, 1<<20)
 }
@@ -120,6 +119,16 @@ func TestAuthAndCRUD(t *testing.T) {
 	if rr.Code != http.StatusPreconditionFailed {
 		t.Fatalf("want 412 got %d", rr.Code)
 	}
+	var conflict struct {
+		Current *struct {
+			ID      string `json:"id"`
+			Version int64  `json:"version"`
+		} `json:"current"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &conflict)
+	if conflict.Current == nil || conflict.Current.ID != rec.ID || conflict.Current.Version != rec.Version {
+		t.Fatalf("412 must carry current record: %s", rr.Body.String())
+	}
 
 	// Conditional update: correct If-Match
 	hdr["If-Match"] = etag
@@ -143,3 +152,219 @@ func TestAuthMiddleware_Unauthorized(t *testing.T) {
 		t.Fatalf("want 401 got %d", rr.Code)
 	}
 }
+
+func TestRecords_ListQueryAndPaging(t *testing.T) {
+	ts := newTestServer(t)
+	authz := loginTestUser(t, ts, "list@example.com")
+	for i, site := range []string{"a.example", "b.example", "a.example"} {
+		typ := "login"
+		if i == 1 {
+			typ = "text"
+		}
+		rr := doJSON(t, ts, "POST", "/api/v1/records", map[string]any{"type": typ, "meta": map[string]string{"site": site}, "payload": []byte("x")}, authz)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	var page []models.Record
+	rr := doJSON(t, ts, "GET", "/api/v1/records?type=login&meta.site=a.example", nil, authz)
+	_ = json.Unmarshal(rr.Body.Bytes(), &page)
+	if rr.Code != http.StatusOK || len(page) != 2 || rr.Header().Get("X-Next-Cursor") != "" {
+		t.Fatalf("filtered list: %d %s", rr.Code, rr.Body.String())
+	}
+
+	var ids []string
+	path := "/api/v1/records?limit=2&sort=updated_at"
+	for path != "" {
+		rr = doJSON(t, ts, "GET", path, nil, authz)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("page: %d %s", rr.Code, rr.Body.String())
+		}
+		page = nil
+		_ = json.Unmarshal(rr.Body.Bytes(), &page)
+		for _, rec := range page {
+			ids = append(ids, rec.ID)
+		}
+		path = ""
+		if next := rr.Header().Get("X-Next-Cursor"); next != "" {
+			path = "/api/v1/records?limit=2&sort=updated_at&cursor=" + next
+		}
+	}
+	if len(ids) != 3 || ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Fatalf("paged ids: %v", ids)
+	}
+
+	rr = doJSON(t, ts, "GET", "/api/v1/records?fields=meta&type=text", nil, authz)
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "payload") || !strings.Contains(rr.Body.String(), "b.example") {
+		t.Fatalf("meta-only list: %d %s", rr.Code, rr.Body.String())
+	}
+
+	for _, bad := range []string{"limit=0", "limit=x", "sort=type", "updated_after=yesterday", "cursor=bogus", "fields=payload"} {
+		if rr := doJSON(t, ts, "GET", "/api/v1/records?"+bad, nil, authz); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: want 400 got %d", bad, rr.Code)
+		}
+	}
+}
+
+func TestRecords_RevisionsAndRestore(t *testing.T) {
+	ts := newTestServer(t)
+	authz := loginTestUser(t, ts, "revisions@example.com")
+
+	var rec models.Record
+	for _, p := range []string{"v1", "v2"} {
+		rr := doJSON(t, ts, "POST", "/api/v1/records", map[string]any{"id": rec.ID, "version": rec.Version, "type": "text", "payload": []byte(p)}, authz)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("upsert: %d %s", rr.Code, rr.Body.String())
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &rec)
+	}
+
+	rr := doJSON(t, ts, "GET", "/api/v1/records/"+rec.ID+"/revisions", nil, authz)
+	var revs []models.Record
+	_ = json.Unmarshal(rr.Body.Bytes(), &revs)
+	if rr.Code != http.StatusOK || len(revs) != 1 || revs[0].Version != 1 || string(revs[0].Payload) != "v1" {
+		t.Fatalf("revisions: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, ts, "GET", "/api/v1/records/missing/revisions", nil, authz); rr.Code != http.StatusNotFound {
+		t.Fatalf("missing record: %d", rr.Code)
+	}
+
+	if rr := doJSON(t, ts, "POST", "/api/v1/records/"+rec.ID+"/restore?version=x", nil, authz); rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad version: %d", rr.Code)
+	}
+	if rr := doJSON(t, ts, "POST", "/api/v1/records/"+rec.ID+"/restore?version=7", nil, authz); rr.Code != http.StatusNotFound {
+		t.Fatalf("missing revision: %d", rr.Code)
+	}
+	stale := map[string]string{"Authorization": authz["Authorization"], "If-Match": "1"}
+	if rr := doJSON(t, ts, "POST", "/api/v1/records/"+rec.ID+"/restore?version=1", nil, stale); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: %d", rr.Code)
+	}
+	rr = doJSON(t, ts, "POST", "/api/v1/records/"+rec.ID+"/restore?version=1", nil, authz)
+	_ = json.Unmarshal(rr.Body.Bytes(), &rec)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != "3" || rec.Version != 3 || string(rec.Payload) != "v1" {
+		t.Fatalf("restore: %d %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := doJSON(t, ts, "DELETE", "/api/v1/records/"+rec.ID+"/revisions", nil, authz); rr.Code != http.StatusNoContent {
+		t.Fatalf("drop revisions: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, ts, "GET", "/api/v1/records/"+rec.ID+"/revisions", nil, authz); rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Fatalf("revisions after drop: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRecords_ForeignIDNotTakenOver(t *testing.T) {
+	ts := newTestServer(t)
+	alice := loginTestUser(t, ts, "alice@example.com")
+	bob := loginTestUser(t, ts, "bob@example.com")
+
+	rr := doJSON(t, ts, "POST", "/api/v1/records", map[string]any{"type": "text", "payload": []byte("secret")}, alice)
+	var rec models.Record
+	_ = json.Unmarshal(rr.Body.Bytes(), &rec)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("upsert: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, ts, "POST", "/api/v1/records", map[string]any{"id": rec.ID, "type": "text", "payload": []byte("mine")}, bob); rr.Code != http.StatusNotFound {
+		t.Fatalf("foreign upsert: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, ts, "GET", "/api/v1/records/"+rec.ID, nil, alice)
+	var got models.Record
+	_ = json.Unmarshal(rr.Body.Bytes(), &got)
+	if rr.Code != http.StatusOK || string(got.Payload) != "secret" || got.Version != 1 {
+		t.Fatalf("owner's record: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTrash_ListRestoreEmpty(t *testing.T) {
+	ts := newTestServer(t)
+	authz := loginTestUser(t, ts, "trash@example.com")
+
+	var ids []string
+	for _, p := range []string{"a", "b"} {
+		rr := doJSON(t, ts, "POST", "/api/v1/records", map[string]any{"type": "text", "meta": map[string]string{"title": p}, "payload": []byte(p)}, authz)
+		var rec models.Record
+		_ = json.Unmarshal(rr.Body.Bytes(), &rec)
+		if rr := doJSON(t, ts, "DELETE", "/api/v1/records/"+rec.ID, nil, authz); rr.Code != http.StatusNoContent {
+			t.Fatalf("delete: %d", rr.Code)
+		}
+		ids = append(ids, rec.ID)
+	}
+
+	rr := doJSON(t, ts, "GET", "/api/v1/trash", nil, authz)
+	var items []models.TrashedRecord
+	_ = json.Unmarshal(rr.Body.Bytes(), &items)
+	if rr.Code != http.StatusOK || len(items) != 2 || items[0].Meta["title"] != "b" || items[0].DeletedAt.IsZero() || string(items[0].Payload) != "b" {
+		t.Fatalf("trash: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = doJSON(t, ts, "POST", "/api/v1/trash/"+ids[0]+"/restore", nil, authz)
+	var restored models.Record
+	_ = json.Unmarshal(rr.Body.Bytes(), &restored)
+	if rr.Code != http.StatusOK || restored.Version != 3 || string(restored.Payload) != "a" || restored.Meta["title"] != "a" {
+		t.Fatalf("restore: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, ts, "GET", "/api/v1/records/"+ids[0], nil, authz); rr.Code != http.StatusOK {
+		t.Fatalf("restored record: %d", rr.Code)
+	}
+	if rr := doJSON(t, ts, "POST", "/api/v1/trash/"+ids[0]+"/restore", nil, authz); rr.Code != http.StatusNotFound {
+		t.Fatalf("second restore: %d", rr.Code)
+	}
+
+	rr = doJSON(t, ts, "DELETE", "/api/v1/trash", nil, authz)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"deleted":1`) {
+		t.Fatalf("empty: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, ts, "GET", "/api/v1/trash", nil, authz)
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Fatalf("emptied trash: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRecords_Batch(t *testing.T) {
+	ts := newTestServer(t)
+	authz := loginTestUser(t, ts, "batch@example.com")
+	rr := doJSON(t, ts, "POST", "/api/v1/records", map[string]any{"type": "text", "payload": []byte("a")}, authz)
+	var rec models.Record
+	_ = json.Unmarshal(rr.Body.Bytes(), &rec)
+
+	var resp struct {
+		Error   string               `json:"error"`
+		Results []models.BatchResult `json:"results"`
+	}
+	rr = doJSON(t, ts, "POST", "/api/v1/records:batch", map[string]any{"ops": []map[string]any{
+		{"op": "upsert", "record": map[string]any{"type": "text", "payload": []byte("b")}},
+		{"op": "upsert", "record": map[string]any{"id": rec.ID, "type": "text", "payload": []byte("a2")}, "expected_version": 1},
+	}}, authz)
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || len(resp.Results) != 2 || resp.Results[0].Status != models.BatchOK || resp.Results[0].ID == "" || resp.Results[1].Version != 2 {
+		t.Fatalf("batch: %d %s", rr.Code, rr.Body.String())
+	}
+	created := resp.Results[0].ID
+
+	resp.Results = nil
+	rr = doJSON(t, ts, "POST", "/api/v1/records:batch", map[string]any{"ops": []map[string]any{
+		{"op": "delete", "id": created},
+		{"op": "upsert", "record": map[string]any{"id": rec.ID, "type": "text", "payload": []byte("stale")}, "expected_version": 1},
+	}}, authz)
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusConflict || len(resp.Results) != 2 || resp.Results[0].Status != models.BatchAborted || resp.Results[1].Status != models.BatchConflict {
+		t.Fatalf("conflicting batch: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, ts, "GET", "/api/v1/records/"+created, nil, authz); rr.Code != http.StatusOK {
+		t.Fatalf("rejected delete applied: %d", rr.Code)
+	}
+
+	resp.Results = nil
+	rr = doJSON(t, ts, "POST", "/api/v1/records:batch", map[string]any{"ops": []map[string]any{
+		{"op": "delete", "id": created},
+		{"op": "upsert", "record": map[string]any{"payload": []byte("untyped")}},
+	}}, authz)
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusBadRequest || len(resp.Results) != 2 || resp.Results[1].Status != models.BatchInvalid || resp.Results[1].Error != "type required" {
+		t.Fatalf("invalid batch: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, ts, "POST", "/api/v1/records:batch", map[string]any{"ops": []any{}}, authz); rr.Code != http.StatusBadRequest {
+		t.Fatalf("empty batch: %d", rr.Code)
+	}
+}
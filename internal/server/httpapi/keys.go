@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gophkeeper/internal/server/repository"
+)
+
+// handleGetKeyEscrow returns the caller's wrapped vault key.
+func (r *Router) handleGetKeyEscrow(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	k, err := r.services.Keys.Get(req.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no vault key stored"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf("%d", k.Version))
+	writeJSON(w, http.StatusOK, k)
+}
+
+// handlePutKeyEscrow stores the caller's wrapped vault key. Without If-Match
+// the stored key is overwritten; If-Match: 0 only creates it.
+func (r *Router) handlePutKeyEscrow(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	if r.maxRequestBytes > 0 {
+		req.Body = http.MaxBytesReader(w, req.Body, r.maxRequestBytes)
+	}
+	var body struct {
+		Blob []byte `json:"blob"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return
+	}
+	expected := int64(-1)
+	if v := req.Header.Get("If-Match"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid If-Match"})
+			return
+		}
+		expected = n
+	}
+	k, err := r.services.Keys.Put(req.Context(), userID, body.Blob, expected)
+	if err != nil {
+		if errors.Is(err, repository.ErrVersionConflict) {
+			if cur, err := r.services.Keys.Get(req.Context(), userID); err == nil {
+				w.Header().Set("ETag", fmt.Sprintf("%d", cur.Version))
+			}
+			writeJSON(w, http.StatusPreconditionFailed, map[string]string{"error": "version conflict"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf("%d", k.Version))
+	writeJSON(w, http.StatusOK, k)
+}
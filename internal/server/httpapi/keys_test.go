@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"gophkeeper/internal/server/config"
+	"gophkeeper/internal/server/repository/memory"
+	"gophkeeper/internal/server/service"
+	"gophkeeper/internal/shared/models"
+)
+
+// escrowOutageRepo fails key escrow reads like an unreachable database.
+type escrowOutageRepo struct {
+	service.Repository
+}
+
+func (escrowOutageRepo) GetKeyEscrow(context.Context, string) (models.KeyEscrow, error) {
+	return models.KeyEscrow{}, errors.New("database is down")
+}
+
+func TestKeyEscrow_PutGet(t *testing.T) {
+	ts := newTestServer(t)
+	authz := loginTestUser(t, ts, "escrow@example.com")
+
+	if rr := doJSON(t, ts, "GET", "/api/v1/keys/vault", nil, authz); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+	create := map[string]string{"Authorization": authz["Authorization"], "If-Match": "0"}
+	rr := doJSON(t, ts, "PUT", "/api/v1/keys/vault", map[string]any{"blob": []byte("wrapped")}, create)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != "1" {
+		t.Fatalf("put: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := doJSON(t, ts, "PUT", "/api/v1/keys/vault", map[string]any{"blob": []byte("other")}, create); rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d", rr.Code)
+	}
+	if rr := doJSON(t, ts, "PUT", "/api/v1/keys/vault", map[string]any{"blob": []byte{}}, authz); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty blob, got %d", rr.Code)
+	}
+
+	rr = doJSON(t, ts, "GET", "/api/v1/keys/vault", nil, authz)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("get: %d", rr.Code)
+	}
+	var k models.KeyEscrow
+	_ = json.Unmarshal(rr.Body.Bytes(), &k)
+	if string(k.Blob) != "wrapped" || k.Version != 1 {
+		t.Fatalf("unexpected escrow: %+v", k)
+	}
+
+	other := loginTestUser(t, ts, "escrow-other@example.com")
+	if rr := doJSON(t, ts, "GET", "/api/v1/keys/vault", nil, other); rr.Code != http.StatusNotFound {
+		t.Fatalf("other user must not see the key, got %d", rr.Code)
+	}
+}
+
+func TestKeyEscrow_GetFailureIsNotMissing(t *testing.T) {
+	svcs := service.NewServices(escrowOutageRepo{memory.New()}, config.Config{JWTSecret: "test", MaxRequestBytes: 1 << 20, MaxRecordPayloadBytes: 1 << 20})
+	ts := NewRouter(svcs, nil, 1<<20)
+	authz := loginTestUser(t, ts, "outage@example.com")
+	if rr := doJSON(t, ts, "GET", "/api/v1/keys/vault", nil, authz); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 on a repository error, got %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -1,28 +1,101 @@
 package httpapi
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"gophkeeper/internal/server/repository"
+	"gophkeeper/internal/server/service"
 	"gophkeeper/internal/shared/models"
 )
 
+// handleListRecords returns the owner's records as a JSON array. With
+// `limit` the listing is paged and the cursor for the next page, if any, is
+// sent in the X-Next-Cursor header. `fields=meta` leaves out the payloads.
 func (r *Router) handleListRecords(w http.ResponseWriter, req *http.Request) {
 	userID := getUserID(req.Context())
-	records, err := r.services.Records.List(req.Context(), userID)
+	q, err := parseRecordQuery(req.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	var (
+		records any
+		next    string
+	)
+	switch req.URL.Query().Get("fields") {
+	case "":
+		records, next, err = r.services.Records.List(req.Context(), userID, q, req.URL.Query().Get("cursor"))
+	case "meta":
+		records, next, err = r.services.Records.ListSummaries(req.Context(), userID, q, req.URL.Query().Get("cursor"))
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid fields"})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidQuery) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
 	}
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
 	writeJSON(w, http.StatusOK, records)
 }
 
+// parseRecordQuery reads the listing parameters: limit, type, updated_after
+// (RFC 3339), sort (`updated_at` or the default `-updated_at`) and any number
+// of `meta.<key>=<value>` filters.
+func parseRecordQuery(v url.Values) (models.RecordQuery, error) {
+	var q models.RecordQuery
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return q, errors.New("invalid limit")
+		}
+		q.Limit = n
+	}
+	q.Type = models.RecordType(v.Get("type"))
+	if s := v.Get("updated_after"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return q, errors.New("invalid updated_after")
+		}
+		q.UpdatedAfter = t
+	}
+	switch v.Get("sort") {
+	case "", "-updated_at":
+	case "updated_at":
+		q.Ascending = true
+	default:
+		return q, errors.New("invalid sort")
+	}
+	for name, vals := range v {
+		key, ok := strings.CutPrefix(name, "meta.")
+		if !ok {
+			continue
+		}
+		if q.Meta == nil {
+			q.Meta = map[string]string{}
+		}
+		q.Meta[key] = vals[0]
+	}
+	return q, nil
+}
+
 func (r *Router) handleGetRecord(w http.ResponseWriter, req *http.Request) {
 	userID := getUserID(req.Context())
 	id := chi.URLParam(req, "id")
@@ -69,7 +142,7 @@ func (r *Router) handleUpsertRecord(w http.ResponseWriter, req *http.Request) {
 		rec, err := r.services.Records.UpsertConditional(req.Context(), body, expected)
 		if err != nil {
 			if errors.Is(err, repository.ErrVersionConflict) {
-				writeJSON(w, http.StatusPreconditionFailed, map[string]string{"error": "version conflict"})
+				r.writeConflict(w, req, body.ID)
 				return
 			}
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
@@ -80,6 +153,10 @@ func (r *Router) handleUpsertRecord(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	rec, err := r.services.Records.Upsert(req.Context(), body)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
+		return
+	}
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
@@ -97,3 +174,81 @@ func (r *Router) handleDeleteRecord(w http.ResponseWriter, req *http.Request) {
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// handleListRevisions returns the kept earlier versions of a record, newest
+// first.
+func (r *Router) handleListRevisions(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	revs, err := r.services.Records.Revisions(req.Context(), userID, chi.URLParam(req, "id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "record not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if revs == nil {
+		revs = []models.Record{}
+	}
+	writeJSON(w, http.StatusOK, revs)
+}
+
+// handleDropRevisions removes the kept earlier versions of a record.
+func (r *Router) handleDropRevisions(w http.ResponseWriter, req *http.Request) {
+	if err := r.services.Records.DropRevisions(req.Context(), getUserID(req.Context()), chi.URLParam(req, "id")); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRestoreRecord saves revision `version` as the newest version of the
+// record. An optional If-Match must name the current version.
+func (r *Router) handleRestoreRecord(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	id := chi.URLParam(req, "id")
+	version, err := strconv.ParseInt(req.URL.Query().Get("version"), 10, 64)
+	if err != nil || version < 1 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid version"})
+		return
+	}
+	var expected int64
+	if ifMatch := req.Header.Get("If-Match"); ifMatch != "" {
+		expected, err = strconv.ParseInt(ifMatch, 10, 64)
+		if err != nil || expected < 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid If-Match"})
+			return
+		}
+	}
+	rec, err := r.services.Records.Restore(req.Context(), userID, id, version, expected)
+	switch {
+	case errors.Is(err, repository.ErrVersionConflict):
+		r.writeConflict(w, req, id)
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "record or revision not found"})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf("%d", rec.Version))
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// conflictResponse is the 412 body; Current is the record as stored on the
+// server so that clients can resolve the conflict without another round trip.
+type conflictResponse struct {
+	Error   string         `json:"error"`
+	Current *models.Record `json:"current,omitempty"`
+}
+
+func (r *Router) writeConflict(w http.ResponseWriter, req *http.Request, id string) {
+	resp := conflictResponse{Error: "version conflict"}
+	if cur, err := r.services.Records.Get(req.Context(), getUserID(req.Context()), id); err == nil {
+		resp.Current = &cur
+		w.Header().Set("ETag", fmt.Sprintf("%d", cur.Version))
+	}
+	writeJSON(w, http.StatusPreconditionFailed, resp)
+}
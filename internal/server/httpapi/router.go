@@ -30,8 +30,24 @@ func NewRouter(services *service.Services, logger *log.Logger, maxRequestBytes i
 		pr.Use(r.authMiddleware)
 		pr.Get("/api/v1/records", r.handleListRecords)
 		pr.Post("/api/v1/records", r.handleUpsertRecord)
+		pr.Post("/api/v1/records:batch", r.handleBatchRecords)
 		pr.Get("/api/v1/records/{id}", r.handleGetRecord)
 		pr.Delete("/api/v1/records/{id}", r.handleDeleteRecord)
+		pr.Get("/api/v1/records/{id}/content", r.handleGetRecordContent)
+		pr.Get("/api/v1/records/{id}/revisions", r.handleListRevisions)
+		pr.Delete("/api/v1/records/{id}/revisions", r.handleDropRevisions)
+		pr.Post("/api/v1/records/{id}/restore", r.handleRestoreRecord)
+		pr.Get("/api/v1/trash", r.handleListTrash)
+		pr.Delete("/api/v1/trash", r.handleEmptyTrash)
+		pr.Post("/api/v1/trash/{id}/restore", r.handleRestoreTrashed)
+		pr.Get("/api/v1/sync", r.handleSync)
+		pr.Get("/api/v1/keys/vault", r.handleGetKeyEscrow)
+		pr.Put("/api/v1/keys/vault", r.handlePutKeyEscrow)
+		pr.Post("/api/v1/uploads", r.handleCreateUpload)
+		pr.Get("/api/v1/uploads/{id}", r.handleGetUpload)
+		pr.Patch("/api/v1/uploads/{id}", r.handleAppendUpload)
+		pr.Delete("/api/v1/uploads/{id}", r.handleAbortUpload)
+		pr.Post("/api/v1/uploads/{id}/complete", r.handleCompleteUpload)
 	})
 
 	return mux
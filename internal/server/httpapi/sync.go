@@ -0,0 +1,27 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// handleSync returns records changed since the `since` cursor together with
+// deletion tombstones and the cursor to use on the next call.
+func (r *Router) handleSync(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	var since int64
+	if v := req.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			return
+		}
+		since = n
+	}
+	changes, err := r.services.Records.Changes(req.Context(), userID, since)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"gophkeeper/internal/shared/models"
+)
+
+// loginTestUser registers a user on ts and returns the Authorization header.
+func loginTestUser(t *testing.T, ts http.Handler, email string) map[string]string {
+	t.Helper()
+	creds := map[string]string{"email": email, "password": "pass"}
+	if rr := doJSON(t, ts, "POST", "/api/v1/auth/register", creds, nil); rr.Code != http.StatusCreated {
+		t.Fatalf("register: %d %s", rr.Code, rr.Body.String())
+	}
+	rr := doJSON(t, ts, "POST", "/api/v1/auth/login", creds, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", rr.Code, rr.Body.String())
+	}
+	var tok models.TokenResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &tok)
+	return map[string]string{"Authorization": "Bearer " + tok.AccessToken}
+}
+
+func TestSync_DeltaAndTombstones(t *testing.T) {
+	ts := newTestServer(t)
+	authz := loginTestUser(t, ts, "sync@example.com")
+
+	rr := doJSON(t, ts, "POST", "/api/v1/records", map[string]any{"type": "text", "payload": []byte("x")}, authz)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: %d", rr.Code)
+	}
+	var rec models.Record
+	_ = json.Unmarshal(rr.Body.Bytes(), &rec)
+
+	rr = doJSON(t, ts, "GET", "/api/v1/sync", nil, authz)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("sync: %d %s", rr.Code, rr.Body.String())
+	}
+	var first models.Changes
+	_ = json.Unmarshal(rr.Body.Bytes(), &first)
+	if len(first.Records) != 1 || first.Cursor == 0 {
+		t.Fatalf("unexpected first sync: %+v", first)
+	}
+
+	if rr := doJSON(t, ts, "DELETE", "/api/v1/records/"+rec.ID, nil, authz); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", rr.Code)
+	}
+	rr = doJSON(t, ts, "GET", fmt.Sprintf("/api/v1/sync?since=%d", first.Cursor), nil, authz)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("sync delta: %d", rr.Code)
+	}
+	var delta models.Changes
+	_ = json.Unmarshal(rr.Body.Bytes(), &delta)
+	if len(delta.Records) != 0 || len(delta.Deleted) != 1 || delta.Deleted[0].ID != rec.ID {
+		t.Fatalf("unexpected delta: %+v", delta)
+	}
+}
+
+func TestSync_InvalidCursor(t *testing.T) {
+	ts := newTestServer(t)
+	authz := loginTestUser(t, ts, "sync-bad@example.com")
+	rr := doJSON(t, ts, "GET", "/api/v1/sync?since=abc", nil, authz)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("want 400 got %d", rr.Code)
+	}
+}
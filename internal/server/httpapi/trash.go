@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"gophkeeper/internal/server/repository"
+	"gophkeeper/internal/shared/models"
+)
+
+// handleListTrash returns the caller's deleted records that can still be
+// restored, most recently deleted first.
+func (r *Router) handleListTrash(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	items, err := r.services.Records.Trash(req.Context(), userID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if items == nil {
+		items = []models.TrashedRecord{}
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// handleRestoreTrashed stores a trashed record again and returns it.
+func (r *Router) handleRestoreTrashed(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	rec, err := r.services.Records.Undelete(req.Context(), userID, chi.URLParam(req, "id"))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "record not in trash"})
+		return
+	case errors.Is(err, repository.ErrVersionConflict):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "record was stored again"})
+		return
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf("%d", rec.Version))
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// handleEmptyTrash permanently removes all records from the caller's trash.
+func (r *Router) handleEmptyTrash(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	n, err := r.services.Records.EmptyTrash(req.Context(), userID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"deleted": n})
+}
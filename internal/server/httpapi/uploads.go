@@ -0,0 +1,152 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"gophkeeper/internal/server/repository"
+	"gophkeeper/internal/server/service"
+	"gophkeeper/internal/shared/models"
+)
+
+// writeUploadError maps upload service errors to HTTP responses.
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrUploadsDisabled):
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "upload not found"})
+	case errors.Is(err, service.ErrUploadTooLarge):
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+}
+
+func setUploadOffset(w http.ResponseWriter, up models.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Size, 10))
+}
+
+func (r *Router) handleCreateUpload(w http.ResponseWriter, req *http.Request) {
+	up, err := r.services.Uploads.Create(req.Context(), getUserID(req.Context()))
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/api/v1/uploads/"+up.ID)
+	setUploadOffset(w, up)
+	writeJSON(w, http.StatusCreated, up)
+}
+
+// handleGetUpload reports how many bytes were received so that an interrupted
+// upload can be resumed.
+func (r *Router) handleGetUpload(w http.ResponseWriter, req *http.Request) {
+	up, err := r.services.Uploads.Status(req.Context(), getUserID(req.Context()), chi.URLParam(req, "id"))
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	setUploadOffset(w, up)
+	writeJSON(w, http.StatusOK, up)
+}
+
+// handleAppendUpload stores the request body at the offset given in the
+// Upload-Offset header. A stale offset yields 409 with the current one.
+func (r *Router) handleAppendUpload(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	id := chi.URLParam(req, "id")
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid Upload-Offset"})
+		return
+	}
+	if r.maxRequestBytes > 0 {
+		req.Body = http.MaxBytesReader(w, req.Body, r.maxRequestBytes)
+	}
+	up, err := r.services.Uploads.Append(req.Context(), userID, id, offset, req.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		switch {
+		case errors.Is(err, service.ErrUploadOffset), errors.Is(err, repository.ErrVersionConflict):
+			setUploadOffset(w, up)
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "upload offset mismatch"})
+		case errors.As(err, &maxErr):
+			setUploadOffset(w, up)
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "request entity too large"})
+		default:
+			writeUploadError(w, err)
+		}
+		return
+	}
+	setUploadOffset(w, up)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Router) handleAbortUpload(w http.ResponseWriter, req *http.Request) {
+	if err := r.services.Uploads.Abort(req.Context(), getUserID(req.Context()), chi.URLParam(req, "id")); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCompleteUpload stores the record in the body with the uploaded data
+// as its content. If-Match works as for POST /api/v1/records.
+func (r *Router) handleCompleteUpload(w http.ResponseWriter, req *http.Request) {
+	userID := getUserID(req.Context())
+	if r.maxRequestBytes > 0 {
+		req.Body = http.MaxBytesReader(w, req.Body, r.maxRequestBytes)
+	}
+	var body models.Record
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		return
+	}
+	expected := int64(-1)
+	if v := req.Header.Get("If-Match"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid If-Match"})
+			return
+		}
+		expected = n
+	}
+	rec, err := r.services.Uploads.Complete(req.Context(), userID, chi.URLParam(req, "id"), body, expected)
+	if err != nil {
+		if errors.Is(err, repository.ErrVersionConflict) {
+			r.writeConflict(w, req, body.ID)
+			return
+		}
+		writeUploadError(w, err)
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf("%d", rec.Version))
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// handleGetRecordContent streams the uploaded content of a record. Range
+// requests are supported so that downloads can be resumed.
+func (r *Router) handleGetRecordContent(w http.ResponseWriter, req *http.Request) {
+	f, rec, err := r.services.Records.OpenContent(req.Context(), getUserID(req.Context()), chi.URLParam(req, "id"))
+	if err != nil {
+		if errors.Is(err, service.ErrUploadsDisabled) {
+			writeUploadError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "content not found"})
+		return
+	}
+	defer f.Close()
+	// Large content cannot be sent within the server write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", fmt.Sprintf("%q", rec.ContentID))
+	http.ServeContent(w, req, "", rec.UpdatedAt, f)
+}
@@ -0,0 +1,163 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/server/blobstore"
+	"gophkeeper/internal/server/config"
+	"gophkeeper/internal/server/repository/memory"
+	"gophkeeper/internal/server/service"
+	"gophkeeper/internal/shared/models"
+)
+
+func newUploadTestServer(t *testing.T) (http.Handler, *service.Services, string) {
+	t.Helper()
+	repo := memory.New()
+	dir := t.TempDir()
+	svcs := service.NewServices(repo, config.Config{JWTSecret: "test", MaxRecordPayloadBytes: 1 << 20, DataDir: dir, MaxUploadBytes: 64, BlobInlineMax: 16, TombstoneRetention: time.Nanosecond, TrashRetention: time.Nanosecond})
+	return NewRouter(svcs, nil, 1<<20), svcs, dir
+}
+
+func patchUpload(t *testing.T, ts http.Handler, authz map[string]string, id, offset string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req, _ := http.NewRequest("PATCH", "/api/v1/uploads/"+id, bytes.NewReader(data))
+	req.Header.Set("Authorization", authz["Authorization"])
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Upload-Offset", offset)
+	rr := httptest.NewRecorder()
+	ts.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestUploads_ResumeCompleteAndDownload(t *testing.T) {
+	ts, svcs, dir := newUploadTestServer(t)
+	authz := loginTestUser(t, ts, "uploads@example.com")
+
+	rr := doJSON(t, ts, "POST", "/api/v1/uploads", nil, authz)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	var up models.Upload
+	_ = json.Unmarshal(rr.Body.Bytes(), &up)
+
+	if rr := patchUpload(t, ts, authz, up.ID, "0", []byte("hello ")); rr.Code != http.StatusNoContent || rr.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("append: %d %v", rr.Code, rr.Header())
+	}
+	if rr := patchUpload(t, ts, authz, up.ID, "0", []byte("again")); rr.Code != http.StatusConflict || rr.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("expected 409 with offset, got %d %v", rr.Code, rr.Header())
+	}
+	if rr := patchUpload(t, ts, authz, up.ID, "6", bytes.Repeat([]byte("x"), 100)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rr.Code)
+	}
+	rr = doJSON(t, ts, "GET", "/api/v1/uploads/"+up.ID, nil, authz)
+	if rr.Code != http.StatusOK || rr.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("status: %d %v", rr.Code, rr.Header())
+	}
+	if rr := patchUpload(t, ts, authz, up.ID, "6", []byte("world")); rr.Code != http.StatusNoContent {
+		t.Fatalf("append: %d", rr.Code)
+	}
+
+	rr = doJSON(t, ts, "POST", "/api/v1/uploads/"+up.ID+"/complete", map[string]any{"type": "binary", "meta": map[string]string{"name": "f"}}, authz)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("complete: %d %s", rr.Code, rr.Body.String())
+	}
+	var rec models.Record
+	_ = json.Unmarshal(rr.Body.Bytes(), &rec)
+	if rec.ContentID != blobstore.Key([]byte("hello world")) || rec.ContentSize != 11 {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	if rr := doJSON(t, ts, "GET", "/api/v1/uploads/"+up.ID, nil, authz); rr.Code != http.StatusNotFound {
+		t.Fatalf("completed upload must be gone, got %d", rr.Code)
+	}
+
+	rr = doJSON(t, ts, "GET", "/api/v1/records/"+rec.ID+"/content", nil, authz)
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello world" {
+		t.Fatalf("download: %d %q", rr.Code, rr.Body.String())
+	}
+	rr = doJSON(t, ts, "GET", "/api/v1/records/"+rec.ID+"/content", nil, map[string]string{"Authorization": authz["Authorization"], "Range": "bytes=6-"})
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "world" {
+		t.Fatalf("range: %d %q", rr.Code, rr.Body.String())
+	}
+	other := loginTestUser(t, ts, "uploads-other@example.com")
+	if rr := doJSON(t, ts, "GET", "/api/v1/records/"+rec.ID+"/content", nil, other); rr.Code != http.StatusNotFound {
+		t.Fatalf("other user must not read content, got %d", rr.Code)
+	}
+
+	// Updating the record keeps its content only if the client echoes it.
+	rec.Meta["name"] = "g"
+	rr = doJSON(t, ts, "POST", "/api/v1/records", rec, authz)
+	var updated models.Record
+	_ = json.Unmarshal(rr.Body.Bytes(), &updated)
+	if rr.Code != http.StatusOK || updated.ContentID != rec.ContentID || updated.ContentSize != 11 {
+		t.Fatalf("update: %d %s", rr.Code, rr.Body.String())
+	}
+	forged := map[string]any{"type": "binary", "content_id": "00000000000000000000000000000000"}
+	if rr := doJSON(t, ts, "POST", "/api/v1/records", forged, authz); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for foreign content_id, got %d", rr.Code)
+	}
+
+	if rr := doJSON(t, ts, "DELETE", "/api/v1/records/"+rec.ID, nil, authz); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d", rr.Code)
+	}
+	// the trash and the earlier revision hold the content until purged
+	if _, err := svcs.Records.PurgeTrash(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svcs.Records.PurgeTombstones(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := svcs.Records.CollectGarbage(context.Background()); err != nil || n != 1 {
+		t.Fatalf("collect: %d %v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blobs", rec.ContentID[:2], rec.ContentID)); !os.IsNotExist(err) {
+		t.Fatalf("blob must be removed, got %v", err)
+	}
+}
+
+func TestRecords_LargePayloadInBlobStore(t *testing.T) {
+	ts, _, dir := newUploadTestServer(t)
+	authz := loginTestUser(t, ts, "blob-payload@example.com")
+
+	payload := []byte("a payload longer than the inline limit")
+	rr := doJSON(t, ts, "POST", "/api/v1/records", map[string]any{"type": "text", "payload": payload}, authz)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	var rec models.Record
+	_ = json.Unmarshal(rr.Body.Bytes(), &rec)
+	if !bytes.Equal(rec.Payload, payload) {
+		t.Fatalf("response payload: %q", rec.Payload)
+	}
+	key := blobstore.Key(payload)
+	if _, err := os.Stat(filepath.Join(dir, "blobs", key[:2], key)); err != nil {
+		t.Fatalf("payload must be in the blob store: %v", err)
+	}
+
+	rr = doJSON(t, ts, "GET", "/api/v1/records/"+rec.ID, nil, authz)
+	_ = json.Unmarshal(rr.Body.Bytes(), &rec)
+	if !bytes.Equal(rec.Payload, payload) {
+		t.Fatalf("get payload: %q", rec.Payload)
+	}
+	rr = doJSON(t, ts, "GET", "/api/v1/sync", nil, authz)
+	var ch models.Changes
+	_ = json.Unmarshal(rr.Body.Bytes(), &ch)
+	if len(ch.Records) != 1 || !bytes.Equal(ch.Records[0].Payload, payload) {
+		t.Fatalf("sync payload: %s", rr.Body.String())
+	}
+}
+
+func TestUploads_DisabledWithoutDataDir(t *testing.T) {
+	ts := newTestServer(t)
+	authz := loginTestUser(t, ts, "uploads-disabled@example.com")
+	if rr := doJSON(t, ts, "POST", "/api/v1/uploads", nil, authz); rr.Code != http.StatusNotImplemented {
+		t.Fatalf("expected 501, got %d", rr.Code)
+	}
+}
@@ -7,4 +7,11 @@ type (
 	TokenResponse = sm.TokenResponse
 	RecordType    = sm.RecordType
 	Record        = sm.Record
+	Tombstone     = sm.Tombstone
+	Changes       = sm.Changes
+	KeyEscrow     = sm.KeyEscrow
+	Upload        = sm.Upload
+	RecordQuery   = sm.RecordQuery
+	RecordKey     = sm.RecordKey
+	RecordSummary = sm.RecordSummary
 )
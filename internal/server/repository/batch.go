@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"gophkeeper/internal/shared/models"
+)
+
+// ApplyBatch runs the owner's batch operations in order through upsert and
+// del, which must share one transaction, and returns their results. When an
+// operation conflicts or misses its record the error is ErrBatchRejected:
+// the operations that succeeded are reported as aborted and the caller must
+// roll the transaction back. Any other error is returned as is.
+//
+// Upserts are stored for ownerID; records without an ID are given one.
+func ApplyBatch(ownerID string, ops []models.BatchOp, upsert func(rec models.Record, expectedVersion *int64) (models.Record, error), del func(id string) error) ([]models.BatchResult, error) {
+	results := make([]models.BatchResult, len(ops))
+	rejected := false
+	for i, op := range ops {
+		var err error
+		res := &results[i]
+		switch {
+		case op.Op == models.BatchUpsert && op.Record != nil:
+			rec := *op.Record
+			rec.OwnerID = ownerID
+			if rec.ID == "" {
+				rec.ID = uuid.NewString()
+			}
+			res.ID = rec.ID
+			var saved models.Record
+			if saved, err = upsert(rec, op.ExpectedVersion); err == nil {
+				res.Version, res.UpdatedAt = saved.Version, &saved.UpdatedAt
+			}
+		case op.Op == models.BatchDelete && op.ID != "":
+			res.ID = op.ID
+			err = del(op.ID)
+		default:
+			res.ID, res.Status, res.Error = op.ID, models.BatchInvalid, "unknown operation"
+			rejected = true
+			continue
+		}
+		switch {
+		case err == nil:
+			res.Status = models.BatchOK
+		case errors.Is(err, ErrVersionConflict):
+			res.Status, res.Error = models.BatchConflict, err.Error()
+			rejected = true
+		case errors.Is(err, sql.ErrNoRows):
+			res.Status, res.Error = models.BatchNotFound, "record not found"
+			rejected = true
+		default:
+			return nil, err
+		}
+	}
+	if !rejected {
+		return results, nil
+	}
+	for i := range results {
+		if results[i].Status == models.BatchOK {
+			results[i].Status, results[i].Version, results[i].UpdatedAt = models.BatchAborted, 0, nil
+		}
+	}
+	return results, ErrBatchRejected
+}
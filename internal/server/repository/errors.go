@@ -4,3 +4,7 @@ import "errors"
 
 // ErrVersionConflict indicates optimistic lock failure on update.
 var ErrVersionConflict = errors.New("version conflict")
+
+// ErrBatchRejected indicates that an operation of a batch failed, so none
+// of its operations were applied.
+var ErrBatchRejected = errors.New("batch rejected")
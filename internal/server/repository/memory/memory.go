@@ -0,0 +1,743 @@
+// Package memory implements service.Repository in process memory for tests
+// and throwaway demo servers. Every repository starts empty and nothing is
+// persisted; the semantics (versions, tombstones, the change sequence, blob
+// reference counts) follow the SQL implementations.
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"gophkeeper/internal/server/repository"
+	"gophkeeper/internal/shared/models"
+)
+
+var (
+	errDuplicateEmail  = errors.New("memory: email already registered")
+	errDuplicateUpload = errors.New("memory: upload already exists")
+	errDuplicateToken  = errors.New("memory: refresh token already exists")
+)
+
+type user struct {
+	id           string
+	passwordHash []byte
+}
+
+// record is a stored record; deletedAt is set on tombstones.
+type record struct {
+	models.Record
+	seq       int64
+	deletedAt time.Time
+}
+
+type upload struct {
+	ownerID string
+	models.Upload
+}
+
+type blob struct {
+	size      int64
+	refs      int64
+	updatedAt time.Time
+}
+
+type refreshToken struct {
+	userID    string
+	expiresAt time.Time
+}
+
+// Repository guards all state with a single mutex, which also makes every
+// method atomic the way a transaction is in the SQL implementations.
+type Repository struct {
+	mu        sync.Mutex
+	users     map[string]user // by email
+	records   map[string]*record
+	revisions map[string][]models.Record // by record id, oldest first
+	trash     map[string]models.TrashedRecord
+	lastSeq   int64
+	purgedSeq int64
+	keys      map[string]models.KeyEscrow
+	uploads   map[string]upload
+	blobs     map[string]*blob
+	tokens    map[string]refreshToken
+}
+
+func New() *Repository {
+	return &Repository{
+		users:     map[string]user{},
+		records:   map[string]*record{},
+		revisions: map[string][]models.Record{},
+		trash:     map[string]models.TrashedRecord{},
+		keys:      map[string]models.KeyEscrow{},
+		uploads:   map[string]upload{},
+		blobs:     map[string]*blob{},
+		tokens:    map[string]refreshToken{},
+	}
+}
+
+// Close is a no-op; it lets the repository stand in for the SQL ones.
+func (r *Repository) Close() error {
+	return nil
+}
+
+// Auth
+
+func (r *Repository) CreateUser(ctx context.Context, email string, passwordHash []byte) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[email]; ok {
+		return models.User{}, errDuplicateEmail
+	}
+	u := models.User{ID: uuid.NewString(), Email: email, CreatedAt: time.Now().UTC()}
+	r.users[email] = user{id: u.ID, passwordHash: clone(passwordHash)}
+	return u, nil
+}
+
+func (r *Repository) GetUserByEmail(ctx context.Context, email string) (id string, passwordHash []byte, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[email]
+	if !ok {
+		return "", nil, sql.ErrNoRows
+	}
+	return u.id, clone(u.passwordHash), nil
+}
+
+// Records
+
+func (r *Repository) UpsertRecord(ctx context.Context, rec models.Record) (models.Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.upsert(rec)
+}
+
+// upsert stores rec unconditionally; a record of another owner with the same
+// ID is reported as sql.ErrNoRows.
+func (r *Repository) upsert(rec models.Record) (models.Record, error) {
+	if rec.ID == "" {
+		rec.ID = uuid.NewString()
+	}
+	if cur, ok := r.records[rec.ID]; ok && cur.OwnerID != rec.OwnerID {
+		return models.Record{}, sql.ErrNoRows
+	}
+	if rec.Version == 0 {
+		rec.Version = 1
+	} else {
+		rec.Version++
+	}
+	rec.UpdatedAt = time.Now().UTC()
+	r.store(rec)
+	return rec, nil
+}
+
+func (r *Repository) UpsertRecordConditional(ctx context.Context, rec models.Record, expectedVersion int64) (models.Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.upsertConditional(rec, expectedVersion)
+}
+
+func (r *Repository) upsertConditional(rec models.Record, expectedVersion int64) (models.Record, error) {
+	if rec.ID == "" {
+		rec.ID = uuid.NewString()
+	}
+	cur, ok := r.records[rec.ID]
+	switch {
+	case expectedVersion == 0 && !ok:
+		rec.Version = 1
+	// a resurrected record continues its version sequence
+	case expectedVersion == 0 && !cur.deletedAt.IsZero() && cur.OwnerID == rec.OwnerID:
+		rec.Version = cur.Version + 1
+	case ok && cur.deletedAt.IsZero() && cur.OwnerID == rec.OwnerID && cur.Version == expectedVersion:
+		rec.Version = expectedVersion + 1
+	default:
+		return models.Record{}, repository.ErrVersionConflict
+	}
+	rec.UpdatedAt = time.Now().UTC()
+	r.store(rec)
+	return rec, nil
+}
+
+// store writes rec as a live record under the next change sequence and moves
+// the blob references of the replaced row to the new one. A replaced live
+// record is kept as a revision.
+func (r *Repository) store(rec models.Record) {
+	if cur, ok := r.records[rec.ID]; ok {
+		if cur.deletedAt.IsZero() {
+			r.keepRevision(cur.Record)
+		}
+		r.release(cur.Record)
+	}
+	r.lastSeq++
+	stored := &record{Record: cloneRecord(rec), seq: r.lastSeq}
+	r.records[rec.ID] = stored
+	r.acquire(stored.Record)
+}
+
+// ListRecords returns the owner's live records matching q, newest first
+// unless q.Ascending is set, ordered by (updated_at, id) like the SQL
+// implementations.
+func (r *Repository) ListRecords(ctx context.Context, ownerID string, q models.RecordQuery) ([]models.Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []models.Record
+	for _, rec := range r.list(ownerID, q) {
+		out = append(out, cloneRecord(rec.Record))
+	}
+	return out, nil
+}
+
+func (r *Repository) ListRecordSummaries(ctx context.Context, ownerID string, q models.RecordQuery) ([]models.RecordSummary, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []models.RecordSummary
+	for _, rec := range r.list(ownerID, q) {
+		out = append(out, cloneRecord(rec.Record).Summary())
+	}
+	return out, nil
+}
+
+func (r *Repository) list(ownerID string, q models.RecordQuery) []*record {
+	before := func(a, b models.RecordKey) bool {
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		}
+		return a.ID < b.ID
+	}
+	// less orders records in listing order
+	less := func(a, b models.RecordKey) bool {
+		if q.Ascending {
+			return before(a, b)
+		}
+		return before(b, a)
+	}
+	var out []*record
+	for _, rec := range r.records {
+		if rec.OwnerID != ownerID || !rec.deletedAt.IsZero() || !q.Matches(rec.Record) {
+			continue
+		}
+		if q.After != nil && !less(*q.After, key(rec)) {
+			continue
+		}
+		out = append(out, rec)
+	}
+	sort.Slice(out, func(i, j int) bool { return less(key(out[i]), key(out[j])) })
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out
+}
+
+func key(rec *record) models.RecordKey {
+	return models.RecordKey{UpdatedAt: rec.UpdatedAt, ID: rec.ID}
+}
+
+func (r *Repository) GetRecord(ctx context.Context, ownerID, id string) (models.Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.records[id]
+	if !ok || rec.OwnerID != ownerID || !rec.deletedAt.IsZero() {
+		return models.Record{}, sql.ErrNoRows
+	}
+	return cloneRecord(rec.Record), nil
+}
+
+// DeleteRecord moves the record to the trash and turns it into a tombstone:
+// the payload and meta are dropped, the version is bumped and the record
+// stays visible to sync until PurgeTombstones removes it.
+func (r *Repository) DeleteRecord(ctx context.Context, ownerID, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.deleteRecord(ownerID, id)
+}
+
+func (r *Repository) deleteRecord(ownerID, id string) error {
+	rec, ok := r.records[id]
+	if !ok || rec.OwnerID != ownerID || !rec.deletedAt.IsZero() {
+		return sql.ErrNoRows
+	}
+	now := time.Now().UTC()
+	if old, ok := r.trash[id]; ok {
+		r.release(old.Record)
+	}
+	// the trash takes over the blob references of the record
+	r.trash[id] = models.TrashedRecord{Record: cloneRecord(rec.Record), DeletedAt: now}
+	r.lastSeq++
+	rec.Meta = map[string]string{}
+	rec.Payload = []byte{}
+	rec.ContentID, rec.ContentSize, rec.PayloadRef = "", 0, ""
+	rec.Version++
+	rec.UpdatedAt = now
+	rec.seq = r.lastSeq
+	rec.deletedAt = now
+	return nil
+}
+
+// ApplyBatch applies the owner's upserts and deletes all or none; see
+// repository.ApplyBatch. A rejected batch is rolled back by restoring a copy
+// of the state taken before it.
+func (r *Repository) ApplyBatch(ctx context.Context, ownerID string, ops []models.BatchOp) ([]models.BatchResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := r.snapshot()
+	results, err := repository.ApplyBatch(ownerID, ops, func(rec models.Record, expectedVersion *int64) (models.Record, error) {
+		if expectedVersion != nil {
+			return r.upsertConditional(rec, *expectedVersion)
+		}
+		return r.upsert(rec)
+	}, func(id string) error {
+		return r.deleteRecord(ownerID, id)
+	})
+	if err != nil {
+		r.restore(saved)
+	}
+	return results, err
+}
+
+// state is the part of the repository a batch can change.
+type state struct {
+	records   map[string]*record
+	revisions map[string][]models.Record
+	trash     map[string]models.TrashedRecord
+	blobs     map[string]*blob
+	lastSeq   int64
+}
+
+// snapshot copies the state; records and blobs are changed in place, so they
+// are copied one by one.
+func (r *Repository) snapshot() state {
+	st := state{
+		records:   make(map[string]*record, len(r.records)),
+		revisions: make(map[string][]models.Record, len(r.revisions)),
+		trash:     make(map[string]models.TrashedRecord, len(r.trash)),
+		blobs:     make(map[string]*blob, len(r.blobs)),
+		lastSeq:   r.lastSeq,
+	}
+	for id, rec := range r.records {
+		c := *rec
+		st.records[id] = &c
+	}
+	for id, revs := range r.revisions {
+		st.revisions[id] = append([]models.Record(nil), revs...)
+	}
+	for id, t := range r.trash {
+		st.trash[id] = t
+	}
+	for h, b := range r.blobs {
+		c := *b
+		st.blobs[h] = &c
+	}
+	return st
+}
+
+func (r *Repository) restore(st state) {
+	r.records, r.revisions, r.trash, r.blobs, r.lastSeq = st.records, st.revisions, st.trash, st.blobs, st.lastSeq
+}
+
+// ListChanges returns records created or updated and tombstones of records
+// deleted after the given change sequence, in sequence order. When tombstones
+// newer than since were already purged, a full snapshot is returned with
+// Reset set.
+func (r *Repository) ListChanges(ctx context.Context, ownerID string, since int64) (models.Changes, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := models.Changes{Records: []models.Record{}, Deleted: []models.Tombstone{}, Cursor: max(since, r.lastSeq)}
+	if since > 0 && since < r.purgedSeq {
+		changes.Reset = true
+		since = 0
+	}
+	var changed []*record
+	for _, rec := range r.records {
+		if rec.OwnerID == ownerID && rec.seq > since {
+			changed = append(changed, rec)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].seq < changed[j].seq })
+	for _, rec := range changed {
+		if rec.deletedAt.IsZero() {
+			changes.Records = append(changes.Records, cloneRecord(rec.Record))
+		} else {
+			changes.Deleted = append(changes.Deleted, models.Tombstone{ID: rec.ID, Version: rec.Version, DeletedAt: rec.deletedAt})
+		}
+	}
+	return changes, nil
+}
+
+// PurgeTombstones permanently removes tombstones deleted before the given time
+// unless their record is still in the trash.
+func (r *Repository) PurgeTombstones(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for id, rec := range r.records {
+		if rec.deletedAt.IsZero() || !rec.deletedAt.Before(before) {
+			continue
+		}
+		if _, ok := r.trash[id]; ok {
+			continue
+		}
+		r.purgedSeq = max(r.purgedSeq, rec.seq)
+		for _, rev := range r.revisions[id] {
+			r.release(rev)
+		}
+		delete(r.revisions, id)
+		delete(r.records, id)
+		n++
+	}
+	return n, nil
+}
+
+// keepRevision adds rec to the revisions of its record unless that version
+// is already kept.
+func (r *Repository) keepRevision(rec models.Record) {
+	revs := r.revisions[rec.ID]
+	for _, rev := range revs {
+		if rev.Version == rec.Version {
+			return
+		}
+	}
+	revs = append(revs, rec)
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Version < revs[j].Version })
+	r.revisions[rec.ID] = revs
+	r.acquire(rec)
+}
+
+// ListRevisions returns the kept earlier versions of the owner's record,
+// newest first.
+func (r *Repository) ListRevisions(ctx context.Context, ownerID, id string) ([]models.Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []models.Record
+	revs := r.revisions[id]
+	for i := len(revs) - 1; i >= 0; i-- {
+		if revs[i].OwnerID == ownerID {
+			out = append(out, cloneRecord(revs[i]))
+		}
+	}
+	return out, nil
+}
+
+// GetRevision returns a kept earlier version of the owner's record or
+// sql.ErrNoRows.
+func (r *Repository) GetRevision(ctx context.Context, ownerID, id string, version int64) (models.Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rev := range r.revisions[id] {
+		if rev.OwnerID == ownerID && rev.Version == version {
+			return cloneRecord(rev), nil
+		}
+	}
+	return models.Record{}, sql.ErrNoRows
+}
+
+// PruneRevisions drops all but the keep newest revisions of the record.
+func (r *Repository) PruneRevisions(ctx context.Context, ownerID, id string, keep int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var kept []models.Record
+	revs := r.revisions[id]
+	n := 0
+	for i := len(revs) - 1; i >= 0; i-- {
+		rev := revs[i]
+		if rev.OwnerID == ownerID {
+			if n >= keep {
+				r.release(rev)
+				continue
+			}
+			n++
+		}
+		kept = append([]models.Record{rev}, kept...)
+	}
+	if len(kept) == 0 {
+		delete(r.revisions, id)
+	} else {
+		r.revisions[id] = kept
+	}
+	return nil
+}
+
+// trashed returns the trash entry of the owner's record unless the record
+// was stored again since.
+func (r *Repository) trashed(ownerID, id string) (models.TrashedRecord, bool) {
+	t, ok := r.trash[id]
+	if !ok || t.OwnerID != ownerID {
+		return models.TrashedRecord{}, false
+	}
+	if rec, ok := r.records[id]; ok && rec.deletedAt.IsZero() {
+		return models.TrashedRecord{}, false
+	}
+	return t, true
+}
+
+// ListTrash returns the owner's deleted records kept in the trash, most
+// recently deleted first.
+func (r *Repository) ListTrash(ctx context.Context, ownerID string) ([]models.TrashedRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []models.TrashedRecord
+	for id := range r.trash {
+		if t, ok := r.trashed(ownerID, id); ok {
+			out = append(out, models.TrashedRecord{Record: cloneRecord(t.Record), DeletedAt: t.DeletedAt})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].DeletedAt.Equal(out[j].DeletedAt) {
+			return out[i].DeletedAt.After(out[j].DeletedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// GetTrashed returns the owner's record kept in the trash or sql.ErrNoRows.
+func (r *Repository) GetTrashed(ctx context.Context, ownerID, id string) (models.TrashedRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.trashed(ownerID, id)
+	if !ok {
+		return models.TrashedRecord{}, sql.ErrNoRows
+	}
+	return models.TrashedRecord{Record: cloneRecord(t.Record), DeletedAt: t.DeletedAt}, nil
+}
+
+// DeleteTrashed removes the record from the owner's trash.
+func (r *Repository) DeleteTrashed(ctx context.Context, ownerID, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.trash[id]; ok && t.OwnerID == ownerID {
+		r.release(t.Record)
+		delete(r.trash, id)
+	}
+	return nil
+}
+
+// EmptyTrash removes all records from the owner's trash.
+func (r *Repository) EmptyTrash(ctx context.Context, ownerID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for id, t := range r.trash {
+		if t.OwnerID == ownerID {
+			r.release(t.Record)
+			delete(r.trash, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// PurgeTrash removes records deleted before the given time from all trashes.
+func (r *Repository) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for id, t := range r.trash {
+		if t.DeletedAt.Before(before) {
+			r.release(t.Record)
+			delete(r.trash, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Key escrow
+
+// GetKeyEscrow returns the owner's wrapped vault key or sql.ErrNoRows.
+func (r *Repository) GetKeyEscrow(ctx context.Context, ownerID string) (models.KeyEscrow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k, ok := r.keys[ownerID]
+	if !ok {
+		return models.KeyEscrow{}, sql.ErrNoRows
+	}
+	k.Blob = clone(k.Blob)
+	return k, nil
+}
+
+// PutKeyEscrow stores the owner's wrapped vault key. A negative
+// expectedVersion overwrites unconditionally, zero requires that no key is
+// stored yet, otherwise the stored version must match.
+func (r *Repository) PutKeyEscrow(ctx context.Context, ownerID string, blob []byte, expectedVersion int64) (models.KeyEscrow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur, ok := r.keys[ownerID]
+	if expectedVersion >= 0 && (ok && cur.Version != expectedVersion || !ok && expectedVersion != 0) {
+		return models.KeyEscrow{}, repository.ErrVersionConflict
+	}
+	k := models.KeyEscrow{Blob: clone(blob), Version: cur.Version + 1, UpdatedAt: time.Now().UTC()}
+	r.keys[ownerID] = k
+	k.Blob = blob
+	return k, nil
+}
+
+// Uploads
+
+func (r *Repository) CreateUpload(ctx context.Context, ownerID, id string) (models.Upload, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.uploads[id]; ok {
+		return models.Upload{}, errDuplicateUpload
+	}
+	now := time.Now().UTC()
+	up := models.Upload{ID: id, CreatedAt: now, UpdatedAt: now}
+	r.uploads[id] = upload{ownerID: ownerID, Upload: up}
+	return up, nil
+}
+
+func (r *Repository) GetUpload(ctx context.Context, ownerID, id string) (models.Upload, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	up, ok := r.uploads[id]
+	if !ok || up.ownerID != ownerID {
+		return models.Upload{}, sql.ErrNoRows
+	}
+	return up.Upload, nil
+}
+
+// SetUploadSize advances the received size of an upload from the given
+// offset; it returns repository.ErrVersionConflict if the offset is stale.
+func (r *Repository) SetUploadSize(ctx context.Context, ownerID, id string, offset, size int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	up, ok := r.uploads[id]
+	if !ok || up.ownerID != ownerID || up.Size != offset {
+		return repository.ErrVersionConflict
+	}
+	up.Size = size
+	up.UpdatedAt = time.Now().UTC()
+	r.uploads[id] = up
+	return nil
+}
+
+func (r *Repository) DeleteUpload(ctx context.Context, ownerID, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	up, ok := r.uploads[id]
+	if !ok || up.ownerID != ownerID {
+		return sql.ErrNoRows
+	}
+	delete(r.uploads, id)
+	return nil
+}
+
+// DeleteStaleUploads removes uploads not touched since before and returns
+// their ids so that the caller can drop the received data.
+func (r *Repository) DeleteStaleUploads(ctx context.Context, before time.Time) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []string
+	for id, up := range r.uploads {
+		if up.UpdatedAt.Before(before) {
+			delete(r.uploads, id)
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Blobs
+
+// RegisterBlob records that a blob is about to be stored, or refreshes its
+// timestamp when it exists, so that DeleteUnreferencedBlobs leaves it alone
+// until a record references it.
+func (r *Repository) RegisterBlob(ctx context.Context, hash string, size int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	if b, ok := r.blobs[hash]; ok {
+		b.updatedAt = now
+		return nil
+	}
+	r.blobs[hash] = &blob{size: size, updatedAt: now}
+	return nil
+}
+
+// DeleteUnreferencedBlobs removes blobs that no record references and that
+// were registered or lost their last reference before the given time, and
+// returns their hashes so that the caller can delete the stored data.
+func (r *Repository) DeleteUnreferencedBlobs(ctx context.Context, before time.Time) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var hashes []string
+	for hash, b := range r.blobs {
+		if b.refs <= 0 && b.updatedAt.Before(before) {
+			delete(r.blobs, hash)
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// acquire and release keep blob reference counts in step with the
+// content_id and payload_ref of stored records, as the SQL triggers do.
+// References to unregistered blobs are not counted.
+func (r *Repository) acquire(rec models.Record) {
+	r.addRefs(rec, 1)
+}
+
+func (r *Repository) release(rec models.Record) {
+	r.addRefs(rec, -1)
+}
+
+func (r *Repository) addRefs(rec models.Record, delta int64) {
+	for _, hash := range []string{rec.ContentID, rec.PayloadRef} {
+		if b, ok := r.blobs[hash]; ok {
+			if b.refs += delta; b.refs <= 0 && b.refs-delta > 0 {
+				b.updatedAt = time.Now().UTC()
+			}
+		}
+	}
+}
+
+// Refresh tokens
+
+func (r *Repository) CreateRefreshToken(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tokens[token]; ok {
+		return errDuplicateToken
+	}
+	r.tokens[token] = refreshToken{userID: userID, expiresAt: expiresAt.UTC()}
+	return nil
+}
+
+func (r *Repository) GetRefreshToken(ctx context.Context, token string) (userID string, expiresAt time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tokens[token]
+	if !ok {
+		return "", time.Time{}, sql.ErrNoRows
+	}
+	return t.userID, t.expiresAt, nil
+}
+
+func (r *Repository) DeleteRefreshToken(ctx context.Context, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tokens, token)
+	return nil
+}
+
+// clone copies b so that callers cannot modify stored state; unlike
+// bytes.Clone it never returns nil, matching what the SQL drivers scan.
+func clone(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+func cloneRecord(rec models.Record) models.Record {
+	rec.Payload = clone(rec.Payload)
+	if rec.Meta != nil {
+		meta := make(map[string]string, len(rec.Meta))
+		for k, v := range rec.Meta {
+			meta[k] = v
+		}
+		rec.Meta = meta
+	}
+	return rec
+}
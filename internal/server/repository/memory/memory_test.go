@@ -0,0 +1,46 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"gophkeeper/internal/server/repository/repotest"
+	"gophkeeper/internal/server/service"
+	"gophkeeper/internal/shared/models"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) service.Repository {
+		return New()
+	})
+}
+
+func TestRepositoriesAreIsolated(t *testing.T) {
+	ctx := context.Background()
+	a, b := New(), New()
+	if _, err := a.CreateUser(ctx, "u@example.com", []byte("h")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.CreateUser(ctx, "u@example.com", []byte("h")); err != nil {
+		t.Fatalf("state leaked between repositories: %v", err)
+	}
+}
+
+func TestReturnedRecordsAreCopies(t *testing.T) {
+	ctx := context.Background()
+	repo := New()
+	u, _ := repo.CreateUser(ctx, "u@example.com", []byte("h"))
+	payload := []byte("secret")
+	rec, err := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Meta: map[string]string{"k": "v"}, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload[0] = 'X'
+	got, _ := repo.GetRecord(ctx, u.ID, rec.ID)
+	got.Payload[1] = 'X'
+	got.Meta["k"] = "changed"
+	got, _ = repo.GetRecord(ctx, u.ID, rec.ID)
+	if string(got.Payload) != "secret" || got.Meta["k"] != "v" {
+		t.Fatalf("stored record was modified through a caller's slice or map: %+v", got)
+	}
+}
@@ -0,0 +1,303 @@
+// Package migrate applies and reverts versioned schema migrations of the SQL
+// repositories.
+//
+// Applied migrations are recorded in schema_migrations together with the
+// checksum of their up SQL, so that a migration edited after it was applied
+// is detected instead of silently diverging from the deployed schema.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrChecksumMismatch reports an applied migration whose up SQL changed.
+	ErrChecksumMismatch = errors.New("applied migration was modified")
+	// ErrUnknownMigration reports an applied migration this build does not
+	// know, i.e. the schema is newer than the code.
+	ErrUnknownMigration = errors.New("applied migration is unknown to this build")
+	// ErrSchemaBehind reports pending migrations.
+	ErrSchemaBehind = errors.New("database schema is behind")
+)
+
+// Migration is a schema change with the SQL reverting it.
+type Migration struct {
+	ID   int
+	Name string
+	Up   string
+	Down string
+}
+
+// Checksum identifies the up SQL of the migration.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// State is a migration known to the build or to the database.
+type State struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is set when the applied checksum differs from Checksum.
+	Modified bool
+	// Unknown is set for a migration recorded in the database only.
+	Unknown bool
+}
+
+// Migrator runs Migrations, sorted by ID, against DB.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// Prepare runs first in every migration transaction. It takes any lock
+	// needed to serialize migrators sharing the database and creates or
+	// upgrades schema_migrations(id, name, checksum, applied_at).
+	Prepare func(ctx context.Context, tx *sql.Tx) error
+	// Bind rewrites ? placeholders for the driver; nil keeps them.
+	Bind func(query string) string
+}
+
+type applied struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Status lists the known migrations in order followed by migrations applied
+// to the database that this build does not know.
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	var states []State
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		done, err := m.applied(ctx, tx)
+		if err != nil {
+			return err
+		}
+		states = m.states(done)
+		return nil
+	})
+	return states, err
+}
+
+// Check returns ErrSchemaBehind when migrations are pending, or the error
+// Up would fail with because of modified or unknown applied migrations.
+func (m *Migrator) Check(ctx context.Context) error {
+	states, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if err := verify(states); err != nil {
+		return err
+	}
+	var pending []string
+	for _, s := range states {
+		if !s.Applied {
+			pending = append(pending, fmt.Sprintf("%d_%s", s.ID, s.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: pending %v", ErrSchemaBehind, pending)
+	}
+	return nil
+}
+
+// Up applies pending migrations up to and including the one with ID to, or
+// all of them when to is 0, each in its own transaction. It returns the
+// applied migrations.
+func (m *Migrator) Up(ctx context.Context, to int) ([]Migration, error) {
+	var done []Migration
+	for _, mig := range m.sorted() {
+		if to > 0 && mig.ID > to {
+			break
+		}
+		ran := false
+		err := m.inTx(ctx, func(tx *sql.Tx) error {
+			states, err := m.backfill(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if err := verify(states); err != nil {
+				return err
+			}
+			for _, s := range states {
+				// another migrator may have applied it while we waited
+				if s.ID == mig.ID && s.Applied {
+					return nil
+				}
+			}
+			if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", mig.ID, mig.Name, err)
+			}
+			ran = true
+			_, err = tx.ExecContext(ctx, m.bind(`INSERT INTO schema_migrations(id, name, checksum, applied_at) VALUES(?,?,?,?)`), mig.ID, mig.Name, mig.Checksum(), time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return done, err
+		}
+		if ran {
+			done = append(done, mig)
+		}
+	}
+	return done, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, each in its
+// own transaction. It returns the reverted migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	for i := 0; i < steps; i++ {
+		var reverted *Migration
+		err := m.inTx(ctx, func(tx *sql.Tx) error {
+			states, err := m.backfill(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if err := verify(states); err != nil {
+				return err
+			}
+			for j := len(states) - 1; j >= 0; j-- {
+				if s := states[j]; s.Applied {
+					reverted = &s.Migration
+					break
+				}
+			}
+			if reverted == nil {
+				return nil
+			}
+			if _, err := tx.ExecContext(ctx, reverted.Down); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", reverted.ID, reverted.Name, err)
+			}
+			_, err = tx.ExecContext(ctx, m.bind(`DELETE FROM schema_migrations WHERE id = ?`), reverted.ID)
+			return err
+		})
+		if err != nil {
+			return done, err
+		}
+		if reverted == nil {
+			break
+		}
+		done = append(done, *reverted)
+	}
+	return done, nil
+}
+
+// backfill records the checksum of migrations applied before checksums were
+// kept and returns the states.
+func (m *Migrator) backfill(ctx context.Context, tx *sql.Tx) ([]State, error) {
+	done, err := m.applied(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, mig := range m.Migrations {
+		if a, ok := done[mig.ID]; ok && a.checksum == "" {
+			if _, err := tx.ExecContext(ctx, m.bind(`UPDATE schema_migrations SET checksum = ? WHERE id = ?`), mig.Checksum(), mig.ID); err != nil {
+				return nil, err
+			}
+			a.checksum = mig.Checksum()
+			done[mig.ID] = a
+		}
+	}
+	return m.states(done), nil
+}
+
+func (m *Migrator) applied(ctx context.Context, tx *sql.Tx) (map[int]applied, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	done := map[int]applied{}
+	for rows.Next() {
+		var id int
+		var a applied
+		if err := rows.Scan(&id, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		done[id] = a
+	}
+	return done, rows.Err()
+}
+
+func (m *Migrator) states(done map[int]applied) []State {
+	var states []State
+	known := map[int]bool{}
+	for _, mig := range m.sorted() {
+		known[mig.ID] = true
+		s := State{Migration: mig}
+		if a, ok := done[mig.ID]; ok {
+			s.Applied, s.AppliedAt = true, a.appliedAt
+			s.Modified = a.checksum != "" && a.checksum != mig.Checksum()
+		}
+		states = append(states, s)
+	}
+	var unknown []State
+	for id, a := range done {
+		if !known[id] {
+			unknown = append(unknown, State{Migration: Migration{ID: id, Name: a.name}, Applied: true, AppliedAt: a.appliedAt, Unknown: true})
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].ID < unknown[j].ID })
+	return append(states, unknown...)
+}
+
+func verify(states []State) error {
+	for _, s := range states {
+		switch {
+		case s.Modified:
+			return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, s.ID, s.Name)
+		case s.Unknown:
+			return fmt.Errorf("%w: %d_%s", ErrUnknownMigration, s.ID, s.Name)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) sorted() []Migration {
+	out := append([]Migration(nil), m.Migrations...)
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (m *Migrator) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := m.Prepare(ctx, tx); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) bind(query string) string {
+	if m.Bind == nil {
+		return query
+	}
+	return m.Bind(query)
+}
+
+// Dollar rewrites ? placeholders to $1, $2, ... as PostgreSQL expects.
+func Dollar(query string) string {
+	var out []byte
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] == '?' {
+			n++
+			out = fmt.Appendf(out, "$%d", n)
+			continue
+		}
+		out = append(out, query[i])
+	}
+	return string(out)
+}
@@ -0,0 +1,138 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+var testMigrations = []Migration{
+	{ID: 2, Name: "notes", Up: `CREATE TABLE notes (id TEXT PRIMARY KEY)`, Down: `DROP TABLE notes`},
+	{ID: 1, Name: "users", Up: `CREATE TABLE users (id TEXT PRIMARY KEY)`, Down: `DROP TABLE users`},
+	{ID: 3, Name: "tags", Up: `CREATE TABLE tags (id TEXT PRIMARY KEY)`, Down: `DROP TABLE tags`},
+}
+
+func newTestMigrator(t *testing.T, dsn string) *Migrator {
+	t.Helper()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+	return &Migrator{
+		DB:         db,
+		Migrations: testMigrations,
+		Prepare: func(ctx context.Context, tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (id INTEGER PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL DEFAULT '', applied_at TIMESTAMP NOT NULL)`)
+			return err
+		},
+	}
+}
+
+func names(migs []Migration) string {
+	out := ""
+	for _, m := range migs {
+		out += m.Name + " "
+	}
+	return out
+}
+
+func TestUpDownAndStatus(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t, "file:migrate_up_down?mode=memory&cache=shared")
+	if err := m.Check(ctx); !errors.Is(err, ErrSchemaBehind) {
+		t.Fatalf("empty database: want ErrSchemaBehind, got %v", err)
+	}
+	applied, err := m.Up(ctx, 2)
+	if err != nil || names(applied) != "users notes " {
+		t.Fatalf("up to 2: %v %v", names(applied), err)
+	}
+	states, err := m.Status(ctx)
+	if err != nil || len(states) != 3 || !states[0].Applied || !states[1].Applied || states[2].Applied || states[0].AppliedAt.IsZero() {
+		t.Fatalf("status: %+v %v", states, err)
+	}
+	if applied, err = m.Up(ctx, 0); err != nil || names(applied) != "tags " {
+		t.Fatalf("up: %v %v", names(applied), err)
+	}
+	if applied, err = m.Up(ctx, 0); err != nil || len(applied) != 0 {
+		t.Fatalf("second up: %v %v", names(applied), err)
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatalf("current schema: %v", err)
+	}
+
+	reverted, err := m.Down(ctx, 2)
+	if err != nil || names(reverted) != "tags notes " {
+		t.Fatalf("down 2: %v %v", names(reverted), err)
+	}
+	if _, err := m.DB.Exec(`INSERT INTO users(id) VALUES('u')`); err != nil {
+		t.Fatalf("remaining table: %v", err)
+	}
+	if _, err := m.DB.Exec(`INSERT INTO notes(id) VALUES('n')`); err == nil {
+		t.Fatal("reverted table still exists")
+	}
+	if reverted, err = m.Down(ctx, 5); err != nil || names(reverted) != "users " {
+		t.Fatalf("down past the first migration: %v %v", names(reverted), err)
+	}
+}
+
+func TestModifiedAndUnknownMigrations(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t, "file:migrate_checksums?mode=memory&cache=shared")
+	if _, err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := *m
+	edited.Migrations = append([]Migration(nil), testMigrations...)
+	edited.Migrations[0].Up = `CREATE TABLE notes (id TEXT PRIMARY KEY, body TEXT)`
+	if err := edited.Check(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("check: want ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := edited.Down(ctx, 1); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("down: want ErrChecksumMismatch, got %v", err)
+	}
+	states, _ := edited.Status(ctx)
+	if !states[1].Modified || states[0].Modified {
+		t.Fatalf("status: %+v", states)
+	}
+
+	older := *m
+	older.Migrations = testMigrations[:2]
+	if _, err := older.Up(ctx, 0); !errors.Is(err, ErrUnknownMigration) {
+		t.Fatalf("up: want ErrUnknownMigration, got %v", err)
+	}
+	states, _ = older.Status(ctx)
+	if len(states) != 3 || !states[2].Unknown || states[2].Name != "tags" {
+		t.Fatalf("status: %+v", states)
+	}
+}
+
+func TestChecksumBackfill(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t, "file:migrate_backfill?mode=memory&cache=shared")
+	if _, err := m.Up(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	// rows recorded before checksums were kept
+	if _, err := m.DB.Exec(`UPDATE schema_migrations SET checksum = ''`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	var sum string
+	if err := m.DB.QueryRow(`SELECT checksum FROM schema_migrations WHERE id = 1`).Scan(&sum); err != nil || sum != testMigrations[1].Checksum() {
+		t.Fatalf("checksum not recorded: %q %v", sum, err)
+	}
+}
+
+func TestDollar(t *testing.T) {
+	if got := Dollar(`UPDATE t SET a = ? WHERE id = ?`); got != `UPDATE t SET a = $1 WHERE id = $2` {
+		t.Fatal(got)
+	}
+}
@@ -0,0 +1,97 @@
+package postgres
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// requireEnv makes the tests fail instead of skipping when no PostgreSQL is
+// available, so that CI cannot pass without running them.
+const requireEnv = "GOPHKEEPER_TEST_POSTGRES"
+
+func TestMain(m *testing.M) {
+	stop, err := startLocalPostgres()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "local postgres:", err)
+	}
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// startLocalPostgres starts a throwaway server in a temporary directory with
+// the initdb and pg_ctl found on PATH or in the usual install locations, and
+// points GOPHKEEPER_TEST_POSTGRES_DSN at it. It does nothing when the variable
+// is already set or the binaries are missing.
+func startLocalPostgres() (stop func(), err error) {
+	stop = func() {}
+	if os.Getenv("GOPHKEEPER_TEST_POSTGRES_DSN") != "" {
+		return stop, nil
+	}
+	bin, ok := findPostgresBin()
+	if !ok {
+		return stop, nil
+	}
+	dir, err := os.MkdirTemp("", "gophkeeper-pg-")
+	if err != nil {
+		return stop, err
+	}
+	data := filepath.Join(dir, "data")
+	run := func(name string, args ...string) error {
+		out, err := exec.Command(filepath.Join(bin, name), args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %w\n%s", name, err, out)
+		}
+		return nil
+	}
+	if err := run("initdb", "-D", data, "-U", "postgres", "--auth=trust", "--no-sync"); err != nil {
+		_ = os.RemoveAll(dir)
+		return stop, err
+	}
+	port, err := freePort()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return stop, err
+	}
+	opts := fmt.Sprintf("-h 127.0.0.1 -p %d -k %s -F", port, dir)
+	if err := run("pg_ctl", "-D", data, "-o", opts, "-l", filepath.Join(dir, "log"), "-w", "start"); err != nil {
+		_ = os.RemoveAll(dir)
+		return stop, err
+	}
+	os.Setenv("GOPHKEEPER_TEST_POSTGRES_DSN", fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port))
+	return func() {
+		_ = run("pg_ctl", "-D", data, "-m", "immediate", "stop")
+		_ = os.RemoveAll(dir)
+	}, nil
+}
+
+// findPostgresBin returns the directory holding initdb and pg_ctl.
+func findPostgresBin() (string, bool) {
+	if p, err := exec.LookPath("initdb"); err == nil {
+		return filepath.Dir(p), true
+	}
+	for _, pattern := range []string{
+		"/usr/lib/postgresql/*/bin/initdb",
+		"/usr/pgsql-*/bin/initdb",
+		"/opt/homebrew/opt/postgresql*/bin/initdb",
+		"/usr/local/opt/postgresql*/bin/initdb",
+	} {
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+			return filepath.Dir(matches[len(matches)-1]), true
+		}
+	}
+	return "", false
+}
+
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,942 @@
+// Package postgres implements service.Repository on PostgreSQL so that
+// several server replicas can share one database.
+//
+// Writers serialize on the sync_state row, which hands out the global change
+// sequence: a change becomes visible to sync only together with every change
+// ordered before it. Conditional updates check the version in the UPDATE
+// itself, so concurrent replicas cannot both win.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+
+	"gophkeeper/internal/server/repository"
+	"gophkeeper/internal/server/repository/migrate"
+	"gophkeeper/internal/shared/models"
+)
+
+type Repository struct {
+	db *sql.DB
+}
+
+// migrationLock is the advisory lock key held while migrating, so replicas
+// starting together apply each migration once.
+const migrationLock = 0x676b6d69
+
+var schemaMigrations = []migrate.Migration{
+	{
+		ID:   1,
+		Name: "init",
+		Up: `
+            CREATE TABLE IF NOT EXISTS users (
+                id TEXT PRIMARY KEY,
+                email TEXT UNIQUE NOT NULL,
+                password_hash BYTEA NOT NULL,
+                created_at TIMESTAMPTZ NOT NULL
+            );
+            CREATE TABLE IF NOT EXISTS records (
+                id TEXT PRIMARY KEY,
+                owner_id TEXT NOT NULL REFERENCES users(id),
+                type TEXT NOT NULL,
+                meta JSONB NOT NULL,
+                payload BYTEA NOT NULL,
+                version BIGINT NOT NULL,
+                updated_at TIMESTAMPTZ NOT NULL,
+                seq BIGINT NOT NULL,
+                deleted_at TIMESTAMPTZ,
+                content_id TEXT NOT NULL DEFAULT '',
+                content_size BIGINT NOT NULL DEFAULT 0,
+                payload_ref TEXT NOT NULL DEFAULT ''
+            );
+            CREATE INDEX IF NOT EXISTS idx_records_owner_seq ON records(owner_id, seq);
+            CREATE INDEX IF NOT EXISTS idx_records_deleted ON records(deleted_at) WHERE deleted_at IS NOT NULL;
+            CREATE INDEX IF NOT EXISTS idx_records_live ON records(owner_id, updated_at, id) WHERE deleted_at IS NULL;
+            CREATE INDEX IF NOT EXISTS idx_records_live_type ON records(owner_id, type, updated_at, id) WHERE deleted_at IS NULL;
+            CREATE TABLE IF NOT EXISTS sync_state (
+                id INTEGER PRIMARY KEY CHECK (id = 1),
+                last_seq BIGINT NOT NULL,
+                purged_seq BIGINT NOT NULL DEFAULT 0
+            );
+            INSERT INTO sync_state(id, last_seq) VALUES(1, 0) ON CONFLICT DO NOTHING;
+            CREATE TABLE IF NOT EXISTS refresh_tokens (
+                token TEXT PRIMARY KEY,
+                user_id TEXT NOT NULL REFERENCES users(id),
+                expires_at TIMESTAMPTZ NOT NULL,
+                created_at TIMESTAMPTZ NOT NULL
+            );
+            CREATE TABLE IF NOT EXISTS key_escrow (
+                owner_id TEXT PRIMARY KEY REFERENCES users(id),
+                blob BYTEA NOT NULL,
+                version BIGINT NOT NULL,
+                updated_at TIMESTAMPTZ NOT NULL
+            );
+            CREATE TABLE IF NOT EXISTS uploads (
+                id TEXT PRIMARY KEY,
+                owner_id TEXT NOT NULL REFERENCES users(id),
+                size BIGINT NOT NULL,
+                created_at TIMESTAMPTZ NOT NULL,
+                updated_at TIMESTAMPTZ NOT NULL
+            );
+            CREATE INDEX IF NOT EXISTS idx_uploads_updated ON uploads(updated_at);
+        `,
+		Down: `
+            DROP TABLE IF EXISTS uploads, key_escrow, refresh_tokens, sync_state, records, users;
+        `,
+	},
+	{
+		ID:   2,
+		Name: "blobs",
+		// Same reference counting as the SQLite schema: records reference
+		// blobs by content_id and payload_ref and the trigger keeps
+		// blobs.refs equal to the number of such references.
+		Up: `
+            CREATE TABLE IF NOT EXISTS blobs (
+                hash TEXT PRIMARY KEY,
+                size BIGINT NOT NULL,
+                refs BIGINT NOT NULL DEFAULT 0,
+                updated_at TIMESTAMPTZ NOT NULL
+            );
+            CREATE INDEX IF NOT EXISTS idx_blobs_unreferenced ON blobs(updated_at) WHERE refs <= 0;
+            CREATE OR REPLACE FUNCTION records_blob_refs() RETURNS trigger AS $$
+            BEGIN
+                IF TG_OP <> 'INSERT' THEN
+                    UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.content_id;
+                    UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.payload_ref;
+                END IF;
+                IF TG_OP <> 'DELETE' THEN
+                    UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.content_id;
+                    UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.payload_ref;
+                END IF;
+                RETURN NULL;
+            END;
+            $$ LANGUAGE plpgsql;
+            DROP TRIGGER IF EXISTS records_blob_refs ON records;
+            CREATE TRIGGER records_blob_refs
+                AFTER INSERT OR DELETE OR UPDATE OF content_id, payload_ref ON records
+                FOR EACH ROW EXECUTE FUNCTION records_blob_refs();
+        `,
+		Down: `
+            DROP TRIGGER IF EXISTS records_blob_refs ON records;
+            DROP FUNCTION IF EXISTS records_blob_refs();
+            DROP TABLE IF EXISTS blobs;
+        `,
+	},
+	{
+		ID:   3,
+		Name: "record_revisions",
+		// Every update keeps the replaced version of a live record. Revisions
+		// hold blob references through the same trigger function as records;
+		// reverting deletes the rows first so that the references are released.
+		Up: `
+            CREATE TABLE IF NOT EXISTS record_revisions (
+                record_id TEXT NOT NULL,
+                owner_id TEXT NOT NULL REFERENCES users(id),
+                version BIGINT NOT NULL,
+                type TEXT NOT NULL,
+                meta JSONB NOT NULL,
+                payload BYTEA NOT NULL,
+                updated_at TIMESTAMPTZ NOT NULL,
+                content_id TEXT NOT NULL DEFAULT '',
+                content_size BIGINT NOT NULL DEFAULT 0,
+                payload_ref TEXT NOT NULL DEFAULT '',
+                PRIMARY KEY (record_id, version)
+            );
+            DROP TRIGGER IF EXISTS record_revisions_blob_refs ON record_revisions;
+            CREATE TRIGGER record_revisions_blob_refs
+                AFTER INSERT OR DELETE ON record_revisions
+                FOR EACH ROW EXECUTE FUNCTION records_blob_refs();
+        `,
+		Down: `
+            DELETE FROM record_revisions;
+            DROP TABLE IF EXISTS record_revisions;
+        `,
+	},
+	{
+		ID:   4,
+		Name: "trash",
+		// Deleting a record keeps its last live row here for undelete until
+		// the trash is emptied or purged. Trashed rows hold blob references,
+		// released by reverting before the table goes.
+		Up: `
+            CREATE TABLE IF NOT EXISTS trash (
+                record_id TEXT PRIMARY KEY,
+                owner_id TEXT NOT NULL REFERENCES users(id),
+                type TEXT NOT NULL,
+                meta JSONB NOT NULL,
+                payload BYTEA NOT NULL,
+                version BIGINT NOT NULL,
+                updated_at TIMESTAMPTZ NOT NULL,
+                content_id TEXT NOT NULL DEFAULT '',
+                content_size BIGINT NOT NULL DEFAULT 0,
+                payload_ref TEXT NOT NULL DEFAULT '',
+                deleted_at TIMESTAMPTZ NOT NULL
+            );
+            CREATE INDEX IF NOT EXISTS idx_trash_owner ON trash(owner_id, deleted_at);
+            DROP TRIGGER IF EXISTS trash_blob_refs ON trash;
+            CREATE TRIGGER trash_blob_refs
+                AFTER INSERT OR DELETE ON trash
+                FOR EACH ROW EXECUTE FUNCTION records_blob_refs();
+        `,
+		Down: `
+            DELETE FROM trash;
+            DROP TABLE IF EXISTS trash;
+        `,
+	},
+	{
+		ID:   5,
+		Name: "blob_release_time",
+		// A blob losing its last reference restarts its GC grace period.
+		Up: `
+            CREATE OR REPLACE FUNCTION blobs_released() RETURNS trigger AS $$
+            BEGIN
+                NEW.updated_at := now();
+                RETURN NEW;
+            END;
+            $$ LANGUAGE plpgsql;
+            DROP TRIGGER IF EXISTS blobs_released ON blobs;
+            CREATE TRIGGER blobs_released
+                BEFORE UPDATE OF refs ON blobs
+                FOR EACH ROW WHEN (NEW.refs <= 0 AND OLD.refs > 0)
+                EXECUTE FUNCTION blobs_released();
+        `,
+		Down: `
+            DROP TRIGGER IF EXISTS blobs_released ON blobs;
+            DROP FUNCTION IF EXISTS blobs_released();
+        `,
+	},
+}
+
+// recordColumns are the columns read by scanRecord, in order.
+const recordColumns = "id, owner_id, type, meta, payload, version, updated_at, content_id, content_size, payload_ref"
+
+// revisionColumns read a record_revisions row like recordColumns.
+const revisionColumns = "record_id, owner_id, type, meta, payload, version, updated_at, content_id, content_size, payload_ref"
+
+// snapshotRevision copies the live record selected by the extra conditions
+// into record_revisions before it is overwritten.
+const snapshotRevision = `
+	INSERT INTO record_revisions(` + revisionColumns + `)
+	SELECT ` + recordColumns + ` FROM records WHERE id = $1 AND deleted_at IS NULL`
+
+// trashColumns read a trash row like recordColumns, followed by deleted_at.
+const trashColumns = revisionColumns + ", deleted_at"
+
+// trashLive hides trash entries of records that were stored again since.
+const trashLive = ` AND NOT EXISTS (SELECT 1 FROM records WHERE records.id = trash.record_id AND records.deleted_at IS NULL)`
+
+// prepareMigrations takes the migration lock, held until the transaction
+// ends, and creates schema_migrations, adding the checksum column to tables
+// created before checksums were kept.
+func prepareMigrations(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(migrationLock)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (id INTEGER PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL DEFAULT '', applied_at TIMESTAMPTZ NOT NULL)`); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS checksum TEXT NOT NULL DEFAULT ''`)
+	return err
+}
+
+// New connects to the database at dsn, a postgres:// URL or a key=value
+// connection string, and applies pending migrations.
+func New(dsn string) (*Repository, error) {
+	r, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Migrator().Up(context.Background(), 0); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Open connects to the database at dsn without migrating it.
+func Open(dsn string) (*Repository, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Repository{db: db}, nil
+}
+
+// Migrator manages the schema of the database. Every migration step holds
+// the migration lock, so replicas starting together apply each migration
+// once.
+func (r *Repository) Migrator() *migrate.Migrator {
+	return &migrate.Migrator{DB: r.db, Migrations: schemaMigrations, Prepare: prepareMigrations, Bind: migrate.Dollar}
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// now returns the current time at the microsecond precision of timestamptz,
+// so that returned records compare equal to what is read back later.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// Auth
+
+func (r *Repository) CreateUser(ctx context.Context, email string, passwordHash []byte) (models.User, error) {
+	id := uuid.NewString()
+	created := now()
+	_, err := r.db.ExecContext(ctx, `INSERT INTO users(id,email,password_hash,created_at) VALUES($1,$2,$3,$4)`, id, email, passwordHash, created)
+	if err != nil {
+		return models.User{}, err
+	}
+	return models.User{ID: id, Email: email, CreatedAt: created}, nil
+}
+
+func (r *Repository) GetUserByEmail(ctx context.Context, email string) (id string, passwordHash []byte, err error) {
+	row := r.db.QueryRowContext(ctx, `SELECT id,password_hash FROM users WHERE email = $1`, email)
+	if err = row.Scan(&id, &passwordHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, sql.ErrNoRows
+		}
+		return "", nil, err
+	}
+	return
+}
+
+// Records
+
+func (r *Repository) UpsertRecord(ctx context.Context, rec models.Record) (models.Record, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Record{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if rec, err = upsertRecord(ctx, tx, rec); err != nil {
+		return models.Record{}, err
+	}
+	return rec, tx.Commit()
+}
+
+func upsertRecord(ctx context.Context, tx *sql.Tx, rec models.Record) (models.Record, error) {
+	if rec.ID == "" {
+		rec.ID = uuid.NewString()
+	}
+	if rec.Version == 0 {
+		rec.Version = 1
+	} else {
+		rec.Version++
+	}
+	rec.UpdatedAt = now()
+	metaJSON, _ := json.Marshal(rec.Meta)
+	seq, err := nextSeq(ctx, tx)
+	if err != nil {
+		return models.Record{}, err
+	}
+	if _, err := tx.ExecContext(ctx, snapshotRevision+` AND owner_id = $2 ON CONFLICT DO NOTHING`, rec.ID, rec.OwnerID); err != nil {
+		return models.Record{}, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO records(id, owner_id, type, meta, payload, version, updated_at, seq, content_id, content_size, payload_ref)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT(id) DO UPDATE SET
+			type=excluded.type,
+			meta=excluded.meta,
+			payload=excluded.payload,
+			version=excluded.version,
+			updated_at=excluded.updated_at,
+			seq=excluded.seq,
+			deleted_at=NULL,
+			content_id=excluded.content_id,
+			content_size=excluded.content_size,
+			payload_ref=excluded.payload_ref
+		WHERE records.owner_id = excluded.owner_id
+    `, rec.ID, rec.OwnerID, string(rec.Type), metaJSON, payloadBytes(rec.Payload), rec.Version, rec.UpdatedAt, seq, rec.ContentID, rec.ContentSize, rec.PayloadRef)
+	if err != nil {
+		return models.Record{}, err
+	}
+	// the ID belongs to a record of another owner
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return models.Record{}, sql.ErrNoRows
+	}
+	return rec, nil
+}
+
+func (r *Repository) UpsertRecordConditional(ctx context.Context, rec models.Record, expectedVersion int64) (models.Record, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Record{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if rec, err = upsertRecordConditional(ctx, tx, rec, expectedVersion); err != nil {
+		return models.Record{}, err
+	}
+	return rec, tx.Commit()
+}
+
+func upsertRecordConditional(ctx context.Context, tx *sql.Tx, rec models.Record, expectedVersion int64) (models.Record, error) {
+	updated := now()
+	metaJSON, _ := json.Marshal(rec.Meta)
+	if rec.ID == "" {
+		rec.ID = uuid.NewString()
+	}
+	seq, err := nextSeq(ctx, tx)
+	if err != nil {
+		return models.Record{}, err
+	}
+	// Insert when expectedVersion == 0 and the record does not exist or is a
+	// tombstone; a resurrected record continues its version sequence.
+	if expectedVersion == 0 {
+		var version int64
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO records(id, owner_id, type, meta, payload, version, updated_at, seq, content_id, content_size, payload_ref) VALUES($1,$2,$3,$4,$5,1,$6,$7,$8,$9,$10)
+			ON CONFLICT(id) DO UPDATE SET
+				type=excluded.type,
+				meta=excluded.meta,
+				payload=excluded.payload,
+				version=records.version+1,
+				updated_at=excluded.updated_at,
+				seq=excluded.seq,
+				deleted_at=NULL,
+				content_id=excluded.content_id,
+				content_size=excluded.content_size,
+				payload_ref=excluded.payload_ref
+			WHERE records.deleted_at IS NOT NULL AND records.owner_id = excluded.owner_id
+			RETURNING version
+		`, rec.ID, rec.OwnerID, string(rec.Type), metaJSON, payloadBytes(rec.Payload), updated, seq, rec.ContentID, rec.ContentSize, rec.PayloadRef).Scan(&version)
+		if err == nil {
+			rec.Version = version
+			rec.UpdatedAt = updated
+			return rec, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return models.Record{}, err
+		}
+		// Live record exists, fall through to conditional update
+	}
+	// The snapshot is rolled back with the transaction if the update fails.
+	if _, err := tx.ExecContext(ctx, snapshotRevision+` AND owner_id = $2 AND version = $3 ON CONFLICT DO NOTHING`, rec.ID, rec.OwnerID, expectedVersion); err != nil {
+		return models.Record{}, err
+	}
+	// The row lock taken by UPDATE makes a concurrent update wait and then
+	// re-check the version, so only one of them succeeds.
+	res, err := tx.ExecContext(ctx, `UPDATE records SET type=$1, meta=$2, payload=$3, version=$4, updated_at=$5, seq=$6, content_id=$7, content_size=$8, payload_ref=$9 WHERE id=$10 AND owner_id=$11 AND version=$12 AND deleted_at IS NULL`, string(rec.Type), metaJSON, payloadBytes(rec.Payload), expectedVersion+1, updated, seq, rec.ContentID, rec.ContentSize, rec.PayloadRef, rec.ID, rec.OwnerID, expectedVersion)
+	if err != nil {
+		return models.Record{}, err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return models.Record{}, repository.ErrVersionConflict
+	}
+	rec.Version = expectedVersion + 1
+	rec.UpdatedAt = updated
+	return rec, nil
+}
+
+// ListRecords returns the owner's live records matching q, newest first
+// unless q.Ascending is set. Ties on updated_at are broken by id so that
+// q.After continues a listing without skipping or repeating records.
+func (r *Repository) ListRecords(ctx context.Context, ownerID string, q models.RecordQuery) ([]models.Record, error) {
+	query, args := listQuery(recordColumns, ownerID, q)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.Record
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// ListRecordSummaries is ListRecords without the payload column.
+func (r *Repository) ListRecordSummaries(ctx context.Context, ownerID string, q models.RecordQuery) ([]models.RecordSummary, error) {
+	query, args := listQuery("id, type, meta, version, updated_at, content_size", ownerID, q)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.RecordSummary
+	for rows.Next() {
+		var sum models.RecordSummary
+		var typ string
+		var metaBytes []byte
+		if err := rows.Scan(&sum.ID, &typ, &metaBytes, &sum.Version, &sum.UpdatedAt, &sum.ContentSize); err != nil {
+			return nil, err
+		}
+		sum.Type = models.RecordType(typ)
+		sum.UpdatedAt = sum.UpdatedAt.UTC()
+		if len(metaBytes) > 0 {
+			_ = json.Unmarshal(metaBytes, &sum.Meta)
+		}
+		out = append(out, sum)
+	}
+	return out, rows.Err()
+}
+
+// listQuery builds the SELECT of columns for a listing of the owner's live
+// records matching q.
+func listQuery(columns, ownerID string, q models.RecordQuery) (string, []any) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	where := []string{"owner_id = " + arg(ownerID), "deleted_at IS NULL"}
+	if q.Type != "" {
+		where = append(where, "type = "+arg(string(q.Type)))
+	}
+	keys := make([]string, 0, len(q.Meta))
+	for k := range q.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		where = append(where, "meta->>("+arg(k)+"::text) = "+arg(q.Meta[k]))
+	}
+	if !q.UpdatedAfter.IsZero() {
+		where = append(where, "updated_at > "+arg(q.UpdatedAfter.UTC()))
+	}
+	order, cmp := "DESC", "<"
+	if q.Ascending {
+		order, cmp = "ASC", ">"
+	}
+	if q.After != nil {
+		where = append(where, "(updated_at, id) "+cmp+" ("+arg(q.After.UpdatedAt.UTC())+", "+arg(q.After.ID)+")")
+	}
+	query := `SELECT ` + columns + ` FROM records WHERE ` + strings.Join(where, " AND ") + ` ORDER BY updated_at ` + order + `, id ` + order
+	if q.Limit > 0 {
+		query += ` LIMIT ` + arg(q.Limit)
+	}
+	return query, args
+}
+
+func (r *Repository) GetRecord(ctx context.Context, ownerID, id string) (models.Record, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+recordColumns+` FROM records WHERE owner_id = $1 AND id = $2 AND deleted_at IS NULL`, ownerID, id)
+	return scanRecord(row)
+}
+
+// DeleteRecord moves the record to the trash and turns it into a tombstone:
+// the payload and meta are dropped, the version is bumped and the row stays
+// visible to sync until PurgeTombstones removes it.
+func (r *Repository) DeleteRecord(ctx context.Context, ownerID, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := deleteRecord(ctx, tx, ownerID, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteRecord(ctx context.Context, tx *sql.Tx, ownerID, id string) error {
+	seq, err := nextSeq(ctx, tx)
+	if err != nil {
+		return err
+	}
+	deleted := now()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM trash WHERE record_id = $1 AND owner_id = $2`, id, ownerID); err != nil {
+		return err
+	}
+	// the row lock makes a concurrent delete of the record wait and then
+	// find it gone instead of trashing it twice
+	if _, err := tx.ExecContext(ctx, `INSERT INTO trash(`+trashColumns+`) SELECT `+recordColumns+`, $1 FROM records WHERE owner_id = $2 AND id = $3 AND deleted_at IS NULL FOR UPDATE`, deleted, ownerID, id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE records SET meta='{}', payload='', content_id='', content_size=0, payload_ref='', version=version+1, updated_at=$1, seq=$2, deleted_at=$1 WHERE owner_id = $3 AND id = $4 AND deleted_at IS NULL`, deleted, seq, ownerID, id)
+	if err != nil {
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ApplyBatch applies the owner's upserts and deletes in one transaction,
+// all or none of them; see repository.ApplyBatch.
+func (r *Repository) ApplyBatch(ctx context.Context, ownerID string, ops []models.BatchOp) ([]models.BatchResult, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	results, err := repository.ApplyBatch(ownerID, ops, func(rec models.Record, expectedVersion *int64) (models.Record, error) {
+		if expectedVersion != nil {
+			return upsertRecordConditional(ctx, tx, rec, *expectedVersion)
+		}
+		return upsertRecord(ctx, tx, rec)
+	}, func(id string) error {
+		return deleteRecord(ctx, tx, ownerID, id)
+	})
+	if err != nil {
+		return results, err
+	}
+	return results, tx.Commit()
+}
+
+// ListChanges returns records created or updated and tombstones of records
+// deleted after the given change sequence, in sequence order. When tombstones
+// newer than since were already purged, a full snapshot is returned with
+// Reset set.
+func (r *Repository) ListChanges(ctx context.Context, ownerID string, since int64) (models.Changes, error) {
+	// A repeatable read snapshot keeps last_seq consistent with the rows.
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return models.Changes{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var lastSeq, purgedSeq int64
+	if err := tx.QueryRowContext(ctx, `SELECT last_seq, purged_seq FROM sync_state WHERE id = 1`).Scan(&lastSeq, &purgedSeq); err != nil {
+		return models.Changes{}, err
+	}
+	changes := models.Changes{Records: []models.Record{}, Deleted: []models.Tombstone{}, Cursor: max(since, lastSeq)}
+	if since > 0 && since < purgedSeq {
+		changes.Reset = true
+		since = 0
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT `+recordColumns+`, deleted_at FROM records WHERE owner_id = $1 AND seq > $2 ORDER BY seq`, ownerID, since)
+	if err != nil {
+		return models.Changes{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deletedAt sql.NullTime
+		rec, err := scanRecord(rows, &deletedAt)
+		if err != nil {
+			return models.Changes{}, err
+		}
+		if deletedAt.Valid {
+			changes.Deleted = append(changes.Deleted, models.Tombstone{ID: rec.ID, Version: rec.Version, DeletedAt: deletedAt.Time.UTC()})
+		} else {
+			changes.Records = append(changes.Records, rec)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return models.Changes{}, err
+	}
+	return changes, nil
+}
+
+// purgeableTombstones selects tombstones deleted before the given time. Those
+// of trashed records are kept so that undelete continues the version sequence.
+const purgeableTombstones = `deleted_at IS NOT NULL AND deleted_at < $1 AND id NOT IN (SELECT record_id FROM trash)`
+
+// PurgeTombstones permanently removes tombstones deleted before the given time
+// unless their record is still in the trash.
+func (r *Repository) PurgeTombstones(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var maxSeq sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `SELECT MAX(seq) FROM records WHERE `+purgeableTombstones, before).Scan(&maxSeq); err != nil {
+		return 0, err
+	}
+	if !maxSeq.Valid {
+		return 0, nil
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM record_revisions WHERE record_id IN (SELECT id FROM records WHERE `+purgeableTombstones+`)`, before); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM records WHERE `+purgeableTombstones, before)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE sync_state SET purged_seq = GREATEST(purged_seq, $1) WHERE id = 1`, maxSeq.Int64); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListRevisions returns the kept earlier versions of the owner's record,
+// newest first.
+func (r *Repository) ListRevisions(ctx context.Context, ownerID, id string) ([]models.Record, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+revisionColumns+` FROM record_revisions WHERE owner_id = $1 AND record_id = $2 ORDER BY version DESC`, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.Record
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// GetRevision returns a kept earlier version of the owner's record or
+// sql.ErrNoRows.
+func (r *Repository) GetRevision(ctx context.Context, ownerID, id string, version int64) (models.Record, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+revisionColumns+` FROM record_revisions WHERE owner_id = $1 AND record_id = $2 AND version = $3`, ownerID, id, version)
+	return scanRecord(row)
+}
+
+// PruneRevisions drops all but the keep newest revisions of the record.
+func (r *Repository) PruneRevisions(ctx context.Context, ownerID, id string, keep int) error {
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM record_revisions WHERE owner_id = $1 AND record_id = $2 AND version NOT IN (
+			SELECT version FROM record_revisions WHERE owner_id = $1 AND record_id = $2 ORDER BY version DESC LIMIT $3
+		)`, ownerID, id, keep)
+	return err
+}
+
+// ListTrash returns the owner's deleted records kept in the trash, most
+// recently deleted first.
+func (r *Repository) ListTrash(ctx context.Context, ownerID string) ([]models.TrashedRecord, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+trashColumns+` FROM trash WHERE owner_id = $1`+trashLive+` ORDER BY deleted_at DESC, record_id`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.TrashedRecord
+	for rows.Next() {
+		var t models.TrashedRecord
+		if t.Record, err = scanRecord(rows, &t.DeletedAt); err != nil {
+			return nil, err
+		}
+		t.DeletedAt = t.DeletedAt.UTC()
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetTrashed returns the owner's record kept in the trash or sql.ErrNoRows.
+func (r *Repository) GetTrashed(ctx context.Context, ownerID, id string) (models.TrashedRecord, error) {
+	var t models.TrashedRecord
+	var err error
+	row := r.db.QueryRowContext(ctx, `SELECT `+trashColumns+` FROM trash WHERE owner_id = $1 AND record_id = $2`+trashLive, ownerID, id)
+	t.Record, err = scanRecord(row, &t.DeletedAt)
+	t.DeletedAt = t.DeletedAt.UTC()
+	return t, err
+}
+
+// DeleteTrashed removes the record from the owner's trash.
+func (r *Repository) DeleteTrashed(ctx context.Context, ownerID, id string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM trash WHERE owner_id = $1 AND record_id = $2`, ownerID, id)
+	return err
+}
+
+// EmptyTrash removes all records from the owner's trash.
+func (r *Repository) EmptyTrash(ctx context.Context, ownerID string) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM trash WHERE owner_id = $1`, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeTrash removes records deleted before the given time from all trashes.
+func (r *Repository) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM trash WHERE deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRecord reads recordColumns followed by any extra destinations.
+func scanRecord(s scanner, extra ...any) (models.Record, error) {
+	var rec models.Record
+	var typ string
+	var metaBytes []byte
+	dest := append([]any{&rec.ID, &rec.OwnerID, &typ, &metaBytes, &rec.Payload, &rec.Version, &rec.UpdatedAt, &rec.ContentID, &rec.ContentSize, &rec.PayloadRef}, extra...)
+	if err := s.Scan(dest...); err != nil {
+		return models.Record{}, err
+	}
+	rec.Type = models.RecordType(typ)
+	rec.UpdatedAt = rec.UpdatedAt.UTC()
+	if len(metaBytes) > 0 {
+		var meta map[string]string
+		_ = json.Unmarshal(metaBytes, &meta)
+		rec.Meta = meta
+	}
+	return rec, nil
+}
+
+// payloadBytes maps a nil payload to an empty one for the NOT NULL column.
+func payloadBytes(p []byte) []byte {
+	if p == nil {
+		return []byte{}
+	}
+	return p
+}
+
+// nextSeq allocates the next value of the global change sequence. The row
+// lock on sync_state is held until the transaction ends, so sequence numbers
+// become visible in order.
+func nextSeq(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var seq int64
+	err := tx.QueryRowContext(ctx, `UPDATE sync_state SET last_seq = last_seq + 1 WHERE id = 1 RETURNING last_seq`).Scan(&seq)
+	return seq, err
+}
+
+// Key escrow
+
+// GetKeyEscrow returns the owner's wrapped vault key or sql.ErrNoRows.
+func (r *Repository) GetKeyEscrow(ctx context.Context, ownerID string) (models.KeyEscrow, error) {
+	var k models.KeyEscrow
+	err := r.db.QueryRowContext(ctx, `SELECT blob, version, updated_at FROM key_escrow WHERE owner_id = $1`, ownerID).Scan(&k.Blob, &k.Version, &k.UpdatedAt)
+	k.UpdatedAt = k.UpdatedAt.UTC()
+	return k, err
+}
+
+// PutKeyEscrow stores the owner's wrapped vault key. A negative
+// expectedVersion overwrites unconditionally, zero requires that no key is
+// stored yet, otherwise the stored version must match.
+func (r *Repository) PutKeyEscrow(ctx context.Context, ownerID string, blob []byte, expectedVersion int64) (models.KeyEscrow, error) {
+	updated := now()
+	var version int64
+	var err error
+	switch {
+	case expectedVersion < 0:
+		err = r.db.QueryRowContext(ctx, `
+			INSERT INTO key_escrow(owner_id, blob, version, updated_at) VALUES($1,$2,1,$3)
+			ON CONFLICT(owner_id) DO UPDATE SET blob=excluded.blob, version=key_escrow.version+1, updated_at=excluded.updated_at
+			RETURNING version
+		`, ownerID, blob, updated).Scan(&version)
+	case expectedVersion == 0:
+		err = r.db.QueryRowContext(ctx, `INSERT INTO key_escrow(owner_id, blob, version, updated_at) VALUES($1,$2,1,$3) ON CONFLICT(owner_id) DO NOTHING RETURNING version`, ownerID, blob, updated).Scan(&version)
+	default:
+		err = r.db.QueryRowContext(ctx, `UPDATE key_escrow SET blob=$1, version=version+1, updated_at=$2 WHERE owner_id=$3 AND version=$4 RETURNING version`, blob, updated, ownerID, expectedVersion).Scan(&version)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.KeyEscrow{}, repository.ErrVersionConflict
+	}
+	if err != nil {
+		return models.KeyEscrow{}, err
+	}
+	return models.KeyEscrow{Blob: blob, Version: version, UpdatedAt: updated}, nil
+}
+
+// Uploads
+
+func (r *Repository) CreateUpload(ctx context.Context, ownerID, id string) (models.Upload, error) {
+	created := now()
+	_, err := r.db.ExecContext(ctx, `INSERT INTO uploads(id, owner_id, size, created_at, updated_at) VALUES($1,$2,0,$3,$3)`, id, ownerID, created)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	return models.Upload{ID: id, CreatedAt: created, UpdatedAt: created}, nil
+}
+
+func (r *Repository) GetUpload(ctx context.Context, ownerID, id string) (models.Upload, error) {
+	var up models.Upload
+	err := r.db.QueryRowContext(ctx, `SELECT id, size, created_at, updated_at FROM uploads WHERE owner_id = $1 AND id = $2`, ownerID, id).Scan(&up.ID, &up.Size, &up.CreatedAt, &up.UpdatedAt)
+	up.CreatedAt, up.UpdatedAt = up.CreatedAt.UTC(), up.UpdatedAt.UTC()
+	return up, err
+}
+
+// SetUploadSize advances the received size of an upload from the given
+// offset; it returns repository.ErrVersionConflict if the offset is stale.
+func (r *Repository) SetUploadSize(ctx context.Context, ownerID, id string, offset, size int64) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE uploads SET size = $1, updated_at = $2 WHERE owner_id = $3 AND id = $4 AND size = $5`, size, now(), ownerID, id, offset)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return repository.ErrVersionConflict
+	}
+	return nil
+}
+
+func (r *Repository) DeleteUpload(ctx context.Context, ownerID, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM uploads WHERE owner_id = $1 AND id = $2`, ownerID, id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteStaleUploads removes uploads not touched since before and returns
+// their ids so that the caller can drop the received data.
+func (r *Repository) DeleteStaleUploads(ctx context.Context, before time.Time) ([]string, error) {
+	return r.deleteReturning(ctx, `DELETE FROM uploads WHERE updated_at < $1 RETURNING id`, before)
+}
+
+// Blobs
+
+// RegisterBlob records that a blob is about to be stored, or refreshes its
+// timestamp when it exists, so that DeleteUnreferencedBlobs leaves it alone
+// until a record references it.
+func (r *Repository) RegisterBlob(ctx context.Context, hash string, size int64) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO blobs(hash, size, refs, updated_at) VALUES($1,$2,0,$3)
+		ON CONFLICT(hash) DO UPDATE SET updated_at=excluded.updated_at
+	`, hash, size, now())
+	return err
+}
+
+// DeleteUnreferencedBlobs removes blobs that no record references and that
+// were registered or lost their last reference before the given time, and
+// returns their hashes so that the caller can delete the stored data.
+func (r *Repository) DeleteUnreferencedBlobs(ctx context.Context, before time.Time) ([]string, error) {
+	return r.deleteReturning(ctx, `DELETE FROM blobs WHERE refs <= 0 AND updated_at < $1 RETURNING hash`, before)
+}
+
+// deleteReturning runs a DELETE ... RETURNING of a single text column.
+func (r *Repository) deleteReturning(ctx context.Context, query string, before time.Time) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Refresh tokens
+
+func (r *Repository) CreateRefreshToken(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `INSERT INTO refresh_tokens(token, user_id, expires_at, created_at) VALUES($1,$2,$3,$4)`, token, userID, expiresAt, now())
+	return err
+}
+
+func (r *Repository) GetRefreshToken(ctx context.Context, token string) (userID string, expiresAt time.Time, err error) {
+	row := r.db.QueryRowContext(ctx, `SELECT user_id, expires_at FROM refresh_tokens WHERE token = $1`, token)
+	err = row.Scan(&userID, &expiresAt)
+	expiresAt = expiresAt.UTC()
+	return
+}
+
+func (r *Repository) DeleteRefreshToken(ctx context.Context, token string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE token = $1`, token)
+	return err
+}
@@ -0,0 +1,990 @@
+// Package repotest is a conformance suite for service.Repository
+// implementations. Every backend runs the same checks:
+//
+//	func TestConformance(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) service.Repository {
+//			repo := openEmptyRepo(t)
+//			t.Cleanup(func() { _ = repo.Close() })
+//			return repo
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/server/repository"
+	"gophkeeper/internal/server/service"
+	"gophkeeper/internal/shared/models"
+)
+
+// Factory returns an empty repository for a single test. It should register
+// any cleanup with t.Cleanup.
+type Factory func(t *testing.T) service.Repository
+
+// Run runs the suite against repositories returned by newRepo, each check in
+// its own subtest with a fresh repository.
+func Run(t *testing.T, newRepo Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo service.Repository)
+	}{
+		{"Users", testUsers},
+		{"RecordsCRUD", testRecordsCRUD},
+		{"VersionConflicts", testVersionConflicts},
+		{"OwnerIsolation", testOwnerIsolation},
+		{"ConcurrentConditionalUpserts", testConcurrentConditionalUpserts},
+		{"ListQuery", testListQuery},
+		{"ListPaging", testListPaging},
+		{"Changes", testChanges},
+		{"PurgeTombstones", testPurgeTombstones},
+		{"KeyEscrow", testKeyEscrow},
+		{"Uploads", testUploads},
+		{"Blobs", testBlobs},
+		{"RefreshTokens", testRefreshTokens},
+		{"Revisions", testRevisions},
+		{"RevisionBlobs", testRevisionBlobs},
+		{"Trash", testTrash},
+		{"Batch", testBatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func newUser(t *testing.T, repo service.Repository, email string) models.User {
+	t.Helper()
+	u, err := repo.CreateUser(context.Background(), email, []byte("hash"))
+	if err != nil {
+		t.Fatalf("create user %s: %v", email, err)
+	}
+	return u
+}
+
+func mustUpsert(t *testing.T, repo service.Repository, rec models.Record) models.Record {
+	t.Helper()
+	saved, err := repo.UpsertRecord(context.Background(), rec)
+	if err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	return saved
+}
+
+func ids(records []models.Record) string {
+	out := make([]string, len(records))
+	for i, rec := range records {
+		out[i] = rec.ID
+	}
+	return strings.Join(out, ",")
+}
+
+func testUsers(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "user@example.com")
+	if u.ID == "" || u.Email != "user@example.com" || u.CreatedAt.IsZero() {
+		t.Fatalf("created user: %+v", u)
+	}
+	if _, err := repo.CreateUser(ctx, "user@example.com", []byte("other")); err == nil {
+		t.Fatal("duplicate email must be rejected")
+	}
+	id, hash, err := repo.GetUserByEmail(ctx, "user@example.com")
+	if err != nil || id != u.ID || string(hash) != "hash" {
+		t.Fatalf("get user: %s %q %v", id, hash, err)
+	}
+	if _, _, err := repo.GetUserByEmail(ctx, "missing@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("missing user: want sql.ErrNoRows, got %v", err)
+	}
+}
+
+func testRecordsCRUD(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "crud@example.com")
+	rec := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Meta: map[string]string{"title": "a"}, Payload: []byte("x")})
+	if rec.ID == "" || rec.Version != 1 || rec.UpdatedAt.IsZero() {
+		t.Fatalf("inserted record: %+v", rec)
+	}
+	got, err := repo.GetRecord(ctx, u.ID, rec.ID)
+	if err != nil || string(got.Payload) != "x" || got.Meta["title"] != "a" || got.Type != models.RecordTypeText || got.OwnerID != u.ID || got.Version != 1 {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+	if !got.UpdatedAt.Equal(rec.UpdatedAt) {
+		t.Fatalf("updated_at changed on read: %v != %v", got.UpdatedAt, rec.UpdatedAt)
+	}
+
+	// unconditional upsert bumps the version it was given
+	got.Payload = []byte("y")
+	got.ContentID, got.ContentSize = "content", 42
+	updated := mustUpsert(t, repo, got)
+	if updated.Version != 2 {
+		t.Fatalf("upsert version: %d", updated.Version)
+	}
+	got, _ = repo.GetRecord(ctx, u.ID, rec.ID)
+	if string(got.Payload) != "y" || got.ContentID != "content" || got.ContentSize != 42 {
+		t.Fatalf("after update: %+v", got)
+	}
+
+	if err := repo.DeleteRecord(ctx, u.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetRecord(ctx, u.ID, rec.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("deleted record: want sql.ErrNoRows, got %v", err)
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, rec.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("second delete: want sql.ErrNoRows, got %v", err)
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("delete missing: want sql.ErrNoRows, got %v", err)
+	}
+	if list, err := repo.ListRecords(ctx, u.ID, models.RecordQuery{}); err != nil || len(list) != 0 {
+		t.Fatalf("tombstone listed: %+v %v", list, err)
+	}
+}
+
+func testVersionConflicts(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "versions@example.com")
+	rec := models.Record{ID: "rec-1", OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("a")}
+
+	created, err := repo.UpsertRecordConditional(ctx, rec, 0)
+	if err != nil || created.Version != 1 || created.ID != "rec-1" {
+		t.Fatalf("create: %+v %v", created, err)
+	}
+	if _, err := repo.UpsertRecordConditional(ctx, rec, 0); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("create over live record: want ErrVersionConflict, got %v", err)
+	}
+	rec.Payload = []byte("b")
+	if _, err := repo.UpsertRecordConditional(ctx, rec, 2); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("future version: want ErrVersionConflict, got %v", err)
+	}
+	updated, err := repo.UpsertRecordConditional(ctx, rec, 1)
+	if err != nil || updated.Version != 2 {
+		t.Fatalf("update: %+v %v", updated, err)
+	}
+	if _, err := repo.UpsertRecordConditional(ctx, rec, 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("stale version: want ErrVersionConflict, got %v", err)
+	}
+	if got, _ := repo.GetRecord(ctx, u.ID, rec.ID); string(got.Payload) != "b" || got.Version != 2 {
+		t.Fatalf("failed updates must not change the record: %+v", got)
+	}
+
+	if err := repo.DeleteRecord(ctx, u.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.UpsertRecordConditional(ctx, rec, 3); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("update of tombstone: want ErrVersionConflict, got %v", err)
+	}
+	// recreating a tombstone continues its version sequence so that other
+	// devices holding the tombstone see a newer version
+	again, err := repo.UpsertRecordConditional(ctx, rec, 0)
+	if err != nil || again.Version != 4 {
+		t.Fatalf("recreate: %+v %v", again, err)
+	}
+	if _, err := repo.UpsertRecordConditional(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("n")}, 0); err != nil {
+		t.Fatalf("create without id: %v", err)
+	}
+}
+
+func testOwnerIsolation(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	alice := newUser(t, repo, "alice@example.com")
+	bob := newUser(t, repo, "bob@example.com")
+	rec := mustUpsert(t, repo, models.Record{OwnerID: alice.ID, Type: models.RecordTypeText, Meta: map[string]string{"k": "v"}, Payload: []byte("secret")})
+
+	if _, err := repo.GetRecord(ctx, bob.ID, rec.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("foreign get: want sql.ErrNoRows, got %v", err)
+	}
+	if list, _ := repo.ListRecords(ctx, bob.ID, models.RecordQuery{}); len(list) != 0 {
+		t.Fatalf("foreign list: %+v", list)
+	}
+	if list, _ := repo.ListRecordSummaries(ctx, bob.ID, models.RecordQuery{Meta: map[string]string{"k": "v"}}); len(list) != 0 {
+		t.Fatalf("foreign summaries: %+v", list)
+	}
+	if changes, _ := repo.ListChanges(ctx, bob.ID, 0); len(changes.Records) != 0 {
+		t.Fatalf("foreign changes: %+v", changes)
+	}
+	stolen := models.Record{ID: rec.ID, OwnerID: bob.ID, Type: models.RecordTypeText, Payload: []byte("mine")}
+	if _, err := repo.UpsertRecordConditional(ctx, stolen, 0); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("foreign create over id: want ErrVersionConflict, got %v", err)
+	}
+	if _, err := repo.UpsertRecordConditional(ctx, stolen, rec.Version); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("foreign update: want ErrVersionConflict, got %v", err)
+	}
+	if err := repo.DeleteRecord(ctx, bob.ID, rec.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("foreign delete: want sql.ErrNoRows, got %v", err)
+	}
+	if _, err := repo.UpsertRecord(ctx, stolen); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("foreign unconditional upsert: want sql.ErrNoRows, got %v", err)
+	}
+	results, err := repo.ApplyBatch(ctx, bob.ID, []models.BatchOp{{Op: models.BatchUpsert, Record: &stolen}})
+	if !errors.Is(err, repository.ErrBatchRejected) || batchStatuses(results) != "not_found:0" {
+		t.Fatalf("foreign batch upsert: %s %v", batchStatuses(results), err)
+	}
+	if got, err := repo.GetRecord(ctx, alice.ID, rec.ID); err != nil || string(got.Payload) != "secret" || got.Version != rec.Version || got.OwnerID != alice.ID {
+		t.Fatalf("owner's record changed: %+v %v", got, err)
+	}
+	for _, owner := range []string{alice.ID, bob.ID} {
+		if revs, _ := repo.ListRevisions(ctx, owner, rec.ID); len(revs) != 0 {
+			t.Fatalf("foreign upsert kept a revision: %s", revisionVersions(revs))
+		}
+	}
+	// a tombstone cannot be taken over either
+	if err := repo.DeleteRecord(ctx, alice.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.UpsertRecord(ctx, stolen); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("foreign upsert over a tombstone: want sql.ErrNoRows, got %v", err)
+	}
+	if trash, _ := repo.ListTrash(ctx, alice.ID); len(trash) != 1 {
+		t.Fatalf("owner's trash after a foreign upsert: %+v", trash)
+	}
+
+	up, err := repo.CreateUpload(ctx, alice.ID, "upload-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetUpload(ctx, bob.ID, up.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("foreign upload: want sql.ErrNoRows, got %v", err)
+	}
+	if err := repo.SetUploadSize(ctx, bob.ID, up.ID, 0, 10); err == nil {
+		t.Fatal("foreign upload append must fail")
+	}
+	if err := repo.DeleteUpload(ctx, bob.ID, up.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("foreign upload delete: want sql.ErrNoRows, got %v", err)
+	}
+	if _, err := repo.PutKeyEscrow(ctx, alice.ID, []byte("alice key"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetKeyEscrow(ctx, bob.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("foreign key escrow: want sql.ErrNoRows, got %v", err)
+	}
+}
+
+func testConcurrentConditionalUpserts(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "race@example.com")
+	rec := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("base")})
+
+	const writers = 16
+	run := func(expected int64, mk func(i int) models.Record) (wins int, versions []int64) {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				saved, err := repo.UpsertRecordConditional(ctx, mk(i), expected)
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err == nil:
+					wins++
+					versions = append(versions, saved.Version)
+				case !errors.Is(err, repository.ErrVersionConflict):
+					t.Errorf("writer %d: %v", i, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		return wins, versions
+	}
+
+	wins, versions := run(rec.Version, func(i int) models.Record {
+		r := rec
+		r.Payload = []byte(fmt.Sprint(i))
+		return r
+	})
+	if wins != 1 || versions[0] != rec.Version+1 {
+		t.Fatalf("concurrent updates: %d winners, versions %v", wins, versions)
+	}
+	wins, _ = run(0, func(i int) models.Record {
+		return models.Record{ID: "contended", OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte(fmt.Sprint(i))}
+	})
+	if wins != 1 {
+		t.Fatalf("concurrent creates: %d winners", wins)
+	}
+	if got, err := repo.GetRecord(ctx, u.ID, "contended"); err != nil || got.Version != 1 {
+		t.Fatalf("contended record: %+v %v", got, err)
+	}
+}
+
+func testListQuery(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "query@example.com")
+	login := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeLogin, Meta: map[string]string{"site": "a.example", "user": "x"}, Payload: []byte("1")})
+	text := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Meta: map[string]string{"site": "a.example"}, Payload: []byte("2")})
+	other := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeLogin, Meta: map[string]string{"site": "b.example"}, Payload: []byte("3")})
+	bin := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Meta: map[string]string{"name": "f"}, Payload: []byte("4"), ContentSize: 7})
+
+	for _, tc := range []struct {
+		name string
+		q    models.RecordQuery
+		want []models.Record
+	}{
+		{"all", models.RecordQuery{}, []models.Record{bin, other, text, login}},
+		{"type", models.RecordQuery{Type: models.RecordTypeLogin}, []models.Record{other, login}},
+		{"meta", models.RecordQuery{Meta: map[string]string{"site": "a.example"}}, []models.Record{text, login}},
+		{"meta all pairs", models.RecordQuery{Meta: map[string]string{"site": "a.example", "user": "x"}}, []models.Record{login}},
+		{"meta missing key", models.RecordQuery{Meta: map[string]string{"nope": ""}}, nil},
+		{"type and meta", models.RecordQuery{Type: models.RecordTypeText, Meta: map[string]string{"site": "a.example"}}, []models.Record{text}},
+		{"updated after", models.RecordQuery{UpdatedAfter: text.UpdatedAt}, []models.Record{bin, other}},
+		{"ascending", models.RecordQuery{Ascending: true, Limit: 2}, []models.Record{login, text}},
+	} {
+		list, err := repo.ListRecords(ctx, u.ID, tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if ids(list) != ids(tc.want) {
+			t.Errorf("%s: got %s, want %s", tc.name, ids(list), ids(tc.want))
+		}
+	}
+
+	sums, err := repo.ListRecordSummaries(ctx, u.ID, models.RecordQuery{Type: models.RecordTypeBinary})
+	if err != nil || len(sums) != 1 {
+		t.Fatalf("summaries: %+v %v", sums, err)
+	}
+	if s := sums[0]; s.ID != bin.ID || s.Type != bin.Type || s.Meta["name"] != "f" || s.Version != bin.Version || s.ContentSize != 7 || !s.UpdatedAt.Equal(bin.UpdatedAt) {
+		t.Fatalf("summary: %+v, record %+v", s, bin)
+	}
+}
+
+func testListPaging(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "paging@example.com")
+	var saved []models.Record
+	for i := 0; i < 7; i++ {
+		saved = append(saved, mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte{byte(i)}}))
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, saved[3].ID); err != nil {
+		t.Fatal(err)
+	}
+	live := append(append([]models.Record{}, saved[:3]...), saved[4:]...)
+
+	for _, asc := range []bool{true, false} {
+		var seen []models.Record
+		q := models.RecordQuery{Ascending: asc, Limit: 2}
+		for page := 0; page < 10; page++ {
+			list, err := repo.ListRecords(ctx, u.ID, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen = append(seen, list...)
+			if len(list) < q.Limit {
+				break
+			}
+			last := list[len(list)-1]
+			q.After = &models.RecordKey{UpdatedAt: last.UpdatedAt, ID: last.ID}
+		}
+		want := append([]models.Record{}, live...)
+		if !asc {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		if ids(seen) != ids(want) {
+			t.Fatalf("ascending=%v pages: %s, want %s", asc, ids(seen), ids(want))
+		}
+	}
+}
+
+func testChanges(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "changes@example.com")
+	other := newUser(t, repo, "changes-other@example.com")
+	a := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("a")})
+	b := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("b")})
+	mustUpsert(t, repo, models.Record{OwnerID: other.ID, Type: models.RecordTypeText, Payload: []byte("c")})
+
+	full, err := repo.ListChanges(ctx, u.ID, 0)
+	if err != nil || ids(full.Records) != a.ID+","+b.ID || len(full.Deleted) != 0 || full.Cursor == 0 || full.Reset {
+		t.Fatalf("full changes: %+v %v", full, err)
+	}
+	empty, err := repo.ListChanges(ctx, u.ID, full.Cursor)
+	if err != nil || len(empty.Records) != 0 || len(empty.Deleted) != 0 || empty.Cursor != full.Cursor {
+		t.Fatalf("no changes: %+v %v", empty, err)
+	}
+
+	// another owner's change advances the cursor but is not returned
+	mustUpsert(t, repo, models.Record{OwnerID: other.ID, Type: models.RecordTypeText, Payload: []byte("d")})
+	if _, err := repo.UpsertRecordConditional(ctx, models.Record{ID: a.ID, OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("a2")}, a.Version); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	delta, err := repo.ListChanges(ctx, u.ID, full.Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Records) != 1 || string(delta.Records[0].Payload) != "a2" || delta.Records[0].Version != 2 {
+		t.Fatalf("delta records: %+v", delta.Records)
+	}
+	if len(delta.Deleted) != 1 || delta.Deleted[0].ID != b.ID || delta.Deleted[0].Version != b.Version+1 || delta.Deleted[0].DeletedAt.IsZero() {
+		t.Fatalf("delta tombstones: %+v", delta.Deleted)
+	}
+	if delta.Cursor <= full.Cursor {
+		t.Fatalf("cursor must advance: %d -> %d", full.Cursor, delta.Cursor)
+	}
+}
+
+func testPurgeTombstones(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "purge@example.com")
+	a := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("a")})
+	b := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("b")})
+	first, _ := repo.ListChanges(ctx, u.ID, 0)
+	if err := repo.DeleteRecord(ctx, u.ID, a.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := repo.PurgeTombstones(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("recent tombstone purged: %d %v", n, err)
+	}
+	// the tombstone of a trashed record stays so that undelete continues its
+	// version sequence
+	if n, err := repo.PurgeTombstones(ctx, time.Now().Add(time.Minute)); err != nil || n != 0 {
+		t.Fatalf("tombstone of a trashed record purged: %d %v", n, err)
+	}
+	if err := repo.DeleteTrashed(ctx, u.ID, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := repo.PurgeTombstones(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("purge: %d %v", n, err)
+	}
+	// a client behind the purged tombstone gets a full snapshot
+	reset, err := repo.ListChanges(ctx, u.ID, first.Cursor)
+	if err != nil || !reset.Reset || ids(reset.Records) != b.ID || len(reset.Deleted) != 0 {
+		t.Fatalf("stale cursor: %+v %v", reset, err)
+	}
+	current, err := repo.ListChanges(ctx, u.ID, reset.Cursor)
+	if err != nil || current.Reset || len(current.Records) != 0 {
+		t.Fatalf("current cursor: %+v %v", current, err)
+	}
+	// the purged id can be used again
+	if _, err := repo.UpsertRecordConditional(ctx, models.Record{ID: a.ID, OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("new")}, 0); err != nil {
+		t.Fatalf("reuse purged id: %v", err)
+	}
+}
+
+func testKeyEscrow(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "escrow@example.com")
+	if _, err := repo.GetKeyEscrow(ctx, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("missing escrow: want sql.ErrNoRows, got %v", err)
+	}
+	if _, err := repo.PutKeyEscrow(ctx, u.ID, []byte("k0"), 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("update of missing escrow: want ErrVersionConflict, got %v", err)
+	}
+	k, err := repo.PutKeyEscrow(ctx, u.ID, []byte("k1"), 0)
+	if err != nil || k.Version != 1 {
+		t.Fatalf("create: %+v %v", k, err)
+	}
+	if _, err := repo.PutKeyEscrow(ctx, u.ID, []byte("k2"), 0); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("second create: want ErrVersionConflict, got %v", err)
+	}
+	if _, err := repo.PutKeyEscrow(ctx, u.ID, []byte("k2"), 2); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("wrong version: want ErrVersionConflict, got %v", err)
+	}
+	if k, err = repo.PutKeyEscrow(ctx, u.ID, []byte("k2"), 1); err != nil || k.Version != 2 {
+		t.Fatalf("update: %+v %v", k, err)
+	}
+	if k, err = repo.PutKeyEscrow(ctx, u.ID, []byte("k3"), -1); err != nil || k.Version != 3 {
+		t.Fatalf("forced update: %+v %v", k, err)
+	}
+	got, err := repo.GetKeyEscrow(ctx, u.ID)
+	if err != nil || string(got.Blob) != "k3" || got.Version != 3 || got.UpdatedAt.IsZero() {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+}
+
+func testUploads(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "uploads@example.com")
+	up, err := repo.CreateUpload(ctx, u.ID, "upload-1")
+	if err != nil || up.ID != "upload-1" || up.Size != 0 {
+		t.Fatalf("create: %+v %v", up, err)
+	}
+	if _, err := repo.CreateUpload(ctx, u.ID, "upload-1"); err == nil {
+		t.Fatal("duplicate upload id must be rejected")
+	}
+	if err := repo.SetUploadSize(ctx, u.ID, up.ID, 0, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetUploadSize(ctx, u.ID, up.ID, 0, 9); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("stale offset: want ErrVersionConflict, got %v", err)
+	}
+	if err := repo.SetUploadSize(ctx, u.ID, up.ID, 5, 9); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetUpload(ctx, u.ID, up.ID)
+	if err != nil || got.Size != 9 || got.CreatedAt.IsZero() || got.UpdatedAt.Before(got.CreatedAt) {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+
+	if _, err := repo.CreateUpload(ctx, u.ID, "upload-2"); err != nil {
+		t.Fatal(err)
+	}
+	if gone, err := repo.DeleteStaleUploads(ctx, time.Now().Add(-time.Hour)); err != nil || len(gone) != 0 {
+		t.Fatalf("fresh uploads removed: %v %v", gone, err)
+	}
+	if err := repo.DeleteUpload(ctx, u.ID, "upload-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteUpload(ctx, u.ID, "upload-2"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("second delete: want sql.ErrNoRows, got %v", err)
+	}
+	gone, err := repo.DeleteStaleUploads(ctx, time.Now().Add(time.Minute))
+	if err != nil || strings.Join(gone, ",") != "upload-1" {
+		t.Fatalf("stale uploads: %v %v", gone, err)
+	}
+	if _, err := repo.GetUpload(ctx, u.ID, "upload-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("removed upload: want sql.ErrNoRows, got %v", err)
+	}
+}
+
+func testBlobs(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "blobs@example.com")
+	for _, h := range []string{"content1", "content2", "payload1", "orphan"} {
+		if err := repo.RegisterBlob(ctx, h, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.RegisterBlob(ctx, "orphan", 10); err != nil {
+		t.Fatalf("registering a known blob: %v", err)
+	}
+	collect := func(before time.Time) string {
+		t.Helper()
+		hashes, err := repo.DeleteUnreferencedBlobs(ctx, before)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(hashes)
+		return strings.Join(hashes, ",")
+	}
+
+	a := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte{}, ContentID: "content1"})
+	b, err := repo.UpsertRecordConditional(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte{}, ContentID: "content1", PayloadRef: "payload1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.GetRecord(ctx, u.ID, b.ID); err != nil || got.PayloadRef != "payload1" || got.ContentID != "content1" {
+		t.Fatalf("blob references not stored: %+v %v", got, err)
+	}
+	if got := collect(time.Now().Add(-time.Hour)); got != "" {
+		t.Fatalf("grace period ignored: %s", got)
+	}
+	if got := collect(time.Now().Add(time.Minute)); got != "content2,orphan" {
+		t.Fatalf("first collection: %s", got)
+	}
+
+	// moving a record to other content releases the old reference only once
+	// no record uses it
+	if err := repo.RegisterBlob(ctx, "content2", 10); err != nil {
+		t.Fatal(err)
+	}
+	a.ContentID = "content2"
+	mustUpsert(t, repo, a)
+	if got := collect(time.Now().Add(time.Minute)); got != "" {
+		t.Fatalf("shared blob collected: %s", got)
+	}
+	b.ContentID = ""
+	if _, err := repo.UpsertRecordConditional(ctx, b, b.Version); err != nil {
+		t.Fatal(err)
+	}
+	// revisions keep the replaced references until they are pruned
+	if got := collect(time.Now().Add(time.Minute)); got != "" {
+		t.Fatalf("content of a revision collected: %s", got)
+	}
+	for _, id := range []string{a.ID, b.ID} {
+		if err := repo.PruneRevisions(ctx, u.ID, id, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := collect(time.Now().Add(time.Minute)); got != "content1" {
+		t.Fatalf("released content: %s", got)
+	}
+	// deleted records hand their blobs to the trash; purging the tombstones
+	// must not release them, emptying the trash does
+	if err := repo.DeleteRecord(ctx, u.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.PurgeTombstones(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(time.Now().Add(time.Minute)); got != "" {
+		t.Fatalf("content of trashed records collected: %s", got)
+	}
+	if _, err := repo.EmptyTrash(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(time.Now().Add(time.Minute)); got != "content2,payload1" {
+		t.Fatalf("after delete: %s", got)
+	}
+	if err := repo.RegisterBlob(ctx, "content2", 10); err != nil {
+		t.Fatal(err)
+	}
+	mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte{}, ContentID: "content2"})
+	if got := collect(time.Now().Add(time.Minute)); got != "" {
+		t.Fatalf("re-registered blob must be referenced once: %s", got)
+	}
+
+	// the grace period runs from the release of the last reference, not
+	// from registration
+	if err := repo.RegisterBlob(ctx, "released", 10); err != nil {
+		t.Fatal(err)
+	}
+	c := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte{}, ContentID: "released"})
+	time.Sleep(20 * time.Millisecond)
+	mark := time.Now()
+	time.Sleep(20 * time.Millisecond)
+	if err := repo.DeleteRecord(ctx, u.ID, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteTrashed(ctx, u.ID, c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(mark); got != "" {
+		t.Fatalf("blob released after the cutoff collected: %s", got)
+	}
+	if got := collect(time.Now().Add(time.Minute)); got != "released" {
+		t.Fatalf("released blob: %s", got)
+	}
+}
+
+func revisionVersions(revs []models.Record) string {
+	out := make([]string, len(revs))
+	for i, rev := range revs {
+		out[i] = fmt.Sprintf("%d:%s", rev.Version, rev.Payload)
+	}
+	return strings.Join(out, ",")
+}
+
+func testRevisions(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "revisions@example.com")
+	other := newUser(t, repo, "revisions-other@example.com")
+	rec := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeLogin, Meta: map[string]string{"site": "a"}, Payload: []byte("v1")})
+	if revs, err := repo.ListRevisions(ctx, u.ID, rec.ID); err != nil || len(revs) != 0 {
+		t.Fatalf("new record has revisions: %+v %v", revs, err)
+	}
+	rec.Payload = []byte("v2")
+	rec, err := repo.UpsertRecordConditional(ctx, rec, rec.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.UpsertRecordConditional(ctx, models.Record{ID: rec.ID, OwnerID: u.ID, Type: models.RecordTypeLogin, Payload: []byte("lost")}, 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("stale update: want ErrVersionConflict, got %v", err)
+	}
+	rec.Payload = []byte("v3")
+	rec = mustUpsert(t, repo, rec)
+
+	revs, err := repo.ListRevisions(ctx, u.ID, rec.ID)
+	if err != nil || revisionVersions(revs) != "2:v2,1:v1" {
+		t.Fatalf("revisions: %s %v", revisionVersions(revs), err)
+	}
+	if r := revs[1]; r.ID != rec.ID || r.OwnerID != u.ID || r.Type != models.RecordTypeLogin || r.Meta["site"] != "a" || r.UpdatedAt.IsZero() {
+		t.Fatalf("revision fields: %+v", r)
+	}
+	first, err := repo.GetRevision(ctx, u.ID, rec.ID, 1)
+	if err != nil || string(first.Payload) != "v1" || first.Version != 1 || !first.UpdatedAt.Equal(revs[1].UpdatedAt) {
+		t.Fatalf("get revision: %+v %v", first, err)
+	}
+	if _, err := repo.GetRevision(ctx, u.ID, rec.ID, 3); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("current version is not a revision: %v", err)
+	}
+	if revs, _ := repo.ListRevisions(ctx, other.ID, rec.ID); len(revs) != 0 {
+		t.Fatalf("foreign revisions: %+v", revs)
+	}
+	if _, err := repo.GetRevision(ctx, other.ID, rec.ID, 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("foreign revision: want sql.ErrNoRows, got %v", err)
+	}
+	if err := repo.PruneRevisions(ctx, other.ID, rec.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.PruneRevisions(ctx, u.ID, rec.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if revs, _ := repo.ListRevisions(ctx, u.ID, rec.ID); revisionVersions(revs) != "2:v2" {
+		t.Fatalf("after prune: %s", revisionVersions(revs))
+	}
+
+	// a resurrected tombstone has no content to keep; purging the tombstone
+	// drops the revisions
+	if err := repo.DeleteRecord(ctx, u.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.UpsertRecordConditional(ctx, models.Record{ID: rec.ID, OwnerID: u.ID, Type: models.RecordTypeLogin, Payload: []byte("v5")}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if revs, _ := repo.ListRevisions(ctx, u.ID, rec.ID); revisionVersions(revs) != "2:v2" {
+		t.Fatalf("after resurrection: %s", revisionVersions(revs))
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteTrashed(ctx, u.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.PurgeTombstones(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if revs, _ := repo.ListRevisions(ctx, u.ID, rec.ID); len(revs) != 0 {
+		t.Fatalf("revisions of a purged record: %s", revisionVersions(revs))
+	}
+}
+
+func testRevisionBlobs(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "revision-blobs@example.com")
+	for _, h := range []string{"old", "new"} {
+		if err := repo.RegisterBlob(ctx, h, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte{}, PayloadRef: "old"})
+	rec.PayloadRef = "new"
+	rec = mustUpsert(t, repo, rec)
+	later := time.Now().Add(time.Minute)
+	if gone, err := repo.DeleteUnreferencedBlobs(ctx, later); err != nil || len(gone) != 0 {
+		t.Fatalf("blob of a kept revision collected: %v %v", gone, err)
+	}
+	if err := repo.PruneRevisions(ctx, u.ID, rec.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if gone, err := repo.DeleteUnreferencedBlobs(ctx, later); err != nil || strings.Join(gone, ",") != "old" {
+		t.Fatalf("blob of a pruned revision: %v %v", gone, err)
+	}
+}
+
+func trashIDs(items []models.TrashedRecord) string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = string(item.Payload)
+	}
+	return strings.Join(out, ",")
+}
+
+func testTrash(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "trash@example.com")
+	other := newUser(t, repo, "trash-other@example.com")
+	if err := repo.RegisterBlob(ctx, "trashed", 10); err != nil {
+		t.Fatal(err)
+	}
+	a := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeLogin, Meta: map[string]string{"site": "a"}, Payload: []byte("a")})
+	b := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte("b"), ContentID: "trashed", ContentSize: 10})
+	mustUpsert(t, repo, models.Record{OwnerID: other.ID, Type: models.RecordTypeText, Payload: []byte("o")})
+	if items, err := repo.ListTrash(ctx, u.ID); err != nil || len(items) != 0 {
+		t.Fatalf("empty trash: %+v %v", items, err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	for _, id := range []string{a.ID, b.ID} {
+		if err := repo.DeleteRecord(ctx, u.ID, id); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	items, err := repo.ListTrash(ctx, u.ID)
+	if err != nil || trashIDs(items) != "b,a" {
+		t.Fatalf("trash: %s %v", trashIDs(items), err)
+	}
+	if it := items[1]; it.ID != a.ID || it.OwnerID != u.ID || it.Version != a.Version || it.Meta["site"] != "a" || it.Type != models.RecordTypeLogin || it.DeletedAt.Before(before) {
+		t.Fatalf("trashed fields: %+v", it)
+	}
+	if got, err := repo.GetTrashed(ctx, u.ID, b.ID); err != nil || got.ContentID != "trashed" || got.ContentSize != 10 {
+		t.Fatalf("get trashed: %+v %v", got, err)
+	}
+	if gone, err := repo.DeleteUnreferencedBlobs(ctx, time.Now().Add(time.Minute)); err != nil || len(gone) != 0 {
+		t.Fatalf("content of a trashed record collected: %v %v", gone, err)
+	}
+	if items, _ := repo.ListTrash(ctx, other.ID); len(items) != 0 {
+		t.Fatalf("foreign trash: %s", trashIDs(items))
+	}
+	if _, err := repo.GetTrashed(ctx, other.ID, a.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("foreign trashed record: want sql.ErrNoRows, got %v", err)
+	}
+
+	// storing the record again hides its trash entry
+	if _, err := repo.UpsertRecordConditional(ctx, models.Record{ID: a.ID, OwnerID: u.ID, Type: models.RecordTypeLogin, Payload: []byte("again")}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := repo.ListTrash(ctx, u.ID); trashIDs(items) != "b" {
+		t.Fatalf("trash after recreate: %s", trashIDs(items))
+	}
+	if _, err := repo.GetTrashed(ctx, u.ID, a.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("trashed live record: want sql.ErrNoRows, got %v", err)
+	}
+	// deleting it again replaces the entry
+	if err := repo.DeleteRecord(ctx, u.ID, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := repo.ListTrash(ctx, u.ID); trashIDs(items) != "again,b" {
+		t.Fatalf("trash after second delete: %s", trashIDs(items))
+	}
+
+	if err := repo.DeleteTrashed(ctx, other.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteTrashed(ctx, u.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if gone, err := repo.DeleteUnreferencedBlobs(ctx, time.Now().Add(time.Minute)); err != nil || strings.Join(gone, ",") != "trashed" {
+		t.Fatalf("content of a removed trash entry: %v %v", gone, err)
+	}
+	if n, err := repo.PurgeTrash(ctx, before); err != nil || n != 0 {
+		t.Fatalf("purge of nothing expired: %d %v", n, err)
+	}
+	if err := repo.DeleteRecord(ctx, other.ID, mustUpsert(t, repo, models.Record{OwnerID: other.ID, Type: models.RecordTypeText, Payload: []byte("x")}).ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := repo.EmptyTrash(ctx, u.ID); err != nil || n != 1 {
+		t.Fatalf("empty: %d %v", n, err)
+	}
+	if n, err := repo.PurgeTrash(ctx, time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("purge: %d %v", n, err)
+	}
+}
+
+func batchStatuses(results []models.BatchResult) string {
+	out := make([]string, len(results))
+	for i, res := range results {
+		out[i] = fmt.Sprintf("%s:%d", res.Status, res.Version)
+	}
+	return strings.Join(out, ",")
+}
+
+func testBatch(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "batch@example.com")
+	other := newUser(t, repo, "batch-other@example.com")
+	a := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("a1")})
+	c := mustUpsert(t, repo, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("c")})
+	foreign := mustUpsert(t, repo, models.Record{OwnerID: other.ID, Type: models.RecordTypeText, Payload: []byte("f")})
+	v := func(n int64) *int64 { return &n }
+
+	results, err := repo.ApplyBatch(ctx, u.ID, []models.BatchOp{
+		{Op: models.BatchUpsert, Record: &models.Record{Type: models.RecordTypeText, Payload: []byte("b")}},
+		{Op: models.BatchUpsert, Record: &models.Record{ID: a.ID, Type: models.RecordTypeText, Payload: []byte("a2")}, ExpectedVersion: v(1)},
+		{Op: models.BatchDelete, ID: c.ID},
+		{Op: models.BatchUpsert, Record: &models.Record{ID: "batch-x", Type: models.RecordTypeText, Payload: []byte("x1")}, ExpectedVersion: v(0)},
+		{Op: models.BatchUpsert, Record: &models.Record{ID: "batch-x", Type: models.RecordTypeText, Payload: []byte("x2")}, ExpectedVersion: v(1)},
+	})
+	if err != nil || batchStatuses(results) != "ok:1,ok:2,ok:0,ok:1,ok:2" || results[0].ID == "" || results[2].ID != c.ID || results[3].ID != "batch-x" {
+		t.Fatalf("batch: %s %+v %v", batchStatuses(results), results, err)
+	}
+	if results[0].UpdatedAt == nil || results[0].UpdatedAt.IsZero() || results[2].UpdatedAt != nil {
+		t.Fatalf("batch update times: %+v", results)
+	}
+	if got, err := repo.GetRecord(ctx, u.ID, results[0].ID); err != nil || string(got.Payload) != "b" || got.OwnerID != u.ID {
+		t.Fatalf("created: %+v %v", got, err)
+	}
+	if got, _ := repo.GetRecord(ctx, u.ID, a.ID); string(got.Payload) != "a2" || got.Version != 2 {
+		t.Fatalf("updated: %+v", got)
+	}
+	if _, err := repo.GetRecord(ctx, u.ID, c.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("deleted: %v", err)
+	}
+	if got, _ := repo.GetRecord(ctx, u.ID, "batch-x"); string(got.Payload) != "x2" {
+		t.Fatalf("operations on one record apply in order: %+v", got)
+	}
+
+	// one failing operation rejects the whole batch
+	if err := repo.RegisterBlob(ctx, "batch-content", 10); err != nil {
+		t.Fatal(err)
+	}
+	before, err := repo.ListChanges(ctx, u.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = repo.ApplyBatch(ctx, u.ID, []models.BatchOp{
+		{Op: models.BatchUpsert, Record: &models.Record{ID: "batch-d", Type: models.RecordTypeBinary, Payload: []byte{}, ContentID: "batch-content"}},
+		{Op: models.BatchDelete, ID: "batch-x"},
+		{Op: models.BatchUpsert, Record: &models.Record{ID: a.ID, Type: models.RecordTypeText, Payload: []byte("lost")}, ExpectedVersion: v(1)},
+		{Op: models.BatchDelete, ID: foreign.ID},
+		{Op: "rename", ID: a.ID},
+	})
+	if !errors.Is(err, repository.ErrBatchRejected) || batchStatuses(results) != "aborted:0,aborted:0,conflict:0,not_found:0,invalid:0" {
+		t.Fatalf("rejected batch: %s %v", batchStatuses(results), err)
+	}
+	if results[0].UpdatedAt != nil {
+		t.Fatalf("aborted upsert reports an update time: %+v", results[0])
+	}
+	if _, err := repo.GetRecord(ctx, u.ID, "batch-d"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("aborted upsert applied: %v", err)
+	}
+	if got, _ := repo.GetRecord(ctx, u.ID, "batch-x"); string(got.Payload) != "x2" || got.Version != 2 {
+		t.Fatalf("aborted delete applied: %+v", got)
+	}
+	if got, _ := repo.GetRecord(ctx, u.ID, a.ID); string(got.Payload) != "a2" {
+		t.Fatalf("conflicting upsert applied: %+v", got)
+	}
+	if got, err := repo.GetRecord(ctx, other.ID, foreign.ID); err != nil || got.Version != 1 {
+		t.Fatalf("foreign record touched: %+v %v", got, err)
+	}
+	if revs, _ := repo.ListRevisions(ctx, u.ID, "batch-x"); revisionVersions(revs) != "1:x1" {
+		t.Fatalf("revisions after rejected batch: %s", revisionVersions(revs))
+	}
+	if items, _ := repo.ListTrash(ctx, u.ID); trashIDs(items) != "c" {
+		t.Fatalf("trash after rejected batch: %s", trashIDs(items))
+	}
+	after, err := repo.ListChanges(ctx, u.ID, before.Cursor)
+	if err != nil || len(after.Records) != 0 || len(after.Deleted) != 0 {
+		t.Fatalf("rejected batch left changes: %+v %v", after, err)
+	}
+	if gone, err := repo.DeleteUnreferencedBlobs(ctx, time.Now().Add(time.Minute)); err != nil || strings.Join(gone, ",") != "batch-content" {
+		t.Fatalf("references of a rejected batch: %v %v", gone, err)
+	}
+}
+
+func testRefreshTokens(t *testing.T, repo service.Repository) {
+	ctx := context.Background()
+	u := newUser(t, repo, "tokens@example.com")
+	// whole seconds survive any backend's timestamp precision
+	valid := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	expired := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	if err := repo.CreateRefreshToken(ctx, u.ID, "valid", valid); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateRefreshToken(ctx, u.ID, "expired", expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateRefreshToken(ctx, u.ID, "valid", valid); err == nil {
+		t.Fatal("duplicate token must be rejected")
+	}
+	uid, exp, err := repo.GetRefreshToken(ctx, "valid")
+	if err != nil || uid != u.ID || !exp.Equal(valid) {
+		t.Fatalf("valid token: %s %v %v", uid, exp, err)
+	}
+	// expiry is enforced by the service, which needs the stored time
+	uid, exp, err = repo.GetRefreshToken(ctx, "expired")
+	if err != nil || uid != u.ID || !exp.Equal(expired) || !exp.Before(time.Now()) {
+		t.Fatalf("expired token: %s %v %v", uid, exp, err)
+	}
+	if err := repo.DeleteRefreshToken(ctx, "expired"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.GetRefreshToken(ctx, "expired"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("deleted token: want sql.ErrNoRows, got %v", err)
+	}
+	if err := repo.DeleteRefreshToken(ctx, "missing"); err != nil {
+		t.Fatalf("deleting a missing token: %v", err)
+	}
+	if _, _, err := repo.GetRefreshToken(ctx, "valid"); err != nil {
+		t.Fatalf("other token affected: %v", err)
+	}
+}
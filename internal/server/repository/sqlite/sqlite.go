@@ -5,12 +5,15 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	_ "modernc.org/sqlite"
 
 	"gophkeeper/internal/server/repository"
+	"gophkeeper/internal/server/repository/migrate"
 	"gophkeeper/internal/shared/models"
 )
 
@@ -18,17 +21,11 @@ type Repository struct {
 	db *sql.DB
 }
 
-type migration struct {
-	id   int
-	name string
-	up   string
-}
-
-var schemaMigrations = []migration{
+var schemaMigrations = []migrate.Migration{
 	{
-		id:   1,
-		name: "init",
-		up: `
+		ID:   1,
+		Name: "init",
+		Up: `
             CREATE TABLE IF NOT EXISTS users (
                 id TEXT PRIMARY KEY,
                 email TEXT UNIQUE NOT NULL,
@@ -54,54 +51,305 @@ var schemaMigrations = []migration{
             );
             CREATE INDEX IF NOT EXISTS idx_records_owner_updated ON records(owner_id, updated_at);
             CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email);
+        `,
+		Down: `
+            DROP TABLE IF EXISTS refresh_tokens;
+            DROP TABLE IF EXISTS records;
+            DROP TABLE IF EXISTS users;
+        `,
+	},
+	{
+		ID:   2,
+		Name: "change_sequence",
+		Up: `
+            ALTER TABLE records ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
+            UPDATE records SET seq = rowid;
+            CREATE TABLE IF NOT EXISTS sync_state (
+                id INTEGER PRIMARY KEY CHECK (id = 1),
+                last_seq INTEGER NOT NULL
+            );
+            INSERT INTO sync_state(id, last_seq) SELECT 1, COALESCE(MAX(seq), 0) FROM records;
+            CREATE TABLE IF NOT EXISTS record_tombstones (
+                id TEXT PRIMARY KEY,
+                owner_id TEXT NOT NULL,
+                version INTEGER NOT NULL,
+                seq INTEGER NOT NULL,
+                deleted_at TIMESTAMP NOT NULL,
+                FOREIGN KEY(owner_id) REFERENCES users(id)
+            );
+            CREATE INDEX IF NOT EXISTS idx_records_owner_seq ON records(owner_id, seq);
+            CREATE INDEX IF NOT EXISTS idx_tombstones_owner_seq ON record_tombstones(owner_id, seq);
+        `,
+		Down: `
+            DROP TABLE IF EXISTS record_tombstones;
+            DROP TABLE IF EXISTS sync_state;
+            DROP INDEX IF EXISTS idx_records_owner_seq;
+            ALTER TABLE records DROP COLUMN seq;
+        `,
+	},
+	{
+		ID:   3,
+		Name: "soft_delete",
+		Up: `
+            ALTER TABLE records ADD COLUMN deleted_at TIMESTAMP;
+            INSERT INTO records(id, owner_id, type, meta, payload, version, updated_at, seq, deleted_at)
+                SELECT id, owner_id, '', '{}', x'', version, deleted_at, seq, deleted_at FROM record_tombstones;
+            DROP TABLE record_tombstones;
+            ALTER TABLE sync_state ADD COLUMN purged_seq INTEGER NOT NULL DEFAULT 0;
+            CREATE INDEX IF NOT EXISTS idx_records_deleted ON records(deleted_at) WHERE deleted_at IS NOT NULL;
+        `,
+		Down: `
+            DROP INDEX IF EXISTS idx_records_deleted;
+            CREATE TABLE IF NOT EXISTS record_tombstones (
+                id TEXT PRIMARY KEY,
+                owner_id TEXT NOT NULL,
+                version INTEGER NOT NULL,
+                seq INTEGER NOT NULL,
+                deleted_at TIMESTAMP NOT NULL,
+                FOREIGN KEY(owner_id) REFERENCES users(id)
+            );
+            CREATE INDEX IF NOT EXISTS idx_tombstones_owner_seq ON record_tombstones(owner_id, seq);
+            INSERT INTO record_tombstones(id, owner_id, version, seq, deleted_at)
+                SELECT id, owner_id, version, seq, deleted_at FROM records WHERE deleted_at IS NOT NULL;
+            DELETE FROM records WHERE deleted_at IS NOT NULL;
+            ALTER TABLE records DROP COLUMN deleted_at;
+            ALTER TABLE sync_state DROP COLUMN purged_seq;
+        `,
+	},
+	{
+		ID:   4,
+		Name: "key_escrow",
+		Up: `
+            CREATE TABLE IF NOT EXISTS key_escrow (
+                owner_id TEXT PRIMARY KEY,
+                blob BLOB NOT NULL,
+                version INTEGER NOT NULL,
+                updated_at TIMESTAMP NOT NULL,
+                FOREIGN KEY(owner_id) REFERENCES users(id)
+            );
+        `,
+		Down: `
+            DROP TABLE IF EXISTS key_escrow;
+        `,
+	},
+	{
+		ID:   5,
+		Name: "record_content",
+		Up: `
+            ALTER TABLE records ADD COLUMN content_id TEXT NOT NULL DEFAULT '';
+            ALTER TABLE records ADD COLUMN content_size INTEGER NOT NULL DEFAULT 0;
+            CREATE TABLE IF NOT EXISTS uploads (
+                id TEXT PRIMARY KEY,
+                owner_id TEXT NOT NULL,
+                size INTEGER NOT NULL,
+                created_at TIMESTAMP NOT NULL,
+                updated_at TIMESTAMP NOT NULL,
+                FOREIGN KEY(owner_id) REFERENCES users(id)
+            );
+            CREATE INDEX IF NOT EXISTS idx_uploads_updated ON uploads(updated_at);
+        `,
+		Down: `
+            DROP TABLE IF EXISTS uploads;
+            ALTER TABLE records DROP COLUMN content_size;
+            ALTER TABLE records DROP COLUMN content_id;
+        `,
+	},
+	{
+		ID:   6,
+		Name: "blobs",
+		// Records reference blobs by content_id (streamed content) and
+		// payload_ref (a large payload moved out of the row). The triggers keep
+		// blobs.refs equal to the number of such references. Reverting drops
+		// the references, so payloads kept in the blob store are lost.
+		Up: `
+            ALTER TABLE records ADD COLUMN payload_ref TEXT NOT NULL DEFAULT '';
+            CREATE TABLE IF NOT EXISTS blobs (
+                hash TEXT PRIMARY KEY,
+                size INTEGER NOT NULL,
+                refs INTEGER NOT NULL DEFAULT 0,
+                updated_at TIMESTAMP NOT NULL
+            );
+            CREATE INDEX IF NOT EXISTS idx_blobs_unreferenced ON blobs(updated_at) WHERE refs <= 0;
+            CREATE TRIGGER IF NOT EXISTS records_blob_refs_insert AFTER INSERT ON records BEGIN
+                UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.content_id;
+                UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.payload_ref;
+            END;
+            CREATE TRIGGER IF NOT EXISTS records_blob_refs_update AFTER UPDATE OF content_id, payload_ref ON records BEGIN
+                UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.content_id;
+                UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.payload_ref;
+                UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.content_id;
+                UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.payload_ref;
+            END;
+            CREATE TRIGGER IF NOT EXISTS records_blob_refs_delete AFTER DELETE ON records BEGIN
+                UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.content_id;
+                UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.payload_ref;
+            END;
+        `,
+		Down: `
+            DROP TRIGGER IF EXISTS records_blob_refs_insert;
+            DROP TRIGGER IF EXISTS records_blob_refs_update;
+            DROP TRIGGER IF EXISTS records_blob_refs_delete;
+            DROP TABLE IF EXISTS blobs;
+            ALTER TABLE records DROP COLUMN payload_ref;
+        `,
+	},
+	{
+		ID:   7,
+		Name: "record_listing",
+		// Listings page by (updated_at, id) over live records only; the
+		// partial indexes cover both the plain and the type-filtered query.
+		Up: `
+            CREATE INDEX IF NOT EXISTS idx_records_live ON records(owner_id, updated_at, id) WHERE deleted_at IS NULL;
+            CREATE INDEX IF NOT EXISTS idx_records_live_type ON records(owner_id, type, updated_at, id) WHERE deleted_at IS NULL;
+        `,
+		Down: `
+            DROP INDEX IF EXISTS idx_records_live;
+            DROP INDEX IF EXISTS idx_records_live_type;
+        `,
+	},
+	{
+		ID:   8,
+		Name: "record_revisions",
+		// Every update keeps the replaced version of a live record. Revisions
+		// hold blob references like records do; reverting releases them
+		// before the triggers go.
+		Up: `
+            CREATE TABLE IF NOT EXISTS record_revisions (
+                record_id TEXT NOT NULL,
+                owner_id TEXT NOT NULL,
+                version INTEGER NOT NULL,
+                type TEXT NOT NULL,
+                meta BLOB NOT NULL,
+                payload BLOB NOT NULL,
+                updated_at TIMESTAMP NOT NULL,
+                content_id TEXT NOT NULL DEFAULT '',
+                content_size INTEGER NOT NULL DEFAULT 0,
+                payload_ref TEXT NOT NULL DEFAULT '',
+                PRIMARY KEY (record_id, version),
+                FOREIGN KEY(owner_id) REFERENCES users(id)
+            );
+            CREATE TRIGGER IF NOT EXISTS record_revisions_blob_refs_insert AFTER INSERT ON record_revisions BEGIN
+                UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.content_id;
+                UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.payload_ref;
+            END;
+            CREATE TRIGGER IF NOT EXISTS record_revisions_blob_refs_delete AFTER DELETE ON record_revisions BEGIN
+                UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.content_id;
+                UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.payload_ref;
+            END;
+        `,
+		Down: `
+            DELETE FROM record_revisions;
+            DROP TRIGGER IF EXISTS record_revisions_blob_refs_insert;
+            DROP TRIGGER IF EXISTS record_revisions_blob_refs_delete;
+            DROP TABLE IF EXISTS record_revisions;
+        `,
+	},
+	{
+		ID:   9,
+		Name: "trash",
+		// Deleting a record keeps its last live row here for undelete until
+		// the trash is emptied or purged. Trashed rows hold blob references,
+		// released by reverting before the triggers go.
+		Up: `
+            CREATE TABLE IF NOT EXISTS trash (
+                record_id TEXT PRIMARY KEY,
+                owner_id TEXT NOT NULL,
+                type TEXT NOT NULL,
+                meta BLOB NOT NULL,
+                payload BLOB NOT NULL,
+                version INTEGER NOT NULL,
+                updated_at TIMESTAMP NOT NULL,
+                content_id TEXT NOT NULL DEFAULT '',
+                content_size INTEGER NOT NULL DEFAULT 0,
+                payload_ref TEXT NOT NULL DEFAULT '',
+                deleted_at TIMESTAMP NOT NULL,
+                FOREIGN KEY(owner_id) REFERENCES users(id)
+            );
+            CREATE INDEX IF NOT EXISTS idx_trash_owner ON trash(owner_id, deleted_at);
+            CREATE TRIGGER IF NOT EXISTS trash_blob_refs_insert AFTER INSERT ON trash BEGIN
+                UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.content_id;
+                UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.payload_ref;
+            END;
+            CREATE TRIGGER IF NOT EXISTS trash_blob_refs_delete AFTER DELETE ON trash BEGIN
+                UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.content_id;
+                UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.payload_ref;
+            END;
+        `,
+		Down: `
+            DELETE FROM trash;
+            DROP TRIGGER IF EXISTS trash_blob_refs_insert;
+            DROP TRIGGER IF EXISTS trash_blob_refs_delete;
+            DROP TABLE IF EXISTS trash;
+        `,
+	},
+	{
+		ID:   10,
+		Name: "blob_release_time",
+		// A blob losing its last reference restarts its GC grace period;
+		// the timestamp is written in the format the driver uses for
+		// time.Time so that DeleteUnreferencedBlobs compares it correctly.
+		Up: `
+            CREATE TRIGGER IF NOT EXISTS blobs_released AFTER UPDATE OF refs ON blobs
+            WHEN NEW.refs <= 0 AND OLD.refs > 0 BEGIN
+                UPDATE blobs SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now') || ' +0000 UTC' WHERE hash = NEW.hash;
+            END;
+        `,
+		Down: `
+            DROP TRIGGER IF EXISTS blobs_released;
         `,
 	},
 }
 
-func runMigrations(ctx context.Context, db *sql.DB) error {
-	if _, err := db.ExecContext(ctx, `PRAGMA foreign_keys = ON; PRAGMA busy_timeout = 5000; PRAGMA journal_mode = WAL;`); err != nil {
+// recordColumns are the columns read by scanRecord, in order.
+const recordColumns = "id, owner_id, type, meta, payload, version, updated_at, content_id, content_size, payload_ref"
+
+// revisionColumns read a record_revisions row like recordColumns.
+const revisionColumns = "record_id, owner_id, type, meta, payload, version, updated_at, content_id, content_size, payload_ref"
+
+// snapshotRevision copies the live record selected by the extra conditions
+// into record_revisions before it is overwritten.
+const snapshotRevision = `
+	INSERT INTO record_revisions(` + revisionColumns + `)
+	SELECT ` + recordColumns + ` FROM records WHERE id = ? AND deleted_at IS NULL`
+
+// trashColumns read a trash row like recordColumns, followed by deleted_at.
+const trashColumns = revisionColumns + ", deleted_at"
+
+// trashLive hides trash entries of records that were stored again since.
+const trashLive = ` AND NOT EXISTS (SELECT 1 FROM records WHERE records.id = trash.record_id AND records.deleted_at IS NULL)`
+
+// prepareMigrations creates schema_migrations, adding the checksum column to
+// tables created before checksums were kept.
+func prepareMigrations(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (id INTEGER PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL DEFAULT '', applied_at TIMESTAMP NOT NULL)`); err != nil {
 		return err
 	}
-	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (id INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TIMESTAMP NOT NULL)`); err != nil {
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info('schema_migrations') WHERE name = 'checksum'`).Scan(&n); err != nil {
 		return err
 	}
-	applied := map[int]bool{}
-	rows, err := db.QueryContext(ctx, `SELECT id FROM schema_migrations`)
-	if err != nil {
+	if n == 0 {
+		_, err := tx.ExecContext(ctx, `ALTER TABLE schema_migrations ADD COLUMN checksum TEXT NOT NULL DEFAULT ''`)
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var id int
-		if err := rows.Scan(&id); err != nil {
-			return err
-		}
-		applied[id] = true
-	}
-	for _, m := range schemaMigrations {
-		if applied[m.id] {
-			continue
-		}
-		tx, err := db.BeginTx(ctx, nil)
-		if err != nil {
-			return err
-		}
-		if _, err := tx.ExecContext(ctx, m.up); err != nil {
-			_ = tx.Rollback()
-			return err
-		}
-		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations(id, name, applied_at) VALUES(?,?,?)`, m.id, m.name, time.Now().UTC()); err != nil {
-			_ = tx.Rollback()
-			return err
-		}
-		if err := tx.Commit(); err != nil {
-			return err
-		}
-	}
 	return nil
 }
 
+// New opens the database at dsn and applies pending migrations.
 func New(dsn string) (*Repository, error) {
+	r, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Migrator().Up(context.Background(), 0); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Open opens the database at dsn without migrating it.
+func Open(dsn string) (*Repository, error) {
 	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, err
@@ -109,13 +357,18 @@ func New(dsn string) (*Repository, error) {
 	// SQLite: keep a single writer connection to reduce lock contention
 	db.SetMaxOpenConns(1)
 	db.SetMaxIdleConns(1)
-	if err := runMigrations(context.Background(), db); err != nil {
+	if _, err := db.Exec(`PRAGMA foreign_keys = ON; PRAGMA busy_timeout = 5000; PRAGMA journal_mode = WAL;`); err != nil {
 		_ = db.Close()
 		return nil, err
 	}
 	return &Repository{db: db}, nil
 }
 
+// Migrator manages the schema of the database.
+func (r *Repository) Migrator() *migrate.Migrator {
+	return &migrate.Migrator{DB: r.db, Migrations: schemaMigrations, Prepare: prepareMigrations}
+}
+
 func (r *Repository) Close() error {
 	return r.db.Close()
 }
@@ -146,6 +399,18 @@ func (r *Repository) GetUserByEmail(ctx context.Context, email string) (id strin
 // Records
 
 func (r *Repository) UpsertRecord(ctx context.Context, rec models.Record) (models.Record, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Record{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if rec, err = upsertRecord(ctx, tx, rec); err != nil {
+		return models.Record{}, err
+	}
+	return rec, tx.Commit()
+}
+
+func upsertRecord(ctx context.Context, tx *sql.Tx, rec models.Record) (models.Record, error) {
 	if rec.ID == "" {
 		rec.ID = uuid.NewString()
 	}
@@ -156,41 +421,97 @@ func (r *Repository) UpsertRecord(ctx context.Context, rec models.Record) (model
 	}
 	rec.UpdatedAt = time.Now().UTC()
 	metaJSON, _ := json.Marshal(rec.Meta)
-	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO records(id, owner_id, type, meta, payload, version, updated_at)
-		VALUES(?,?,?,?,?,?,?)
+	seq, err := nextSeq(ctx, tx)
+	if err != nil {
+		return models.Record{}, err
+	}
+	if _, err := tx.ExecContext(ctx, snapshotRevision+` AND owner_id = ? ON CONFLICT DO NOTHING`, rec.ID, rec.OwnerID); err != nil {
+		return models.Record{}, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO records(id, owner_id, type, meta, payload, version, updated_at, seq, content_id, content_size, payload_ref)
+		VALUES(?,?,?,?,?,?,?,?,?,?,?)
 		ON CONFLICT(id) DO UPDATE SET
-			owner_id=excluded.owner_id,
 			type=excluded.type,
 			meta=excluded.meta,
 			payload=excluded.payload,
 			version=excluded.version,
-			updated_at=excluded.updated_at
-    `, rec.ID, rec.OwnerID, string(rec.Type), metaJSON, rec.Payload, rec.Version, rec.UpdatedAt)
+			updated_at=excluded.updated_at,
+			seq=excluded.seq,
+			deleted_at=NULL,
+			content_id=excluded.content_id,
+			content_size=excluded.content_size,
+			payload_ref=excluded.payload_ref
+		WHERE records.owner_id = excluded.owner_id
+    `, rec.ID, rec.OwnerID, string(rec.Type), metaJSON, rec.Payload, rec.Version, rec.UpdatedAt, seq, rec.ContentID, rec.ContentSize, rec.PayloadRef)
 	if err != nil {
 		return models.Record{}, err
 	}
+	// the ID belongs to a record of another owner
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return models.Record{}, sql.ErrNoRows
+	}
 	return rec, nil
 }
 
 func (r *Repository) UpsertRecordConditional(ctx context.Context, rec models.Record, expectedVersion int64) (models.Record, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Record{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if rec, err = upsertRecordConditional(ctx, tx, rec, expectedVersion); err != nil {
+		return models.Record{}, err
+	}
+	return rec, tx.Commit()
+}
+
+func upsertRecordConditional(ctx context.Context, tx *sql.Tx, rec models.Record, expectedVersion int64) (models.Record, error) {
 	now := time.Now().UTC()
 	metaJSON, _ := json.Marshal(rec.Meta)
 	if rec.ID == "" {
 		rec.ID = uuid.NewString()
 	}
-	// Try insert when expectedVersion == 0 and record not exists
+	seq, err := nextSeq(ctx, tx)
+	if err != nil {
+		return models.Record{}, err
+	}
+	// Insert when expectedVersion == 0 and the record does not exist or is a
+	// tombstone; a resurrected record continues its version sequence.
 	if expectedVersion == 0 {
-		rec.Version = 1
-		rec.UpdatedAt = now
-		_, err := r.db.ExecContext(ctx, `INSERT INTO records(id, owner_id, type, meta, payload, version, updated_at) VALUES(?,?,?,?,?,?,?)`, rec.ID, rec.OwnerID, string(rec.Type), metaJSON, rec.Payload, rec.Version, rec.UpdatedAt)
+		var version int64
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO records(id, owner_id, type, meta, payload, version, updated_at, seq, content_id, content_size, payload_ref) VALUES(?,?,?,?,?,?,?,?,?,?,?)
+			ON CONFLICT(id) DO UPDATE SET
+				type=excluded.type,
+				meta=excluded.meta,
+				payload=excluded.payload,
+				version=records.version+1,
+				updated_at=excluded.updated_at,
+				seq=excluded.seq,
+				deleted_at=NULL,
+				content_id=excluded.content_id,
+				content_size=excluded.content_size,
+				payload_ref=excluded.payload_ref
+			WHERE records.deleted_at IS NOT NULL AND records.owner_id = excluded.owner_id
+			RETURNING version
+		`, rec.ID, rec.OwnerID, string(rec.Type), metaJSON, rec.Payload, 1, now, seq, rec.ContentID, rec.ContentSize, rec.PayloadRef).Scan(&version)
 		if err == nil {
+			rec.Version = version
+			rec.UpdatedAt = now
 			return rec, nil
 		}
-		// If insert failed (exists), fall through to conditional update
+		if !errors.Is(err, sql.ErrNoRows) {
+			return models.Record{}, err
+		}
+		// Live record exists, fall through to conditional update
 	}
-	// Conditional update when current version matches expectedVersion
-	res, err := r.db.ExecContext(ctx, `UPDATE records SET type=?, meta=?, payload=?, version=?, updated_at=? WHERE id=? AND owner_id=? AND version=?`, string(rec.Type), metaJSON, rec.Payload, expectedVersion+1, now, rec.ID, rec.OwnerID, expectedVersion)
+	// Conditional update when current version matches expectedVersion; the
+	// snapshot is rolled back with the transaction if the update fails.
+	if _, err := tx.ExecContext(ctx, snapshotRevision+` AND owner_id = ? AND version = ? ON CONFLICT DO NOTHING`, rec.ID, rec.OwnerID, expectedVersion); err != nil {
+		return models.Record{}, err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE records SET type=?, meta=?, payload=?, version=?, updated_at=?, seq=?, content_id=?, content_size=?, payload_ref=? WHERE id=? AND owner_id=? AND version=? AND deleted_at IS NULL`, string(rec.Type), metaJSON, rec.Payload, expectedVersion+1, now, seq, rec.ContentID, rec.ContentSize, rec.PayloadRef, rec.ID, rec.OwnerID, expectedVersion)
 	if err != nil {
 		return models.Record{}, err
 	}
@@ -203,37 +524,334 @@ func (r *Repository) UpsertRecordConditional(ctx context.Context, rec models.Rec
 	return rec, nil
 }
 
-func (r *Repository) ListRecords(ctx context.Context, ownerID string) ([]models.Record, error) {
-	rows, err := r.db.QueryContext(ctx, `SELECT id, owner_id, type, meta, payload, version, updated_at FROM records WHERE owner_id = ? ORDER BY updated_at DESC`, ownerID)
+// ListRecords returns the owner's live records matching q, newest first
+// unless q.Ascending is set. Ties on updated_at are broken by id so that
+// q.After continues a listing without skipping or repeating records.
+func (r *Repository) ListRecords(ctx context.Context, ownerID string, q models.RecordQuery) ([]models.Record, error) {
+	query, args := listQuery(recordColumns, ownerID, q)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var out []models.Record
 	for rows.Next() {
-		var rec models.Record
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// ListRecordSummaries is ListRecords without the payload column, so that
+// listing records with large inline payloads stays cheap.
+func (r *Repository) ListRecordSummaries(ctx context.Context, ownerID string, q models.RecordQuery) ([]models.RecordSummary, error) {
+	query, args := listQuery("id, type, meta, version, updated_at, content_size", ownerID, q)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.RecordSummary
+	for rows.Next() {
+		var sum models.RecordSummary
 		var typ string
 		var metaBytes []byte
-		if err := rows.Scan(&rec.ID, &rec.OwnerID, &typ, &metaBytes, &rec.Payload, &rec.Version, &rec.UpdatedAt); err != nil {
+		if err := rows.Scan(&sum.ID, &typ, &metaBytes, &sum.Version, &sum.UpdatedAt, &sum.ContentSize); err != nil {
 			return nil, err
 		}
-		rec.Type = models.RecordType(typ)
+		sum.Type = models.RecordType(typ)
 		if len(metaBytes) > 0 {
-			var meta map[string]string
-			_ = json.Unmarshal(metaBytes, &meta)
-			rec.Meta = meta
+			_ = json.Unmarshal(metaBytes, &sum.Meta)
 		}
-		out = append(out, rec)
+		out = append(out, sum)
 	}
-	return out, nil
+	return out, rows.Err()
+}
+
+// listQuery builds the SELECT of columns for a listing of the owner's live
+// records matching q.
+func listQuery(columns, ownerID string, q models.RecordQuery) (string, []any) {
+	where := []string{"owner_id = ?", "deleted_at IS NULL"}
+	args := []any{ownerID}
+	if q.Type != "" {
+		where = append(where, "type = ?")
+		args = append(args, string(q.Type))
+	}
+	keys := make([]string, 0, len(q.Meta))
+	for k := range q.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		where = append(where, "json_extract(CAST(meta AS TEXT), ?) = ?")
+		args = append(args, `$."`+k+`"`, q.Meta[k])
+	}
+	if !q.UpdatedAfter.IsZero() {
+		where = append(where, "updated_at > ?")
+		args = append(args, q.UpdatedAfter.UTC())
+	}
+	order, cmp := "DESC", "<"
+	if q.Ascending {
+		order, cmp = "ASC", ">"
+	}
+	if q.After != nil {
+		at := q.After.UpdatedAt.UTC()
+		where = append(where, "(updated_at "+cmp+" ? OR (updated_at = ? AND id "+cmp+" ?))")
+		args = append(args, at, at, q.After.ID)
+	}
+	query := `SELECT ` + columns + ` FROM records WHERE ` + strings.Join(where, " AND ") + ` ORDER BY updated_at ` + order + `, id ` + order
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+	return query, args
 }
 
 func (r *Repository) GetRecord(ctx context.Context, ownerID, id string) (models.Record, error) {
-	row := r.db.QueryRowContext(ctx, `SELECT id, owner_id, type, meta, payload, version, updated_at FROM records WHERE owner_id = ? AND id = ?`, ownerID, id)
+	row := r.db.QueryRowContext(ctx, `SELECT `+recordColumns+` FROM records WHERE owner_id = ? AND id = ? AND deleted_at IS NULL`, ownerID, id)
+	return scanRecord(row)
+}
+
+// DeleteRecord moves the record to the trash and turns it into a tombstone:
+// the payload and meta are dropped, the version is bumped and the row stays
+// visible to sync until PurgeTombstones removes it.
+func (r *Repository) DeleteRecord(ctx context.Context, ownerID, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := deleteRecord(ctx, tx, ownerID, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteRecord(ctx context.Context, tx *sql.Tx, ownerID, id string) error {
+	seq, err := nextSeq(ctx, tx)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM trash WHERE record_id = ? AND owner_id = ?`, id, ownerID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO trash(`+trashColumns+`) SELECT `+recordColumns+`, ? FROM records WHERE owner_id = ? AND id = ? AND deleted_at IS NULL`, now, ownerID, id); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE records SET meta='{}', payload=x'', content_id='', content_size=0, payload_ref='', version=version+1, updated_at=?, seq=?, deleted_at=? WHERE owner_id = ? AND id = ? AND deleted_at IS NULL`, now, seq, now, ownerID, id)
+	if err != nil {
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ApplyBatch applies the owner's upserts and deletes in one transaction,
+// all or none of them; see repository.ApplyBatch.
+func (r *Repository) ApplyBatch(ctx context.Context, ownerID string, ops []models.BatchOp) ([]models.BatchResult, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	results, err := repository.ApplyBatch(ownerID, ops, func(rec models.Record, expectedVersion *int64) (models.Record, error) {
+		if expectedVersion != nil {
+			return upsertRecordConditional(ctx, tx, rec, *expectedVersion)
+		}
+		return upsertRecord(ctx, tx, rec)
+	}, func(id string) error {
+		return deleteRecord(ctx, tx, ownerID, id)
+	})
+	if err != nil {
+		return results, err
+	}
+	return results, tx.Commit()
+}
+
+// ListChanges returns records created or updated and tombstones of records
+// deleted after the given change sequence, in sequence order. When tombstones
+// newer than since were already purged, a full snapshot is returned with
+// Reset set.
+func (r *Repository) ListChanges(ctx context.Context, ownerID string, since int64) (models.Changes, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return models.Changes{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var lastSeq, purgedSeq int64
+	if err := tx.QueryRowContext(ctx, `SELECT last_seq, purged_seq FROM sync_state WHERE id = 1`).Scan(&lastSeq, &purgedSeq); err != nil {
+		return models.Changes{}, err
+	}
+	// The snapshot covers every change up to lastSeq, so it is a valid cursor
+	// even when none of the owner's rows changed.
+	changes := models.Changes{Records: []models.Record{}, Deleted: []models.Tombstone{}, Cursor: max(since, lastSeq)}
+	if since > 0 && since < purgedSeq {
+		changes.Reset = true
+		since = 0
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT `+recordColumns+`, deleted_at FROM records WHERE owner_id = ? AND seq > ? ORDER BY seq`, ownerID, since)
+	if err != nil {
+		return models.Changes{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deletedAt sql.NullTime
+		rec, err := scanRecord(rows, &deletedAt)
+		if err != nil {
+			return models.Changes{}, err
+		}
+		if deletedAt.Valid {
+			changes.Deleted = append(changes.Deleted, models.Tombstone{ID: rec.ID, Version: rec.Version, DeletedAt: deletedAt.Time})
+		} else {
+			changes.Records = append(changes.Records, rec)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return models.Changes{}, err
+	}
+	return changes, nil
+}
+
+// purgeableTombstones selects tombstones deleted before the given time. Those
+// of trashed records are kept so that undelete continues the version sequence.
+const purgeableTombstones = `deleted_at IS NOT NULL AND deleted_at < ? AND id NOT IN (SELECT record_id FROM trash)`
+
+// PurgeTombstones permanently removes tombstones deleted before the given time
+// unless their record is still in the trash.
+func (r *Repository) PurgeTombstones(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var maxSeq sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `SELECT MAX(seq) FROM records WHERE `+purgeableTombstones, before).Scan(&maxSeq); err != nil {
+		return 0, err
+	}
+	if !maxSeq.Valid {
+		return 0, nil
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM record_revisions WHERE record_id IN (SELECT id FROM records WHERE `+purgeableTombstones+`)`, before); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM records WHERE `+purgeableTombstones, before)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE sync_state SET purged_seq = MAX(purged_seq, ?) WHERE id = 1`, maxSeq.Int64); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListRevisions returns the kept earlier versions of the owner's record,
+// newest first.
+func (r *Repository) ListRevisions(ctx context.Context, ownerID, id string) ([]models.Record, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+revisionColumns+` FROM record_revisions WHERE owner_id = ? AND record_id = ? ORDER BY version DESC`, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.Record
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// GetRevision returns a kept earlier version of the owner's record or
+// sql.ErrNoRows.
+func (r *Repository) GetRevision(ctx context.Context, ownerID, id string, version int64) (models.Record, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+revisionColumns+` FROM record_revisions WHERE owner_id = ? AND record_id = ? AND version = ?`, ownerID, id, version)
+	return scanRecord(row)
+}
+
+// PruneRevisions drops all but the keep newest revisions of the record.
+func (r *Repository) PruneRevisions(ctx context.Context, ownerID, id string, keep int) error {
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM record_revisions WHERE owner_id = ? AND record_id = ? AND version NOT IN (
+			SELECT version FROM record_revisions WHERE owner_id = ? AND record_id = ? ORDER BY version DESC LIMIT ?
+		)`, ownerID, id, ownerID, id, keep)
+	return err
+}
+
+// ListTrash returns the owner's deleted records kept in the trash, most
+// recently deleted first.
+func (r *Repository) ListTrash(ctx context.Context, ownerID string) ([]models.TrashedRecord, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+trashColumns+` FROM trash WHERE owner_id = ?`+trashLive+` ORDER BY deleted_at DESC, record_id`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.TrashedRecord
+	for rows.Next() {
+		var t models.TrashedRecord
+		if t.Record, err = scanRecord(rows, &t.DeletedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetTrashed returns the owner's record kept in the trash or sql.ErrNoRows.
+func (r *Repository) GetTrashed(ctx context.Context, ownerID, id string) (models.TrashedRecord, error) {
+	var t models.TrashedRecord
+	var err error
+	row := r.db.QueryRowContext(ctx, `SELECT `+trashColumns+` FROM trash WHERE owner_id = ? AND record_id = ?`+trashLive, ownerID, id)
+	t.Record, err = scanRecord(row, &t.DeletedAt)
+	return t, err
+}
+
+// DeleteTrashed removes the record from the owner's trash.
+func (r *Repository) DeleteTrashed(ctx context.Context, ownerID, id string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM trash WHERE owner_id = ? AND record_id = ?`, ownerID, id)
+	return err
+}
+
+// EmptyTrash removes all records from the owner's trash.
+func (r *Repository) EmptyTrash(ctx context.Context, ownerID string) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM trash WHERE owner_id = ?`, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeTrash removes records deleted before the given time from all trashes.
+func (r *Repository) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM trash WHERE deleted_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRecord reads recordColumns followed by any extra destinations.
+func scanRecord(s scanner, extra ...any) (models.Record, error) {
 	var rec models.Record
 	var typ string
 	var metaBytes []byte
-	if err := row.Scan(&rec.ID, &rec.OwnerID, &typ, &metaBytes, &rec.Payload, &rec.Version, &rec.UpdatedAt); err != nil {
+	dest := append([]any{&rec.ID, &rec.OwnerID, &typ, &metaBytes, &rec.Payload, &rec.Version, &rec.UpdatedAt, &rec.ContentID, &rec.ContentSize, &rec.PayloadRef}, extra...)
+	if err := s.Scan(dest...); err != nil {
 		return models.Record{}, err
 	}
 	rec.Type = models.RecordType(typ)
@@ -245,18 +863,144 @@ func (r *Repository) GetRecord(ctx context.Context, ownerID, id string) (models.
 	return rec, nil
 }
 
-func (r *Repository) DeleteRecord(ctx context.Context, ownerID, id string) error {
-	res, err := r.db.ExecContext(ctx, `DELETE FROM records WHERE owner_id = ? AND id = ?`, ownerID, id)
+// nextSeq allocates the next value of the global change sequence.
+func nextSeq(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var seq int64
+	err := tx.QueryRowContext(ctx, `UPDATE sync_state SET last_seq = last_seq + 1 WHERE id = 1 RETURNING last_seq`).Scan(&seq)
+	return seq, err
+}
+
+// Key escrow
+
+// GetKeyEscrow returns the owner's wrapped vault key or sql.ErrNoRows.
+func (r *Repository) GetKeyEscrow(ctx context.Context, ownerID string) (models.KeyEscrow, error) {
+	var k models.KeyEscrow
+	err := r.db.QueryRowContext(ctx, `SELECT blob, version, updated_at FROM key_escrow WHERE owner_id = ?`, ownerID).Scan(&k.Blob, &k.Version, &k.UpdatedAt)
+	return k, err
+}
+
+// PutKeyEscrow stores the owner's wrapped vault key. A negative
+// expectedVersion overwrites unconditionally, zero requires that no key is
+// stored yet, otherwise the stored version must match.
+func (r *Repository) PutKeyEscrow(ctx context.Context, ownerID string, blob []byte, expectedVersion int64) (models.KeyEscrow, error) {
+	now := time.Now().UTC()
+	var version int64
+	var err error
+	switch {
+	case expectedVersion < 0:
+		err = r.db.QueryRowContext(ctx, `
+			INSERT INTO key_escrow(owner_id, blob, version, updated_at) VALUES(?,?,1,?)
+			ON CONFLICT(owner_id) DO UPDATE SET blob=excluded.blob, version=key_escrow.version+1, updated_at=excluded.updated_at
+			RETURNING version
+		`, ownerID, blob, now).Scan(&version)
+	case expectedVersion == 0:
+		err = r.db.QueryRowContext(ctx, `INSERT INTO key_escrow(owner_id, blob, version, updated_at) VALUES(?,?,1,?) ON CONFLICT(owner_id) DO NOTHING RETURNING version`, ownerID, blob, now).Scan(&version)
+	default:
+		err = r.db.QueryRowContext(ctx, `UPDATE key_escrow SET blob=?, version=version+1, updated_at=? WHERE owner_id=? AND version=? RETURNING version`, blob, now, ownerID, expectedVersion).Scan(&version)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.KeyEscrow{}, repository.ErrVersionConflict
+	}
+	if err != nil {
+		return models.KeyEscrow{}, err
+	}
+	return models.KeyEscrow{Blob: blob, Version: version, UpdatedAt: now}, nil
+}
+
+// Uploads
+
+func (r *Repository) CreateUpload(ctx context.Context, ownerID, id string) (models.Upload, error) {
+	now := time.Now().UTC()
+	_, err := r.db.ExecContext(ctx, `INSERT INTO uploads(id, owner_id, size, created_at, updated_at) VALUES(?,?,0,?,?)`, id, ownerID, now, now)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	return models.Upload{ID: id, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+func (r *Repository) GetUpload(ctx context.Context, ownerID, id string) (models.Upload, error) {
+	var up models.Upload
+	err := r.db.QueryRowContext(ctx, `SELECT id, size, created_at, updated_at FROM uploads WHERE owner_id = ? AND id = ?`, ownerID, id).Scan(&up.ID, &up.Size, &up.CreatedAt, &up.UpdatedAt)
+	return up, err
+}
+
+// SetUploadSize advances the received size of an upload from the given
+// offset; it returns repository.ErrVersionConflict if the offset is stale.
+func (r *Repository) SetUploadSize(ctx context.Context, ownerID, id string, offset, size int64) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE uploads SET size = ?, updated_at = ? WHERE owner_id = ? AND id = ? AND size = ?`, size, time.Now().UTC(), ownerID, id, offset)
 	if err != nil {
 		return err
 	}
-	affected, _ := res.RowsAffected()
-	if affected == 0 {
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return repository.ErrVersionConflict
+	}
+	return nil
+}
+
+func (r *Repository) DeleteUpload(ctx context.Context, ownerID, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM uploads WHERE owner_id = ? AND id = ?`, ownerID, id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
 		return sql.ErrNoRows
 	}
 	return nil
 }
 
+// DeleteStaleUploads removes uploads not touched since before and returns
+// their ids so that the caller can drop the received data.
+func (r *Repository) DeleteStaleUploads(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `DELETE FROM uploads WHERE updated_at < ? RETURNING id`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Blobs
+
+// RegisterBlob records that a blob is about to be stored, or refreshes its
+// timestamp when it exists, so that DeleteUnreferencedBlobs leaves it alone
+// until a record references it.
+func (r *Repository) RegisterBlob(ctx context.Context, hash string, size int64) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO blobs(hash, size, refs, updated_at) VALUES(?,?,0,?)
+		ON CONFLICT(hash) DO UPDATE SET updated_at=excluded.updated_at
+	`, hash, size, time.Now().UTC())
+	return err
+}
+
+// DeleteUnreferencedBlobs removes blobs that no record references and that
+// were registered or lost their last reference before the given time, and
+// returns their hashes so that
+// the caller can delete the stored data.
+func (r *Repository) DeleteUnreferencedBlobs(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `DELETE FROM blobs WHERE refs <= 0 AND updated_at < ? RETURNING hash`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
 // Refresh tokens
 
 func (r *Repository) CreateRefreshToken(ctx context.Context, userID, token string, expiresAt time.Time) error {
@@ -0,0 +1,112 @@
+package sqlite
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/shared/models"
+)
+
+func blobRefs(t *testing.T, repo *Repository, hash string) int64 {
+	t.Helper()
+	var refs int64
+	if err := repo.db.QueryRow(`SELECT refs FROM blobs WHERE hash = ?`, hash).Scan(&refs); err != nil {
+		t.Fatalf("refs of %s: %v", hash, err)
+	}
+	return refs
+}
+
+func TestBlobs_RefcountsFollowRecords(t *testing.T) {
+	repo, err := New("file:repo_blobs?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	ctx := context.Background()
+	u, _ := repo.CreateUser(ctx, "blobs@example.com", []byte("h"))
+	for _, h := range []string{"content1", "content2", "payload1"} {
+		if err := repo.RegisterBlob(ctx, h, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte{}, ContentID: "content1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := repo.UpsertRecordConditional(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte{}, ContentID: "content1", PayloadRef: "payload1"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := blobRefs(t, repo, "content1"); got != 2 {
+		t.Fatalf("content1 refs after two records: %d", got)
+	}
+	got, err := repo.GetRecord(ctx, u.ID, b.ID)
+	if err != nil || got.PayloadRef != "payload1" {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+
+	// replacing the content moves the reference
+	a.ContentID = "content2"
+	if _, err := repo.UpsertRecord(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	b.ContentID = ""
+	if _, err := repo.UpsertRecordConditional(ctx, b, b.Version); err != nil {
+		t.Fatal(err)
+	}
+	// the replaced versions are kept as revisions with their references
+	if got := blobRefs(t, repo, "content1"); got != 2 {
+		t.Fatalf("content1 refs held by revisions: %d", got)
+	}
+	for _, id := range []string{a.ID, b.ID} {
+		if err := repo.PruneRevisions(ctx, u.ID, id, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if blobRefs(t, repo, "content1") != 0 || blobRefs(t, repo, "content2") != 1 || blobRefs(t, repo, "payload1") != 1 {
+		t.Fatal("references not moved on update")
+	}
+
+	if err := repo.DeleteRecord(ctx, u.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if blobRefs(t, repo, "payload1") != 1 {
+		t.Fatal("the trash must take over the blobs of a deleted record")
+	}
+	if err := repo.DeleteTrashed(ctx, u.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if blobRefs(t, repo, "payload1") != 0 {
+		t.Fatal("removing the trashed record must release its blobs")
+	}
+
+	// only unreferenced blobs registered before the cutoff are collected
+	if hashes, err := repo.DeleteUnreferencedBlobs(ctx, time.Now().UTC().Add(-time.Hour)); err != nil || len(hashes) != 0 {
+		t.Fatalf("grace period ignored: %v %v", hashes, err)
+	}
+	hashes, err := repo.DeleteUnreferencedBlobs(ctx, time.Now().UTC().Add(time.Minute))
+	sort.Strings(hashes)
+	if err != nil || len(hashes) != 2 || hashes[0] != "content1" || hashes[1] != "payload1" {
+		t.Fatalf("collected: %v %v", hashes, err)
+	}
+	if blobRefs(t, repo, "content2") != 1 {
+		t.Fatal("referenced blob must survive")
+	}
+
+	// purging the tombstone must not drive counts negative for live blobs
+	if err := repo.DeleteRecord(ctx, u.ID, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.PurgeTombstones(ctx, time.Now().UTC().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.PurgeTrash(ctx, time.Now().UTC().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if blobRefs(t, repo, "content2") != 0 {
+		t.Fatal("content2 must be unreferenced after delete and purge")
+	}
+}
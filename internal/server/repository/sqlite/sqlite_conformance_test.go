@@ -0,0 +1,21 @@
+package sqlite
+
+import (
+	"strings"
+	"testing"
+
+	"gophkeeper/internal/server/repository/repotest"
+	"gophkeeper/internal/server/service"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) service.Repository {
+		name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+		repo, err := New("file:" + name + "?mode=memory&cache=shared")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = repo.Close() })
+		return repo
+	})
+}
@@ -0,0 +1,50 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"gophkeeper/internal/server/repository"
+)
+
+func TestKeyEscrow_PutGetVersions(t *testing.T) {
+	repo, err := New("file:repo_key_escrow?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	ctx := context.Background()
+	u, _ := repo.CreateUser(ctx, "keys@example.com", []byte("h"))
+	other, _ := repo.CreateUser(ctx, "keys-other@example.com", []byte("h"))
+
+	if _, err := repo.GetKeyEscrow(ctx, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected no rows, got %v", err)
+	}
+	k, err := repo.PutKeyEscrow(ctx, u.ID, []byte("v1"), 0)
+	if err != nil || k.Version != 1 {
+		t.Fatalf("create: %+v %v", k, err)
+	}
+	if _, err := repo.PutKeyEscrow(ctx, u.ID, []byte("again"), 0); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("expected conflict on second create, got %v", err)
+	}
+	if _, err := repo.PutKeyEscrow(ctx, u.ID, []byte("stale"), 5); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("expected conflict on stale version, got %v", err)
+	}
+	k, err = repo.PutKeyEscrow(ctx, u.ID, []byte("v2"), 1)
+	if err != nil || k.Version != 2 {
+		t.Fatalf("update: %+v %v", k, err)
+	}
+	k, err = repo.PutKeyEscrow(ctx, u.ID, []byte("v3"), -1)
+	if err != nil || k.Version != 3 {
+		t.Fatalf("overwrite: %+v %v", k, err)
+	}
+	got, err := repo.GetKeyEscrow(ctx, u.ID)
+	if err != nil || string(got.Blob) != "v3" || got.Version != 3 {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+	if _, err := repo.GetKeyEscrow(ctx, other.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("other owner must not see the key, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/shared/models"
+)
+
+func TestListRecords_Query(t *testing.T) {
+	repo, err := New("file:repo_list_query?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	ctx := context.Background()
+	u, _ := repo.CreateUser(ctx, "q@example.com", []byte("h"))
+	other, _ := repo.CreateUser(ctx, "o@example.com", []byte("h"))
+	var saved []models.Record
+	for i, site := range []string{"a.example", "b.example", "a.example", "c.example", "a.example"} {
+		typ := models.RecordTypeLogin
+		if i%2 == 1 {
+			typ = models.RecordTypeText
+		}
+		rec, err := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: typ, Meta: map[string]string{"site": site}, Payload: []byte("x")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, rec)
+	}
+	if _, err := repo.UpsertRecord(ctx, models.Record{OwnerID: other.ID, Type: models.RecordTypeLogin, Meta: map[string]string{"site": "a.example"}, Payload: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, saved[4].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := repo.ListRecords(ctx, u.ID, models.RecordQuery{Type: models.RecordTypeLogin, Meta: map[string]string{"site": "a.example"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != saved[2].ID || list[1].ID != saved[0].ID {
+		t.Fatalf("filtered list: %+v", list)
+	}
+	list, _ = repo.ListRecords(ctx, u.ID, models.RecordQuery{UpdatedAfter: saved[1].UpdatedAt})
+	if len(list) != 2 {
+		t.Fatalf("updated_after must keep 2 live records, got %d", len(list))
+	}
+
+	// page through all live records in both orders
+	for _, asc := range []bool{false, true} {
+		var seen []string
+		q := models.RecordQuery{Ascending: asc, Limit: 2}
+		for {
+			page, err := repo.ListRecords(ctx, u.ID, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range page {
+				seen = append(seen, rec.ID)
+			}
+			if len(page) < q.Limit {
+				break
+			}
+			last := page[len(page)-1]
+			q.After = &models.RecordKey{UpdatedAt: last.UpdatedAt, ID: last.ID}
+		}
+		want := []string{saved[3].ID, saved[2].ID, saved[1].ID, saved[0].ID}
+		if asc {
+			want = []string{saved[0].ID, saved[1].ID, saved[2].ID, saved[3].ID}
+		}
+		if strings.Join(seen, ",") != strings.Join(want, ",") {
+			t.Fatalf("asc=%v pages: %v, want %v", asc, seen, want)
+		}
+	}
+}
+
+func TestListRecords_UsesIndex(t *testing.T) {
+	repo, err := New("file:repo_list_plan?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, query := range []struct {
+		sql  string
+		args []any
+	}{
+		{`SELECT id FROM records WHERE owner_id = ? AND deleted_at IS NULL AND (updated_at < ? OR (updated_at = ? AND id < ?)) ORDER BY updated_at DESC, id DESC LIMIT 10`, []any{"u", now, now, "x"}},
+		{`SELECT id FROM records WHERE owner_id = ? AND deleted_at IS NULL AND type = ? ORDER BY updated_at DESC, id DESC LIMIT 10`, []any{"u", "login"}},
+	} {
+		rows, err := repo.db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query.sql, query.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plan []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatal(err)
+			}
+			plan = append(plan, detail)
+		}
+		rows.Close()
+		joined := strings.Join(plan, "; ")
+		if !strings.Contains(joined, "USING INDEX idx_records_live") || strings.Contains(joined, "TEMP B-TREE") {
+			t.Fatalf("unexpected plan for %q: %s", query.sql, joined)
+		}
+	}
+}
+
+func TestListRecordSummaries(t *testing.T) {
+	repo, err := New("file:repo_list_summaries?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	ctx := context.Background()
+	u, _ := repo.CreateUser(ctx, "s@example.com", []byte("h"))
+	rec, err := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Meta: map[string]string{"name": "a.bin"}, Payload: make([]byte, 1<<16)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := repo.ListRecordSummaries(ctx, u.ID, models.RecordQuery{Type: models.RecordTypeBinary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.RecordSummary{ID: rec.ID, Type: models.RecordTypeBinary, Meta: map[string]string{"name": "a.bin"}, Version: rec.Version}
+	if len(list) != 1 || list[0].ID != want.ID || list[0].Version != want.Version || list[0].Meta["name"] != "a.bin" || !list[0].UpdatedAt.Equal(rec.UpdatedAt) {
+		t.Fatalf("summaries: %+v, want %+v", list, want)
+	}
+}
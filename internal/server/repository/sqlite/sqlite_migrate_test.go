@@ -0,0 +1,163 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"gophkeeper/internal/shared/models"
+)
+
+func TestMigrations_DownAndUpAgain(t *testing.T) {
+	ctx := context.Background()
+	repo, err := New("file:repo_migrate_down?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	u, _ := repo.CreateUser(ctx, "m@example.com", []byte("h"))
+	kept, _ := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("x")})
+	gone, _ := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("y")})
+	if err := repo.DeleteRecord(ctx, u.ID, gone.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	m := repo.Migrator()
+	// back to the schema before soft deletes: tombstones move to their table
+	back := len(schemaMigrations) - 2
+	if reverted, err := m.Down(ctx, back); err != nil || len(reverted) != back {
+		t.Fatalf("down: %v %v", reverted, err)
+	}
+	var n int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM record_tombstones WHERE id = ?`, gone.ID).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("tombstone not restored: %d %v", n, err)
+	}
+	if _, err := m.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.GetRecord(ctx, u.ID, kept.ID); err != nil || string(got.Payload) != "x" {
+		t.Fatalf("record lost: %+v %v", got, err)
+	}
+	changes, err := repo.ListChanges(ctx, u.ID, 0)
+	if err != nil || len(changes.Deleted) != 1 || changes.Deleted[0].ID != gone.ID {
+		t.Fatalf("tombstone lost: %+v %v", changes, err)
+	}
+
+	if reverted, err := m.Down(ctx, len(schemaMigrations)); err != nil || len(reverted) != len(schemaMigrations) {
+		t.Fatalf("down to empty: %v %v", reverted, err)
+	}
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name IN ('users', 'records', 'refresh_tokens')`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("tables left: %d %v", n, err)
+	}
+}
+
+func TestMigrations_DownReleasesBlobRefs(t *testing.T) {
+	ctx := context.Background()
+	repo, err := New("file:repo_migrate_blobs?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	u, _ := repo.CreateUser(ctx, "b@example.com", []byte("h"))
+	for _, h := range []string{"old", "new", "trashed"} {
+		if err := repo.RegisterBlob(ctx, h, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec, err := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte{}, ContentID: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.ContentID = "new"
+	if _, err := repo.UpsertRecord(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+	gone, _ := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte{}, ContentID: "trashed"})
+	if err := repo.DeleteRecord(ctx, u.ID, gone.ID); err != nil {
+		t.Fatal(err)
+	}
+	refs := func() map[string]int {
+		out := map[string]int{}
+		rows, err := repo.db.Query(`SELECT hash, refs FROM blobs`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var h string
+			var n int
+			_ = rows.Scan(&h, &n)
+			out[h] = n
+		}
+		return out
+	}
+	if got := refs(); got["old"] != 1 || got["new"] != 1 || got["trashed"] != 1 {
+		t.Fatalf("refs before down: %v", got)
+	}
+
+	// revert blob_release_time, trash and record_revisions
+	if _, err := repo.Migrator().Down(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := refs(); got["old"] != 0 || got["new"] != 1 || got["trashed"] != 0 {
+		t.Fatalf("refs after down: %v", got)
+	}
+}
+
+func TestMigrations_LegacyTableWithoutChecksums(t *testing.T) {
+	dsn := "file:repo_migrate_legacy?mode=memory&cache=shared"
+	repo, err := Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	// schema_migrations as written before checksums were kept
+	if _, err := repo.db.Exec(`CREATE TABLE schema_migrations (id INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TIMESTAMP NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.db.Exec(schemaMigrations[0].Up); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.db.Exec(`INSERT INTO schema_migrations(id, name, applied_at) VALUES(1, 'init', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+	repo2, err := New(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo2.Close() })
+	states, err := repo2.Migrator().Status(context.Background())
+	if err != nil || len(states) != len(schemaMigrations) {
+		t.Fatalf("status: %+v %v", states, err)
+	}
+	for _, s := range states {
+		if !s.Applied || s.Modified {
+			t.Fatalf("state: %+v", s)
+		}
+	}
+}
+
+// TestMigrations_ChecksumsPinned fails when a released migration is edited,
+// which would make every database that applied it refuse to start. Add a new
+// migration instead.
+func TestMigrations_ChecksumsPinned(t *testing.T) {
+	released := map[int]string{
+		1:  "1622f20f26c8fba08b4405840b66272c1b7e05081a9b402a341fafacda1d90fc",
+		2:  "65965744f455c41a43a00b4c14ecc6704e0ffcb3b459850daa35f6dfdc79cbf5",
+		3:  "eefe1e7bceb2a937acd1a77bf004c9bfadfdb8d1b5a2084c4591d3f395d9e95f",
+		4:  "8ee1f2e452e77168bb51d52db7619526fdb20d8aa3dac221edc5dd80ffd07126",
+		5:  "0662f189e853693c76260e7b46be9c80a48abdd71f250d76acdcd08dc3f4f585",
+		6:  "8678edad23f18b5a0fb5cc883bb6c128bd2a603d010727400d9240cfd5c2d273",
+		7:  "d04002150dbbff4b2defdef457ae0d1996289c0a91fdaa7ce864c71435035953",
+		8:  "3e41c503df140e343e609b18f7f94068111bd827a6c964fd02cd7a827cf57546",
+		9:  "551596e1eb3ffed0258146bb6db1cd2cdb8526baa07f4e433c0af8d70b06a98f",
+		10: "68cbe8bd79e9ba70aca3b9a926eb4738f07e19dcb19f123f76422ef88a88d556",
+	}
+	for _, m := range schemaMigrations {
+		if want, ok := released[m.ID]; ok && m.Checksum() != want {
+			t.Errorf("migration %d_%s was modified", m.ID, m.Name)
+		}
+	}
+}
@@ -34,7 +34,7 @@ func TestListRecords_MetaDecode(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	list, err := repo.ListRecords(ctx, u.ID)
+	list, err := repo.ListRecords(ctx, u.ID, models.RecordQuery{})
 	if err != nil || len(list) != 1 {
 		t.Fatalf("list err: %v", err)
 	}
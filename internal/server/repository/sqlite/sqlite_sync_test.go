@@ -0,0 +1,138 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/shared/models"
+)
+
+func TestListChanges_CursorAndTombstones(t *testing.T) {
+	repo, err := New("file:repo_list_changes?mode=memory&cache=shared&_journal=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	ctx := context.Background()
+	u, _ := repo.CreateUser(ctx, "sync@example.com", []byte("h"))
+	other, _ := repo.CreateUser(ctx, "other@example.com", []byte("h"))
+
+	a, err := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.UpsertRecord(ctx, models.Record{OwnerID: other.ID, Type: models.RecordTypeText, Payload: []byte("c")}); err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := repo.ListChanges(ctx, u.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(full.Records) != 2 || len(full.Deleted) != 0 || full.Cursor == 0 {
+		t.Fatalf("unexpected full sync: %+v", full)
+	}
+
+	// nothing changed -> empty delta, cursor unchanged
+	empty, err := repo.ListChanges(ctx, u.ID, full.Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(empty.Records) != 0 || len(empty.Deleted) != 0 || empty.Cursor != full.Cursor {
+		t.Fatalf("unexpected empty delta: %+v", empty)
+	}
+
+	// update one, delete another
+	if _, err := repo.UpsertRecordConditional(ctx, models.Record{ID: a.ID, OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("a2")}, a.Version); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	delta, err := repo.ListChanges(ctx, u.ID, full.Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Records) != 1 || delta.Records[0].ID != a.ID || string(delta.Records[0].Payload) != "a2" {
+		t.Fatalf("want updated record in delta: %+v", delta.Records)
+	}
+	if len(delta.Deleted) != 1 || delta.Deleted[0].ID != b.ID || delta.Deleted[0].Version != b.Version+1 {
+		t.Fatalf("want tombstone in delta: %+v", delta.Deleted)
+	}
+	if delta.Cursor <= full.Cursor {
+		t.Fatalf("cursor must advance: %d -> %d", full.Cursor, delta.Cursor)
+	}
+
+	// re-creating a deleted id drops its tombstone
+	if _, err := repo.UpsertRecordConditional(ctx, models.Record{ID: b.ID, OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("b2")}, 0); err != nil {
+		t.Fatal(err)
+	}
+	again, err := repo.ListChanges(ctx, u.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Records) != 2 || len(again.Deleted) != 0 {
+		t.Fatalf("unexpected sync after re-create: %+v", again)
+	}
+}
+
+func TestDeleteRecord_TombstoneAndPurge(t *testing.T) {
+	repo, err := New("file:repo_tombstones?mode=memory&cache=shared&_journal=WAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	ctx := context.Background()
+	u, _ := repo.CreateUser(ctx, "tomb@example.com", []byte("h"))
+	rec, err := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Meta: map[string]string{"title": "t"}, Payload: []byte("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := repo.ListChanges(ctx, u.ID, 0)
+	if err := repo.DeleteRecord(ctx, u.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, rec.ID); err == nil {
+		t.Fatalf("second delete of a tombstone must fail")
+	}
+	if _, err := repo.GetRecord(ctx, u.ID, rec.ID); err == nil {
+		t.Fatalf("tombstone must be hidden from GetRecord")
+	}
+	if list, _ := repo.ListRecords(ctx, u.ID, models.RecordQuery{}); len(list) != 0 {
+		t.Fatalf("tombstone must be hidden from ListRecords: %+v", list)
+	}
+	// conditional update of a tombstone conflicts
+	if _, err := repo.UpsertRecordConditional(ctx, models.Record{ID: rec.ID, OwnerID: u.ID, Type: models.RecordTypeText}, rec.Version+1); err == nil {
+		t.Fatalf("want conflict on updating a tombstone")
+	}
+
+	// purge cutoff in the past keeps the tombstone
+	if n, err := repo.PurgeTombstones(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("purge old: %d %v", n, err)
+	}
+	if _, err := repo.EmptyTrash(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := repo.PurgeTombstones(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Fatalf("purge: %d %v", n, err)
+	}
+	// a consumer that missed the purged tombstone must resync from scratch
+	changes, err := repo.ListChanges(ctx, u.ID, before.Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changes.Reset || len(changes.Records) != 0 || len(changes.Deleted) != 0 {
+		t.Fatalf("want reset with empty snapshot: %+v", changes)
+	}
+	if changes.Cursor < before.Cursor {
+		t.Fatalf("cursor must not go back after reset: %d -> %d", before.Cursor, changes.Cursor)
+	}
+	if changes, _ := repo.ListChanges(ctx, u.ID, changes.Cursor); changes.Reset {
+		t.Fatalf("reset must not repeat after catching up: %+v", changes)
+	}
+}
@@ -33,7 +33,7 @@ func TestUsersAndRecords(t *testing.T) {
 	if rec.ID == "" || rec.Version == 0 {
 		t.Fatalf("bad rec: %+v", rec)
 	}
-	list, err := repo.ListRecords(ctx, user.ID)
+	list, err := repo.ListRecords(ctx, user.ID, models.RecordQuery{})
 	if err != nil || len(list) != 1 {
 		t.Fatalf("list: %v %d", err, len(list))
 	}
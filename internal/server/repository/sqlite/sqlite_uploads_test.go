@@ -0,0 +1,74 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/server/repository"
+	"gophkeeper/internal/shared/models"
+)
+
+func TestUploads_SizeAndStalePurge(t *testing.T) {
+	repo, err := New("file:repo_uploads?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	ctx := context.Background()
+	u, _ := repo.CreateUser(ctx, "uploads@example.com", []byte("h"))
+	other, _ := repo.CreateUser(ctx, "uploads-other@example.com", []byte("h"))
+
+	if _, err := repo.CreateUpload(ctx, u.ID, "up1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetUploadSize(ctx, u.ID, "up1", 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetUploadSize(ctx, u.ID, "up1", 0, 20); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("expected conflict on stale offset, got %v", err)
+	}
+	up, err := repo.GetUpload(ctx, u.ID, "up1")
+	if err != nil || up.Size != 10 {
+		t.Fatalf("get: %+v %v", up, err)
+	}
+	if _, err := repo.GetUpload(ctx, other.ID, "up1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("other owner must not see the upload, got %v", err)
+	}
+
+	ids, err := repo.DeleteStaleUploads(ctx, time.Now().UTC().Add(time.Minute))
+	if err != nil || len(ids) != 1 || ids[0] != "up1" {
+		t.Fatalf("purge: %v %v", ids, err)
+	}
+	if err := repo.DeleteUpload(ctx, u.ID, "up1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected no rows after purge, got %v", err)
+	}
+}
+
+func TestRecords_ContentColumns(t *testing.T) {
+	repo, err := New("file:repo_content?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = repo.Close() })
+	ctx := context.Background()
+	u, _ := repo.CreateUser(ctx, "content@example.com", []byte("h"))
+
+	rec, err := repo.UpsertRecord(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeBinary, Payload: []byte("p"), ContentID: "c1", ContentSize: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetRecord(ctx, u.ID, rec.ID)
+	if err != nil || got.ContentID != "c1" || got.ContentSize != 42 {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+	if err := repo.DeleteRecord(ctx, u.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	var contentID string
+	if err := repo.db.QueryRowContext(ctx, `SELECT content_id FROM records WHERE id = ?`, rec.ID).Scan(&contentID); err != nil || contentID != "" {
+		t.Fatalf("tombstone must not reference content: %q %v", contentID, err)
+	}
+}
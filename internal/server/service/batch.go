@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gophkeeper/internal/shared/models"
+)
+
+// MaxBatchOps limits the number of operations in one batch.
+const MaxBatchOps = 1000
+
+// ErrBatchInvalid is returned for an empty or oversized batch, and with the
+// results of a batch containing operations that failed validation. Nothing
+// was applied.
+var ErrBatchInvalid = errors.New("invalid batch operations")
+
+// Batch validates the owner's upserts and deletes like Upsert and Delete do
+// and applies them in order, all or none. Besides the results it returns
+// ErrBatchInvalid when an operation is malformed and
+// repository.ErrBatchRejected when one conflicts or misses its record.
+func (s *RecordsService) Batch(ctx context.Context, ownerID string, ops []models.BatchOp) ([]models.BatchResult, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("%w: no operations", ErrBatchInvalid)
+	}
+	if len(ops) > MaxBatchOps {
+		return nil, fmt.Errorf("%w: at most %d operations allowed", ErrBatchInvalid, MaxBatchOps)
+	}
+	checked := make([]models.BatchOp, len(ops))
+	results := make([]models.BatchResult, len(ops))
+	invalid := false
+	for i, op := range ops {
+		results[i] = models.BatchResult{ID: op.ID, Status: models.BatchAborted}
+		var err error
+		switch op.Op {
+		case models.BatchUpsert:
+			if op.Record == nil {
+				err = errors.New("record required")
+				break
+			}
+			rec := *op.Record
+			rec.OwnerID = ownerID
+			results[i].ID = rec.ID
+			if err = s.check(ctx, &rec); err == nil {
+				_, err = s.prepare(ctx, &rec)
+			}
+			op.Record = &rec
+		case models.BatchDelete:
+			if op.ID == "" {
+				err = errors.New("id required")
+			}
+		default:
+			err = fmt.Errorf("unknown operation %q", op.Op)
+		}
+		if err != nil {
+			results[i].Status, results[i].Error = models.BatchInvalid, err.Error()
+			invalid = true
+		}
+		checked[i] = op
+	}
+	if invalid {
+		return results, ErrBatchInvalid
+	}
+
+	results, err := s.repo.ApplyBatch(ctx, ownerID, checked)
+	if err != nil {
+		return results, err
+	}
+	for i, res := range results {
+		if ops[i].Op == models.BatchUpsert {
+			s.prune(ctx, ownerID, res.ID)
+		}
+	}
+	return results, nil
+}
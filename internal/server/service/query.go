@@ -0,0 +1,80 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gophkeeper/internal/shared/models"
+)
+
+// MaxPageSize caps the limit of a record listing.
+const MaxPageSize = 1000
+
+// ErrInvalidQuery is returned for malformed listing parameters and cursors.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// pageCursor is the decoded form of the opaque cursor handed to clients.
+type pageCursor struct {
+	models.RecordKey
+	Ascending bool `json:"asc,omitempty"`
+}
+
+func encodeCursor(key models.RecordKey, ascending bool) string {
+	b, _ := json.Marshal(pageCursor{RecordKey: key, Ascending: ascending})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err != nil || c.ID == "" {
+		return pageCursor{}, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	return c, nil
+}
+
+// validateQuery rejects parameters the repositories cannot express safely.
+func validateQuery(q models.RecordQuery) error {
+	if q.Limit < 0 {
+		return fmt.Errorf("%w: negative limit", ErrInvalidQuery)
+	}
+	for k := range q.Meta {
+		if k == "" || strings.ContainsAny(k, "\"\\") {
+			return fmt.Errorf("%w: meta key %q", ErrInvalidQuery, k)
+		}
+	}
+	return nil
+}
+
+// pageQuery validates q and applies cursor and the page size cap to it. The
+// returned query asks the repository for one record more than the page size
+// so that the caller can tell whether another page follows.
+func pageQuery(q models.RecordQuery, cursor string) (models.RecordQuery, int, error) {
+	if err := validateQuery(q); err != nil {
+		return q, 0, err
+	}
+	if cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil {
+			return q, 0, err
+		}
+		if c.Ascending != q.Ascending {
+			return q, 0, fmt.Errorf("%w: cursor belongs to a different sort order", ErrInvalidQuery)
+		}
+		q.After = &c.RecordKey
+	}
+	limit := q.Limit
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	if limit > 0 {
+		q.Limit = limit + 1
+	}
+	return q, limit, nil
+}
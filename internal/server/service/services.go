@@ -1,12 +1,19 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 
+	"gophkeeper/internal/server/blobstore"
 	"gophkeeper/internal/server/config"
 	"gophkeeper/internal/shared/models"
 	"gophkeeper/internal/shared/passhash"
@@ -18,9 +25,33 @@ type Repository interface {
 
 	UpsertRecord(ctx context.Context, rec models.Record) (models.Record, error)
 	UpsertRecordConditional(ctx context.Context, rec models.Record, expectedVersion int64) (models.Record, error)
-	ListRecords(ctx context.Context, ownerID string) ([]models.Record, error)
+	ListRecords(ctx context.Context, ownerID string, q models.RecordQuery) ([]models.Record, error)
+	ListRecordSummaries(ctx context.Context, ownerID string, q models.RecordQuery) ([]models.RecordSummary, error)
 	GetRecord(ctx context.Context, ownerID, id string) (models.Record, error)
 	DeleteRecord(ctx context.Context, ownerID, id string) error
+	ApplyBatch(ctx context.Context, ownerID string, ops []models.BatchOp) ([]models.BatchResult, error)
+	ListChanges(ctx context.Context, ownerID string, since int64) (models.Changes, error)
+	PurgeTombstones(ctx context.Context, before time.Time) (int64, error)
+	ListRevisions(ctx context.Context, ownerID, id string) ([]models.Record, error)
+	GetRevision(ctx context.Context, ownerID, id string, version int64) (models.Record, error)
+	PruneRevisions(ctx context.Context, ownerID, id string, keep int) error
+	ListTrash(ctx context.Context, ownerID string) ([]models.TrashedRecord, error)
+	GetTrashed(ctx context.Context, ownerID, id string) (models.TrashedRecord, error)
+	DeleteTrashed(ctx context.Context, ownerID, id string) error
+	EmptyTrash(ctx context.Context, ownerID string) (int64, error)
+	PurgeTrash(ctx context.Context, before time.Time) (int64, error)
+
+	GetKeyEscrow(ctx context.Context, ownerID string) (models.KeyEscrow, error)
+	PutKeyEscrow(ctx context.Context, ownerID string, blob []byte, expectedVersion int64) (models.KeyEscrow, error)
+
+	RegisterBlob(ctx context.Context, hash string, size int64) error
+	DeleteUnreferencedBlobs(ctx context.Context, before time.Time) ([]string, error)
+
+	CreateUpload(ctx context.Context, ownerID, id string) (models.Upload, error)
+	GetUpload(ctx context.Context, ownerID, id string) (models.Upload, error)
+	SetUploadSize(ctx context.Context, ownerID, id string, offset, size int64) error
+	DeleteUpload(ctx context.Context, ownerID, id string) error
+	DeleteStaleUploads(ctx context.Context, before time.Time) ([]string, error)
 
 	CreateRefreshToken(ctx context.Context, userID, token string, expiresAt time.Time) error
 	GetRefreshToken(ctx context.Context, token string) (userID string, expiresAt time.Time, err error)
@@ -30,12 +61,30 @@ type Repository interface {
 type Services struct {
 	Auth    *AuthService
 	Records *RecordsService
+	Keys    *KeysService
+	Uploads *UploadsService
 }
 
 func NewServices(repo Repository, cfg config.Config) *Services {
+	records := &RecordsService{
+		repo:               repo,
+		maxPayloadBytes:    cfg.MaxRecordPayloadBytes,
+		tombstoneRetention: cfg.TombstoneRetention,
+		revisions:          int(cfg.RecordRevisions),
+		trashRetention:     cfg.TrashRetention,
+		blobs:              newBlobStore(cfg),
+		inlineMax:          cfg.BlobInlineMax,
+		blobGrace:          cfg.BlobGCGrace,
+	}
+	var staging string
+	if cfg.DataDir != "" {
+		staging = filepath.Join(cfg.DataDir, "uploads")
+	}
 	return &Services{
 		Auth:    &AuthService{repo: repo, jwtSecret: []byte(cfg.JWTSecret)},
-		Records: &RecordsService{repo: repo, maxPayloadBytes: cfg.MaxRecordPayloadBytes},
+		Records: records,
+		Keys:    &KeysService{repo: repo},
+		Uploads: &UploadsService{repo: repo, records: records, staging: staging, maxBytes: cfg.MaxUploadBytes, retention: cfg.UploadRetention},
 	}
 }
 
@@ -127,51 +176,399 @@ func (a *AuthService) Refresh(ctx context.Context, refreshToken string) (string,
 
 // RecordsService stores opaque, client-encrypted payloads with optional
 // optimistic concurrency control based on monotonically increasing version.
+// Payloads over inlineMax and uploaded content live in the blob store.
 type RecordsService struct {
-	repo            Repository
-	maxPayloadBytes int64
+	repo               Repository
+	maxPayloadBytes    int64
+	tombstoneRetention time.Duration
+	revisions          int
+	trashRetention     time.Duration
+	blobs              blobstore.Store
+	inlineMax          int64
+	blobGrace          time.Duration
 }
 
 func (s *RecordsService) Upsert(ctx context.Context, rec models.Record) (models.Record, error) {
-	if rec.OwnerID == "" {
-		return models.Record{}, errors.New("owner_id required")
+	return s.save(ctx, rec, nil)
+}
+
+func (s *RecordsService) UpsertConditional(ctx context.Context, rec models.Record, expectedVersion int64) (models.Record, error) {
+	return s.save(ctx, rec, &expectedVersion)
+}
+
+// save stores a client-supplied record.
+func (s *RecordsService) save(ctx context.Context, rec models.Record, expectedVersion *int64) (models.Record, error) {
+	if err := s.check(ctx, &rec); err != nil {
+		return models.Record{}, err
 	}
-	if rec.Type == "" {
-		return models.Record{}, errors.New("type required")
+	return s.upsert(ctx, rec, expectedVersion)
+}
+
+// check validates a client-supplied record against the schema of its type
+// and clears the fields the server maintains. Content can only be attached by
+// completing an upload, so a content_id is kept only if it already is the
+// record's content.
+func (s *RecordsService) check(ctx context.Context, rec *models.Record) error {
+	if err := rec.Validate(); err != nil {
+		return err
 	}
-	if rec.Meta == nil {
-		rec.Meta = map[string]string{}
+	rec.ContentSize = 0
+	rec.PayloadRef = ""
+	if rec.ContentID != "" {
+		prev, err := s.repo.GetRecord(ctx, rec.OwnerID, rec.ID)
+		if err != nil || rec.ContentID != prev.ContentID {
+			return errors.New("unknown content_id")
+		}
+		rec.ContentSize = prev.ContentSize
 	}
-	if s.maxPayloadBytes > 0 && int64(len(rec.Payload)) > s.maxPayloadBytes {
-		return models.Record{}, errors.New("payload too large")
+	return nil
+}
+
+// upsert validates rec and stores it, conditionally if expectedVersion is set.
+func (s *RecordsService) upsert(ctx context.Context, rec models.Record, expectedVersion *int64) (models.Record, error) {
+	payload, err := s.prepare(ctx, &rec)
+	if err != nil {
+		return models.Record{}, err
+	}
+	var saved models.Record
+	if expectedVersion != nil {
+		saved, err = s.repo.UpsertRecordConditional(ctx, rec, *expectedVersion)
+	} else {
+		saved, err = s.repo.UpsertRecord(ctx, rec)
 	}
-	return s.repo.UpsertRecord(ctx, rec)
+	if err != nil {
+		return models.Record{}, err
+	}
+	s.prune(ctx, saved.OwnerID, saved.ID)
+	saved.Payload = payload
+	return saved, nil
 }
 
-func (s *RecordsService) UpsertConditional(ctx context.Context, rec models.Record, expectedVersion int64) (models.Record, error) {
+// prepare validates rec and moves a payload over inlineMax to the blob
+// store. It returns the original payload.
+func (s *RecordsService) prepare(ctx context.Context, rec *models.Record) ([]byte, error) {
 	if rec.OwnerID == "" {
-		return models.Record{}, errors.New("owner_id required")
+		return nil, errors.New("owner_id required")
 	}
 	if rec.Type == "" {
-		return models.Record{}, errors.New("type required")
+		return nil, errors.New("type required")
 	}
 	if rec.Meta == nil {
 		rec.Meta = map[string]string{}
 	}
+	if rec.Payload == nil {
+		rec.Payload = []byte{}
+	}
 	if s.maxPayloadBytes > 0 && int64(len(rec.Payload)) > s.maxPayloadBytes {
-		return models.Record{}, errors.New("payload too large")
+		return nil, errors.New("payload too large")
+	}
+	payload := rec.Payload
+	if s.blobs != nil && s.inlineMax > 0 && int64(len(payload)) > s.inlineMax {
+		key := blobstore.Key(payload)
+		if err := s.putBlob(ctx, key, bytes.NewReader(payload), int64(len(payload))); err != nil {
+			return nil, err
+		}
+		rec.PayloadRef = key
+		rec.Payload = []byte{}
+	}
+	return payload, nil
+}
+
+// prune drops revisions of the record beyond the configured number. A failed
+// prune leaves extra revisions that the next save removes.
+func (s *RecordsService) prune(ctx context.Context, ownerID, id string) {
+	if s.revisions > 0 {
+		_ = s.repo.PruneRevisions(ctx, ownerID, id, s.revisions)
 	}
-	return s.repo.UpsertRecordConditional(ctx, rec, expectedVersion)
 }
 
-func (s *RecordsService) List(ctx context.Context, ownerID string) ([]models.Record, error) {
-	return s.repo.ListRecords(ctx, ownerID)
+// putBlob stores a blob and registers it first, so that garbage collection
+// spares it until a record references it.
+func (s *RecordsService) putBlob(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := s.repo.RegisterBlob(ctx, key, size); err != nil {
+		return err
+	}
+	return s.blobs.Put(ctx, key, r, size)
+}
+
+// storeBlobFile moves the file at path into the blob store and returns its
+// key and size.
+func (s *RecordsService) storeBlobFile(ctx context.Context, path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	key, size, err := blobstore.KeyOf(f)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	return key, size, s.putBlob(ctx, key, f, size)
+}
+
+// hydrate loads a payload kept in the blob store back into rec.
+func (s *RecordsService) hydrate(ctx context.Context, rec *models.Record) error {
+	if rec.PayloadRef == "" {
+		return nil
+	}
+	if s.blobs == nil {
+		return fmt.Errorf("record %s payload is in the blob store, which is not configured", rec.ID)
+	}
+	obj, err := s.blobs.Open(ctx, rec.PayloadRef)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	rec.Payload, err = io.ReadAll(obj)
+	return err
+}
+
+// List returns a page of the owner's records matching q. cursor is the value
+// returned with the previous page, or empty for the first one. The returned
+// cursor is empty when there are no more records.
+func (s *RecordsService) List(ctx context.Context, ownerID string, q models.RecordQuery, cursor string) ([]models.Record, string, error) {
+	q, limit, err := pageQuery(q, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	records, err := s.repo.ListRecords(ctx, ownerID, q)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+		last := records[limit-1]
+		next = encodeCursor(models.RecordKey{UpdatedAt: last.UpdatedAt, ID: last.ID}, q.Ascending)
+	}
+	for i := range records {
+		if err := s.hydrate(ctx, &records[i]); err != nil {
+			return nil, "", err
+		}
+	}
+	return records, next, nil
+}
+
+// ListSummaries is List without payloads; neither the database nor the blob
+// store is asked for them.
+func (s *RecordsService) ListSummaries(ctx context.Context, ownerID string, q models.RecordQuery, cursor string) ([]models.RecordSummary, string, error) {
+	q, limit, err := pageQuery(q, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	items, err := s.repo.ListRecordSummaries(ctx, ownerID, q)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+		last := items[limit-1]
+		next = encodeCursor(models.RecordKey{UpdatedAt: last.UpdatedAt, ID: last.ID}, q.Ascending)
+	}
+	return items, next, nil
 }
 
 func (s *RecordsService) Get(ctx context.Context, ownerID, id string) (models.Record, error) {
-	return s.repo.GetRecord(ctx, ownerID, id)
+	rec, err := s.repo.GetRecord(ctx, ownerID, id)
+	if err != nil {
+		return models.Record{}, err
+	}
+	return rec, s.hydrate(ctx, &rec)
 }
 
+// Revisions returns the kept earlier versions of the owner's live record,
+// newest first.
+func (s *RecordsService) Revisions(ctx context.Context, ownerID, id string) ([]models.Record, error) {
+	if _, err := s.repo.GetRecord(ctx, ownerID, id); err != nil {
+		return nil, err
+	}
+	revs, err := s.repo.ListRevisions(ctx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range revs {
+		if err := s.hydrate(ctx, &revs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return revs, nil
+}
+
+// DropRevisions removes the kept earlier versions of the owner's record,
+// live or deleted.
+func (s *RecordsService) DropRevisions(ctx context.Context, ownerID, id string) error {
+	return s.repo.PruneRevisions(ctx, ownerID, id, 0)
+}
+
+// Restore saves the kept revision version as the newest version of the
+// record, which must be at expectedVersion, or at any version if it is 0.
+// The replaced version becomes a revision itself.
+func (s *RecordsService) Restore(ctx context.Context, ownerID, id string, version, expectedVersion int64) (models.Record, error) {
+	cur, err := s.repo.GetRecord(ctx, ownerID, id)
+	if err != nil {
+		return models.Record{}, err
+	}
+	if expectedVersion == 0 {
+		expectedVersion = cur.Version
+	}
+	rev, err := s.repo.GetRevision(ctx, ownerID, id, version)
+	if err != nil {
+		return models.Record{}, err
+	}
+	rev.Version = 0
+	saved, err := s.upsert(ctx, rev, &expectedVersion)
+	if err != nil {
+		return models.Record{}, err
+	}
+	return saved, s.hydrate(ctx, &saved)
+}
+
+// Delete moves the record to the trash and turns it into a tombstone; blobs
+// it referenced are collected by CollectGarbage once it leaves the trash.
 func (s *RecordsService) Delete(ctx context.Context, ownerID, id string) error {
 	return s.repo.DeleteRecord(ctx, ownerID, id)
 }
+
+// OpenContent opens the uploaded content of the owner's record.
+func (s *RecordsService) OpenContent(ctx context.Context, ownerID, id string) (blobstore.Object, models.Record, error) {
+	if s.blobs == nil {
+		return nil, models.Record{}, ErrUploadsDisabled
+	}
+	rec, err := s.repo.GetRecord(ctx, ownerID, id)
+	if err != nil {
+		return nil, models.Record{}, err
+	}
+	if rec.ContentID == "" {
+		return nil, rec, sql.ErrNoRows
+	}
+	obj, err := s.blobs.Open(ctx, rec.ContentID)
+	if err != nil {
+		return nil, rec, err
+	}
+	return obj, rec, nil
+}
+
+// Changes returns the owner's record changes after the since cursor.
+func (s *RecordsService) Changes(ctx context.Context, ownerID string, since int64) (models.Changes, error) {
+	if since < 0 {
+		return models.Changes{}, errors.New("invalid cursor")
+	}
+	changes, err := s.repo.ListChanges(ctx, ownerID, since)
+	if err != nil {
+		return models.Changes{}, err
+	}
+	for i := range changes.Records {
+		if err := s.hydrate(ctx, &changes.Records[i]); err != nil {
+			return models.Changes{}, err
+		}
+	}
+	return changes, nil
+}
+
+// PurgeTombstones removes tombstones older than the configured retention.
+func (s *RecordsService) PurgeTombstones(ctx context.Context) (int64, error) {
+	if s.tombstoneRetention <= 0 {
+		return 0, nil
+	}
+	return s.repo.PurgeTombstones(ctx, time.Now().UTC().Add(-s.tombstoneRetention))
+}
+
+// Trash returns the owner's deleted records that can still be restored, most
+// recently deleted first.
+func (s *RecordsService) Trash(ctx context.Context, ownerID string) ([]models.TrashedRecord, error) {
+	items, err := s.repo.ListTrash(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		if err := s.hydrate(ctx, &items[i].Record); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// Undelete stores the trashed record again, continuing its version sequence,
+// and takes it out of the trash.
+func (s *RecordsService) Undelete(ctx context.Context, ownerID, id string) (models.Record, error) {
+	t, err := s.repo.GetTrashed(ctx, ownerID, id)
+	if err != nil {
+		return models.Record{}, err
+	}
+	rec := t.Record
+	rec.Version = 0
+	var created int64
+	saved, err := s.upsert(ctx, rec, &created)
+	if err != nil {
+		return models.Record{}, err
+	}
+	// a failed removal leaves an entry that is hidden while the record lives
+	_ = s.repo.DeleteTrashed(ctx, ownerID, id)
+	return saved, s.hydrate(ctx, &saved)
+}
+
+// EmptyTrash permanently removes all records from the owner's trash.
+func (s *RecordsService) EmptyTrash(ctx context.Context, ownerID string) (int64, error) {
+	return s.repo.EmptyTrash(ctx, ownerID)
+}
+
+// PurgeTrash removes records deleted longer than the configured retention ago
+// from the trash.
+func (s *RecordsService) PurgeTrash(ctx context.Context) (int64, error) {
+	if s.trashRetention <= 0 {
+		return 0, nil
+	}
+	return s.repo.PurgeTrash(ctx, time.Now().UTC().Add(-s.trashRetention))
+}
+
+// CollectGarbage deletes blobs that have been unreferenced for the grace
+// period, counted from registration or from the release of the last reference.
+func (s *RecordsService) CollectGarbage(ctx context.Context) (int, error) {
+	if s.blobs == nil {
+		return 0, nil
+	}
+	keys, err := s.repo.DeleteUnreferencedBlobs(ctx, time.Now().UTC().Add(-s.blobGrace))
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			return 0, fmt.Errorf("delete blob %s: %w", key, err)
+		}
+	}
+	return len(keys), nil
+}
+
+// maxKeyEscrowBytes bounds the wrapped key blob; it only holds a small
+// envelope with the wrapped key and KDF parameters.
+const maxKeyEscrowBytes = 16 << 10
+
+// KeysService keeps the client-wrapped vault key of each user so that a new
+// device can recover it. The blob is opaque to the server.
+type KeysService struct {
+	repo Repository
+}
+
+// Get returns the owner's wrapped vault key.
+func (s *KeysService) Get(ctx context.Context, ownerID string) (models.KeyEscrow, error) {
+	return s.repo.GetKeyEscrow(ctx, ownerID)
+}
+
+// Put stores the owner's wrapped vault key; see Repository.PutKeyEscrow for
+// the meaning of expectedVersion.
+func (s *KeysService) Put(ctx context.Context, ownerID string, blob []byte, expectedVersion int64) (models.KeyEscrow, error) {
+	if ownerID == "" {
+		return models.KeyEscrow{}, errors.New("owner_id required")
+	}
+	if len(blob) == 0 {
+		return models.KeyEscrow{}, errors.New("blob required")
+	}
+	if len(blob) > maxKeyEscrowBytes {
+		return models.KeyEscrow{}, errors.New("blob too large")
+	}
+	return s.repo.PutKeyEscrow(ctx, ownerID, blob, expectedVersion)
+}
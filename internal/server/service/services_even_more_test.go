@@ -2,10 +2,14 @@ package service
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
 	"gophkeeper/internal/server/config"
+	"gophkeeper/internal/server/repository"
 	"gophkeeper/internal/server/repository/sqlite"
 	"gophkeeper/internal/shared/models"
 )
@@ -66,3 +70,197 @@ func TestRecordsService_MetaNilPaths(t *testing.T) {
 		t.Fatalf("meta should be normalized, got nil")
 	}
 }
+
+func TestRecordsService_PurgeTombstones(t *testing.T) {
+	repo, err := sqlite.New("file:svc_purge_tombstones?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// retention disabled -> nothing is purged
+	disabled := NewServices(repo, config.Config{JWTSecret: "test"})
+	u, err := disabled.Auth.Register(ctx, "purge@example.com", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := disabled.Records.Upsert(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disabled.Records.Delete(ctx, u.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := disabled.Records.PurgeTombstones(ctx); err != nil || n != 0 {
+		t.Fatalf("disabled purge: %d %v", n, err)
+	}
+
+	// tiny retention -> tombstone is gone once the record leaves the trash
+	svcs := NewServices(repo, config.Config{JWTSecret: "test", TombstoneRetention: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	if n, err := svcs.Records.PurgeTombstones(ctx); err != nil || n != 0 {
+		t.Fatalf("purge of a trashed record: %d %v", n, err)
+	}
+	kept, err := svcs.Records.Upsert(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("y")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svcs.Records.Delete(ctx, u.ID, kept.ID); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := svcs.Records.PurgeTombstones(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// undelete continues after the tombstone version instead of restarting at 1
+	if back, err := svcs.Records.Undelete(ctx, u.ID, kept.ID); err != nil || back.Version != 3 {
+		t.Fatalf("undelete after purge: %+v %v", back, err)
+	}
+	if _, err := svcs.Records.EmptyTrash(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := svcs.Records.PurgeTombstones(ctx); err != nil || n != 1 {
+		t.Fatalf("purge: %d %v", n, err)
+	}
+}
+
+func TestRecordsService_RevisionsKeepAndRestore(t *testing.T) {
+	repo, err := sqlite.New("file:svc_revisions?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	svcs := NewServices(repo, config.Config{JWTSecret: "test", RecordRevisions: 2})
+	u, err := svcs.Auth.Register(ctx, "revisions@example.com", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := models.Record{OwnerID: u.ID, Type: models.RecordTypeText}
+	for _, p := range []string{"v1", "v2", "v3", "v4"} {
+		rec.Payload = []byte(p)
+		if rec, err = svcs.Records.Upsert(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revs, err := svcs.Records.Revisions(ctx, u.ID, rec.ID)
+	if err != nil || len(revs) != 2 || string(revs[0].Payload) != "v3" || string(revs[1].Payload) != "v2" {
+		t.Fatalf("revisions: %+v %v", revs, err)
+	}
+
+	if _, err := svcs.Records.Restore(ctx, u.ID, rec.ID, 2, 3); err != repository.ErrVersionConflict {
+		t.Fatalf("stale restore: want conflict, got %v", err)
+	}
+	restored, err := svcs.Records.Restore(ctx, u.ID, rec.ID, 2, 0)
+	if err != nil || restored.Version != 5 || string(restored.Payload) != "v2" {
+		t.Fatalf("restore: %+v %v", restored, err)
+	}
+	revs, _ = svcs.Records.Revisions(ctx, u.ID, rec.ID)
+	if len(revs) != 2 || string(revs[0].Payload) != "v4" {
+		t.Fatalf("restored-over version must be kept: %+v", revs)
+	}
+	if _, err := svcs.Records.Restore(ctx, u.ID, rec.ID, 1, 0); err != sql.ErrNoRows {
+		t.Fatalf("pruned revision: want ErrNoRows, got %v", err)
+	}
+}
+
+func TestRecordsService_PurgeTrash(t *testing.T) {
+	repo, err := sqlite.New("file:svc_purge_trash?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	keep := NewServices(repo, config.Config{JWTSecret: "test"})
+	u, err := keep.Auth.Register(ctx, "trash@example.com", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := keep.Records.Upsert(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keep.Records.Delete(ctx, u.ID, rec.ID); err != nil {
+		t.Fatal(err)
+	}
+	// retention disabled -> trash is kept until emptied
+	if n, err := keep.Records.PurgeTrash(ctx); err != nil || n != 0 {
+		t.Fatalf("disabled purge: %d %v", n, err)
+	}
+
+	svcs := NewServices(repo, config.Config{JWTSecret: "test", TrashRetention: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	if n, err := svcs.Records.PurgeTrash(ctx); err != nil || n != 1 {
+		t.Fatalf("purge: %d %v", n, err)
+	}
+	if _, err := svcs.Records.Undelete(ctx, u.ID, rec.ID); err != sql.ErrNoRows {
+		t.Fatalf("undelete purged record: want ErrNoRows, got %v", err)
+	}
+}
+
+func TestRecordsService_BatchValidatesAndPrunes(t *testing.T) {
+	repo, err := sqlite.New("file:svc_batch?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	svcs := NewServices(repo, config.Config{JWTSecret: "test", RecordRevisions: 1, MaxRecordPayloadBytes: 4})
+	u, err := svcs.Auth.Register(ctx, "batch@example.com", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := svcs.Records.Upsert(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeText, Payload: []byte("v1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := svcs.Records.Batch(ctx, u.ID, []models.BatchOp{
+		{Op: models.BatchDelete, ID: rec.ID},
+		{Op: models.BatchUpsert, Record: &models.Record{Type: models.RecordTypeText, Payload: []byte("too long")}},
+		{Op: models.BatchUpsert, Record: &models.Record{ID: rec.ID, Type: models.RecordTypeText, ContentID: "foreign"}},
+		{Op: models.BatchDelete},
+	})
+	if !errors.Is(err, ErrBatchInvalid) || len(results) != 4 || results[0].Status != models.BatchAborted ||
+		results[1].Error != "payload too large" || results[2].Error != "unknown content_id" || results[3].Status != models.BatchInvalid {
+		t.Fatalf("invalid batch: %+v %v", results, err)
+	}
+	if _, err := svcs.Records.Get(ctx, u.ID, rec.ID); err != nil {
+		t.Fatalf("invalid batch applied: %v", err)
+	}
+	if _, err := svcs.Records.Batch(ctx, u.ID, nil); !errors.Is(err, ErrBatchInvalid) {
+		t.Fatalf("empty batch: %v", err)
+	}
+
+	var ops []models.BatchOp
+	for i, p := range []string{"v2", "v3", "v4"} {
+		expected := int64(i + 1)
+		ops = append(ops, models.BatchOp{Op: models.BatchUpsert, Record: &models.Record{ID: rec.ID, Type: models.RecordTypeText, Payload: []byte(p)}, ExpectedVersion: &expected})
+	}
+	if _, err := svcs.Records.Batch(ctx, u.ID, ops); err != nil {
+		t.Fatal(err)
+	}
+	revs, err := svcs.Records.Revisions(ctx, u.ID, rec.ID)
+	if err != nil || len(revs) != 1 || string(revs[0].Payload) != "v3" {
+		t.Fatalf("revisions after batch: %+v %v", revs, err)
+	}
+}
+
+func TestRecordsService_RejectsRecordsOffSchema(t *testing.T) {
+	repo, err := sqlite.New("file:svc_schema?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	svcs := NewServices(repo, config.Config{JWTSecret: "test"})
+	u, err := svcs.Auth.Register(ctx, "schema@example.com", "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svcs.Records.Upsert(ctx, models.Record{OwnerID: u.ID, Type: "note", Payload: []byte("x")}); err == nil || !strings.Contains(err.Error(), "unknown record type") {
+		t.Fatalf("unknown type: %v", err)
+	}
+	if _, err := svcs.Records.UpsertConditional(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeBankCard, Payload: []byte("x")}, 0); err == nil || !strings.Contains(err.Error(), `meta "bank"`) {
+		t.Fatalf("missing meta: %v", err)
+	}
+	if _, err := svcs.Records.Upsert(ctx, models.Record{OwnerID: u.ID, Type: models.RecordTypeBankCard, Meta: map[string]string{"bank": "b"}, Payload: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -64,7 +64,7 @@ func TestRefreshFlowAndRecordsService(t *testing.T) {
 	if err != nil || rec.ID == "" {
 		t.Fatalf("upsert: %v", err)
 	}
-	list, err := svcs.Records.List(ctx, u.ID)
+	list, _, err := svcs.Records.List(ctx, u.ID, models.RecordQuery{}, "")
 	if err != nil || len(list) == 0 {
 		t.Fatalf("list: %v", err)
 	}
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gophkeeper/internal/server/blobstore"
+	"gophkeeper/internal/server/config"
+	"gophkeeper/internal/shared/models"
+)
+
+var (
+	// ErrUploadsDisabled is returned when the server has no data directory
+	// or blob store.
+	ErrUploadsDisabled = errors.New("uploads disabled")
+	// ErrUploadOffset is returned when a part does not start at the current
+	// upload size; the client should query the upload and resume from there.
+	ErrUploadOffset = errors.New("upload offset mismatch")
+	// ErrUploadTooLarge is returned when an upload exceeds the size limit.
+	ErrUploadTooLarge = errors.New("upload too large")
+)
+
+// newBlobStore builds the configured blob store; nil disables it.
+func newBlobStore(cfg config.Config) blobstore.Store {
+	switch cfg.BlobBackend {
+	case "s3":
+		return blobstore.NewS3(blobstore.S3Config(cfg.S3))
+	default:
+		if cfg.DataDir == "" {
+			return nil
+		}
+		return blobstore.NewFS(filepath.Join(cfg.DataDir, "blobs"))
+	}
+}
+
+// validUploadID reports whether id looks like an id generated by uuid4 and
+// is therefore safe to use as a file name.
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// UploadsService receives large encrypted record payloads in parts so that an
+// interrupted transfer can be resumed from the last stored offset. Parts are
+// staged in a local directory; a completed upload moves to the blob store and
+// becomes the content of a record.
+type UploadsService struct {
+	repo      Repository
+	records   *RecordsService
+	staging   string
+	maxBytes  int64
+	retention time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock serializes operations on a single upload.
+func (s *UploadsService) lock(id string) func() {
+	s.mu.Lock()
+	if s.locks == nil {
+		s.locks = map[string]*sync.Mutex{}
+	}
+	l, ok := s.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[id] = l
+	}
+	s.mu.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+func (s *UploadsService) enabled() bool {
+	return s.staging != "" && s.records.blobs != nil
+}
+
+func (s *UploadsService) path(id string) (string, error) {
+	if !validUploadID(id) {
+		return "", sql.ErrNoRows
+	}
+	return filepath.Join(s.staging, id), nil
+}
+
+func (s *UploadsService) forget(id string) {
+	s.mu.Lock()
+	delete(s.locks, id)
+	s.mu.Unlock()
+}
+
+// Create starts a new upload for the owner.
+func (s *UploadsService) Create(ctx context.Context, ownerID string) (models.Upload, error) {
+	if !s.enabled() {
+		return models.Upload{}, ErrUploadsDisabled
+	}
+	if err := os.MkdirAll(s.staging, 0o700); err != nil {
+		return models.Upload{}, err
+	}
+	return s.repo.CreateUpload(ctx, ownerID, uuid4())
+}
+
+// Status returns the upload with the number of bytes received so far.
+func (s *UploadsService) Status(ctx context.Context, ownerID, id string) (models.Upload, error) {
+	if !s.enabled() {
+		return models.Upload{}, ErrUploadsDisabled
+	}
+	return s.repo.GetUpload(ctx, ownerID, id)
+}
+
+// Append writes the data read from r at offset, which must equal the current
+// upload size. Bytes received before a read error are kept so that the
+// client can resume after them.
+func (s *UploadsService) Append(ctx context.Context, ownerID, id string, offset int64, r io.Reader) (models.Upload, error) {
+	if !s.enabled() {
+		return models.Upload{}, ErrUploadsDisabled
+	}
+	path, err := s.path(id)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if _, err := s.repo.GetUpload(ctx, ownerID, id); err != nil {
+		return models.Upload{}, err
+	}
+	unlock := s.lock(id)
+	defer unlock()
+	up, err := s.repo.GetUpload(ctx, ownerID, id)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if offset != up.Size {
+		return up, ErrUploadOffset
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return up, err
+	}
+	defer f.Close()
+	// Drop any bytes written after the last recorded size, e.g. by a part
+	// that failed to commit.
+	if err := f.Truncate(up.Size); err != nil {
+		return up, err
+	}
+	if _, err := f.Seek(up.Size, io.SeekStart); err != nil {
+		return up, err
+	}
+	src := r
+	if s.maxBytes > 0 {
+		src = io.LimitReader(r, s.maxBytes-up.Size+1)
+	}
+	n, copyErr := io.Copy(f, src)
+	if s.maxBytes > 0 && up.Size+n > s.maxBytes {
+		_ = f.Truncate(up.Size)
+		return up, ErrUploadTooLarge
+	}
+	if n > 0 {
+		if err := f.Sync(); err != nil {
+			return up, err
+		}
+		if err := s.repo.SetUploadSize(ctx, ownerID, id, up.Size, up.Size+n); err != nil {
+			return up, err
+		}
+		up.Size += n
+	}
+	return up, copyErr
+}
+
+// Abort discards an upload and its data.
+func (s *UploadsService) Abort(ctx context.Context, ownerID, id string) error {
+	if !s.enabled() {
+		return ErrUploadsDisabled
+	}
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	unlock := s.lock(id)
+	defer unlock()
+	if err := s.repo.DeleteUpload(ctx, ownerID, id); err != nil {
+		return err
+	}
+	_ = os.Remove(path)
+	s.forget(id)
+	return nil
+}
+
+// Complete stores rec with the uploaded data as its content. A non-negative
+// expectedVersion makes the update conditional as in
+// RecordsService.UpsertConditional. On failure the upload is left intact.
+func (s *UploadsService) Complete(ctx context.Context, ownerID, id string, rec models.Record, expectedVersion int64) (models.Record, error) {
+	if !s.enabled() {
+		return models.Record{}, ErrUploadsDisabled
+	}
+	if err := rec.Validate(); err != nil {
+		return models.Record{}, err
+	}
+	path, err := s.path(id)
+	if err != nil {
+		return models.Record{}, err
+	}
+	unlock := s.lock(id)
+	defer unlock()
+	up, err := s.repo.GetUpload(ctx, ownerID, id)
+	if err != nil {
+		return models.Record{}, err
+	}
+	if up.Size == 0 {
+		return models.Record{}, errors.New("upload is empty")
+	}
+	key, size, err := s.records.storeBlobFile(ctx, path)
+	if err != nil {
+		return models.Record{}, err
+	}
+	if size != up.Size {
+		return models.Record{}, errors.New("upload data is incomplete")
+	}
+	rec.OwnerID = ownerID
+	rec.ContentID = key
+	rec.ContentSize = size
+	var saved models.Record
+	if expectedVersion >= 0 {
+		saved, err = s.records.upsert(ctx, rec, &expectedVersion)
+	} else {
+		saved, err = s.records.upsert(ctx, rec, nil)
+	}
+	if err != nil {
+		return models.Record{}, err
+	}
+	_ = s.repo.DeleteUpload(ctx, ownerID, id)
+	_ = os.Remove(path)
+	s.forget(id)
+	return saved, nil
+}
+
+// PurgeStale removes uploads that were not touched within the retention.
+func (s *UploadsService) PurgeStale(ctx context.Context) (int, error) {
+	if !s.enabled() || s.retention <= 0 {
+		return 0, nil
+	}
+	ids, err := s.repo.DeleteStaleUploads(ctx, time.Now().UTC().Add(-s.retention))
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if path, err := s.path(id); err == nil {
+			_ = os.Remove(path)
+		}
+		s.forget(id)
+	}
+	return len(ids), nil
+}
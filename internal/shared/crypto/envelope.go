@@ -0,0 +1,158 @@
+package cryptohelper
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Envelope layout:
+//
+//	magic(1) | version(1) | key id length(1) | key id | algorithm(1) | nonce | ciphertext
+//
+// The header up to and including the algorithm byte is authenticated
+// together with the caller's AAD, so the key id and algorithm cannot be
+// swapped without failing decryption. Payloads produced by EncryptAESGCM
+// (bare nonce||ciphertext) are still accepted by Open.
+const (
+	EnvelopeMagic   byte = 0xE7
+	EnvelopeVersion byte = 1
+)
+
+// Algorithm identifies the AEAD used inside an envelope.
+type Algorithm byte
+
+const (
+	AlgAESGCM Algorithm = 1
+	// AlgXChaCha20Poly1305 uses 24-byte random nonces, which stay safe for
+	// far more messages under one key than the 12-byte nonces of AES-GCM.
+	AlgXChaCha20Poly1305 Algorithm = 2
+)
+
+var algorithmNames = map[Algorithm]string{
+	AlgAESGCM:            "aes-256-gcm",
+	AlgXChaCha20Poly1305: "xchacha20-poly1305",
+}
+
+func (a Algorithm) String() string {
+	if name, ok := algorithmNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("alg(%d)", byte(a))
+}
+
+// ParseAlgorithm returns the algorithm with the given name.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for a, n := range algorithmNames {
+		if n == name {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, name)
+}
+
+// Header describes how an envelope was produced.
+type Header struct {
+	KeyID string
+	Alg   Algorithm
+}
+
+var (
+	errNotEnvelope = errors.New("not an envelope")
+	// ErrUnsupportedAlgorithm is returned for envelopes of unknown algorithms.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+)
+
+func (h Header) encode() ([]byte, error) {
+	if len(h.KeyID) > 255 {
+		return nil, errors.New("key id too long")
+	}
+	b := make([]byte, 0, 4+len(h.KeyID))
+	b = append(b, EnvelopeMagic, EnvelopeVersion, byte(len(h.KeyID)))
+	b = append(b, h.KeyID...)
+	return append(b, byte(h.Alg)), nil
+}
+
+// parseEnvelope splits data into its header, the raw header bytes and the
+// nonce||ciphertext body.
+func parseEnvelope(data []byte) (Header, []byte, []byte, error) {
+	if len(data) < 4 || data[0] != EnvelopeMagic || data[1] != EnvelopeVersion {
+		return Header{}, nil, nil, errNotEnvelope
+	}
+	n := int(data[2])
+	if len(data) < 4+n {
+		return Header{}, nil, nil, errNotEnvelope
+	}
+	h := Header{KeyID: string(data[3 : 3+n]), Alg: Algorithm(data[3+n])}
+	return h, data[:4+n], data[4+n:], nil
+}
+
+// ParseHeader returns the header of an envelope; ok is false for data in the
+// legacy layout.
+func ParseHeader(data []byte) (h Header, ok bool) {
+	h, _, _, err := parseEnvelope(data)
+	return h, err == nil
+}
+
+// Seal encrypts plaintext with key into an envelope described by h.
+func Seal(key []byte, h Header, plaintext, aad []byte) ([]byte, error) {
+	header, err := h.encode()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(h.Alg, key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, plaintext, append(header[:len(header):len(header)], aad...)), nil
+}
+
+// Open decrypts data produced by Seal or by EncryptAESGCM. When data does not
+// parse or authenticate as an envelope, the legacy nonce||ciphertext layout
+// is tried, since a legacy nonce may start with the magic byte by chance.
+func Open(key, data, aad []byte) ([]byte, error) {
+	h, header, body, err := parseEnvelope(data)
+	if err != nil {
+		return DecryptAESGCM(key, data, aad)
+	}
+	aead, err := newAEAD(h.Alg, key)
+	if err == nil {
+		if len(body) >= aead.NonceSize() {
+			nonce, ct := body[:aead.NonceSize()], body[aead.NonceSize():]
+			pt, openErr := aead.Open(nil, nonce, ct, append(header[:len(header):len(header)], aad...))
+			if openErr == nil {
+				return pt, nil
+			}
+			err = openErr
+		} else {
+			err = errors.New("ciphertext too short")
+		}
+	}
+	if pt, legacyErr := DecryptAESGCM(key, data, aad); legacyErr == nil {
+		return pt, nil
+	}
+	return nil, err
+}
+
+func newAEAD(alg Algorithm, key []byte) (cipher.AEAD, error) {
+	switch alg {
+	case AlgAESGCM:
+		blk, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(blk)
+	case AlgXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+}
@@ -0,0 +1,110 @@
+package cryptohelper_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	cryptohelper "gophkeeper/internal/shared/crypto"
+)
+
+func TestEnvelope_SealOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	h := cryptohelper.Header{KeyID: "k1", Alg: cryptohelper.AlgAESGCM}
+	data, err := cryptohelper.Seal(key, h, []byte("secret"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != cryptohelper.EnvelopeMagic || data[1] != cryptohelper.EnvelopeVersion {
+		t.Fatalf("unexpected prefix % x", data[:2])
+	}
+	got, ok := cryptohelper.ParseHeader(data)
+	if !ok || got != h {
+		t.Fatalf("header: %+v %v", got, ok)
+	}
+	pt, err := cryptohelper.Open(key, data, []byte("aad"))
+	if err != nil || string(pt) != "secret" {
+		t.Fatalf("open: %q %v", pt, err)
+	}
+	if _, err := cryptohelper.Open(key, data, []byte("other")); err == nil {
+		t.Fatalf("expected auth error with wrong AAD")
+	}
+}
+
+func TestEnvelope_HeaderIsAuthenticated(t *testing.T) {
+	key := bytes.Repeat([]byte{2}, 32)
+	data, err := cryptohelper.Seal(key, cryptohelper.Header{KeyID: "k1", Alg: cryptohelper.AlgAESGCM}, []byte("x"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), data...)
+	tampered[3] = 'j' // key id "k1" -> "j1"
+	if _, err := cryptohelper.Open(key, tampered, nil); err == nil {
+		t.Fatalf("expected error for tampered key id")
+	}
+}
+
+func TestEnvelope_OpensLegacyLayout(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 32)
+	legacy, err := cryptohelper.EncryptAESGCM(key, []byte("old"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt, err := cryptohelper.Open(key, legacy, []byte("aad")); err != nil || string(pt) != "old" {
+		t.Fatalf("legacy open: %q %v", pt, err)
+	}
+
+	// A legacy nonce that happens to look like an envelope header.
+	blk, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(blk)
+	nonce := make([]byte, gcm.NonceSize())
+	nonce[0], nonce[1] = cryptohelper.EnvelopeMagic, cryptohelper.EnvelopeVersion
+	lookalike := gcm.Seal(append([]byte(nil), nonce...), nonce, []byte("old"), []byte("aad"))
+	if pt, err := cryptohelper.Open(key, lookalike, []byte("aad")); err != nil || string(pt) != "old" {
+		t.Fatalf("legacy fallback: %q %v", pt, err)
+	}
+}
+
+func TestEnvelope_UnknownAlgorithm(t *testing.T) {
+	key := bytes.Repeat([]byte{4}, 32)
+	if _, err := cryptohelper.Seal(key, cryptohelper.Header{KeyID: "k", Alg: 99}, []byte("x"), nil); !errors.Is(err, cryptohelper.ErrUnsupportedAlgorithm) {
+		t.Fatalf("expected unsupported algorithm, got %v", err)
+	}
+}
+
+func TestEnvelope_XChaCha20Poly1305(t *testing.T) {
+	key := bytes.Repeat([]byte{5}, 32)
+	h := cryptohelper.Header{KeyID: "k", Alg: cryptohelper.AlgXChaCha20Poly1305}
+	data, err := cryptohelper.Seal(key, h, []byte("secret"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// header(5) + 24-byte nonce + plaintext + 16-byte tag
+	if len(data) != 5+24+len("secret")+16 {
+		t.Fatalf("unexpected envelope size %d", len(data))
+	}
+	if got, ok := cryptohelper.ParseHeader(data); !ok || got.Alg != cryptohelper.AlgXChaCha20Poly1305 {
+		t.Fatalf("header: %+v %v", got, ok)
+	}
+	pt, err := cryptohelper.Open(key, data, []byte("aad"))
+	if err != nil || string(pt) != "secret" {
+		t.Fatalf("open: %q %v", pt, err)
+	}
+	if _, err := cryptohelper.Open(key, data, []byte("other")); err == nil {
+		t.Fatalf("expected auth error with wrong AAD")
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, a := range []cryptohelper.Algorithm{cryptohelper.AlgAESGCM, cryptohelper.AlgXChaCha20Poly1305} {
+		got, err := cryptohelper.ParseAlgorithm(a.String())
+		if err != nil || got != a {
+			t.Fatalf("round trip %s: %v %v", a, got, err)
+		}
+	}
+	if _, err := cryptohelper.ParseAlgorithm("rot13"); !errors.Is(err, cryptohelper.ErrUnsupportedAlgorithm) {
+		t.Fatalf("expected unsupported algorithm, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package cryptohelper
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stream layout:
+//
+//	magic(1) | stream version(1) | key id length(1) | key id | algorithm(1) |
+//	chunk size(4, big endian) | nonce prefix | chunk 0 | chunk 1 | ... | final chunk
+//
+// Every chunk except the final one holds exactly ChunkSize plaintext bytes;
+// the final chunk holds the remainder and may be empty. Chunk i is sealed
+// with nonce prefix || uint32(i) || final flag, and the header is
+// authenticated as AAD of every chunk, so chunks cannot be reordered,
+// dropped or moved between streams, and truncation is detected.
+const StreamVersion byte = 0x81
+
+// DefaultChunkSize is the plaintext size of stream chunks.
+const DefaultChunkSize = 64 << 10
+
+// MaxChunkSize bounds the chunk size of a stream header, which is read
+// before anything is authenticated and decides the size of chunk buffers.
+const MaxChunkSize = 16 << 20
+
+// streamNonceTail is the counter and final flag appended to the nonce prefix.
+const streamNonceTail = 5
+
+// ErrTruncatedStream is returned when a stream ends before its final chunk.
+var ErrTruncatedStream = errors.New("truncated stream")
+
+// StreamHeader describes a chunked stream.
+type StreamHeader struct {
+	Header
+	ChunkSize   int
+	NoncePrefix []byte
+}
+
+// NewStreamHeader returns a header with a random nonce prefix.
+func NewStreamHeader(h Header, chunkSize int) (StreamHeader, error) {
+	aead, err := newAEAD(h.Alg, make([]byte, 32))
+	if err != nil {
+		return StreamHeader{}, err
+	}
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return StreamHeader{}, errors.New("invalid chunk size")
+	}
+	prefix := make([]byte, aead.NonceSize()-streamNonceTail)
+	if _, err := rand.Read(prefix); err != nil {
+		return StreamHeader{}, err
+	}
+	return StreamHeader{Header: h, ChunkSize: chunkSize, NoncePrefix: prefix}, nil
+}
+
+// Encode returns the serialized header that starts the stream.
+func (sh StreamHeader) Encode() ([]byte, error) {
+	if len(sh.KeyID) > 255 {
+		return nil, errors.New("key id too long")
+	}
+	b := make([]byte, 0, 8+len(sh.KeyID)+len(sh.NoncePrefix))
+	b = append(b, EnvelopeMagic, StreamVersion, byte(len(sh.KeyID)))
+	b = append(b, sh.KeyID...)
+	b = append(b, byte(sh.Alg))
+	b = binary.BigEndian.AppendUint32(b, uint32(sh.ChunkSize))
+	return append(b, sh.NoncePrefix...), nil
+}
+
+// ReadStreamHeader reads and parses a stream header from r.
+func ReadStreamHeader(r io.Reader) (StreamHeader, error) {
+	var fixed [3]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return StreamHeader{}, err
+	}
+	if fixed[0] != EnvelopeMagic || fixed[1] != StreamVersion {
+		return StreamHeader{}, errors.New("not a stream")
+	}
+	rest := make([]byte, int(fixed[2])+5)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return StreamHeader{}, err
+	}
+	n := int(fixed[2])
+	sh := StreamHeader{
+		Header:    Header{KeyID: string(rest[:n]), Alg: Algorithm(rest[n])},
+		ChunkSize: int(binary.BigEndian.Uint32(rest[n+1:])),
+	}
+	aead, err := newAEAD(sh.Alg, make([]byte, 32))
+	if err != nil {
+		return StreamHeader{}, err
+	}
+	if sh.ChunkSize <= 0 || sh.ChunkSize > MaxChunkSize {
+		return StreamHeader{}, fmt.Errorf("invalid chunk size %d", sh.ChunkSize)
+	}
+	sh.NoncePrefix = make([]byte, aead.NonceSize()-streamNonceTail)
+	if _, err := io.ReadFull(r, sh.NoncePrefix); err != nil {
+		return StreamHeader{}, err
+	}
+	return sh, nil
+}
+
+// StreamCipher seals and opens individual chunks of a stream. It allows a
+// stream to be produced starting at any chunk, e.g. to resume an upload.
+type StreamCipher struct {
+	aead cipher.AEAD
+	sh   StreamHeader
+	aad  []byte
+}
+
+// NewStreamCipher prepares chunk encryption for sh with key; aad is bound to
+// every chunk in addition to the header.
+func NewStreamCipher(key []byte, sh StreamHeader, aad []byte) (*StreamCipher, error) {
+	aead, err := newAEAD(sh.Alg, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sh.NoncePrefix) != aead.NonceSize()-streamNonceTail {
+		return nil, errors.New("invalid nonce prefix")
+	}
+	header, err := sh.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return &StreamCipher{aead: aead, sh: sh, aad: append(header, aad...)}, nil
+}
+
+// Overhead is the number of bytes each chunk grows by when sealed.
+func (s *StreamCipher) Overhead() int { return s.aead.Overhead() }
+
+func (s *StreamCipher) nonce(i uint64, final bool) ([]byte, error) {
+	if i > 0xFFFFFFFF {
+		return nil, errors.New("stream too long")
+	}
+	n := make([]byte, 0, s.aead.NonceSize())
+	n = append(n, s.sh.NoncePrefix...)
+	n = binary.BigEndian.AppendUint32(n, uint32(i))
+	if final {
+		return append(n, 1), nil
+	}
+	return append(n, 0), nil
+}
+
+// SealChunk encrypts chunk i. Every chunk but the last holds exactly
+// ChunkSize bytes; the last one, sealed with final, holds fewer.
+func (s *StreamCipher) SealChunk(i uint64, plaintext []byte, final bool) ([]byte, error) {
+	if (final && len(plaintext) >= s.sh.ChunkSize) || (!final && len(plaintext) != s.sh.ChunkSize) {
+		return nil, fmt.Errorf("invalid chunk %d size %d", i, len(plaintext))
+	}
+	nonce, err := s.nonce(i, final)
+	if err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nil, nonce, plaintext, s.aad), nil
+}
+
+// OpenChunk decrypts chunk i.
+func (s *StreamCipher) OpenChunk(i uint64, ciphertext []byte, final bool) ([]byte, error) {
+	nonce, err := s.nonce(i, final)
+	if err != nil {
+		return nil, err
+	}
+	return s.aead.Open(nil, nonce, ciphertext, s.aad)
+}
+
+// ChunkCount returns the number of chunks of a stream of size plaintext bytes.
+func ChunkCount(size int64, chunkSize int) int64 {
+	return size/int64(chunkSize) + 1
+}
+
+// EncryptStream encrypts everything read from r into w as a stream described
+// by sh, holding a single chunk in memory.
+func EncryptStream(w io.Writer, r io.Reader, key []byte, sh StreamHeader, aad []byte) error {
+	sc, err := NewStreamCipher(key, sh, aad)
+	if err != nil {
+		return err
+	}
+	header, _ := sh.Encode()
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	buf := make([]byte, sh.ChunkSize)
+	br := bufio.NewReader(r)
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(br, buf)
+		final := false
+		switch {
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+			final = true
+		case err != nil:
+			return err
+		}
+		ct, err := sc.SealChunk(i, buf[:n], final)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(ct); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// DecryptStream decrypts a stream read from r into w. Plaintext of a chunk is
+// written only after the chunk authenticates; a stream cut before its final
+// chunk yields ErrTruncatedStream.
+func DecryptStream(w io.Writer, r io.Reader, key []byte, aad []byte) error {
+	br := bufio.NewReader(r)
+	sh, err := ReadStreamHeader(br)
+	if err != nil {
+		return err
+	}
+	sc, err := NewStreamCipher(key, sh, aad)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, sh.ChunkSize+sc.Overhead())
+	for i := uint64(0); ; i++ {
+		n, err := io.ReadFull(br, buf)
+		final := false
+		switch {
+		case errors.Is(err, io.EOF):
+			return ErrTruncatedStream
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			final = true
+		case err != nil:
+			return err
+		}
+		pt, err := sc.OpenChunk(i, buf[:n], final)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(pt); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
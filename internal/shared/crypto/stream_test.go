@@ -0,0 +1,130 @@
+package cryptohelper_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	cryptohelper "gophkeeper/internal/shared/crypto"
+)
+
+func streamRoundTrip(t *testing.T, alg cryptohelper.Algorithm, size int) {
+	t.Helper()
+	key := bytes.Repeat([]byte{6}, 32)
+	plain := make([]byte, size)
+	_, _ = rand.Read(plain)
+	sh, err := cryptohelper.NewStreamHeader(cryptohelper.Header{KeyID: "k", Alg: alg}, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var enc bytes.Buffer
+	if err := cryptohelper.EncryptStream(&enc, bytes.NewReader(plain), key, sh, []byte("aad")); err != nil {
+		t.Fatal(err)
+	}
+	var dec bytes.Buffer
+	if err := cryptohelper.DecryptStream(&dec, bytes.NewReader(enc.Bytes()), key, []byte("aad")); err != nil {
+		t.Fatalf("%s size %d: %v", alg, size, err)
+	}
+	if !bytes.Equal(dec.Bytes(), plain) {
+		t.Fatalf("%s size %d: plaintext mismatch", alg, size)
+	}
+}
+
+func TestStream_RoundTrip(t *testing.T) {
+	for _, alg := range []cryptohelper.Algorithm{cryptohelper.AlgAESGCM, cryptohelper.AlgXChaCha20Poly1305} {
+		for _, size := range []int{0, 1, 1023, 1024, 1025, 4096, 5000} {
+			streamRoundTrip(t, alg, size)
+		}
+	}
+}
+
+func encryptedStream(t *testing.T, key []byte, size int) ([]byte, int) {
+	t.Helper()
+	sh, err := cryptohelper.NewStreamHeader(cryptohelper.Header{KeyID: "k", Alg: cryptohelper.AlgAESGCM}, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var enc bytes.Buffer
+	if err := cryptohelper.EncryptStream(&enc, bytes.NewReader(make([]byte, size)), key, sh, nil); err != nil {
+		t.Fatal(err)
+	}
+	header, _ := sh.Encode()
+	return enc.Bytes(), len(header)
+}
+
+func TestStream_DetectsTruncationAndReordering(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	enc, headerLen := encryptedStream(t, key, 3000)
+	chunk := 1024 + 16
+
+	// cut right after the second full chunk
+	cut := enc[:headerLen+2*chunk]
+	if err := cryptohelper.DecryptStream(new(bytes.Buffer), bytes.NewReader(cut), key, nil); !errors.Is(err, cryptohelper.ErrTruncatedStream) {
+		t.Fatalf("expected truncation error, got %v", err)
+	}
+	// cut in the middle of a chunk
+	if err := cryptohelper.DecryptStream(new(bytes.Buffer), bytes.NewReader(enc[:headerLen+chunk+10]), key, nil); err == nil {
+		t.Fatalf("expected error for partial chunk")
+	}
+	// swap the first two chunks
+	swapped := append([]byte(nil), enc...)
+	copy(swapped[headerLen:], enc[headerLen+chunk:headerLen+2*chunk])
+	copy(swapped[headerLen+chunk:], enc[headerLen:headerLen+chunk])
+	if err := cryptohelper.DecryptStream(new(bytes.Buffer), bytes.NewReader(swapped), key, nil); err == nil {
+		t.Fatalf("expected error for reordered chunks")
+	}
+}
+
+func TestReadStreamHeader_RejectsOversizedChunks(t *testing.T) {
+	sh, err := cryptohelper.NewStreamHeader(cryptohelper.Header{KeyID: "k", Alg: cryptohelper.AlgAESGCM}, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh.ChunkSize = 1<<32 - 1
+	header, _ := sh.Encode()
+	if _, err := cryptohelper.ReadStreamHeader(bytes.NewReader(header)); err == nil {
+		t.Fatal("expected error for a 4 GiB chunk size")
+	}
+	if err := cryptohelper.DecryptStream(new(bytes.Buffer), bytes.NewReader(header), bytes.Repeat([]byte{7}, 32), nil); err == nil {
+		t.Fatal("expected DecryptStream to reject the header")
+	}
+	if _, err := cryptohelper.NewStreamHeader(cryptohelper.Header{KeyID: "k", Alg: cryptohelper.AlgAESGCM}, cryptohelper.MaxChunkSize+1); err == nil {
+		t.Fatal("expected NewStreamHeader to reject an oversized chunk size")
+	}
+}
+
+func TestStreamCipher_ResumeFromChunk(t *testing.T) {
+	key := bytes.Repeat([]byte{8}, 32)
+	plain := make([]byte, 2500)
+	_, _ = rand.Read(plain)
+	sh, err := cryptohelper.NewStreamHeader(cryptohelper.Header{KeyID: "k", Alg: cryptohelper.AlgXChaCha20Poly1305}, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var full bytes.Buffer
+	if err := cryptohelper.EncryptStream(&full, bytes.NewReader(plain), key, sh, nil); err != nil {
+		t.Fatal(err)
+	}
+	// producing chunks one by one from the same header yields the same stream
+	sc, err := cryptohelper.NewStreamCipher(key, sh, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _ := sh.Encode()
+	for i := int64(0); i < cryptohelper.ChunkCount(int64(len(plain)), sh.ChunkSize); i++ {
+		end := min(int(i+1)*sh.ChunkSize, len(plain))
+		ct, err := sc.SealChunk(uint64(i), plain[int(i)*sh.ChunkSize:end], end-int(i)*sh.ChunkSize < sh.ChunkSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, ct...)
+	}
+	var dec bytes.Buffer
+	if err := cryptohelper.DecryptStream(&dec, bytes.NewReader(stream), key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec.Bytes(), plain) {
+		t.Fatalf("plaintext mismatch")
+	}
+}
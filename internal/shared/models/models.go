@@ -20,14 +20,157 @@ const (
 	RecordTypeText     RecordType = "text"
 	RecordTypeBinary   RecordType = "binary"
 	RecordTypeBankCard RecordType = "bank_card"
+	RecordTypeTOTP     RecordType = "totp"
+	RecordTypeSSHKey   RecordType = "ssh_key"
 )
 
+// Record is a client-encrypted item. Large encrypted payloads are uploaded
+// separately as a stream; ContentID and ContentSize then refer to it and can
+// only be set by completing an upload.
 type Record struct {
-	ID        string            `json:"id"`
-	OwnerID   string            `json:"owner_id"`
-	Type      RecordType        `json:"type"`
-	Meta      map[string]string `json:"meta"`
-	Payload   []byte            `json:"payload"`
-	Version   int64             `json:"version"`
-	UpdatedAt time.Time         `json:"updated_at"`
+	ID          string            `json:"id"`
+	OwnerID     string            `json:"owner_id"`
+	Type        RecordType        `json:"type"`
+	Meta        map[string]string `json:"meta"`
+	Payload     []byte            `json:"payload"`
+	Version     int64             `json:"version"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	ContentID   string            `json:"content_id,omitempty"`
+	ContentSize int64             `json:"content_size,omitempty"`
+	// PayloadRef is set by the server when it keeps a large payload in its
+	// blob store instead of the record row; it is never sent to clients.
+	PayloadRef string `json:"-"`
+}
+
+// RecordSummary is a record without its payload, as returned by
+// metadata-only listings.
+type RecordSummary struct {
+	ID          string            `json:"id"`
+	Type        RecordType        `json:"type"`
+	Meta        map[string]string `json:"meta"`
+	Version     int64             `json:"version"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	ContentSize int64             `json:"content_size,omitempty"`
+}
+
+// Summary returns rec without its payload.
+func (rec Record) Summary() RecordSummary {
+	return RecordSummary{ID: rec.ID, Type: rec.Type, Meta: rec.Meta, Version: rec.Version, UpdatedAt: rec.UpdatedAt, ContentSize: rec.ContentSize}
+}
+
+// RecordQuery narrows and pages a record listing. Zero fields do not restrict
+// the result.
+type RecordQuery struct {
+	Type RecordType
+	// Meta holds key/value pairs that must all be present in the record meta.
+	Meta map[string]string
+	// UpdatedAfter keeps records changed strictly after the given time.
+	UpdatedAfter time.Time
+	// Ascending orders the listing oldest first instead of newest first.
+	Ascending bool
+	// After continues the listing behind the last record of a previous page.
+	After *RecordKey
+	Limit int
+}
+
+// RecordKey is the position of a record in a listing ordered by updated_at
+// and then id.
+type RecordKey struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	ID        string    `json:"id"`
+}
+
+// Matches reports whether rec passes the filters of q; paging is ignored.
+func (q RecordQuery) Matches(rec Record) bool {
+	if q.Type != "" && rec.Type != q.Type {
+		return false
+	}
+	if !q.UpdatedAfter.IsZero() && !rec.UpdatedAt.After(q.UpdatedAfter) {
+		return false
+	}
+	for k, v := range q.Meta {
+		if got, ok := rec.Meta[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Tombstone marks a record removed on the server so that sync consumers
+// can drop their cached copy.
+type Tombstone struct {
+	ID        string    `json:"id"`
+	Version   int64     `json:"version"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// TrashedRecord is a deleted record kept in the owner's trash for undelete.
+// Version is the last live version of the record.
+type TrashedRecord struct {
+	Record
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Operations of a record batch.
+const (
+	BatchUpsert = "upsert"
+	BatchDelete = "delete"
+)
+
+// BatchOp is an operation of a record batch: an upsert of Record, made
+// conditional by ExpectedVersion like If-Match, or a delete of ID.
+type BatchOp struct {
+	Op              string  `json:"op"`
+	Record          *Record `json:"record,omitempty"`
+	ID              string  `json:"id,omitempty"`
+	ExpectedVersion *int64  `json:"expected_version,omitempty"`
+}
+
+// Statuses of batch operations. A batch is applied only when every
+// operation is ok; otherwise the operations that would have succeeded are
+// reported as aborted.
+const (
+	BatchOK       = "ok"
+	BatchInvalid  = "invalid"
+	BatchConflict = "conflict"
+	BatchNotFound = "not_found"
+	BatchAborted  = "aborted"
+)
+
+// BatchResult is the outcome of the batch operation at the same index.
+type BatchResult struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Version int64  `json:"version,omitempty"`
+	// UpdatedAt is the stored time of an applied upsert.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Changes is a delta of owner's records after a sync cursor.
+// Cursor must be passed as `since` on the next sync call. Reset reports that
+// the delta could not be computed because tombstones were already purged:
+// Records then hold the full snapshot and anything else cached must be dropped.
+type Changes struct {
+	Records []Record    `json:"records"`
+	Deleted []Tombstone `json:"deleted"`
+	Cursor  int64       `json:"cursor"`
+	Reset   bool        `json:"reset,omitempty"`
+}
+
+// KeyEscrow is the user's vault key wrapped on the client with a key derived
+// from the master password. The server stores Blob as is and cannot unwrap it.
+type KeyEscrow struct {
+	Blob      []byte    `json:"blob"`
+	Version   int64     `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Upload is a resumable upload of a large encrypted record payload. Size is
+// the number of bytes received so far, i.e. the offset of the next part.
+type Upload struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
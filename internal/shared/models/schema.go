@@ -0,0 +1,159 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// LoginContent is the plaintext payload of a login record.
+type LoginContent struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// TextContent is the plaintext payload of a text record.
+type TextContent struct {
+	Text string `json:"text"`
+}
+
+// BankCardContent is the plaintext payload of a bank card record.
+type BankCardContent struct {
+	Holder string `json:"holder"`
+	Number string `json:"number"`
+	Exp    string `json:"exp"`
+	CVV    string `json:"cvv"`
+}
+
+// TOTPContent is the plaintext payload of a totp record: an RFC 6238 key.
+// Secret is base32 encoded, Algorithm is SHA1, SHA256 or SHA512. Numbers are
+// kept as JSON strings like the fields of the other types.
+type TOTPContent struct {
+	Secret    string `json:"secret"`
+	Algorithm string `json:"algorithm"`
+	Digits    int    `json:"digits,string"`
+	Period    int    `json:"period,string"`
+}
+
+// SSHKeyContent is the plaintext payload of an ssh_key record. PrivateKey is
+// PEM encoded, PublicKey is in authorized_keys format. Passphrase is set
+// when PrivateKey itself is encrypted.
+type SSHKeyContent struct {
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+	Comment    string `json:"comment"`
+	Passphrase string `json:"passphrase"`
+}
+
+// RecordSchema describes a record type. The server sees payloads encrypted
+// and can only enforce Meta; Fields describe the plaintext for clients.
+type RecordSchema struct {
+	Type RecordType
+	// Meta lists the meta keys every record of the type must have.
+	Meta []string
+	// Fields lists the JSON fields of the plaintext payload in input order.
+	// It is empty for types whose payload is opaque bytes.
+	Fields []string
+	// Secret lists the fields that are masked when shown.
+	Secret []string
+
+	// content returns a new payload struct, nil for opaque payloads.
+	content func() any
+}
+
+var schemas = map[RecordType]RecordSchema{
+	RecordTypeLogin: {
+		Type:    RecordTypeLogin,
+		Meta:    []string{"site"},
+		Fields:  []string{"login", "password"},
+		Secret:  []string{"password"},
+		content: func() any { return new(LoginContent) },
+	},
+	RecordTypeText: {
+		Type:    RecordTypeText,
+		Fields:  []string{"text"},
+		content: func() any { return new(TextContent) },
+	},
+	RecordTypeBinary: {
+		Type: RecordTypeBinary,
+		Meta: []string{"name"},
+	},
+	RecordTypeBankCard: {
+		Type:    RecordTypeBankCard,
+		Meta:    []string{"bank"},
+		Fields:  []string{"holder", "number", "exp", "cvv"},
+		Secret:  []string{"cvv"},
+		content: func() any { return new(BankCardContent) },
+	},
+	RecordTypeTOTP: {
+		Type:    RecordTypeTOTP,
+		Meta:    []string{"account"},
+		Fields:  []string{"secret", "algorithm", "digits", "period"},
+		Secret:  []string{"secret"},
+		content: func() any { return new(TOTPContent) },
+	},
+	RecordTypeSSHKey: {
+		Type:    RecordTypeSSHKey,
+		Meta:    []string{"name"},
+		Fields:  []string{"private_key", "public_key", "comment", "passphrase"},
+		Secret:  []string{"private_key", "passphrase"},
+		content: func() any { return new(SSHKeyContent) },
+	},
+}
+
+// SchemaOf returns the schema of a known record type.
+func SchemaOf(t RecordType) (RecordSchema, bool) {
+	s, ok := schemas[t]
+	return s, ok
+}
+
+// RecordTypes returns the known record types in alphabetical order.
+func RecordTypes() []RecordType {
+	out := make([]RecordType, 0, len(schemas))
+	for t := range schemas {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// Structured reports whether the payload is a JSON object of Fields.
+func (s RecordSchema) Structured() bool {
+	return len(s.Fields) > 0
+}
+
+// NewContent returns a pointer to a zero payload struct of the type, or nil
+// when the payload is not structured.
+func (s RecordSchema) NewContent() any {
+	if s.content == nil {
+		return nil
+	}
+	return s.content()
+}
+
+// IsSecret reports whether field is masked when shown.
+func (s RecordSchema) IsSecret(field string) bool {
+	for _, f := range s.Secret {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that rec has a known type and all meta keys it requires.
+func (rec Record) Validate() error {
+	if rec.Type == "" {
+		return errors.New("type required")
+	}
+	s, ok := SchemaOf(rec.Type)
+	if !ok {
+		return fmt.Errorf("unknown record type %q", rec.Type)
+	}
+	for _, k := range s.Meta {
+		if _, ok := rec.Meta[k]; !ok {
+			return fmt.Errorf("%s record requires meta %q", rec.Type, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSchemaFieldsMatchContentTypes(t *testing.T) {
+	for typ, content := range map[RecordType]any{
+		RecordTypeLogin:    LoginContent{},
+		RecordTypeText:     TextContent{},
+		RecordTypeBankCard: BankCardContent{},
+		RecordTypeTOTP:     TOTPContent{},
+		RecordTypeSSHKey:   SSHKeyContent{},
+	} {
+		b, _ := json.Marshal(content)
+		var fields map[string]any
+		_ = json.Unmarshal(b, &fields)
+		var got []string
+		for k := range fields {
+			got = append(got, k)
+		}
+		s, _ := SchemaOf(typ)
+		want := append([]string(nil), s.Fields...)
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: content fields %v, schema fields %v", typ, got, want)
+		}
+		for _, f := range s.Secret {
+			if _, ok := fields[f]; !ok {
+				t.Errorf("%s: secret %q is not a field", typ, f)
+			}
+		}
+	}
+}
+
+func TestRecordValidate(t *testing.T) {
+	for _, tc := range []struct {
+		rec Record
+		err string
+	}{
+		{Record{Type: RecordTypeLogin, Meta: map[string]string{"site": ""}}, ""},
+		{Record{Type: RecordTypeText}, ""},
+		{Record{Type: RecordTypeLogin, Meta: map[string]string{"title": "x"}}, `login record requires meta "site"`},
+		{Record{Type: "note"}, `unknown record type "note"`},
+		{Record{}, "type required"},
+	} {
+		err := tc.rec.Validate()
+		if (err == nil) != (tc.err == "") || err != nil && err.Error() != tc.err {
+			t.Errorf("%+v: got %v, want %q", tc.rec, err, tc.err)
+		}
+	}
+}
+
+func TestSchema_NewContent(t *testing.T) {
+	s, _ := SchemaOf(RecordTypeTOTP)
+	if _, ok := s.NewContent().(*TOTPContent); !ok {
+		t.Fatalf("totp content: %T", s.NewContent())
+	}
+	if s, _ := SchemaOf(RecordTypeBinary); s.NewContent() != nil {
+		t.Fatal("binary payloads have no content struct")
+	}
+	for _, typ := range RecordTypes() {
+		if s, _ := SchemaOf(typ); s.Structured() != (s.NewContent() != nil) {
+			t.Fatalf("%s: fields and content struct disagree", typ)
+		}
+	}
+}
@@ -10,25 +10,49 @@ import (
 	"golang.org/x/crypto/argon2"
 )
 
-// Parameters tuned for interactive logins.
-const (
-	memory      uint32 = 64 * 1024
-	iterations  uint32 = 3
-	parallelism uint8  = 2
-	saltLength  uint32 = 16
-	hashLength  uint32 = 32
-)
+// Params are Argon2id cost parameters.
+type Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams are tuned for interactive logins.
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// NewSalt returns a random salt of p.SaltLength bytes.
+func NewSalt(p Params) ([]byte, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// DeriveKey derives a p.KeyLength bytes key from password and salt with Argon2id.
+func DeriveKey(password, salt []byte, p Params) []byte {
+	return argon2.IDKey(password, salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+}
 
 // HashPassword returns a PHC formatted Argon2id hash string for the provided password.
 func HashPassword(password string) (string, error) {
-	salt := make([]byte, saltLength)
-	if _, err := rand.Read(salt); err != nil {
+	p := DefaultParams
+	salt, err := NewSalt(p)
+	if err != nil {
 		return "", err
 	}
-	hash := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, hashLength)
+	hash := DeriveKey([]byte(password), salt, p)
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
 	b64Hash := base64.RawStdEncoding.EncodeToString(hash)
-	encoded := fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", memory, iterations, parallelism, b64Salt, b64Hash)
+	encoded := fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", p.Memory, p.Iterations, p.Parallelism, b64Salt, b64Hash)
 	return encoded, nil
 }
 
@@ -55,7 +79,7 @@ func VerifyPassword(encoded, password string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	calc := argon2.IDKey([]byte(password), salt, t, m, p, uint32(len(decodedHash)))
+	calc := DeriveKey([]byte(password), salt, Params{Memory: m, Iterations: t, Parallelism: p, KeyLength: uint32(len(decodedHash))})
 	if subtleConstantTimeEquals(calc, decodedHash) {
 		return true, nil
 	}
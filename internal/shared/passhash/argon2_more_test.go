@@ -10,3 +10,17 @@ func TestVerify_Errors(t *testing.T) {
 		t.Fatalf("want error on bad format")
 	}
 }
+
+func TestDeriveKey_Deterministic(t *testing.T) {
+	p := Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 8, KeyLength: 32}
+	salt, err := NewSalt(p)
+	if err != nil || len(salt) != 8 {
+		t.Fatalf("salt: %v %d", err, len(salt))
+	}
+	k1 := DeriveKey([]byte("pw"), salt, p)
+	k2 := DeriveKey([]byte("pw"), salt, p)
+	k3 := DeriveKey([]byte("other"), salt, p)
+	if len(k1) != 32 || string(k1) != string(k2) || string(k1) == string(k3) {
+		t.Fatalf("unexpected derived keys")
+	}
+}